@@ -41,6 +41,45 @@ const (
 
 	// ProxmoxConnectionCheckTimeout is the timeout for connectivity checks
 	ProxmoxConnectionCheckTimeout = 5 * time.Second
+
+	// CloneTaskTimeout bounds how long a template clone may run before it is configured
+	CloneTaskTimeout = 30 * time.Minute
+
+	// TaskPollInterval is how often a running Proxmox task status is polled
+	TaskPollInterval = 2 * time.Second
+
+	// TaskTrackTimeout bounds how long a started task is followed before it is reported as unknown
+	TaskTrackTimeout = 30 * time.Minute
+
+	// MaxTrackedTasksPerVM is how many recent tasks are kept per VM for the details page
+	MaxTrackedTasksPerVM = 10
+
+	// TaskLogLimit is the maximum number of task log lines returned to the UI
+	TaskLogLimit = 200
+
+	// VMTaskWaitTimeout bounds how long a stop or delete waits for its task to finish
+	VMTaskWaitTimeout = 2 * time.Minute
+
+	// PoolDeletionTimeout bounds the deletion of a user pool and all of its VMs
+	PoolDeletionTimeout = 10 * time.Minute
+
+	// LeaseReaperInterval is how often expired VM leases are checked in background
+	LeaseReaperInterval = 5 * time.Minute
+
+	// PowerScheduleInterval is how often VM power schedules are checked in background
+	PowerScheduleInterval = time.Minute
+
+	// PowerScheduleMissedWindow is how late a schedule may still run, e.g. after a restart
+	PowerScheduleMissedWindow = 15 * time.Minute
+
+	// PowerScheduleShutdownTimeout bounds a scheduled shutdown before the VM is stopped
+	PowerScheduleShutdownTimeout = 3 * time.Minute
+
+	// AuditPruneInterval is how often audit events past their retention are dropped
+	AuditPruneInterval = time.Hour
+
+	// DefaultAuditRetentionDays is how long audit events are kept unless the settings say otherwise
+	DefaultAuditRetentionDays = 365
 )
 
 // Console Session Configuration
@@ -64,6 +103,9 @@ const (
 
 	// GuestAgentTimeout is the maximum time to wait for guest agent responses
 	GuestAgentTimeout = 1 * time.Second
+
+	// InventoryTTL is how long the cluster-wide VM inventory is reused before it is fetched again
+	InventoryTTL = 10 * time.Second
 )
 
 // Security Configuration
@@ -79,6 +121,18 @@ const (
 
 	// SessionCleanupInterval is how often to clean expired sessions
 	SessionCleanupInterval = 30 * time.Minute
+
+	// SessionActivityInterval is how often the last activity of a session is recorded
+	SessionActivityInterval = time.Minute
+
+	// AdminSecondFactorTimeout is how long an administrator has to enter a TOTP code after the password
+	AdminSecondFactorTimeout = 5 * time.Minute
+
+	// AdminSecondFactorMaxAttempts is the number of wrong codes before the password is asked again
+	AdminSecondFactorMaxAttempts = 5
+
+	// AdminRecoveryCodeCount is the number of recovery codes issued to an administrator
+	AdminRecoveryCodeCount = 10
 )
 
 // Rate Limiting
@@ -144,8 +198,23 @@ const (
 	// MinPasswordLength is the minimum required password length
 	MinPasswordLength = 5
 
+	// MinAdminPasswordLength is the minimum password length of named administrator accounts
+	MinAdminPasswordLength = 12
+
 	// MaxVMNameLength is the maximum VM name length
 	MaxVMNameLength = 100
+
+	// MaxAPITokenNameLength is the maximum personal API token name length
+	MaxAPITokenNameLength = 64
+
+	// MaxAPITokensPerUser is the maximum number of personal API tokens a user may hold
+	MaxAPITokensPerUser = 20
+
+	// MaxAPITokenLifetimeDays is the longest expiry a user can choose for a personal API token
+	MaxAPITokenLifetimeDays = 365
+
+	// MaxSessionUserAgentLength is the maximum user agent length recorded for a session
+	MaxSessionUserAgentLength = 256
 )
 
 // Default Values
@@ -188,4 +257,49 @@ const (
 
 	// SessionKeyPVETicketCreated is the session key for ticket creation timestamp
 	SessionKeyPVETicketCreated = "pve_ticket_created"
+
+	// SessionKeyNewAPIToken holds a freshly created API token until the profile page has shown it once
+	SessionKeyNewAPIToken = "new_api_token"
+
+	// SessionKeyAdminUsername is the session key for the name of the signed-in administrator account
+	SessionKeyAdminUsername = "admin_username"
+
+	// SessionKeyAdminPending is the session key for the administrator account whose password was
+	// verified and who still has to enter a TOTP code
+	SessionKeyAdminPending = "admin_pending"
+
+	// SessionKeyAdminPendingAt is the session key for the time the password was verified
+	SessionKeyAdminPendingAt = "admin_pending_at"
+
+	// SessionKeyAdminPendingReturn is the session key for the page to open once the login completes
+	SessionKeyAdminPendingReturn = "admin_pending_return"
+
+	// SessionKeyAdminPendingAttempts is the session key for the number of wrong TOTP codes entered
+	SessionKeyAdminPendingAttempts = "admin_pending_attempts"
+
+	// SessionKeyNewRecoveryCodes holds freshly issued recovery codes until they have been shown once
+	SessionKeyNewRecoveryCodes = "new_recovery_codes"
+
+	// SessionKeyID is the session key for the identifier shown to revoke a session; the session
+	// token itself never leaves its cookie
+	SessionKeyID = "session_id"
+
+	// SessionKeyCreatedAt is the session key for the time the session signed in
+	SessionKeyCreatedAt = "session_created_at"
+
+	// SessionKeyLastSeen is the session key for the time of the last request of the session
+	SessionKeyLastSeen = "session_last_seen"
+
+	// SessionKeyClientIP is the session key for the IP address of the last request of the session
+	SessionKeyClientIP = "session_client_ip"
+
+	// SessionKeyUserAgent is the session key for the user agent of the session
+	SessionKeyUserAgent = "session_user_agent"
+
+	// SessionKeyAuthMethod is the session key for how the session signed in, when not with a password
+	SessionKeyAuthMethod = "auth_method"
+
+	// SessionKeyAdminRole is the session key for the administrator role granted by the identity
+	// provider to a single sign-on session
+	SessionKeyAdminRole = "admin_role"
 )
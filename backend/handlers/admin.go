@@ -256,8 +256,10 @@ func (h *AdminHandler) RegisterRoutes(router *httprouter.Router) {
 
 	// Admin ticket test form with CSRF protection
 	router.POST("/admin/ticket-test", SecureFormHandler("ProxmoxTicketTest",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.ProxmoxTicketTestFormHandler(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
+
+	h.RegisterAuditRoutes(router)
 }
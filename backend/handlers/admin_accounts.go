@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/security"
+	"pvmss/state"
+)
+
+// AdminAccountView is an administrator account as listed on the admin accounts page
+type AdminAccountView struct {
+	Username          string
+	Role              string
+	TOTPEnrolled      bool
+	RecoveryCodesLeft int
+	Disabled          bool
+	CreatedAt         string
+	LastLogin         string
+	IsCurrent         bool
+}
+
+// AccountsPageHandler renders the administrator accounts and the bootstrap account status
+func (h *AdminHandler) AccountsPageHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("AccountsPageHandler", r)
+
+	successMsg := ""
+	switch r.URL.Query().Get("success") {
+	case "created":
+		successMsg = "Account " + r.URL.Query().Get("account") + " created; it enrolls two-factor authentication at its first login"
+	case "password":
+		successMsg = "Password of " + r.URL.Query().Get("account") + " changed"
+	case "role":
+		successMsg = "Role of " + r.URL.Query().Get("account") + " changed"
+	case "enabled":
+		successMsg = "Account " + r.URL.Query().Get("account") + " enabled"
+	case "disabled":
+		successMsg = "Account " + r.URL.Query().Get("account") + " disabled"
+	case "totp":
+		successMsg = "Two-factor authentication of " + r.URL.Query().Get("account") + " reset; it enrolls again at its next login"
+	case "deleted":
+		successMsg = "Account " + r.URL.Query().Get("account") + " deleted"
+	case "recovery":
+		successMsg = "New recovery codes issued; the previous ones no longer work"
+	case "bootstrap":
+		successMsg = "Bootstrap account updated"
+	}
+	data := AdminPageDataWithMessage("Admin Accounts", "accounts", successMsg, r.URL.Query().Get("error"))
+
+	current := adminAccountName(r)
+	store := h.stateManager.GetAdminAccountStore()
+	accounts := make([]AdminAccountView, 0)
+	currentEnrolled := false
+	for _, a := range store.List() {
+		view := AdminAccountView{
+			Username:          a.Username,
+			Role:              a.Role,
+			TOTPEnrolled:      a.TOTPEnrolled(),
+			RecoveryCodesLeft: len(a.RecoveryCodes),
+			Disabled:          a.Disabled,
+			CreatedAt:         a.CreatedAt.Local().Format("2006-01-02 15:04"),
+			IsCurrent:         a.Username == current,
+		}
+		if a.LastLogin != nil {
+			view.LastLogin = a.LastLogin.Local().Format("2006-01-02 15:04")
+		}
+		if view.IsCurrent {
+			currentEnrolled = view.TOTPEnrolled
+		}
+		accounts = append(accounts, view)
+	}
+	data["Accounts"] = accounts
+	data["CurrentAdmin"] = current
+	data["Roles"] = state.AdminRoles
+	data["CanRegenerateRecoveryCodes"] = currentEnrolled
+	data["MinPasswordLength"] = constants.MinAdminPasswordLength
+	data["BootstrapConfigured"] = os.Getenv("ADMIN_PASSWORD_HASH") != ""
+	data["BootstrapDisabled"] = store.BootstrapDisabled()
+	data["CanDisableBootstrap"] = store.HasUsableAccount()
+
+	// Recovery codes are shown once, right after they were issued
+	if sessionManager := security.GetSession(r); sessionManager != nil {
+		if codes := sessionManager.PopString(r.Context(), constants.SessionKeyNewRecoveryCodes); codes != "" {
+			data["NewRecoveryCodes"] = strings.Fields(codes)
+		}
+	}
+
+	log.Debug().Int("accounts", len(accounts)).Msg("Admin accounts page rendered")
+	renderTemplateInternal(w, r, "admin_accounts", data)
+}
+
+// redirectAccounts returns to the accounts page with a success code, or with err
+func redirectAccounts(w http.ResponseWriter, r *http.Request, success, account string, err error) {
+	if err != nil {
+		http.Redirect(w, r, "/admin/accounts?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/admin/accounts?success="+success+"&account="+url.QueryEscape(account), http.StatusSeeOther)
+}
+
+// auditAccount records a change of the administrator account named account
+func auditAccount(r *http.Request, action, account string, params map[string]string, err error) {
+	if params == nil {
+		params = make(map[string]string)
+	}
+	params["account"] = account
+	recordAudit(r, state.AuditEvent{Action: action, Params: params}, err)
+}
+
+// CreateAccountHandler adds a named administrator account
+func (h *AdminHandler) CreateAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("CreateAccountHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	username := state.NormalizeAdminUsername(r.FormValue("username"))
+	password := r.FormValue("password")
+	role := r.FormValue("role")
+	if password != r.FormValue("confirm_password") {
+		redirectAccounts(w, r, "", "", errors.New("passwords do not match"))
+		return
+	}
+	err := h.stateManager.GetAdminAccountStore().Create(username, password, role)
+	auditAccount(r, state.AuditActionAccountCreate, username, map[string]string{"role": role}, err)
+	if err != nil {
+		log.Warn().Err(err).Str("admin_account", username).Msg("Failed to create admin account")
+		redirectAccounts(w, r, "", "", err)
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Str("admin_account", username).Str("role", role).Msg("Admin account created")
+	redirectAccounts(w, r, "created", username, nil)
+}
+
+// AccountPasswordHandler sets a new password on an administrator account
+func (h *AdminHandler) AccountPasswordHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("AccountPasswordHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	if password != r.FormValue("confirm_password") {
+		redirectAccounts(w, r, "", "", errors.New("passwords do not match"))
+		return
+	}
+	err := h.stateManager.GetAdminAccountStore().SetPassword(username, password)
+	auditAccount(r, state.AuditActionAccountPassword, username, nil, err)
+	if err != nil {
+		redirectAccounts(w, r, "", "", err)
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Str("admin_account", username).Msg("Admin account password changed")
+	redirectAccounts(w, r, "password", username, nil)
+}
+
+// AccountRoleHandler changes the role of an administrator account other than the current one
+func (h *AdminHandler) AccountRoleHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("AccountRoleHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	username := r.FormValue("username")
+	role := r.FormValue("role")
+	if username == adminAccountName(r) {
+		redirectAccounts(w, r, "", "", errors.New("you cannot change your own role"))
+		return
+	}
+	err := h.stateManager.GetAdminAccountStore().SetRole(username, role)
+	auditAccount(r, state.AuditActionAccountRole, username, map[string]string{"role": role}, err)
+	if err != nil {
+		redirectAccounts(w, r, "", "", err)
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Str("admin_account", username).Str("role", role).Msg("Admin account role changed")
+	redirectAccounts(w, r, "role", username, nil)
+}
+
+// ToggleAccountHandler enables or disables an administrator account other than the current one
+func (h *AdminHandler) ToggleAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("ToggleAccountHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	username := r.FormValue("username")
+	disable := r.FormValue("disabled") == "1"
+	if disable && username == adminAccountName(r) {
+		redirectAccounts(w, r, "", "", errors.New("you cannot disable your own account"))
+		return
+	}
+	err := h.stateManager.GetAdminAccountStore().SetDisabled(username, disable)
+	auditAccount(r, state.AuditActionAccountToggle, username, map[string]string{"disabled": strconv.FormatBool(disable)}, err)
+	if err != nil {
+		redirectAccounts(w, r, "", "", err)
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Str("admin_account", username).Bool("disabled", disable).Msg("Admin account toggled")
+	success := "enabled"
+	if disable {
+		success = "disabled"
+	}
+	redirectAccounts(w, r, success, username, nil)
+}
+
+// ResetAccountTOTPHandler removes the authenticator of an administrator account, for a lost phone
+func (h *AdminHandler) ResetAccountTOTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("ResetAccountTOTPHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	username := r.FormValue("username")
+	err := h.stateManager.GetAdminAccountStore().ResetTOTP(username)
+	auditAccount(r, state.AuditActionAccountTOTPReset, username, nil, err)
+	if err != nil {
+		redirectAccounts(w, r, "", "", err)
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Str("admin_account", username).Msg("Admin account two-factor authentication reset")
+	redirectAccounts(w, r, "totp", username, nil)
+}
+
+// DeleteAccountHandler removes an administrator account other than the current one
+func (h *AdminHandler) DeleteAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("DeleteAccountHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	username := r.FormValue("username")
+	if username == adminAccountName(r) {
+		redirectAccounts(w, r, "", "", errors.New("you cannot delete your own account"))
+		return
+	}
+	err := h.stateManager.GetAdminAccountStore().Delete(username)
+	auditAccount(r, state.AuditActionAccountDelete, username, nil, err)
+	if err != nil {
+		redirectAccounts(w, r, "", "", err)
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Str("admin_account", username).Msg("Admin account deleted")
+	redirectAccounts(w, r, "deleted", username, nil)
+}
+
+// RegenerateRecoveryCodesHandler issues new recovery codes to the current administrator
+func (h *AdminHandler) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("RegenerateRecoveryCodesHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	username := adminAccountName(r)
+	codes, err := h.stateManager.GetAdminAccountStore().RegenerateRecoveryCodes(username)
+	auditAccount(r, state.AuditActionAccountRecoveryCodes, username, nil, err)
+	if err != nil {
+		redirectAccounts(w, r, "", "", err)
+		return
+	}
+	if sessionManager := security.GetSession(r); sessionManager != nil {
+		sessionManager.Put(r.Context(), constants.SessionKeyNewRecoveryCodes, strings.Join(codes, " "))
+	}
+
+	log.Info().Str("admin_account", username).Msg("Admin recovery codes regenerated")
+	redirectAccounts(w, r, "recovery", username, nil)
+}
+
+// BootstrapAccountHandler disables or re-enables the ADMIN_PASSWORD_HASH account
+func (h *AdminHandler) BootstrapAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("BootstrapAccountHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	disable := r.FormValue("disabled") == "1"
+	err := h.stateManager.GetAdminAccountStore().SetBootstrapDisabled(disable)
+	auditAccount(r, state.AuditActionAccountBootstrap, state.BootstrapAdminUsername, map[string]string{"disabled": strconv.FormatBool(disable)}, err)
+	if err != nil {
+		redirectAccounts(w, r, "", "", err)
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Bool("disabled", disable).Msg("Bootstrap admin account updated")
+	redirectAccounts(w, r, "bootstrap", state.BootstrapAdminUsername, nil)
+}
+
+// RegisterAccountRoutes registers the admin account routes
+func (h *AdminHandler) RegisterAccountRoutes(router *httprouter.Router) {
+	routeHelpers := NewAdminPageRoutes()
+
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/accounts", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+		"page":   h.AccountsPageHandler,
+		"create": h.CreateAccountHandler,
+		"toggle": h.ToggleAccountHandler,
+		"delete": h.DeleteAccountHandler,
+	})
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/accounts/password", PermissionManage, h.AccountPasswordHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/accounts/role", PermissionManage, h.AccountRoleHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/accounts/totp/reset", PermissionManage, h.ResetAccountTOTPHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/accounts/recovery", PermissionView, h.RegenerateRecoveryCodesHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/accounts/bootstrap", PermissionManage, h.BootstrapAccountHandler)
+}
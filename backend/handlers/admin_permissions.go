@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/security"
+	"pvmss/state"
+)
+
+// Permission is what a route needs from the role of an administrator
+type Permission string
+
+const (
+	// PermissionView reads the admin pages and any VM
+	PermissionView Permission = state.AdminRoleAuditor
+	// PermissionOperate powers VMs, resets user passwords and handles VM requests
+	PermissionOperate Permission = state.AdminRoleHelpdesk
+	// PermissionManage changes settings and creates or deletes VMs, users and accounts
+	PermissionManage Permission = state.AdminRoleFull
+)
+
+// adminRole returns the role of the administrator of the session. The bootstrap account is a full
+// administrator, and an administrator signed in with single sign-on has the role mapped from the
+// claims at sign-in; a named account is read from the store on every request, so a new role or a
+// disabled account applies immediately.
+func adminRole(r *http.Request) string {
+	sessionManager := security.GetSession(r)
+	if sessionManager == nil {
+		return ""
+	}
+	if isOIDCSession(r) {
+		return sessionManager.GetString(r.Context(), constants.SessionKeyAdminRole)
+	}
+	account := sessionManager.GetString(r.Context(), constants.SessionKeyAdminUsername)
+	stateManager := getStateManager(r)
+	if account == "" || stateManager == nil {
+		return state.AdminRoleFull
+	}
+
+	store := stateManager.GetAdminAccountStore()
+	if account == state.BootstrapAdminUsername {
+		if store.BootstrapDisabled() {
+			return ""
+		}
+		return state.AdminRoleFull
+	}
+	a, ok := store.Get(account)
+	if !ok || a.Disabled {
+		return ""
+	}
+	return a.Role
+}
+
+// adminCan reports whether the session is an administrator whose role grants perm
+func adminCan(r *http.Request, perm Permission) bool {
+	return IsAdmin(r) && state.AdminRoleAllows(adminRole(r), string(perm))
+}
+
+// canPerform reports whether the session may perform actions needing perm on what it can access:
+// regular users act on their own VMs, administrators as far as their role allows
+func canPerform(r *http.Request, perm Permission) bool {
+	if !IsAdmin(r) {
+		return IsAuthenticated(r)
+	}
+	return adminCan(r, perm)
+}
+
+// renderPermissionDenied refuses an action the role of the administrator does not allow
+func renderPermissionDenied(w http.ResponseWriter, r *http.Request, perm Permission) {
+	log := CreateHandlerLogger("renderPermissionDenied", r)
+	log.Warn().
+		Str("admin", adminAccountName(r)).
+		Str("role", adminRole(r)).
+		Str("required", string(perm)).
+		Msg("Admin action refused by role")
+	RenderErrorPage(w, r, http.StatusForbidden, "Access Denied: your administrator role does not allow this action")
+}
+
+// restrictAdminRole lets regular users through, their ownership being checked by the handler,
+// and refuses administrators whose role does not grant perm
+func restrictAdminRole(perm Permission, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if IsAdmin(r) && !adminCan(r, perm) {
+			renderPermissionDenied(w, r, perm)
+			return
+		}
+		next(w, r, ps)
+	}
+}
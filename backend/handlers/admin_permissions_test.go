@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/state"
+)
+
+// adminSessionCookies signs a browser in as an administrator, with account as its admin account
+// ("" for sessions without one), and returns its session cookies
+func adminSessionCookies(sm *fakeStateManager, account string) []*http.Cookie {
+	rec := serveWithCookies(sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		sm.sessions.Put(r.Context(), "authenticated", true)
+		sm.sessions.Put(r.Context(), "is_admin", true)
+		if account != "" {
+			sm.sessions.Put(r.Context(), constants.SessionKeyAdminUsername, account)
+		}
+	}, httptest.NewRequest(http.MethodGet, "/admin/login", nil), nil)
+	return rec.Result().Cookies()
+}
+
+func TestAdminRolePermissions(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	store := sm.GetAdminAccountStore()
+	for _, role := range state.AdminRoles {
+		if err := store.Create(role+"-admin", testAdminPassword, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	tests := []struct {
+		account string
+		perm    Permission
+		want    int
+	}{
+		{"auditor-admin", PermissionView, http.StatusOK},
+		{"auditor-admin", PermissionOperate, http.StatusForbidden},
+		{"auditor-admin", PermissionManage, http.StatusForbidden},
+		{"helpdesk-admin", PermissionView, http.StatusOK},
+		{"helpdesk-admin", PermissionOperate, http.StatusOK},
+		{"helpdesk-admin", PermissionManage, http.StatusForbidden},
+		{"full-admin", PermissionManage, http.StatusOK},
+		// Sessions without a named account are the bootstrap-era administrators
+		{"", PermissionManage, http.StatusOK},
+		{state.BootstrapAdminUsername, PermissionManage, http.StatusOK},
+	}
+	for _, tt := range tests {
+		cookies := adminSessionCookies(sm, tt.account)
+		handler := HandlerFuncToHTTPrHandle(RequireAdminPermission(tt.perm, ok))
+		rec := serveWithCookies(sm, handler, httptest.NewRequest(http.MethodPost, "/admin/settings", nil), cookies)
+		if rec.Code != tt.want {
+			t.Errorf("account %q with permission %s: expected %d, got %d", tt.account, tt.perm, tt.want, rec.Code)
+		}
+	}
+
+	// A new role applies to the sessions already open
+	cookies := adminSessionCookies(sm, "helpdesk-admin")
+	if err := store.SetRole("helpdesk-admin", state.AdminRoleAuditor); err != nil {
+		t.Fatal(err)
+	}
+	rec := serveWithCookies(sm, HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionOperate, ok)),
+		httptest.NewRequest(http.MethodPost, "/vm/action", nil), cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a demoted account to be refused, got %d", rec.Code)
+	}
+
+	// So does a disabled account
+	cookies = adminSessionCookies(sm, "full-admin")
+	if err := store.SetDisabled("full-admin", true); err != nil {
+		t.Fatal(err)
+	}
+	rec = serveWithCookies(sm, HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionView, ok)),
+		httptest.NewRequest(http.MethodGet, "/admin/nodes", nil), cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a disabled account to be refused, got %d", rec.Code)
+	}
+}
+
+func TestRestrictAdminRole(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	if err := sm.GetAdminAccountStore().Create("auditor", testAdminPassword, state.AdminRoleAuditor); err != nil {
+		t.Fatal(err)
+	}
+
+	reached := false
+	handler := restrictAdminRole(PermissionOperate, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		reached = true
+	})
+
+	// Regular users reach the handler, which checks they own the VM
+	rec := serveWithCookies(sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		sm.sessions.Put(r.Context(), "authenticated", true)
+		sm.sessions.Put(r.Context(), "username", "alice")
+	}, httptest.NewRequest(http.MethodGet, "/", nil), nil)
+	serveWithCookies(sm, handler, httptest.NewRequest(http.MethodPost, "/vm/action", nil), rec.Result().Cookies())
+	if !reached {
+		t.Error("Expected a regular user to reach the handler")
+	}
+
+	reached = false
+	rec = serveWithCookies(sm, handler, httptest.NewRequest(http.MethodPost, "/vm/action", nil), adminSessionCookies(sm, "auditor"))
+	if reached || rec.Code != http.StatusForbidden {
+		t.Errorf("Expected an auditor to be refused a VM action, got %d", rec.Code)
+	}
+}
+
+func TestTemplateDataRoleFlags(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	if err := sm.GetAdminAccountStore().Create("helpdesk", testAdminPassword, state.AdminRoleHelpdesk); err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]interface{}{}
+	serveWithCookies(sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		populateTemplateData(w, r, data)
+	}, httptest.NewRequest(http.MethodGet, "/admin/nodes", nil), adminSessionCookies(sm, "helpdesk"))
+
+	if data["AdminRole"] != state.AdminRoleHelpdesk || data["CanOperate"] != true || data["CanManage"] != false {
+		t.Errorf("Expected helpdesk flags, got role=%v operate=%v manage=%v", data["AdminRole"], data["CanOperate"], data["CanManage"])
+	}
+}
@@ -149,8 +149,8 @@ func (h *AdminVMsHandler) getVMsWithPVMSSTag(ctx context.Context, client proxmox
 		Str("function", "getVMsWithPVMSSTag").
 		Logger()
 
-	// Get all VMs from Proxmox
-	allVMs, err := proxmox.GetVMsWithContext(ctx, client)
+	// Get all VMs from the cluster inventory (tags included)
+	allVMs, err := proxmox.GetInventoryVMsWithContext(ctx, client)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get VMs")
 		return nil, "Failed to retrieve VMs from Proxmox"
@@ -161,15 +161,7 @@ func (h *AdminVMsHandler) getVMsWithPVMSSTag(ctx context.Context, client proxmox
 	// Filter VMs with pvmss tag
 	results := []AdminVMInfo{}
 	for _, vm := range allVMs {
-		// Get VM config to check for pvmss tag
-		cfg, err := proxmox.GetVMConfigWithContext(ctx, client, vm.Node, vm.VMID)
-		if err != nil {
-			log.Debug().Err(err).Int("vmid", vm.VMID).Msg("Failed to get VM config, skipping")
-			continue
-		}
-
-		// Check for pvmss tag
-		if !h.hasTag(cfg, "pvmss") {
+		if !vm.HasTag("pvmss") {
 			continue
 		}
 
@@ -178,18 +170,12 @@ func (h *AdminVMsHandler) getVMsWithPVMSSTag(ctx context.Context, client proxmox
 			status = "unknown"
 		}
 
-		// Extract tags for display
-		tags := ""
-		if tagsValue, ok := cfg["tags"].(string); ok {
-			tags = tagsValue
-		}
-
 		results = append(results, AdminVMInfo{
 			VMID:   vm.VMID,
 			Name:   vm.Name,
 			Node:   vm.Node,
 			Status: strings.ToLower(status),
-			Tags:   tags,
+			Tags:   vm.Tags,
 		})
 
 		log.Debug().
@@ -212,32 +198,6 @@ func (h *AdminVMsHandler) getVMsWithPVMSSTag(ctx context.Context, client proxmox
 	return results, ""
 }
 
-// hasTag checks if a VM config contains a specific tag
-func (h *AdminVMsHandler) hasTag(cfg map[string]interface{}, targetTag string) bool {
-	tagsStr, ok := cfg["tags"].(string)
-	if !ok || tagsStr == "" {
-		return false
-	}
-
-	targetTag = strings.ToLower(strings.TrimSpace(targetTag))
-
-	// Proxmox can use either semicolon or comma as delimiter
-	var tags []string
-	if strings.Contains(tagsStr, ";") {
-		tags = strings.Split(tagsStr, ";")
-	} else {
-		tags = strings.Split(tagsStr, ",")
-	}
-
-	for _, tag := range tags {
-		if strings.ToLower(strings.TrimSpace(tag)) == targetTag {
-			return true
-		}
-	}
-
-	return false
-}
-
 // RegisterRoutes registers the routes for admin VM listing
 func (h *AdminVMsHandler) RegisterRoutes(router *httprouter.Router) {
 	log := logger.Get().With().
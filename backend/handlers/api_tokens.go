@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/security"
+	"pvmss/state"
+)
+
+// apiTokenContextKey carries the *state.APIToken of a bearer-authenticated request
+type apiTokenContextKey struct{}
+
+// apiTokenFromRequest returns the API token that authenticated r, if any
+func apiTokenFromRequest(r *http.Request) *state.APIToken {
+	if r == nil {
+		return nil
+	}
+	token, _ := r.Context().Value(apiTokenContextKey{}).(*state.APIToken)
+	return token
+}
+
+// authenticateBearer validates the bearer token of r and returns a request carrying its identity.
+// Tokens always map to a regular user: username and pvmss_<username> pool, never admin.
+func authenticateBearer(r *http.Request, raw string) (*http.Request, *state.APIToken, error) {
+	sm := getStateManager(r)
+	if sm == nil {
+		return nil, nil, errors.New("state manager not available")
+	}
+	store := sm.GetAPITokenStore()
+	if store == nil {
+		return nil, nil, state.ErrAPITokenInvalid
+	}
+	token, err := store.Authenticate(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.WithContext(context.WithValue(r.Context(), apiTokenContextKey{}, token)), token, nil
+}
+
+// ProfileAPIToken is an API token as displayed on the profile and admin sessions pages
+type ProfileAPIToken struct {
+	ID        string
+	Name      string
+	Username  string
+	Scope     string
+	CreatedAt string
+	ExpiresAt string
+	LastUsed  string
+	Expired   bool
+}
+
+// listProfileAPITokens returns the user's tokens formatted for the profile template
+func (h *ProfileHandler) listProfileAPITokens(username string) []ProfileAPIToken {
+	store := h.stateManager.GetAPITokenStore()
+	if store == nil {
+		return nil
+	}
+	return formatAPITokens(store.List(username))
+}
+
+// formatAPITokens formats tokens for the templates
+func formatAPITokens(tokens []state.APIToken) []ProfileAPIToken {
+	const layout = "2006-01-02 15:04"
+	now := time.Now()
+	out := make([]ProfileAPIToken, 0, len(tokens))
+	for _, t := range tokens {
+		pt := ProfileAPIToken{
+			ID:        t.ID,
+			Name:      t.Name,
+			Username:  t.Username,
+			Scope:     t.Scope,
+			CreatedAt: t.CreatedAt.Local().Format(layout),
+			Expired:   t.Expired(now),
+		}
+		if t.ExpiresAt != nil {
+			pt.ExpiresAt = t.ExpiresAt.Local().Format(layout)
+		}
+		if t.LastUsed != nil {
+			pt.LastUsed = t.LastUsed.Local().Format(layout)
+		}
+		out = append(out, pt)
+	}
+	return out
+}
+
+// revokeUserAPITokens deletes every API token of username and returns how many it deleted.
+// Tokens go with the sessions: a deleted user, or one whose password was changed, keeps no access.
+func revokeUserAPITokens(sm state.StateManager, username string) (int, error) {
+	if sm == nil {
+		return 0, nil
+	}
+	store := sm.GetAPITokenStore()
+	if store == nil {
+		return 0, nil
+	}
+	return store.RevokeAll(username)
+}
+
+// CreateAPIToken handles personal API token creation from the profile page
+func (h *ProfileHandler) CreateAPIToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "ProfileHandler.CreateAPIToken")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+	if ctx.IsAdmin() {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	username := ctx.GetUsername()
+	if username == "" || ctx.SessionManager == nil {
+		http.Redirect(w, r, "/profile?error=session_expired", http.StatusSeeOther)
+		return
+	}
+
+	store := h.stateManager.GetAPITokenStore()
+	if store == nil {
+		ctx.RedirectWithError("/profile", "Profile.APITokenCreateFailed")
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	scope := r.FormValue("scope")
+	if name == "" || len(name) > constants.MaxAPITokenNameLength || !state.IsValidAPITokenScope(scope) {
+		ctx.RedirectWithError("/profile", "Profile.APITokenInvalid")
+		return
+	}
+
+	var expiresAt *time.Time
+	if v := strings.TrimSpace(r.FormValue("expires_in_days")); v != "" && v != "0" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 || days > constants.MaxAPITokenLifetimeDays {
+			ctx.RedirectWithError("/profile", "Profile.APITokenInvalid")
+			return
+		}
+		t := time.Now().UTC().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	if len(store.List(username)) >= constants.MaxAPITokensPerUser {
+		ctx.RedirectWithError("/profile", "Profile.APITokenLimitReached")
+		return
+	}
+
+	plaintext, token, err := store.Create(username, name, scope, expiresAt)
+	params := map[string]string{"name": auditParam(name), "scope": scope}
+	if token != nil {
+		params["token"] = token.ID
+	}
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionTokenCreate, Params: params}, err)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("username", username).Msg("Failed to create API token")
+		ctx.RedirectWithError("/profile", "Profile.APITokenCreateFailed")
+		return
+	}
+
+	// The plaintext token is shown exactly once, on the next profile render
+	ctx.SessionManager.Put(r.Context(), constants.SessionKeyNewAPIToken, plaintext)
+	ctx.Log.Info().Str("username", username).Str("token_id", token.ID).Str("scope", scope).Msg("API token created from profile")
+	http.Redirect(w, r, "/profile#api-tokens", http.StatusSeeOther)
+}
+
+// RevokeAPIToken handles personal API token revocation from the profile page
+func (h *ProfileHandler) RevokeAPIToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "ProfileHandler.RevokeAPIToken")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+	username := ctx.GetUsername()
+	if username == "" {
+		http.Redirect(w, r, "/profile?error=session_expired", http.StatusSeeOther)
+		return
+	}
+
+	store := h.stateManager.GetAPITokenStore()
+	if store == nil {
+		ctx.RedirectWithError("/profile", "Profile.APITokenRevokeFailed")
+		return
+	}
+
+	err := store.Revoke(username, ps.ByName("id"))
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionTokenRevoke, Params: map[string]string{"token": ps.ByName("id")}}, err)
+	if err != nil {
+		ctx.Log.Warn().Err(err).Str("username", username).Str("token_id", ps.ByName("id")).Msg("Failed to revoke API token")
+		ctx.RedirectWithError("/profile", "Profile.APITokenRevokeFailed")
+		return
+	}
+	ctx.RedirectWithSuccess("/profile", "Profile.APITokenRevoked")
+}
+
+// scopePermissions maps each token scope to the admin permission a session needs for it
+var scopePermissions = map[string]Permission{
+	state.APITokenScopeRead:  PermissionView,
+	state.APITokenScopePower: PermissionOperate,
+	state.APITokenScopeFull:  PermissionManage,
+}
+
+// requireAPIScope is the bearer-aware authentication guard of the /api/v1 routes.
+// Session users are not restricted by scope, except administrators whose role is below it;
+// token users need a token granting scope.
+func requireAPIScope(scope string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if raw, ok := security.BearerToken(r); ok {
+			authed, token, err := authenticateBearer(r, raw)
+			if err != nil {
+				code, message := "invalid_token", "Invalid API token"
+				if errors.Is(err, state.ErrAPITokenExpired) {
+					code, message = "token_expired", "API token has expired"
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="pvmss", error="invalid_token"`)
+				writeAPIError(w, http.StatusUnauthorized, code, message)
+				return
+			}
+			if !token.Allows(scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="pvmss", error="insufficient_scope", scope="`+scope+`"`)
+				writeAPIError(w, http.StatusForbidden, "insufficient_scope", "API token scope does not allow this operation", "required scope: "+scope)
+				return
+			}
+			h(w, authed, ps)
+			return
+		}
+
+		_, isAdmin, ok := apiIdentity(r)
+		if !ok {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+			return
+		}
+		if isAdmin && !adminCan(r, scopePermissions[scope]) {
+			writeAPIError(w, http.StatusForbidden, "insufficient_role", "Administrator role does not allow this operation", "required scope: "+scope)
+			return
+		}
+		h(w, r, ps)
+	}
+}
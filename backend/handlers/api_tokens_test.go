@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/security"
+	"pvmss/state"
+)
+
+func TestAPIv1BearerTokens(t *testing.T) {
+	sm, client := newAPITestState(nil)
+	store, err := state.NewAPITokenStore("")
+	if err != nil {
+		t.Fatalf("NewAPITokenStore() error = %v", err)
+	}
+	sm.tokens = store
+
+	readToken, _, _ := store.Create("bob", "ci", state.APITokenScopeRead, nil)
+	powerToken, _, _ := store.Create("bob", "ops", state.APITokenScopePower, nil)
+	past := time.Now().Add(-time.Hour)
+	expiredToken, _, _ := store.Create("bob", "old", state.APITokenScopeFull, &past)
+	revokedToken, revoked, _ := store.Create("bob", "gone", state.APITokenScopeFull, nil)
+	if err := store.Revoke("bob", revoked.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	router := httprouter.New()
+	NewAPIv1Handler(sm).RegisterRoutes(router)
+	// CSRF runs in front of the router as in InitHandlers; bearer requests carry no CSRF token
+	handler := security.CSRF(router)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		wantCode int
+		wantErr  string
+	}{
+		{"read token lists pool VMs", http.MethodGet, "/api/v1/vms", readToken, http.StatusOK, ""},
+		{"read token cannot power on", http.MethodPost, "/api/v1/vms/200/actions/start", readToken, http.StatusForbidden, "insufficient_scope"},
+		{"power token starts own VM", http.MethodPost, "/api/v1/vms/200/actions/start", powerToken, http.StatusAccepted, ""},
+		{"power token cannot delete", http.MethodDelete, "/api/v1/vms/200", powerToken, http.StatusForbidden, "insufficient_scope"},
+		{"token is bound to its user's pool", http.MethodPost, "/api/v1/vms/100/actions/start", powerToken, http.StatusForbidden, "forbidden"},
+		{"expired token", http.MethodGet, "/api/v1/vms", expiredToken, http.StatusUnauthorized, "token_expired"},
+		{"revoked token", http.MethodGet, "/api/v1/vms", revokedToken, http.StatusUnauthorized, "invalid_token"},
+		{"unknown token", http.MethodGet, "/api/v1/vms", "pvmss_deadbeef0000_0123", http.StatusUnauthorized, "invalid_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			req = req.WithContext(context.WithValue(req.Context(), StateManagerKey, state.StateManager(sm)))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if tt.wantErr != "" {
+				if body := decodeAPIErrorBody(t, rec); body.Code != tt.wantErr {
+					t.Errorf("Expected code %q, got %q", tt.wantErr, body.Code)
+				}
+			}
+		})
+	}
+
+	if client.called(http.MethodPost, "/nodes/pve1/qemu/100/status") {
+		t.Error("A token must never act on a VM outside of its user's pool")
+	}
+}
+
+func TestAdminRevokesUserAPITokens(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	store, err := state.NewAPITokenStore("")
+	if err != nil {
+		t.Fatalf("NewAPITokenStore() error = %v", err)
+	}
+	sm.tokens = store
+	bobToken, _, _ := store.Create("bob", "ci", state.APITokenScopeFull, nil)
+	store.Create("bob", "ops", state.APITokenScopePower, nil)
+	_, alice, _ := store.Create("alice", "ci", state.APITokenScopeRead, nil)
+	h := NewUserPoolHandler(sm)
+
+	// Signing a user out everywhere revokes their tokens too
+	form := url.Values{"username": {"bob"}}
+	req := httptest.NewRequest(http.MethodPost, "/admin/sessions/revoke", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rec := serveAs(sm, "admin", true, h.RevokeSessionsHandler, req, nil); rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect, got %d", rec.Code)
+	}
+	if _, err := store.Authenticate(bobToken); err == nil {
+		t.Error("Expected the tokens of bob to be revoked")
+	}
+	if got := store.ListAll(); len(got) != 1 || got[0].Username != "alice" {
+		t.Fatalf("Expected only the token of alice to remain, got %+v", got)
+	}
+
+	// Any token can be revoked from the admin page
+	form = url.Values{"username": {"alice"}, "id": {alice.ID}}
+	req = httptest.NewRequest(http.MethodPost, "/admin/sessions/tokens/revoke", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := serveAs(sm, "admin", true, h.RevokeAPITokenHandler, req, nil)
+	if rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "success=revoke_token") {
+		t.Fatalf("Expected the token to be revoked, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if got := store.ListAll(); len(got) != 0 {
+		t.Errorf("Expected no token left, got %+v", got)
+	}
+}
@@ -0,0 +1,612 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/logger"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// apiMaxBodyBytes caps the size of JSON request bodies accepted by the API
+const apiMaxBodyBytes = 1 << 20
+
+// APIError is the structured error body returned by /api/v1 endpoints
+type APIError struct {
+	Status    string   `json:"status"`
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Details   []string `json:"details,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+}
+
+// writeAPIJSON writes a success envelope {"status":"success","data":...}
+func writeAPIJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	}); err != nil {
+		logger.Get().Error().Err(err).Msg("Failed to encode API response")
+	}
+}
+
+// writeAPIError writes a structured JSON error body
+func writeAPIError(w http.ResponseWriter, statusCode int, code, message string, details ...string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(APIError{
+		Status:    "error",
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: responseRequestID(w),
+	}); err != nil {
+		logger.Get().Error().Err(err).Msg("Failed to encode API error response")
+	}
+}
+
+// decodeAPIBody decodes a size-limited JSON request body into v, rejecting unknown fields
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", "Request body must be a valid JSON object", err.Error())
+		return false
+	}
+	return true
+}
+
+// apiIdentity returns the username and admin flag of the authenticated API caller
+func apiIdentity(r *http.Request) (username string, isAdmin bool, ok bool) {
+	ctx := NewHandlerContext(nil, r, "apiIdentity")
+	if !ctx.IsAuthenticated() {
+		return "", false, false
+	}
+	return ctx.GetUsername(), ctx.IsAdmin(), true
+}
+
+// APIVM is the JSON representation of a VM
+type APIVM struct {
+	VMID        int      `json:"vmid"`
+	Name        string   `json:"name"`
+	Node        string   `json:"node"`
+	Status      string   `json:"status"`
+	CPUs        int      `json:"cpus"`
+	Mem         int64    `json:"mem"`
+	MaxMem      int64    `json:"maxmem"`
+	MaxDisk     int64    `json:"maxdisk"`
+	Uptime      int64    `json:"uptime"`
+	Tags        []string `json:"tags,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+func newAPIVM(vm proxmox.VM) APIVM {
+	return APIVM{
+		VMID:    vm.VMID,
+		Name:    vm.Name,
+		Node:    vm.Node,
+		Status:  strings.ToLower(vm.Status),
+		CPUs:    vm.CPUs,
+		Mem:     vm.Mem,
+		MaxMem:  vm.MaxMem,
+		MaxDisk: vm.MaxDisk,
+		Uptime:  vm.Uptime,
+	}
+}
+
+// APIVMCreateRequest is the JSON body accepted by POST /api/v1/vms
+type APIVMCreateRequest struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	VMID        int           `json:"vmid"`
+	Sockets     int           `json:"sockets"`
+	Cores       int           `json:"cores"`
+	Memory      int           `json:"memory"`    // MB
+	DiskSize    int           `json:"disk_size"` // GB
+	ISO         string        `json:"iso"`
+	Bridge      string        `json:"bridge"`
+	Node        string        `json:"node"`
+	Pool        string        `json:"pool"`
+	Storage     string        `json:"storage"`
+	Tags        []string      `json:"tags"`
+	CloudInit   *APICloudInit `json:"cloud_init,omitempty"`
+	Template    int           `json:"template,omitempty"` // VMID of a template offering to clone
+	LinkedClone bool          `json:"linked_clone,omitempty"`
+	LeaseDays   int           `json:"lease_days,omitempty"` // 0 applies the default lease
+}
+
+// APICloudInit is the optional Cloud-Init section of a VM creation request
+type APICloudInit struct {
+	Hostname     string              `json:"hostname"`
+	User         string              `json:"user"`
+	SSHKeys      []string            `json:"ssh_keys"`
+	IPConfig     []CloudInitIPConfig `json:"ipconfig"`
+	Nameservers  []string            `json:"nameservers"`
+	SearchDomain string              `json:"searchdomain"`
+	UserData     string              `json:"user_data"`
+}
+
+// toFormData converts the JSON request to the form representation used by the HTML flow
+func (req APIVMCreateRequest) toFormData() VMCreateFormData {
+	itoa := func(v int) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.Itoa(v)
+	}
+	return VMCreateFormData{
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		VMID:        itoa(req.VMID),
+		Sockets:     itoa(req.Sockets),
+		Cores:       itoa(req.Cores),
+		Memory:      itoa(req.Memory),
+		DiskSize:    itoa(req.DiskSize),
+		ISO:         req.ISO,
+		Bridge:      req.Bridge,
+		Node:        req.Node,
+		Pool:        req.Pool,
+		Storage:     req.Storage,
+		Tags:        req.Tags,
+		CloudInit:   req.CloudInit.toFormData(),
+		Template:    itoa(req.Template),
+		LinkedClone: req.LinkedClone,
+		LeaseDays:   itoa(req.LeaseDays),
+	}
+}
+
+// toFormData converts the Cloud-Init section; a nil section leaves Cloud-Init disabled
+func (c *APICloudInit) toFormData() CloudInitFormData {
+	if c == nil {
+		return CloudInitFormData{}
+	}
+	return CloudInitFormData{
+		Enabled:      true,
+		Hostname:     strings.TrimSpace(c.Hostname),
+		User:         strings.TrimSpace(c.User),
+		SSHKeys:      strings.Join(c.SSHKeys, "\n"),
+		Networks:     c.IPConfig,
+		Nameservers:  strings.Join(c.Nameservers, " "),
+		SearchDomain: strings.TrimSpace(c.SearchDomain),
+		UserData:     strings.TrimSpace(c.UserData),
+	}
+}
+
+// APIv1Handler serves the versioned JSON API under /api/v1
+type APIv1Handler struct {
+	stateManager state.StateManager
+	vm           *VMHandler
+}
+
+// NewAPIv1Handler creates a new APIv1Handler
+func NewAPIv1Handler(sm state.StateManager) *APIv1Handler {
+	return &APIv1Handler{
+		stateManager: sm,
+		vm:           NewVMHandler(sm),
+	}
+}
+
+// client returns the Proxmox client or writes a 503 when it is unavailable
+func (h *APIv1Handler) client(w http.ResponseWriter) (proxmox.ClientInterface, bool) {
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "proxmox_unavailable", "Proxmox client not available")
+		return nil, false
+	}
+	return client, true
+}
+
+// authorizedVM parses :vmid, enforces pool ownership and resolves the VM.
+// It writes the error response itself and returns nil when the request must stop.
+func (h *APIv1Handler) authorizedVM(w http.ResponseWriter, r *http.Request, ps httprouter.Params, client proxmox.ClientInterface) *proxmox.VM {
+	vmid, err := strconv.Atoi(ps.ByName("vmid"))
+	if err != nil || vmid <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_vmid", "Invalid VM ID")
+		return nil
+	}
+
+	if err := authorizeVMAccess(r, client, vmid); err != nil {
+		if errors.Is(err, ErrVMAccessDenied) {
+			writeAPIError(w, http.StatusForbidden, "forbidden", "You do not have access to this virtual machine")
+		} else {
+			writeAPIError(w, http.StatusBadGateway, "proxmox_error", "Unable to verify VM ownership")
+		}
+		return nil
+	}
+
+	vms, err := proxmox.GetVMsWithContext(r.Context(), client)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "proxmox_error", "Failed to get VMs")
+		return nil
+	}
+	vm := findVMByID(vms, vmid)
+	if vm == nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "VM not found")
+		return nil
+	}
+	return vm
+}
+
+// ListVMs handles GET /api/v1/vms. Admins see every VM, users only those of their pool.
+func (h *APIv1Handler) ListVMs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("APIv1.ListVMs", r)
+	client, ok := h.client(w)
+	if !ok {
+		return
+	}
+	username, isAdmin, _ := apiIdentity(r)
+
+	var owned map[int]struct{}
+	if !isAdmin {
+		var err error
+		owned, err = getPoolVMIDs(r.Context(), client, userPoolName(username), r.URL.Query().Get("refresh") == "1")
+		if err != nil {
+			log.Error().Err(err).Str("username", username).Msg("Failed to fetch user pool")
+			writeAPIError(w, http.StatusBadGateway, "proxmox_error", "Failed to fetch user pool")
+			return
+		}
+	}
+
+	vms, err := proxmox.GetVMsWithContext(r.Context(), client)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get VMs")
+		writeAPIError(w, http.StatusBadGateway, "proxmox_error", "Failed to get VMs")
+		return
+	}
+
+	result := make([]APIVM, 0, len(vms))
+	for _, vm := range vms {
+		if !isAdmin {
+			if _, ok := owned[vm.VMID]; !ok {
+				continue
+			}
+		}
+		result = append(result, newAPIVM(vm))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].VMID < result[j].VMID })
+
+	writeAPIJSON(w, http.StatusOK, result)
+}
+
+// GetVM handles GET /api/v1/vms/:vmid
+func (h *APIv1Handler) GetVM(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	client, ok := h.client(w)
+	if !ok {
+		return
+	}
+	vm := h.authorizedVM(w, r, ps, client)
+	if vm == nil {
+		return
+	}
+
+	result := newAPIVM(*vm)
+	if cfg, err := proxmox.GetVMConfigWithContext(r.Context(), client, vm.Node, vm.VMID); err == nil {
+		if desc, ok := cfg["description"].(string); ok {
+			result.Description = desc
+		}
+		if tags, ok := cfg["tags"].(string); ok {
+			result.Tags = parseTags(tags)
+		}
+	}
+
+	writeAPIJSON(w, http.StatusOK, result)
+}
+
+// CreateVM handles POST /api/v1/vms using the same validation and limits as the HTML form
+func (h *APIv1Handler) CreateVM(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("APIv1.CreateVM", r)
+
+	var req APIVMCreateRequest
+	if !decodeAPIBody(w, r, &req) {
+		return
+	}
+
+	form := req.toFormData()
+	if missing := requiredVMCreateFields(form); len(missing) > 0 {
+		sort.Strings(missing)
+		writeAPIError(w, http.StatusBadRequest, "validation_failed", "Missing required fields", missing...)
+		return
+	}
+
+	// Regular users always create into their own pool
+	username, isAdmin, _ := apiIdentity(r)
+	if !isAdmin {
+		form.Pool = userPoolName(username)
+	}
+
+	client, ok := h.client(w)
+	if !ok {
+		return
+	}
+
+	// Held until the VM is created, as in CreateVMHandler
+	if !isAdmin {
+		unlock := lockUserLimits(username)
+		defer unlock()
+	}
+
+	spec, err := h.vm.validateVMCreate(r.Context(), client, form)
+	if err == nil && !isAdmin {
+		err = h.vm.validateCreateQuota(r.Context(), client, username, spec)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("API VM creation rejected")
+		writeVMCreateAPIError(w, err)
+		return
+	}
+
+	// VMs larger than the auto-approve envelope wait for an administrator
+	if !isAdmin {
+		if exceeds := autoApproveLimits(h.stateManager.GetSettings()).exceededBy(spec); exceeds != "" {
+			vmReq, err := h.vm.submitVMRequest(r, username, form, exceeds)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to store VM request")
+				writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+				return
+			}
+			log.Info().Str("vm_request_id", vmReq.ID).Str("exceeds", exceeds).Msg("API VM creation submitted for approval")
+			writeAPIJSON(w, http.StatusAccepted, map[string]interface{}{
+				"vm_request_id": vmReq.ID,
+				"status":        vmReq.Status,
+				"exceeds":       exceeds,
+			})
+			return
+		}
+	}
+
+	vmid, err := h.vm.provisionVM(r.Context(), client, spec)
+	recordAudit(r, spec.auditEvent(vmid), err)
+	if err != nil {
+		writeVMCreateAPIError(w, err)
+		return
+	}
+
+	log.Info().Int("vmid", vmid).Str("node", spec.Node).Str("pool", spec.Pool).Msg("VM created via API")
+	w.Header().Set("Location", "/api/v1/vms/"+strconv.Itoa(vmid))
+	writeAPIJSON(w, http.StatusCreated, map[string]interface{}{
+		"vmid": vmid,
+		"node": spec.Node,
+		"pool": spec.Pool,
+	})
+}
+
+// writeVMCreateAPIError maps a creation failure to a structured JSON error
+func writeVMCreateAPIError(w http.ResponseWriter, err error) {
+	var createErr *vmCreateError
+	if errors.As(err, &createErr) {
+		writeAPIError(w, createErr.Status, createErr.Code, createErr.Message)
+		return
+	}
+	writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+}
+
+// DeleteVM handles DELETE /api/v1/vms/:vmid (force stop + delete)
+func (h *APIv1Handler) DeleteVM(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	client, ok := h.client(w)
+	if !ok {
+		return
+	}
+	vm := h.authorizedVM(w, r, ps, client)
+	if vm == nil {
+		return
+	}
+
+	username, _, _ := apiIdentity(r)
+	upid, err := destroyVM(r.Context(), client, vm.Node, vm.VMID, username)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMDelete,
+		VMID:   vm.VMID,
+		Params: map[string]string{"node": vm.Node},
+		UPID:   upid,
+	}, err)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "proxmox_error", "VM deletion failed")
+		return
+	}
+
+	if username != "" {
+		client.InvalidateCache("/pools/" + url.PathEscape(userPoolName(username)))
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"vmid": vm.VMID, "deleted": true})
+}
+
+// VMAction handles POST /api/v1/vms/:vmid/actions/:action
+func (h *APIv1Handler) VMAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	action := ps.ByName("action")
+	switch action {
+	case "start", "stop", "shutdown", "reboot", "reset":
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_action", "Unsupported VM action", "allowed: start, stop, shutdown, reboot, reset")
+		return
+	}
+
+	client, ok := h.client(w)
+	if !ok {
+		return
+	}
+	vm := h.authorizedVM(w, r, ps, client)
+	if vm == nil {
+		return
+	}
+
+	upid, err := proxmox.VMActionWithContext(r.Context(), client, vm.Node, strconv.Itoa(vm.VMID), action)
+	recordAudit(r, state.AuditEvent{
+		Action: "vm." + action,
+		VMID:   vm.VMID,
+		Params: map[string]string{"node": vm.Node},
+		UPID:   upid,
+	}, err)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "proxmox_error", "VM action failed")
+		return
+	}
+	username, _, _ := apiIdentity(r)
+	proxmox.TrackTask(client, upid, vm.VMID, action, username)
+	writeAPIJSON(w, http.StatusAccepted, map[string]interface{}{
+		"vmid":   vm.VMID,
+		"action": action,
+		"upid":   upid,
+	})
+}
+
+// UpdateVMTags handles PUT /api/v1/vms/:vmid/tags with body {"tags": [...]}
+func (h *APIv1Handler) UpdateVMTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	// Only tags configured by an administrator may be applied
+	var unknown []string
+	if settings := h.stateManager.GetSettings(); settings != nil {
+		for _, t := range body.Tags {
+			if !tagExists(settings.Tags, t) {
+				unknown = append(unknown, t)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_tags", "Unknown tags", unknown...)
+		return
+	}
+
+	client, ok := h.client(w)
+	if !ok {
+		return
+	}
+	vm := h.authorizedVM(w, r, ps, client)
+	if vm == nil {
+		return
+	}
+
+	tags := ensureMandatoryTag(body.Tags)
+	err := proxmox.UpdateVMConfigWithContext(r.Context(), client, vm.Node, vm.VMID, map[string]string{"tags": strings.Join(tags, ";")})
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMTags,
+		VMID:   vm.VMID,
+		Params: map[string]string{"tags": strings.Join(tags, ",")},
+	}, err)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "proxmox_error", "Failed to update tags")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"vmid": vm.VMID, "tags": tags})
+}
+
+// UpdateVMDescription handles PUT /api/v1/vms/:vmid/description with body {"description": "..."}
+func (h *APIv1Handler) UpdateVMDescription(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var body struct {
+		Description string `json:"description"`
+	}
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	client, ok := h.client(w)
+	if !ok {
+		return
+	}
+	vm := h.authorizedVM(w, r, ps, client)
+	if vm == nil {
+		return
+	}
+
+	err := proxmox.UpdateVMConfigWithContext(r.Context(), client, vm.Node, vm.VMID, map[string]string{"description": body.Description})
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMDescription,
+		VMID:   vm.VMID,
+		Params: map[string]string{"description": auditParam(body.Description)},
+	}, err)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "proxmox_error", "Failed to update description")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"vmid": vm.VMID, "description": body.Description})
+}
+
+// ListNodes handles GET /api/v1/nodes
+func (h *APIv1Handler) ListNodes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	client, ok := h.client(w)
+	if !ok {
+		return
+	}
+	nodes, err := proxmox.GetNodeNamesWithContext(r.Context(), client)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "proxmox_error", "Failed to get nodes")
+		return
+	}
+	sort.Strings(nodes)
+	writeAPIJSON(w, http.StatusOK, nodes)
+}
+
+// settingsList returns a copy of a settings list, never nil
+func (h *APIv1Handler) settingsList(get func(*state.AppSettings) []string) []string {
+	out := []string{}
+	if settings := h.stateManager.GetSettings(); settings != nil {
+		out = append(out, get(settings)...)
+	}
+	return out
+}
+
+// ListISOs handles GET /api/v1/isos (ISOs enabled by an administrator)
+func (h *APIv1Handler) ListISOs(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	writeAPIJSON(w, http.StatusOK, h.settingsList(func(s *state.AppSettings) []string { return s.ISOs }))
+}
+
+// ListTemplates handles GET /api/v1/templates (template offerings enabled by an administrator)
+func (h *APIv1Handler) ListTemplates(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	templates := []state.VMTemplateOffering{}
+	if settings := h.stateManager.GetSettings(); settings != nil && settings.Templates != nil {
+		templates = settings.Templates
+	}
+	writeAPIJSON(w, http.StatusOK, templates)
+}
+
+// ListBridges handles GET /api/v1/bridges (bridges enabled by an administrator)
+func (h *APIv1Handler) ListBridges(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	writeAPIJSON(w, http.StatusOK, h.settingsList(func(s *state.AppSettings) []string { return s.VMBRs }))
+}
+
+// ListStorages handles GET /api/v1/storages (storages enabled by an administrator)
+func (h *APIv1Handler) ListStorages(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	writeAPIJSON(w, http.StatusOK, h.settingsList(func(s *state.AppSettings) []string { return s.EnabledStorages }))
+}
+
+// apiNotFound answers unknown /api/v1 paths with a structured JSON error
+func apiNotFound(w http.ResponseWriter, _ *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "not_found", "The requested resource was not found")
+}
+
+// RegisterRoutes registers the /api/v1 routes
+func (h *APIv1Handler) RegisterRoutes(router *httprouter.Router) {
+	read, power, full := state.APITokenScopeRead, state.APITokenScopePower, state.APITokenScopeFull
+
+	router.GET("/api/v1/vms", requireAPIScope(read, h.ListVMs))
+	router.POST("/api/v1/vms", requireAPIScope(full, h.CreateVM))
+	router.GET("/api/v1/vms/:vmid", requireAPIScope(read, h.GetVM))
+	router.DELETE("/api/v1/vms/:vmid", requireAPIScope(full, h.DeleteVM))
+	router.POST("/api/v1/vms/:vmid/actions/:action", requireAPIScope(power, h.VMAction))
+	router.PUT("/api/v1/vms/:vmid/tags", requireAPIScope(full, h.UpdateVMTags))
+	router.PUT("/api/v1/vms/:vmid/description", requireAPIScope(full, h.UpdateVMDescription))
+
+	router.GET("/api/v1/nodes", requireAPIScope(read, h.ListNodes))
+	router.GET("/api/v1/isos", requireAPIScope(read, h.ListISOs))
+	router.GET("/api/v1/templates", requireAPIScope(read, h.ListTemplates))
+	router.GET("/api/v1/bridges", requireAPIScope(read, h.ListBridges))
+	router.GET("/api/v1/storages", requireAPIScope(read, h.ListStorages))
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/state"
+)
+
+func newAPITestState(settings *state.AppSettings) (*fakeStateManager, *fakeProxmoxClient) {
+	client := newFakeProxmoxClient()
+	client.responses["/nodes"] = map[string]interface{}{
+		"data": []map[string]interface{}{{"node": "pve1", "status": "online"}},
+	}
+	client.responses["/nodes/pve1/qemu"] = map[string]interface{}{
+		"data": []map[string]interface{}{
+			{"vmid": 100, "name": "alice-vm", "status": "running"},
+			{"vmid": 200, "name": "bob-vm", "status": "stopped"},
+		},
+	}
+	client.responses["/cluster/resources?type=vm"] = map[string]interface{}{
+		"data": []map[string]interface{}{
+			{"id": "qemu/100", "type": "qemu", "vmid": 100, "name": "alice-vm", "node": "pve1", "status": "running",
+				"tags": "pvmss", "pool": "pvmss_alice", "maxcpu": 4, "maxmem": 2048 * 1024 * 1024},
+			{"id": "qemu/200", "type": "qemu", "vmid": 200, "name": "bob-vm", "node": "pve1", "status": "stopped",
+				"tags": "pvmss", "pool": "pvmss_bob", "maxcpu": 2, "maxmem": 1024 * 1024 * 1024},
+		},
+	}
+	client.setPool("pvmss_alice", 100)
+	client.setPool("pvmss_bob", 200)
+	return &fakeStateManager{client: client, sessions: scs.New(), settings: settings}, client
+}
+
+func decodeAPIErrorBody(t *testing.T, rec *httptest.ResponseRecorder) APIError {
+	t.Helper()
+	var body APIError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Response is not a JSON error body: %v (%s)", err, rec.Body.String())
+	}
+	return body
+}
+
+func TestAPIv1ListVMsOnlyReturnsPoolVMs(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	h := NewAPIv1Handler(sm)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/vms", nil)
+	rec := serveAs(sm, "alice", false, requireAPIScope(state.APITokenScopeRead, h.ListVMs), req, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Status string  `json:"status"`
+		Data   []APIVM `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Data) != 1 || body.Data[0].VMID != 100 {
+		t.Errorf("Expected only VM 100, got %+v", body.Data)
+	}
+}
+
+func TestAPIv1GetVMDeniesNonOwner(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	h := NewAPIv1Handler(sm)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/vms/100", nil)
+	rec := serveAs(sm, "bob", false, h.GetVM, req, httprouter.Params{{Key: "vmid", Value: "100"}})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+	if body := decodeAPIErrorBody(t, rec); body.Code != "forbidden" {
+		t.Errorf("Expected code forbidden, got %q", body.Code)
+	}
+}
+
+func TestAPIv1CreateVMValidation(t *testing.T) {
+	settings := &state.AppSettings{
+		Limits: map[string]interface{}{
+			"vm": map[string]interface{}{
+				"sockets": map[string]interface{}{"min": float64(1), "max": float64(2)},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		wantCode    string
+		wantDetails []string
+	}{
+		{
+			name:     "missing fields",
+			body:     `{"name":"web"}`,
+			wantCode: "validation_failed",
+			wantDetails: []string{
+				"CPU cores is required", "CPU sockets is required", "Disk size is required", "ISO image is required",
+				"Memory is required", "Network bridge is required", "Proxmox node is required", "Storage is required",
+			},
+		},
+		{
+			name:     "limit exceeded",
+			body:     `{"name":"web","node":"pve1","sockets":4,"cores":1,"memory":1024,"disk_size":10,"iso":"local:iso/a.iso","bridge":"vmbr0","storage":"local-lvm"}`,
+			wantCode: "limit_exceeded",
+		},
+		{
+			name:     "unknown field",
+			body:     `{"name":"web","cpu":4}`,
+			wantCode: "invalid_json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, client := newAPITestState(settings)
+			h := NewAPIv1Handler(sm)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/vms", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := serveAs(sm, "alice", false, h.CreateVM, req, nil)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+			body := decodeAPIErrorBody(t, rec)
+			if body.Code != tt.wantCode {
+				t.Errorf("Expected code %q, got %q", tt.wantCode, body.Code)
+			}
+			if tt.wantDetails != nil && strings.Join(body.Details, ",") != strings.Join(tt.wantDetails, ",") {
+				t.Errorf("Expected details %v, got %v", tt.wantDetails, body.Details)
+			}
+			if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+				t.Error("VM must not be created when validation fails")
+			}
+		})
+	}
+}
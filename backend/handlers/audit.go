@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/logger"
+	"pvmss/middleware"
+	"pvmss/state"
+)
+
+// Roles recorded for actors who are not administrators
+const (
+	auditRoleUser     = "user"
+	auditRoleAPIToken = "api-token"
+)
+
+// auditTimeFormat is the layout of the event times on the admin audit page
+const auditTimeFormat = "2006-01-02 15:04:05"
+
+// auditDateFormat is the layout of the date filters of the admin audit page
+const auditDateFormat = "2006-01-02"
+
+// maxDisplayedAuditEvents bounds the events shown on the admin audit page; exports are not bounded
+const maxDisplayedAuditEvents = 500
+
+// maxAuditParamLength bounds free-text parameters such as descriptions
+const maxAuditParamLength = 200
+
+// Errors recorded for refused logins
+var (
+	errAuditInvalidCredentials = errors.New("invalid credentials")
+	errAuditRealmNotAllowed    = errors.New("realm not allowed")
+)
+
+// auditActions are the actions offered by the filter of the admin audit page
+var auditActions = []string{
+	state.AuditActionLogin,
+	state.AuditActionAdminLogin,
+	"vm.",
+	state.AuditActionVMCreate,
+	"vm.start",
+	"vm.stop",
+	"vm.shutdown",
+	"vm.reboot",
+	"vm.reset",
+	state.AuditActionVMDelete,
+	state.AuditActionVMTags,
+	state.AuditActionVMDescription,
+	state.AuditActionVMConsole,
+	state.AuditActionSettingsUpdate,
+	state.AuditActionPoolCreate,
+	state.AuditActionPoolDelete,
+	state.AuditActionPasswordReset,
+	"user.",
+	"admin.",
+}
+
+// auditRole returns the role of the caller: the administrator role, user, or api-token
+func auditRole(r *http.Request) string {
+	if apiTokenFromRequest(r) != nil {
+		return auditRoleAPIToken
+	}
+	if IsAdmin(r) {
+		return adminRole(r)
+	}
+	return auditRoleUser
+}
+
+// recordAudit appends event to the audit log. The actor and role are read from the session unless
+// the actor is set, as for a failed login; the source IP is read from the request and err decides
+// the result. Failing to write is logged, never returned:
+// the action already happened and the response must reflect it.
+func recordAudit(r *http.Request, event state.AuditEvent, err error) {
+	sm := getStateManager(r)
+	if sm == nil {
+		return
+	}
+	if event.Actor == "" {
+		if IsAdmin(r) {
+			event.Actor = adminAccountName(r)
+		} else {
+			event.Actor = NewHandlerContext(nil, r, "recordAudit").GetUsername()
+		}
+		if event.Role == "" && event.Actor != "" {
+			event.Role = auditRole(r)
+		}
+	}
+	if event.IP == "" {
+		event.IP = middleware.ClientIP(r)
+	}
+	writeAudit(sm.GetAuditLogStore(), event, err)
+}
+
+// recordSystemAudit appends an action PVMSS took on its own, outside of any request, to the audit log
+func recordSystemAudit(store *state.AuditLogStore, event state.AuditEvent, err error) {
+	if store == nil {
+		return
+	}
+	event.Actor = state.AuditActorSystem
+	event.Role = state.AuditActorSystem
+	writeAudit(store, event, err)
+}
+
+// writeAudit sets the result of event from err and appends it to store, logging a write failure
+func writeAudit(store *state.AuditLogStore, event state.AuditEvent, err error) {
+	if err != nil {
+		event.Result = state.AuditResultFailure
+		event.Error = err.Error()
+	}
+
+	if werr := store.Record(event); werr != nil {
+		logger.Get().Error().Err(werr).
+			Str("action", event.Action).
+			Str("actor", event.Actor).
+			Msg("Failed to record audit event")
+	}
+}
+
+// auditParam shortens a free-text parameter to maxAuditParamLength characters
+func auditParam(s string) string {
+	if runes := []rune(s); len(runes) > maxAuditParamLength {
+		return string(runes[:maxAuditParamLength]) + "…"
+	}
+	return s
+}
+
+// auditLogin records a login. A failed login names the actor it was attempted for; a successful one
+// reads it from the new session.
+func auditLogin(r *http.Request, admin bool, actor string, params map[string]string, err error) {
+	action := state.AuditActionLogin
+	if admin {
+		action = state.AuditActionAdminLogin
+	}
+	recordAudit(r, state.AuditEvent{Action: action, Actor: actor, Params: params}, err)
+}
+
+// auditSettings records a change of the settings: setting names what changed, params the new values
+func auditSettings(r *http.Request, setting string, params map[string]string, err error) {
+	if params == nil {
+		params = make(map[string]string)
+	}
+	params["setting"] = setting
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionSettingsUpdate, Params: params}, err)
+}
+
+// auditRetention returns how long audit events are kept
+func auditRetention(settings *state.AppSettings) time.Duration {
+	days := constants.DefaultAuditRetentionDays
+	if settings != nil && settings.AuditRetention > 0 {
+		days = settings.AuditRetention
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// pruneAuditLog drops the audit events past their retention
+func pruneAuditLog(sm state.StateManager, now time.Time) {
+	n, err := sm.GetAuditLogStore().Prune(now.Add(-auditRetention(sm.GetSettings())))
+	if err != nil {
+		logger.Get().Error().Err(err).Msg("Failed to prune audit log")
+		return
+	}
+	if n > 0 {
+		logger.Get().Info().Int("events", n).Msg("Audit events past their retention dropped")
+	}
+}
+
+var (
+	auditPrunerMu      sync.Mutex
+	auditPrunerStarted bool
+)
+
+// StartAuditPruner starts a non-blocking background goroutine that drops audit events past their
+// retention. Runs every AuditPruneInterval.
+func StartAuditPruner(sm state.StateManager) {
+	auditPrunerMu.Lock()
+	if auditPrunerStarted {
+		auditPrunerMu.Unlock()
+		return
+	}
+	auditPrunerStarted = true
+	auditPrunerMu.Unlock()
+
+	go func() {
+		pruneAuditLog(sm, time.Now())
+
+		ticker := time.NewTicker(constants.AuditPruneInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			pruneAuditLog(sm, now)
+		}
+	}()
+}
+
+// auditFilterFromQuery reads the filters of the audit page and export. Dates are whole days in
+// the server time zone, the until date included.
+func auditFilterFromQuery(q url.Values) (state.AuditFilter, error) {
+	filter := state.AuditFilter{
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Action: strings.TrimSpace(q.Get("action")),
+		Pool:   strings.TrimSpace(q.Get("pool")),
+		Result: strings.TrimSpace(q.Get("result")),
+	}
+	if raw := strings.TrimSpace(q.Get("vmid")); raw != "" {
+		vmid, err := strconv.Atoi(raw)
+		if err != nil || vmid <= 0 {
+			return filter, fmt.Errorf("invalid VM ID '%s'", raw)
+		}
+		filter.VMID = vmid
+	}
+	if raw := strings.TrimSpace(q.Get("since")); raw != "" {
+		since, err := time.ParseInLocation(auditDateFormat, raw, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid start date '%s'", raw)
+		}
+		filter.Since = since
+	}
+	if raw := strings.TrimSpace(q.Get("until")); raw != "" {
+		until, err := time.ParseInLocation(auditDateFormat, raw, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid end date '%s'", raw)
+		}
+		filter.Until = until.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// AuditEventView is an audit event on the admin audit page
+type AuditEventView struct {
+	Time    string
+	Actor   string
+	Role    string
+	IP      string
+	Action  string
+	VMID    int
+	Pool    string
+	Params  string
+	Success bool
+	Error   string
+	UPID    string
+}
+
+// formatAuditParams renders the parameters of an event as sorted key=value pairs
+func formatAuditParams(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+params[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// AuditPageHandler renders the filterable audit log
+func (h *AdminHandler) AuditPageHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("AuditPageHandler", r)
+
+	successMsg := ""
+	if r.URL.Query().Get("success") == "retention" {
+		successMsg = "Audit retention updated"
+	}
+	data := AdminPageDataWithMessage("Audit Log", "audit", successMsg, r.URL.Query().Get("error"))
+
+	filter, err := auditFilterFromQuery(r.URL.Query())
+	if err != nil {
+		data["Error"] = true
+		data["ErrorMessage"] = err.Error()
+		filter = state.AuditFilter{}
+	}
+	filter.Limit = maxDisplayedAuditEvents + 1
+
+	store := h.stateManager.GetAuditLogStore()
+	found := store.Query(filter)
+	data["Truncated"] = len(found) > maxDisplayedAuditEvents
+	if len(found) > maxDisplayedAuditEvents {
+		found = found[:maxDisplayedAuditEvents]
+	}
+
+	events := make([]AuditEventView, 0, len(found))
+	for _, event := range found {
+		events = append(events, AuditEventView{
+			Time:    event.Time.Local().Format(auditTimeFormat),
+			Actor:   event.Actor,
+			Role:    event.Role,
+			IP:      event.IP,
+			Action:  event.Action,
+			VMID:    event.VMID,
+			Pool:    event.Pool,
+			Params:  formatAuditParams(event.Params),
+			Success: event.Result == state.AuditResultSuccess,
+			Error:   event.Error,
+			UPID:    event.UPID,
+		})
+	}
+
+	actors := store.Actors()
+	sort.Strings(actors)
+
+	data["AuditEvents"] = events
+	data["AuditActors"] = actors
+	data["AuditActions"] = auditActions
+	data["Filter"] = map[string]string{
+		"actor":  r.URL.Query().Get("actor"),
+		"action": r.URL.Query().Get("action"),
+		"vmid":   r.URL.Query().Get("vmid"),
+		"pool":   r.URL.Query().Get("pool"),
+		"result": r.URL.Query().Get("result"),
+		"since":  r.URL.Query().Get("since"),
+		"until":  r.URL.Query().Get("until"),
+	}
+	data["FilterQuery"] = template.URL(auditFilterQuery(r.URL.Query()).Encode())
+	data["RetentionDays"] = int(auditRetention(h.stateManager.GetSettings()).Hours() / 24)
+
+	log.Debug().Int("events", len(events)).Msg("Audit page rendered")
+	renderTemplateInternal(w, r, "admin_audit", data)
+}
+
+// auditFilterQuery keeps the filter parameters of a query, for the export links
+func auditFilterQuery(q url.Values) url.Values {
+	out := make(url.Values)
+	for _, key := range []string{"actor", "action", "vmid", "pool", "result", "since", "until"} {
+		if v := strings.TrimSpace(q.Get(key)); v != "" {
+			out.Set(key, v)
+		}
+	}
+	return out
+}
+
+// AuditExportHandler downloads the filtered audit log as CSV or JSON
+func (h *AdminHandler) AuditExportHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("AuditExportHandler", r)
+
+	filter, err := auditFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events := h.stateManager.GetAuditLogStore().Query(filter)
+	name := "pvmss-audit-" + time.Now().Format("20060102-150405")
+
+	w.Header().Set("Cache-Control", "no-store")
+	switch format := r.URL.Query().Get("format"); format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(events); err != nil {
+			log.Error().Err(err).Msg("Failed to write audit export")
+		}
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"time", "actor", "role", "ip", "action", "vmid", "pool", "params", "result", "error", "upid"})
+		for _, event := range events {
+			vmid := ""
+			if event.VMID != 0 {
+				vmid = strconv.Itoa(event.VMID)
+			}
+			_ = cw.Write([]string{
+				event.Time.UTC().Format(time.RFC3339),
+				csvSafe(event.Actor),
+				event.Role,
+				event.IP,
+				event.Action,
+				vmid,
+				csvSafe(event.Pool),
+				csvSafe(formatAuditParams(event.Params)),
+				event.Result,
+				csvSafe(event.Error),
+				event.UPID,
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Error().Err(err).Msg("Failed to write audit export")
+		}
+	default:
+		http.Error(w, "Unsupported export format '"+format+"'", http.StatusBadRequest)
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Int("events", len(events)).Msg("Audit log exported")
+}
+
+// csvSafe stops spreadsheets from evaluating a user-controlled cell as a formula
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// UpdateAuditRetentionHandler saves how many days of audit log are kept and prunes right away
+func (h *AdminHandler) UpdateAuditRetentionHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("UpdateAuditRetentionHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	days, err := strconv.Atoi(strings.TrimSpace(r.FormValue("retention_days")))
+	if err != nil || days <= 0 {
+		http.Redirect(w, r, "/admin/audit?error="+url.QueryEscape("Retention must be a positive number of days"), http.StatusSeeOther)
+		return
+	}
+
+	settings := h.stateManager.GetSettings()
+	if settings == nil {
+		http.Error(w, "Settings not available", http.StatusInternalServerError)
+		return
+	}
+	settings.AuditRetention = days
+
+	err = h.stateManager.SetSettings(settings)
+	auditSettings(r, "audit_retention_days", map[string]string{"days": strconv.Itoa(days)}, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save settings")
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	pruneAuditLog(h.stateManager, time.Now())
+	log.Info().Int("retention_days", days).Msg("Audit retention updated")
+	http.Redirect(w, r, "/admin/audit?success=retention", http.StatusSeeOther)
+}
+
+// RegisterAuditRoutes registers the admin audit log routes
+func (h *AdminHandler) RegisterAuditRoutes(router *httprouter.Router) {
+	routeHelpers := NewAdminPageRoutes()
+
+	routeHelpers.helpers.RegisterAdminRouteWithRedirect(router, "/admin/audit", h.AuditPageHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodGet, "/admin/audit/export", PermissionView, h.AuditExportHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/audit/retention", PermissionManage, h.UpdateAuditRetentionHandler)
+}
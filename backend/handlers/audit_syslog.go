@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pvmss/logger"
+	"pvmss/state"
+	"pvmss/syslog"
+)
+
+// auditSyslogEnterpriseID qualifies the structured-data IDs of the forwarded events. It is the
+// private enterprise number RFC 5612 reserves for documentation, as PVMSS has none of its own.
+const auditSyslogEnterpriseID = "@32473"
+
+// auditSyslogAppName is the APP-NAME of the forwarded events
+const auditSyslogAppName = "pvmss"
+
+// StartAuditSyslog forwards every audit event to the syslog collector of SYSLOG_URL, such as
+// tls://siem.example.com:6514. It returns nil when SYSLOG_URL is not set.
+//
+// SYSLOG_FACILITY selects the facility (authpriv by default), SYSLOG_HOSTNAME the host name sent
+// (the machine name by default) and SYSLOG_TLS_CA_FILE the CA bundle verifying a TLS collector.
+func StartAuditSyslog(sm state.StateManager) (*syslog.Forwarder, error) {
+	rawURL := strings.TrimSpace(os.Getenv("SYSLOG_URL"))
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	facility, err := syslog.ParseFacility("authpriv")
+	if name := os.Getenv("SYSLOG_FACILITY"); name != "" {
+		facility, err = syslog.ParseFacility(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hostname := os.Getenv("SYSLOG_HOSTNAME")
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+
+	opts := syslog.Options{
+		Header: syslog.Header{
+			Facility: facility,
+			Hostname: hostname,
+			AppName:  auditSyslogAppName,
+			ProcID:   strconv.Itoa(os.Getpid()),
+		},
+	}
+	if caFile := os.Getenv("SYSLOG_TLS_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SYSLOG_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in SYSLOG_TLS_CA_FILE %s", caFile)
+		}
+		opts.TLSConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	forwarder, err := syslog.New(rawURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	sm.GetAuditLogStore().SetForwarder(func(event state.AuditEvent) {
+		forwarder.Send(auditSyslogMessage(event))
+	})
+
+	logger.Get().Info().Str("collector", forwarder.Addr()).Msg("Audit events are forwarded to syslog")
+	return forwarder, nil
+}
+
+// auditSyslogMessage turns an audit event into a syslog message: the action is the MSGID, and the
+// actor, target and result are structured-data elements. Failures are warnings, the rest notices.
+func auditSyslogMessage(event state.AuditEvent) syslog.Message {
+	severity := syslog.SeverityNotice
+	if event.Result != state.AuditResultSuccess {
+		severity = syslog.SeverityWarning
+	}
+
+	actor := syslog.Element{ID: "actor" + auditSyslogEnterpriseID}
+	target := syslog.Element{ID: "target" + auditSyslogEnterpriseID}
+	result := syslog.Element{ID: "result" + auditSyslogEnterpriseID}
+	add := func(el *syslog.Element, name, value string) {
+		if value != "" {
+			el.Params = append(el.Params, syslog.Param{Name: name, Value: value})
+		}
+	}
+
+	add(&actor, "name", event.Actor)
+	add(&actor, "role", event.Role)
+	add(&actor, "ip", event.IP)
+	if event.VMID != 0 {
+		add(&target, "vmid", strconv.Itoa(event.VMID))
+	}
+	add(&target, "pool", event.Pool)
+	keys := make([]string, 0, len(event.Params))
+	for k := range event.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(&target, k, event.Params[k])
+	}
+	add(&result, "status", event.Result)
+	add(&result, "error", event.Error)
+	add(&result, "upid", event.UPID)
+
+	data := make([]syslog.Element, 0, 3)
+	for _, el := range []syslog.Element{actor, target, result} {
+		if len(el.Params) > 0 {
+			data = append(data, el)
+		}
+	}
+
+	text := fmt.Sprintf("%s %s", event.Actor, event.Action)
+	if event.VMID != 0 {
+		text += fmt.Sprintf(" on VM %d", event.VMID)
+	} else if event.Pool != "" {
+		text += " on pool " + event.Pool
+	}
+	text += ": " + event.Result
+	if event.Error != "" {
+		text += " (" + event.Error + ")"
+	}
+
+	return syslog.Message{
+		Time:     event.Time,
+		Severity: severity,
+		MsgID:    event.Action,
+		Data:     data,
+		Text:     text,
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/state"
+	"pvmss/syslog"
+)
+
+func TestAuditLogStorePersistsAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	store, err := state.NewAuditLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	recent := old.AddDate(0, 6, 0)
+	for _, event := range []state.AuditEvent{
+		{Time: old, Actor: "alice", Action: "vm.start", VMID: 100},
+		{Time: recent, Actor: "admin", Action: state.AuditActionPoolDelete, Pool: "pvmss_bob", Result: state.AuditResultFailure},
+	} {
+		if err := store.Record(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A line cut short by a crash does not lose the rest of the trail
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"time":"2026-07-01T`)
+	_ = f.Close()
+
+	reopened, err := state.NewAuditLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Query(state.AuditFilter{}); len(got) != 2 || got[0].Actor != "admin" || got[1].Result != state.AuditResultSuccess {
+		t.Fatalf("Expected both events newest first, got %+v", got)
+	}
+	if got := reopened.Query(state.AuditFilter{Action: "vm."}); len(got) != 1 || got[0].VMID != 100 {
+		t.Errorf("Expected the action prefix to select the VM event, got %+v", got)
+	}
+	if got := reopened.Query(state.AuditFilter{Since: old.Add(time.Hour), Result: state.AuditResultFailure}); len(got) != 1 {
+		t.Errorf("Expected the date and result filters to select the pool event, got %+v", got)
+	}
+
+	n, err := reopened.Prune(recent.Add(-time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one event pruned, got %d (%v)", n, err)
+	}
+	reopened, _ = state.NewAuditLogStore(path)
+	if got := reopened.Query(state.AuditFilter{}); len(got) != 1 || got[0].Pool != "pvmss_bob" {
+		t.Errorf("Expected only the recent event to survive pruning, got %+v", got)
+	}
+}
+
+func TestAuditVMHandlers(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	h := &VMHandler{stateManager: sm}
+
+	form := func(target string, values url.Values) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+
+	serveAs(sm, "alice", false, h.VMActionHandler, form("/vm/action", url.Values{"vmid": {"100"}, "node": {"pve1"}, "action": {"start"}}), nil)
+	serveAs(sm, "alice", false, h.UpdateVMTagsHandler, form("/vm/update/tags", url.Values{"vmid": {"100"}, "node": {"pve1"}, "tags": {"pvmss", "web"}}), nil)
+	// Refused for ownership before anything happens, so not audited
+	serveAs(sm, "alice", false, h.VMActionHandler, form("/vm/action", url.Values{"vmid": {"200"}, "node": {"pve1"}, "action": {"stop"}}), nil)
+
+	events := sm.GetAuditLogStore().Query(state.AuditFilter{Actor: "alice"})
+	if len(events) != 2 {
+		t.Fatalf("Expected two audited actions, got %+v", events)
+	}
+	tags, start := events[0], events[1]
+	if start.Action != "vm.start" || start.VMID != 100 || start.Role != auditRoleUser || start.UPID == "" || start.Result != state.AuditResultSuccess || start.IP == "" {
+		t.Errorf("Unexpected audit event for the start action: %+v", start)
+	}
+	if tags.Action != state.AuditActionVMTags || tags.Params["tags"] != "pvmss,web" {
+		t.Errorf("Unexpected audit event for the tag change: %+v", tags)
+	}
+}
+
+func TestAuditAccountChanges(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	h := &AdminHandler{stateManager: sm}
+	if err := sm.GetAdminAccountStore().Create("root-admin", testAdminPassword, state.AdminRoleFull); err != nil {
+		t.Fatal(err)
+	}
+	cookies := adminSessionCookies(sm, "root-admin")
+
+	post := func(handler httprouter.Handle, target string, values url.Values) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		serveWithCookies(sm, handler, req, cookies)
+	}
+	post(h.CreateAccountHandler, "/admin/accounts/create", url.Values{
+		"username": {"dave"}, "password": {testAdminPassword}, "confirm_password": {testAdminPassword}, "role": {state.AdminRoleHelpdesk},
+	})
+	post(h.AccountRoleHandler, "/admin/accounts/role", url.Values{"username": {"dave"}, "role": {"unknown"}})
+
+	events := sm.GetAuditLogStore().Query(state.AuditFilter{Actor: "root-admin"})
+	if len(events) != 2 {
+		t.Fatalf("Expected two audited account changes, got %+v", events)
+	}
+	role, create := events[0], events[1]
+	if create.Action != state.AuditActionAccountCreate || create.Params["account"] != "dave" || create.Result != state.AuditResultSuccess {
+		t.Errorf("Unexpected audit event for the account creation: %+v", create)
+	}
+	if role.Action != state.AuditActionAccountRole || role.Result != state.AuditResultFailure || role.Error == "" {
+		t.Errorf("Expected the refused role change to be audited as a failure, got %+v", role)
+	}
+}
+
+func TestAuditExport(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	store := sm.GetAuditLogStore()
+	_ = store.Record(state.AuditEvent{Actor: "alice", Role: auditRoleUser, Action: state.AuditActionVMDescription, VMID: 100,
+		Params: map[string]string{"description": "web"}, Result: state.AuditResultFailure, Error: "=HYPERLINK(\"http://evil\")"})
+	_ = store.Record(state.AuditEvent{Actor: "bob", Role: auditRoleUser, Action: state.AuditActionLogin})
+
+	h := &AdminHandler{stateManager: sm}
+	rec := serveAs(sm, "", true, h.AuditExportHandler, httptest.NewRequest(http.MethodGet, "/admin/audit/export?format=csv&actor=alice", nil), nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected a CSV download, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][1] != "alice" || rows[1][5] != "100" {
+		t.Fatalf("Expected the header and the event of alice, got %q", rows)
+	}
+	if rows[1][7] != "description=web" || !strings.HasPrefix(rows[1][9], "'=") {
+		t.Errorf("Expected the parameters in one cell and the formula neutralised, got %q", rows[1])
+	}
+
+	rec = serveAs(sm, "", true, h.AuditExportHandler, httptest.NewRequest(http.MethodGet, "/admin/audit/export?format=xml", nil), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown format to be refused, got %d", rec.Code)
+	}
+}
+
+func TestAuditSyslogMessage(t *testing.T) {
+	event := state.AuditEvent{
+		Time:   time.Date(2026, 5, 1, 8, 0, 0, 0, time.UTC),
+		Actor:  "alice",
+		Role:   auditRoleUser,
+		IP:     "192.0.2.1",
+		Action: state.AuditActionVMConsole,
+		VMID:   100,
+		Params: map[string]string{"node": "pve1"},
+		Result: state.AuditResultFailure,
+		Error:  "access denied",
+	}
+	got := string(syslog.Format(syslog.Header{Facility: 10, Hostname: "pvmss", AppName: auditSyslogAppName}, auditSyslogMessage(event)))
+	want := `<84>1 2026-05-01T08:00:00.000000Z pvmss pvmss - vm.console ` +
+		`[actor@32473 name="alice" role="user" ip="192.0.2.1"][target@32473 vmid="100" node="pve1"]` +
+		`[result@32473 status="failure" error="access denied"] alice vm.console on VM 100: failure (access denied)`
+	if got != want {
+		t.Errorf("Unexpected syslog message:\n got %s\nwant %s", got, want)
+	}
+}
@@ -12,8 +12,10 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"golang.org/x/crypto/bcrypt"
 
+	"pvmss/constants"
 	"pvmss/i18n"
 	"pvmss/logger"
+	"pvmss/oidc"
 	"pvmss/proxmox"
 	"pvmss/security"
 	"pvmss/state"
@@ -42,6 +44,7 @@ func setLanguageCookieAndRedirect(w http.ResponseWriter, r *http.Request, baseUR
 // AuthHandler handles authentication routes
 type AuthHandler struct {
 	stateManager state.StateManager
+	oidc         *oidc.Provider // nil when single sign-on is not configured
 }
 
 // LogoutGet handles GET requests to /logout by redirecting to POST /logout.
@@ -79,7 +82,12 @@ func (h *AuthHandler) LogoutGet(w http.ResponseWriter, r *http.Request, _ httpro
 
 // NewAuthHandler creates a new instance of AuthHandler
 func NewAuthHandler(sm state.StateManager) *AuthHandler {
-	return &AuthHandler{stateManager: sm}
+	h := &AuthHandler{stateManager: sm}
+	if cfg := oidc.ConfigFromEnv(); cfg != nil {
+		h.oidc = oidc.NewProvider(*cfg, nil)
+		logger.Get().Info().Str("issuer", cfg.IssuerURL).Msg("OIDC single sign-on enabled")
+	}
+	return h
 }
 
 // RedirectIfAuthenticated is middleware that redirects authenticated users away from login page
@@ -110,6 +118,11 @@ func (h *AuthHandler) RegisterRoutes(router *httprouter.Router) {
 	// Admin login routes
 	router.GET("/admin/login", h.ShowAdminLoginForm)
 	router.POST("/admin/login", h.handleAdminLogin)
+	router.POST("/admin/login/verify", h.handleAdminSecondFactor)
+
+	// OIDC single sign-on routes
+	router.GET("/auth/oidc/login", h.handleOIDCLogin)
+	router.GET("/auth/oidc/callback", h.handleOIDCCallback)
 
 	// Logout routes
 	router.GET("/logout", h.LogoutGet)
@@ -179,12 +192,15 @@ func (h *AuthHandler) renderAdminLoginForm(w http.ResponseWriter, r *http.Reques
 
 	// Prepare template data
 	data := map[string]interface{}{
-		"Title":       "Admin Login",
-		"Error":       errorMsg,
-		"CSRFToken":   csrfToken,
-		"RedirectURL": r.URL.Query().Get("redirect"),
-		"ReturnURL":   r.URL.Query().Get("return"),
-		"Lang":        i18n.GetLanguage(r),
+		"Title":            "Admin Login",
+		"Error":            errorMsg,
+		"CSRFToken":        csrfToken,
+		"RedirectURL":      r.URL.Query().Get("redirect"),
+		"ReturnURL":        r.URL.Query().Get("return"),
+		"Lang":             i18n.GetLanguage(r),
+		"OIDCEnabled":      h.OIDCEnabled(),
+		"BootstrapEnabled": !h.stateManager.GetAdminAccountStore().BootstrapDisabled(),
+		"Step":             "",
 	}
 
 	ctx.RenderTemplate("admin_login", data)
@@ -219,7 +235,8 @@ func validateCSRF(r *http.Request) error {
 	return nil
 }
 
-// handleAdminLogin handles admin login form submission (password-only)
+// handleAdminLogin handles the first step of the admin login: the password of the bootstrap
+// account, or the username and password of a named account, which then needs a TOTP code
 func (h *AuthHandler) handleAdminLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	ctx := NewHandlerContext(w, r, "AuthHandler.handleAdminLogin")
 
@@ -236,15 +253,8 @@ func (h *AuthHandler) handleAdminLogin(w http.ResponseWriter, r *http.Request, _
 		return
 	}
 
-	// Get admin password hash from environment
-	adminHash := os.Getenv("ADMIN_PASSWORD_HASH")
-	if adminHash == "" {
-		ctx.Log.Error().Msg("ADMIN_PASSWORD_HASH is not set in environment variables")
-		http.Error(w, "Server configuration error", http.StatusInternalServerError)
-		return
-	}
-
-	// Get password from form
+	// Get username and password from form; no username means the bootstrap account
+	username := state.NormalizeAdminUsername(r.FormValue("username"))
 	password := r.FormValue("password")
 	if password == "" {
 		ctx.Log.Debug().Msg("Admin login attempt with empty password")
@@ -259,8 +269,41 @@ func (h *AuthHandler) handleAdminLogin(w http.ResponseWriter, r *http.Request, _
 		return
 	}
 
+	store := h.stateManager.GetAdminAccountStore()
+	if username != "" && username != state.BootstrapAdminUsername {
+		account, err := store.Authenticate(username, password)
+		if err != nil {
+			auditLogin(r, true, username, nil, errAuditInvalidCredentials)
+			ctx.Log.Info().Str("admin_account", username).Msg("Admin login failed - invalid credentials")
+			h.renderAdminLoginForm(w, r, "Invalid credentials.")
+			return
+		}
+		h.beginAdminSecondFactor(w, r, account)
+		return
+	}
+
+	if store.BootstrapDisabled() {
+		ctx.Log.Warn().Msg("Admin login failed - the bootstrap account is disabled")
+		h.renderAdminLoginForm(w, r, "Invalid credentials.")
+		return
+	}
+
+	// Get admin password hash from environment
+	adminHash := os.Getenv("ADMIN_PASSWORD_HASH")
+	if adminHash == "" {
+		if len(store.List()) > 0 {
+			ctx.Log.Info().Msg("Admin login failed - ADMIN_PASSWORD_HASH is not set")
+			h.renderAdminLoginForm(w, r, "Invalid credentials.")
+			return
+		}
+		ctx.Log.Error().Msg("ADMIN_PASSWORD_HASH is not set in environment variables")
+		http.Error(w, "Server configuration error", http.StatusInternalServerError)
+		return
+	}
+
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(adminHash), []byte(password)); err != nil {
+		auditLogin(r, true, state.BootstrapAdminUsername, nil, errAuditInvalidCredentials)
 		ctx.Log.Info().Err(err).Msg("Admin login failed - incorrect password")
 		h.renderAdminLoginForm(w, r, "Invalid credentials.")
 		return
@@ -268,10 +311,11 @@ func (h *AuthHandler) handleAdminLogin(w http.ResponseWriter, r *http.Request, _
 
 	ctx.Log.Debug().Msg("Admin authentication successful, creating session")
 
-	if err := establishSession(w, r, true, ""); err != nil {
+	if err := establishAdminSession(w, r, state.BootstrapAdminUsername); err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	auditLogin(r, true, "", nil, nil)
 
 	// Persist language selection in cookie and append to redirect
 	redirectURL := getRedirectURL(r, "/admin/nodes")
@@ -305,9 +349,16 @@ func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request, _ http
 		return
 	}
 
-	// Get username and password from form
-	username := r.FormValue("username")
+	// Get username, password and realm from form; a user@realm username overrides the selected realm
+	username := strings.TrimSpace(r.FormValue("username"))
 	password := r.FormValue("password")
+	realm := strings.TrimSpace(r.FormValue("realm"))
+	if strings.Contains(username, "@") {
+		username, realm = splitUserID(username)
+	}
+	if realm == "" {
+		realm = constants.DefaultLoginRealm
+	}
 
 	if username == "" || password == "" {
 		log.Debug().Msg("User login attempt with empty username or password")
@@ -322,6 +373,13 @@ func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request, _ http
 		return
 	}
 
+	if !isLoginRealmAllowed(h.stateManager.GetSettings(), realm) {
+		auditLogin(r, false, sessionUsername(username, realm), map[string]string{"method": "password", "realm": realm}, errAuditRealmNotAllowed)
+		log.Warn().Str("username", username).Str("realm", realm).Msg("User login attempt with a realm that is not allowed")
+		h.renderLoginForm(w, r, "Invalid credentials.")
+		return
+	}
+
 	// Create a new Proxmox client for user authentication
 	proxmoxURL := strings.TrimSpace(os.Getenv("PROXMOX_URL"))
 	insecureSkip := strings.TrimSpace(os.Getenv("PROXMOX_VERIFY_SSL")) == "false"
@@ -340,16 +398,17 @@ func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request, _ http
 		return
 	}
 
-	// Attempt to authenticate user via Proxmox with "@pve" realm
+	// Attempt to authenticate user via Proxmox in the selected realm
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
 	// Use CreateTicket to get the authentication ticket with full response
 	ticketResp, err := proxmox.CreateTicket(ctx, pxClient, username, password, &proxmox.CreateTicketOptions{
-		Realm: "pve",
+		Realm: realm,
 	})
 	if err != nil {
-		log.Info().Err(err).Str("username", username).Msg("User login failed - Proxmox authentication failed")
+		auditLogin(r, false, sessionUsername(username, realm), map[string]string{"method": "password", "realm": realm}, errAuditInvalidCredentials)
+		log.Info().Err(err).Str("username", username).Str("realm", realm).Msg("User login failed - Proxmox authentication failed")
 		h.renderLoginForm(w, r, "Invalid credentials.")
 		return
 	}
@@ -361,10 +420,11 @@ func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request, _ http
 		Msg("User authentication successful via Proxmox, creating session")
 
 	// Establish session and store Proxmox ticket for later use (console access, API calls)
-	if err := establishSessionWithTicket(w, r, false, username, ticketResp); err != nil {
+	if err := establishSessionWithTicket(w, r, false, sessionUsername(username, realm), ticketResp); err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	auditLogin(r, false, "", map[string]string{"method": "password", "realm": realm}, nil)
 
 	// Persist language selection in cookie and append to redirect
 	redirectURL := getRedirectURL(r, "/vm/create")
@@ -398,6 +458,10 @@ func establishSessionWithTicket(_ http.ResponseWriter, r *http.Request, isAdmin
 	if username != "" {
 		sessionManager.Put(r.Context(), "username", username)
 	}
+	if err := trackSession(r, sessionManager); err != nil {
+		log.Error().Err(err).Msg("Failed to track session")
+		return fmt.Errorf("internal server error")
+	}
 
 	// Store Proxmox ticket if provided (for console access and API operations)
 	if ticket != nil {
@@ -518,15 +582,23 @@ func (h *AuthHandler) renderLoginForm(w http.ResponseWriter, r *http.Request, er
 		warning = ctx.Translate(warningKey)
 	}
 
+	selectedRealm := r.FormValue("realm")
+	if selectedRealm == "" {
+		selectedRealm = constants.DefaultLoginRealm
+	}
+
 	// Prepare template data with CSRF token
 	data := map[string]interface{}{
-		"Title":       "Login",
-		"Error":       errorMsg,
-		"Warning":     warning,
-		"CSRFToken":   csrfToken,
-		"RedirectURL": r.URL.Query().Get("redirect"),
-		"ReturnURL":   r.URL.Query().Get("return"),
-		"Lang":        i18n.GetLanguage(r),
+		"Title":         "Login",
+		"Error":         errorMsg,
+		"Warning":       warning,
+		"CSRFToken":     csrfToken,
+		"RedirectURL":   r.URL.Query().Get("redirect"),
+		"ReturnURL":     r.URL.Query().Get("return"),
+		"Lang":          i18n.GetLanguage(r),
+		"OIDCEnabled":   h.OIDCEnabled(),
+		"Realms":        loginRealmOptions(r.Context(), h.stateManager),
+		"SelectedRealm": selectedRealm,
 	}
 
 	ctx.RenderTemplate("login", data)
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/i18n"
+	"pvmss/qrcode"
+	"pvmss/security"
+	"pvmss/state"
+	"pvmss/totp"
+)
+
+// totpIssuer names PVMSS in authenticator apps
+const totpIssuer = "PVMSS"
+
+// establishAdminSession opens an admin session for an administrator account
+func establishAdminSession(w http.ResponseWriter, r *http.Request, account string) error {
+	if err := establishSession(w, r, true, ""); err != nil {
+		return err
+	}
+	security.GetSession(r).Put(r.Context(), constants.SessionKeyAdminUsername, account)
+	return nil
+}
+
+// adminAccountName returns the administrator account of the session, or the username of an
+// administrator signed in with single sign-on
+func adminAccountName(r *http.Request) string {
+	sessionManager := security.GetSession(r)
+	if sessionManager == nil {
+		return ""
+	}
+	if account := sessionManager.GetString(r.Context(), constants.SessionKeyAdminUsername); account != "" {
+		return account
+	}
+	return sessionManager.GetString(r.Context(), constants.SessionKeyUsername)
+}
+
+// clearAdminPending forgets the login waiting for its second factor
+func clearAdminPending(r *http.Request) {
+	sessionManager := security.GetSession(r)
+	for _, key := range []string{
+		constants.SessionKeyAdminPending,
+		constants.SessionKeyAdminPendingAt,
+		constants.SessionKeyAdminPendingReturn,
+		constants.SessionKeyAdminPendingAttempts,
+	} {
+		sessionManager.Remove(r.Context(), key)
+	}
+}
+
+// pendingAdmin returns the account whose password was verified, unless the second step timed out
+func pendingAdmin(r *http.Request) (string, bool) {
+	sessionManager := security.GetSession(r)
+	username := sessionManager.GetString(r.Context(), constants.SessionKeyAdminPending)
+	verifiedAt := time.Unix(sessionManager.GetInt64(r.Context(), constants.SessionKeyAdminPendingAt), 0)
+	if username == "" || time.Since(verifiedAt) > constants.AdminSecondFactorTimeout {
+		clearAdminPending(r)
+		return "", false
+	}
+	return username, true
+}
+
+// beginAdminSecondFactor remembers an account whose password was verified and asks for its TOTP
+// code. The session only becomes an admin session once the code is checked.
+func (h *AuthHandler) beginAdminSecondFactor(w http.ResponseWriter, r *http.Request, account state.AdminAccount) {
+	ctx := NewHandlerContext(w, r, "AuthHandler.beginAdminSecondFactor")
+
+	sessionManager := security.GetSession(r)
+	if err := sessionManager.RenewToken(r.Context()); err != nil {
+		ctx.HandleError(err, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	sessionManager.Put(r.Context(), constants.SessionKeyAdminPending, account.Username)
+	sessionManager.Put(r.Context(), constants.SessionKeyAdminPendingAt, time.Now().Unix())
+	sessionManager.Put(r.Context(), constants.SessionKeyAdminPendingReturn, getRedirectURL(r, "/admin/nodes"))
+	sessionManager.Remove(r.Context(), constants.SessionKeyAdminPendingAttempts)
+
+	ctx.Log.Info().
+		Str("admin_account", account.Username).
+		Bool("totp_enrolled", account.TOTPEnrolled()).
+		Msg("Admin password verified, waiting for the second factor")
+	h.renderAdminSecondFactor(w, r, account.Username, "")
+}
+
+// renderAdminSecondFactor asks for a TOTP code, or shows the QR code of a new authenticator when
+// the account has not enrolled yet
+func (h *AuthHandler) renderAdminSecondFactor(w http.ResponseWriter, r *http.Request, username, errorMsg string) {
+	ctx := NewHandlerContext(w, r, "AuthHandler.renderAdminSecondFactor")
+
+	csrfToken, err := ctx.GetCSRFToken()
+	if err != nil {
+		ctx.HandleError(err, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{
+		"Title":        "Admin Login",
+		"Error":        errorMsg,
+		"CSRFToken":    csrfToken,
+		"Lang":         i18n.GetLanguage(r),
+		"AdminAccount": username,
+		"Step":         "verify",
+	}
+
+	store := h.stateManager.GetAdminAccountStore()
+	if account, ok := store.Get(username); ok && !account.TOTPEnrolled() {
+		secret, err := store.BeginTOTPEnrollment(username)
+		if err != nil {
+			ctx.HandleError(err, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		code, err := qrcode.Encode(totp.URI(totpIssuer, username, secret))
+		if err != nil {
+			ctx.HandleError(err, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data["Step"] = "enroll"
+		data["TOTPSecret"] = groupSecret(secret)
+		// The SVG is generated from the secret only, never from user input
+		data["TOTPQRCode"] = template.HTML(code.SVG(4))
+	}
+
+	ctx.RenderTemplate("admin_login", data)
+}
+
+// groupSecret splits a base32 secret in groups of four characters, for typing it by hand
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
+
+// handleAdminSecondFactor handles the second step of a named admin login: a TOTP code or a
+// recovery code, or the first code of a new authenticator, which also issues the recovery codes
+func (h *AuthHandler) handleAdminSecondFactor(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "AuthHandler.handleAdminSecondFactor")
+
+	if !ctx.ValidateSessionManager() {
+		return
+	}
+	if err := validateCSRF(r); err != nil {
+		h.renderAdminLoginForm(w, r, "Invalid request. Please try again.")
+		return
+	}
+
+	username, ok := pendingAdmin(r)
+	if !ok {
+		h.renderAdminLoginForm(w, r, "Session expired. Please try again.")
+		return
+	}
+	store := h.stateManager.GetAdminAccountStore()
+	account, ok := store.Get(username)
+	if !ok || account.Disabled {
+		clearAdminPending(r)
+		h.renderAdminLoginForm(w, r, "Invalid credentials.")
+		return
+	}
+
+	code := r.FormValue("code")
+	now := time.Now()
+	if !account.TOTPEnrolled() {
+		recoveryCodes, err := store.ConfirmTOTPEnrollment(username, code, now)
+		if err != nil {
+			h.failAdminSecondFactor(w, r, username, err)
+			return
+		}
+		returnURL, ok := h.completeAdminLogin(w, r, username)
+		if !ok {
+			return
+		}
+
+		csrfToken, _ := ctx.GetCSRFToken()
+		ctx.RenderTemplate("admin_login", map[string]interface{}{
+			"Title":         "Admin Login",
+			"CSRFToken":     csrfToken,
+			"Lang":          i18n.GetLanguage(r),
+			"AdminAccount":  username,
+			"Step":          "recovery",
+			"RecoveryCodes": recoveryCodes,
+			"ContinueURL":   returnURL,
+		})
+		return
+	}
+
+	usedRecovery, err := store.VerifySecondFactor(username, code, now)
+	if err != nil {
+		h.failAdminSecondFactor(w, r, username, err)
+		return
+	}
+	if usedRecovery {
+		remaining, _ := store.Get(username)
+		ctx.Log.Warn().Str("admin_account", username).Int("recovery_codes_left", len(remaining.RecoveryCodes)).Msg("Admin signed in with a recovery code")
+	}
+	returnURL, ok := h.completeAdminLogin(w, r, username)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, returnURL, http.StatusSeeOther)
+}
+
+// failAdminSecondFactor asks for the code again, or for the password after too many wrong codes
+func (h *AuthHandler) failAdminSecondFactor(w http.ResponseWriter, r *http.Request, username string, err error) {
+	log := CreateHandlerLogger("AuthHandler.failAdminSecondFactor", r)
+	if !errors.Is(err, state.ErrAdminInvalidCode) {
+		log.Error().Err(err).Str("admin_account", username).Msg("Second factor check failed")
+	}
+	auditLogin(r, true, username, map[string]string{"step": "second_factor"}, err)
+
+	sessionManager := security.GetSession(r)
+	attempts := sessionManager.GetInt(r.Context(), constants.SessionKeyAdminPendingAttempts) + 1
+	if attempts >= constants.AdminSecondFactorMaxAttempts {
+		clearAdminPending(r)
+		log.Warn().Str("admin_account", username).Int("attempts", attempts).Msg("Admin login abandoned after too many invalid codes")
+		h.renderAdminLoginForm(w, r, "Too many invalid codes. Please sign in again.")
+		return
+	}
+	sessionManager.Put(r.Context(), constants.SessionKeyAdminPendingAttempts, attempts)
+
+	log.Info().Str("admin_account", username).Int("attempts", attempts).Msg("Admin login failed - invalid verification code")
+	h.renderAdminSecondFactor(w, r, username, "Invalid verification code.")
+}
+
+// completeAdminLogin opens the admin session of a pending account and returns the page to open
+func (h *AuthHandler) completeAdminLogin(w http.ResponseWriter, r *http.Request, username string) (string, bool) {
+	log := CreateHandlerLogger("AuthHandler.completeAdminLogin", r)
+
+	returnURL := "/admin/nodes"
+	if pending := security.GetSession(r).GetString(r.Context(), constants.SessionKeyAdminPendingReturn); pending != "" {
+		returnURL = ensureLocalPath(pending)
+	}
+	clearAdminPending(r)
+
+	if err := establishAdminSession(w, r, username); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+	auditLogin(r, true, "", nil, nil)
+	if err := h.stateManager.GetAdminAccountStore().RecordLogin(username, time.Now()); err != nil {
+		log.Warn().Err(err).Str("admin_account", username).Msg("Failed to record the admin login")
+	}
+
+	log.Info().Str("admin_account", username).Msg("Admin authentication successful")
+	return setLanguageCookieAndRedirect(w, r, returnURL), true
+}
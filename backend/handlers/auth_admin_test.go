@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+
+	"pvmss/constants"
+	"pvmss/state"
+	"pvmss/totp"
+)
+
+// adminLoginSession is a browser going through the admin login, with its session cookies
+type adminLoginSession struct {
+	h       *AuthHandler
+	sm      *fakeStateManager
+	cookies []*http.Cookie
+}
+
+func newAdminLoginSession(t *testing.T) *adminLoginSession {
+	t.Helper()
+	sm, _ := newAPITestState(nil)
+	s := &adminLoginSession{h: &AuthHandler{stateManager: sm}, sm: sm}
+	s.reset()
+	return s
+}
+
+// reset starts over with a new session, as another browser would
+func (s *adminLoginSession) reset() {
+	rec := serveWithCookies(s.sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s.sm.sessions.Put(r.Context(), "csrf_token", "test-csrf")
+	}, httptest.NewRequest(http.MethodGet, "/admin/login", nil), nil)
+	s.cookies = rec.Result().Cookies()
+}
+
+// post submits a login form and keeps the renewed session cookie
+func (s *adminLoginSession) post(handler httprouter.Handle, path string, form url.Values) *httptest.ResponseRecorder {
+	form.Set("csrf_token", "test-csrf")
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := serveWithCookies(s.sm, handler, req, s.cookies)
+	if cookies := rec.Result().Cookies(); len(cookies) > 0 {
+		s.cookies = cookies
+	}
+	return rec
+}
+
+func (s *adminLoginSession) login(username, password string) *httptest.ResponseRecorder {
+	return s.post(s.h.handleAdminLogin, "/admin/login", url.Values{"username": {username}, "password": {password}})
+}
+
+func (s *adminLoginSession) verify(code string) *httptest.ResponseRecorder {
+	return s.post(s.h.handleAdminSecondFactor, "/admin/login/verify", url.Values{"code": {code}})
+}
+
+// session returns whether the session is an admin one, its admin account and its pending account
+func (s *adminLoginSession) session() (isAdmin bool, account, pending string) {
+	serveWithCookies(s.sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		isAdmin = s.sm.sessions.GetBool(r.Context(), "is_admin")
+		account = s.sm.sessions.GetString(r.Context(), constants.SessionKeyAdminUsername)
+		pending = s.sm.sessions.GetString(r.Context(), constants.SessionKeyAdminPending)
+	}, httptest.NewRequest(http.MethodGet, "/admin/nodes", nil), s.cookies)
+	return isAdmin, account, pending
+}
+
+const testAdminPassword = "correct horse battery"
+
+func TestAdminLoginEnrollsTOTP(t *testing.T) {
+	s := newAdminLoginSession(t)
+	store := s.sm.GetAdminAccountStore()
+	if err := store.Create("alice", testAdminPassword, state.AdminRoleFull); err != nil {
+		t.Fatal(err)
+	}
+
+	s.login("alice", "wrong password")
+	if isAdmin, _, pending := s.session(); isAdmin || pending != "" {
+		t.Fatal("Expected a wrong password to be refused")
+	}
+
+	s.login("alice", testAdminPassword)
+	if isAdmin, _, pending := s.session(); isAdmin || pending != "alice" {
+		t.Fatalf("Expected the password to wait for the second factor, got admin=%v pending=%q", isAdmin, pending)
+	}
+	account, _ := store.Get("alice")
+	if account.PendingTOTP == "" {
+		t.Fatal("Expected an authenticator to be offered for enrollment")
+	}
+
+	code, _ := totp.Code(account.PendingTOTP, time.Now())
+	s.verify(code)
+	if isAdmin, name, pending := s.session(); !isAdmin || name != "alice" || pending != "" {
+		t.Fatalf("Expected an admin session for alice, got admin=%v account=%q pending=%q", isAdmin, name, pending)
+	}
+	account, _ = store.Get("alice")
+	if !account.TOTPEnrolled() || len(account.RecoveryCodes) != constants.AdminRecoveryCodeCount || account.LastLogin == nil {
+		t.Errorf("Expected enrollment to issue recovery codes and record the login, got %+v", account)
+	}
+
+	// The code that confirmed the enrollment cannot sign in again
+	s.reset()
+	s.login("alice", testAdminPassword)
+	s.verify(code)
+	if isAdmin, _, pending := s.session(); isAdmin || pending != "alice" {
+		t.Errorf("Expected a replayed code to be refused, got admin=%v pending=%q", isAdmin, pending)
+	}
+}
+
+func TestAdminLoginRecoveryCode(t *testing.T) {
+	s := newAdminLoginSession(t)
+	store := s.sm.GetAdminAccountStore()
+	if err := store.Create("bob", testAdminPassword, state.AdminRoleFull); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := store.BeginTOTPEnrollment("bob")
+	code, _ := totp.Code(secret, time.Now())
+	codes, err := store.ConfirmTOTPEnrollment("bob", code, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.login("bob", testAdminPassword)
+	s.verify(strings.ToUpper(codes[0]))
+	if isAdmin, name, _ := s.session(); !isAdmin || name != "bob" {
+		t.Fatalf("Expected a recovery code to sign in, got admin=%v account=%q", isAdmin, name)
+	}
+
+	s.reset()
+	s.login("bob", testAdminPassword)
+	s.verify(codes[0])
+	if isAdmin, _, _ := s.session(); isAdmin {
+		t.Error("Expected a used recovery code to be refused")
+	}
+}
+
+func TestAdminLoginTooManyCodes(t *testing.T) {
+	s := newAdminLoginSession(t)
+	store := s.sm.GetAdminAccountStore()
+	if err := store.Create("carol", testAdminPassword, state.AdminRoleFull); err != nil {
+		t.Fatal(err)
+	}
+
+	s.login("carol", testAdminPassword)
+	for i := 0; i < constants.AdminSecondFactorMaxAttempts; i++ {
+		s.verify("000000")
+	}
+	if isAdmin, _, pending := s.session(); isAdmin || pending != "" {
+		t.Errorf("Expected the login to be abandoned after too many codes, got admin=%v pending=%q", isAdmin, pending)
+	}
+}
+
+func TestAdminLoginBootstrapAccount(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("bootstrap secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADMIN_PASSWORD_HASH", string(hash))
+
+	s := newAdminLoginSession(t)
+	rec := s.login("", "bootstrap secret")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/nodes" {
+		t.Fatalf("Expected the bootstrap password to sign in, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if isAdmin, name, _ := s.session(); !isAdmin || name != state.BootstrapAdminUsername {
+		t.Fatalf("Expected an admin session for the bootstrap account, got admin=%v account=%q", isAdmin, name)
+	}
+
+	// The bootstrap account stays until a named account can sign in
+	store := s.sm.GetAdminAccountStore()
+	if err := store.SetBootstrapDisabled(true); err != state.ErrAdminLastAccount {
+		t.Fatalf("Expected disabling the only usable account to be refused, got %v", err)
+	}
+	if err := store.Create("dave", testAdminPassword, state.AdminRoleFull); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := store.BeginTOTPEnrollment("dave")
+	code, _ := totp.Code(secret, time.Now())
+	if _, err := store.ConfirmTOTPEnrollment("dave", code, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetBootstrapDisabled(true); err != nil {
+		t.Fatal(err)
+	}
+
+	s.reset()
+	if rec := s.login("", "bootstrap secret"); rec.Code == http.StatusSeeOther {
+		t.Error("Expected a disabled bootstrap account to be refused")
+	}
+}
+
+func TestAdminLoginUnavailableAccountStore(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("bootstrap secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADMIN_PASSWORD_HASH", string(hash))
+
+	// An accounts file that exists but cannot be parsed must not re-enable the bootstrap account
+	s := newAdminLoginSession(t)
+	s.sm.admins = state.NewUnavailableAdminAccountStore(errors.New("unexpected end of JSON input"))
+	if rec := s.login("", "bootstrap secret"); rec.Code == http.StatusSeeOther {
+		t.Error("Expected the bootstrap account to be refused")
+	}
+	if isAdmin, _, _ := s.session(); isAdmin {
+		t.Error("Expected no admin session")
+	}
+
+	store := s.sm.GetAdminAccountStore()
+	if err := store.Create("dave", testAdminPassword, state.AdminRoleFull); !errors.Is(err, state.ErrAdminAccountsUnavailable) {
+		t.Errorf("Expected account changes to be refused, got %v", err)
+	}
+	if err := store.SetBootstrapDisabled(false); !errors.Is(err, state.ErrAdminAccountsUnavailable) || !store.BootstrapDisabled() {
+		t.Errorf("Expected the bootstrap account to stay disabled, got %v", err)
+	}
+	if len(store.List()) != 0 {
+		t.Error("Expected a refused change to leave the store empty")
+	}
+}
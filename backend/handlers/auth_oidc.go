@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/oidc"
+	"pvmss/proxmox"
+	"pvmss/security"
+)
+
+// oidcUserRole is the role OIDC users get on their pool, the same one the user pool admin page grants
+const oidcUserRole = "PVEVMUser"
+
+// oidcUserPrefix keeps single sign-on accounts apart from local Proxmox users: the provider
+// account alice becomes oidc-alice@pve, whose pool is pvmss_oidc-alice
+const oidcUserPrefix = "oidc-"
+
+// oidcSubjectMarker tags the comment of a Proxmox user created by single sign-on with the
+// identity it belongs to, issuer|subject, as backup notes are tagged with their owner
+const oidcSubjectMarker = "pvmss-oidc-sub="
+
+// authMethodOIDC is the constants.SessionKeyAuthMethod value of single sign-on sessions
+const authMethodOIDC = "oidc"
+
+// maxProxmoxUserIDLength is the longest user@realm Proxmox accepts
+const maxProxmoxUserIDLength = 64
+
+// errOIDCAccountTaken is returned when the Proxmox user an identity maps to was not created
+// by single sign-on for that identity
+var errOIDCAccountTaken = errors.New("the Proxmox user exists and does not belong to this identity")
+
+// Session keys holding the pending OIDC authorization request
+const (
+	oidcSessionState    = "oidc_state"
+	oidcSessionNonce    = "oidc_nonce"
+	oidcSessionVerifier = "oidc_verifier"
+	oidcSessionReturn   = "oidc_return"
+)
+
+// OIDCEnabled reports whether single sign-on through an OIDC provider is configured
+func (h *AuthHandler) OIDCEnabled() bool {
+	return h.oidc != nil
+}
+
+// handleOIDCLogin starts the authorization code flow: it stores a fresh state, nonce and PKCE
+// verifier in the session and sends the browser to the identity provider
+func (h *AuthHandler) handleOIDCLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "AuthHandler.handleOIDCLogin")
+
+	if h.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	sessionManager := security.GetSession(r)
+	if sessionManager == nil {
+		ctx.HandleError(nil, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			ctx.HandleError(err, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	reqCtx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	authURL, err := h.oidc.AuthCodeURL(reqCtx, state, nonce, verifier)
+	if err != nil {
+		ctx.Log.Error().Err(err).Msg("Failed to reach the OIDC provider")
+		h.renderLoginForm(w, r, "Authentication service unavailable. Please try again later.")
+		return
+	}
+
+	sessionManager.Put(r.Context(), oidcSessionState, state)
+	sessionManager.Put(r.Context(), oidcSessionNonce, nonce)
+	sessionManager.Put(r.Context(), oidcSessionVerifier, verifier)
+	if r.URL.Query().Get("return") != "" || r.URL.Query().Get("redirect") != "" {
+		sessionManager.Put(r.Context(), oidcSessionReturn, getRedirectURL(r, "/"))
+	} else {
+		sessionManager.Remove(r.Context(), oidcSessionReturn)
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcIdentity returns the issuer|subject key of an identity: a subject is only unique at its issuer
+func oidcIdentity(issuer, sub string) string {
+	return strings.TrimRight(issuer, "/") + "|" + sub
+}
+
+// oidcUserSubject returns the identity recorded in the comment of a Proxmox user, or ""
+func oidcUserSubject(comment string) string {
+	for _, field := range strings.Fields(comment) {
+		if sub, ok := strings.CutPrefix(field, oidcSubjectMarker); ok {
+			return sub
+		}
+	}
+	return ""
+}
+
+// isOIDCSession reports whether the session signed in with single sign-on
+func isOIDCSession(r *http.Request) bool {
+	sessionManager := security.GetSession(r)
+	return sessionManager != nil && sessionManager.GetString(r.Context(), constants.SessionKeyAuthMethod) == authMethodOIDC
+}
+
+// resolveOIDCUser returns the pve realm user name of an OIDC identity, keyed by oidcIdentity:
+// the user created for it is kept even if the username claim changes. A new identity gets
+// oidc-<username>, unless a user with that name already exists.
+func resolveOIDCUser(ctx context.Context, client proxmox.ClientInterface, identity, username string) (string, error) {
+	users, err := proxmox.GetUsers(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	target := oidcUserPrefix + username
+	taken := false
+	for _, u := range users {
+		name, realm := splitUserID(u.UserID)
+		if realm != constants.DefaultLoginRealm {
+			continue
+		}
+		if oidcUserSubject(u.Comment) == identity {
+			return name, nil
+		}
+		taken = taken || name == target
+	}
+	if taken {
+		return "", errOIDCAccountTaken
+	}
+	if len(target)+len("@"+constants.DefaultLoginRealm) > maxProxmoxUserIDLength {
+		return "", fmt.Errorf("%w: %q is too long", oidc.ErrInvalidUsername, username)
+	}
+	return target, nil
+}
+
+// handleOIDCCallback completes the authorization code flow: it checks the state, redeems the
+// code, maps the claims to a user, provisions the user's Proxmox account and pool, and opens
+// the session
+func (h *AuthHandler) handleOIDCCallback(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "AuthHandler.handleOIDCCallback")
+
+	if h.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	sessionManager := security.GetSession(r)
+	if sessionManager == nil {
+		ctx.HandleError(nil, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// The pending request is single-use, whatever the outcome
+	expectedState := sessionManager.PopString(r.Context(), oidcSessionState)
+	nonce := sessionManager.PopString(r.Context(), oidcSessionNonce)
+	verifier := sessionManager.PopString(r.Context(), oidcSessionVerifier)
+	returnURL := sessionManager.PopString(r.Context(), oidcSessionReturn)
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		ctx.Log.Info().Str("error", providerErr).Str("description", query.Get("error_description")).Msg("OIDC provider refused the login")
+		h.renderLoginForm(w, r, "Single sign-on failed. Please try again.")
+		return
+	}
+	state, code := query.Get("state"), query.Get("code")
+	if expectedState == "" || code == "" || subtle.ConstantTimeCompare([]byte(state), []byte(expectedState)) != 1 {
+		ctx.Log.Warn().Bool("has_pending_request", expectedState != "").Msg("OIDC callback with an unknown or mismatched state")
+		h.renderLoginForm(w, r, "Session expired. Please try again.")
+		return
+	}
+
+	reqCtx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	claims, err := h.oidc.Exchange(reqCtx, code, verifier, nonce)
+	if err != nil {
+		ctx.Log.Warn().Err(err).Msg("OIDC code exchange failed")
+		h.renderLoginForm(w, r, "Single sign-on failed. Please try again.")
+		return
+	}
+	username, err := h.oidc.Username(claims)
+	var sub string
+	if err == nil {
+		sub, err = h.oidc.Subject(claims)
+	}
+	if err != nil {
+		auditLogin(r, false, "", map[string]string{"method": "oidc"}, err)
+		ctx.Log.Warn().Err(err).Msg("OIDC login refused")
+		h.renderLoginForm(w, r, "Your account cannot be used with PVMSS. Please contact an administrator.")
+		return
+	}
+	identity := oidcIdentity(h.oidc.Config().IssuerURL, sub)
+	role := h.oidc.AdminRole(claims)
+	isAdmin := role != ""
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.Log.Error().Str("username", username).Msg("Proxmox client not available; cannot provision OIDC user")
+		h.renderLoginForm(w, r, "Authentication service unavailable. Please try again later.")
+		return
+	}
+	name, err := resolveOIDCUser(reqCtx, client, identity, username)
+	if err != nil {
+		if errors.Is(err, errOIDCAccountTaken) || errors.Is(err, oidc.ErrInvalidUsername) {
+			auditLogin(r, false, username, map[string]string{"method": "oidc"}, err)
+			ctx.Log.Warn().Err(err).Str("username", username).Msg("OIDC login refused")
+			h.renderLoginForm(w, r, "Your account cannot be used with PVMSS. Please contact an administrator.")
+			return
+		}
+		ctx.Log.Error().Err(err).Str("username", username).Msg("Failed to look up the Proxmox user of an OIDC identity")
+		h.renderLoginForm(w, r, "Authentication service unavailable. Please try again later.")
+		return
+	}
+
+	// The Proxmox password is random: OIDC users never sign in to Proxmox with it
+	password, err := oidc.RandomString()
+	if err != nil {
+		ctx.HandleError(err, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	email, _ := claims["email"].(string)
+	comment := "Provisioned by PVMSS single sign-on " + oidcSubjectMarker + identity
+	userID, poolID, err := provisionUserPool(reqCtx, client, name, constants.DefaultLoginRealm, password, email, comment, oidcUserRole, true)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("username", username).Msg("Failed to provision OIDC user")
+		h.renderLoginForm(w, r, "Authentication service unavailable. Please try again later.")
+		return
+	}
+
+	username = sessionUsername(name, constants.DefaultLoginRealm)
+	ctx.Log.Info().
+		Str("username", username).
+		Str("user_id", userID).
+		Str("pool", poolID).
+		Str("admin_role", role).
+		Msg("OIDC authentication successful, creating session")
+
+	if err := establishSession(w, r, isAdmin, username); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Without a Proxmox password there is no Proxmox ticket, which the console needs
+	sessionManager.Put(r.Context(), constants.SessionKeyAuthMethod, authMethodOIDC)
+	if isAdmin {
+		sessionManager.Put(r.Context(), constants.SessionKeyAdminRole, role)
+	}
+	auditLogin(r, isAdmin, "", map[string]string{"method": "oidc"}, nil)
+
+	redirectURL := returnURL
+	if redirectURL == "" {
+		redirectURL = "/vm/create"
+		if isAdmin {
+			redirectURL = "/admin/nodes"
+		}
+	}
+	redirectURL = setLanguageCookieAndRedirect(w, r, redirectURL)
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
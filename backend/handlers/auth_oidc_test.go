@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/oidc"
+	"pvmss/oidc/oidctest"
+	"pvmss/security"
+	"pvmss/state"
+)
+
+// serveWithCookies runs handler with the session identified by cookies and returns the response
+func serveWithCookies(sm *fakeStateManager, handler httprouter.Handle, req *http.Request, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	ctx := context.WithValue(req.Context(), StateManagerKey, state.StateManager(sm))
+	req = req.WithContext(security.WithSessionManager(ctx, sm.sessions))
+	rec := httptest.NewRecorder()
+	sm.sessions.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, nil)
+	})).ServeHTTP(rec, req)
+	return rec
+}
+
+// newOIDCTestHandler returns an AuthHandler signing in through a mock issuer that
+// authenticates a user with claims
+func newOIDCTestHandler(t *testing.T, claims map[string]any) (*AuthHandler, *fakeStateManager, *fakeProxmoxClient) {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("pvmss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	issuer.SetClaims(claims)
+
+	sm, client := newAPITestState(nil)
+	h := &AuthHandler{stateManager: sm, oidc: oidc.NewProvider(oidc.Config{
+		IssuerURL:      issuer.URL,
+		ClientID:       "pvmss",
+		RedirectURL:    "http://pvmss.test/auth/oidc/callback",
+		Scopes:         []string{"openid"},
+		UsernameClaim:  "preferred_username",
+		AdminClaim:     "groups",
+		AdminValues:    []string{"pvmss-admins"},
+		HelpdeskValues: []string{"pvmss-helpdesk"},
+	}, nil)}
+	client.responses["/access/users"] = map[string]interface{}{"data": []map[string]interface{}{}}
+	return h, sm, client
+}
+
+// completeOIDCLogin signs in through the mock issuer of h and returns the callback response
+func completeOIDCLogin(t *testing.T, h *AuthHandler, sm *fakeStateManager) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := serveWithCookies(sm, h.handleOIDCLogin, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil), nil)
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	return serveWithCookies(sm, h.handleOIDCCallback, httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil), rec.Result().Cookies())
+}
+
+// sessionUser returns the username of the session carried by rec
+func sessionUser(sm *fakeStateManager, rec *httptest.ResponseRecorder) string {
+	var username string
+	serveWithCookies(sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		username = NewHandlerContext(w, r, "test").GetUsername()
+	}, httptest.NewRequest(http.MethodGet, "/profile", nil), rec.Result().Cookies())
+	return username
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	h, sm, client := newOIDCTestHandler(t, map[string]any{"preferred_username": "carol", "email": "carol@example.com", "groups": []any{"staff"}})
+
+	rec := serveWithCookies(sm, h.handleOIDCLogin, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?return=/profile", nil), nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect to the provider, got %d", rec.Code)
+	}
+	loginCookies := rec.Result().Cookies()
+
+	// The mock issuer grants the request and sends the browser back with a code
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || callback.Path != "/auth/oidc/callback" {
+		t.Fatalf("Expected a redirect to the callback, got %q", resp.Header.Get("Location"))
+	}
+
+	rec = serveWithCookies(sm, h.handleOIDCCallback, httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil), loginCookies)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/profile" {
+		t.Fatalf("Expected a redirect to the return URL, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, call := range []struct{ method, path string }{
+		{http.MethodPost, "/access/users"},
+		{http.MethodPost, "/pools"},
+		{http.MethodPut, "/access/acl"},
+	} {
+		if !client.called(call.method, call.path) {
+			t.Errorf("Expected the user to be provisioned with %s %s", call.method, call.path)
+		}
+	}
+
+	var username string
+	var isAdmin bool
+	serveWithCookies(sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		ctx := NewHandlerContext(w, r, "test")
+		username, isAdmin = ctx.GetUsername(), ctx.IsAdmin()
+	}, httptest.NewRequest(http.MethodGet, "/profile", nil), rec.Result().Cookies())
+	if username != "oidc-carol" || isAdmin {
+		t.Errorf("Expected a user session for oidc-carol, got %q (admin=%v)", username, isAdmin)
+	}
+
+	// The authorization request is single-use
+	rec = serveWithCookies(sm, h.handleOIDCCallback, httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil), loginCookies)
+	if rec.Code == http.StatusSeeOther {
+		t.Error("Expected a replayed callback to be refused")
+	}
+}
+
+func TestOIDCLoginGrantsAdmin(t *testing.T) {
+	h, sm, _ := newOIDCTestHandler(t, map[string]any{"preferred_username": "dave", "groups": []any{"pvmss-admins"}})
+
+	rec := completeOIDCLogin(t, h, sm)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/nodes" {
+		t.Fatalf("Expected a redirect to the admin area, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestOIDCLoginMapsAdminRole(t *testing.T) {
+	h, sm, _ := newOIDCTestHandler(t, map[string]any{"preferred_username": "erin", "groups": []any{"pvmss-helpdesk"}})
+
+	rec := completeOIDCLogin(t, h, sm)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/nodes" {
+		t.Fatalf("Expected a redirect to the admin area, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	var role string
+	var canOperate, canManage bool
+	serveWithCookies(sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		role, canOperate, canManage = adminRole(r), adminCan(r, PermissionOperate), adminCan(r, PermissionManage)
+	}, httptest.NewRequest(http.MethodGet, "/admin/nodes", nil), rec.Result().Cookies())
+	if role != state.AdminRoleHelpdesk || !canOperate || canManage {
+		t.Errorf("Expected the helpdesk role from the groups claim, got %q (operate=%v, manage=%v)", role, canOperate, canManage)
+	}
+}
+
+func TestOIDCLoginRefusesLocalUser(t *testing.T) {
+	h, sm, client := newOIDCTestHandler(t, map[string]any{"preferred_username": "mallory"})
+	client.responses["/access/users"] = map[string]interface{}{"data": []map[string]interface{}{
+		{"userid": "oidc-mallory@pve", "comment": "Created by the cluster administrator"},
+	}}
+
+	rec := completeOIDCLogin(t, h, sm)
+	if rec.Code == http.StatusSeeOther {
+		t.Fatalf("Expected the login to be refused, got a redirect to %q", rec.Header().Get("Location"))
+	}
+	if client.called(http.MethodPost, "/access/users") || client.called(http.MethodPut, "/access/acl") {
+		t.Error("Expected the existing user to be left untouched")
+	}
+	if username := sessionUser(sm, rec); username != "" {
+		t.Errorf("Expected no session, got %q", username)
+	}
+}
+
+func TestOIDCLoginKeysOnSubject(t *testing.T) {
+	// The provider account was renamed: the user created for its subject is kept. The same
+	// subject at another issuer is another identity.
+	h, sm, client := newOIDCTestHandler(t, map[string]any{"preferred_username": "carol"})
+	issuer := h.oidc.Config().IssuerURL
+	client.responses["/access/users"] = map[string]interface{}{"data": []map[string]interface{}{
+		{"userid": "oidc-carol@pve", "comment": "Provisioned by PVMSS single sign-on pvmss-oidc-sub=https://other.example|oidctest-subject"},
+		{"userid": "oidc-caroline@pve", "comment": "Provisioned by PVMSS single sign-on pvmss-oidc-sub=" + issuer + "|oidctest-subject"},
+	}}
+
+	rec := completeOIDCLogin(t, h, sm)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the login to succeed, got %d", rec.Code)
+	}
+	if username := sessionUser(sm, rec); username != "oidc-caroline" {
+		t.Errorf("Expected a session for oidc-caroline, got %q", username)
+	}
+}
@@ -128,8 +128,9 @@ func (b *BaseAPIHandler) WriteJSONError(w http.ResponseWriter, message string, s
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "error",
-		"message": message,
+		"status":     "error",
+		"message":    message,
+		"request_id": responseRequestID(w),
 	}); err != nil {
 		logger.Get().Error().Err(err).Msg("failed to write JSON error response")
 	}
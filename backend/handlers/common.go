@@ -15,6 +15,7 @@ import (
 	"pvmss/security"
 	"pvmss/state"
 	"pvmss/templates"
+	"pvmss/tracing"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/julienschmidt/httprouter"
@@ -107,6 +108,12 @@ func populateTemplateData(w http.ResponseWriter, r *http.Request, data map[strin
 		log.Debug().Msg("Authenticated user detected, adding session data")
 		data["IsAuthenticated"] = true
 		data["IsAdmin"] = IsAdmin(r)
+		// Templates hide the actions the role of an administrator does not allow
+		data["CanOperate"] = canPerform(r, PermissionOperate)
+		data["CanManage"] = canPerform(r, PermissionManage)
+		if IsAdmin(r) {
+			data["AdminRole"] = adminRole(r)
+		}
 
 		// Add username for regular users (admin users don't have username in session)
 		if username, ok := sessionManager.Get(r.Context(), "username").(string); ok && username != "" {
@@ -118,6 +125,8 @@ func populateTemplateData(w http.ResponseWriter, r *http.Request, data map[strin
 		log.Debug().Msg("No authenticated user detected")
 		data["IsAuthenticated"] = false
 		data["IsAdmin"] = false
+		data["CanOperate"] = false
+		data["CanManage"] = false
 	}
 
 	// Add/override CSRF token from request context if available (prefer context value set by middleware)
@@ -173,6 +182,9 @@ func renderTemplateInternal(w http.ResponseWriter, r *http.Request, name string,
 		Str("template", name).
 		Logger()
 
+	_, span := tracing.Start(r.Context(), "render "+name, tracing.KindInternal, tracing.String("template", name))
+	defer span.End()
+
 	if data == nil {
 		data = make(map[string]interface{})
 	}
@@ -221,6 +233,7 @@ func renderTemplateInternal(w http.ResponseWriter, r *http.Request, name string,
 	var buf bytes.Buffer
 	if err := instance.ExecuteTemplate(&buf, name, data); err != nil {
 		log.Error().Err(err).Str("template", name).Msg("Error executing content template")
+		span.RecordError(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
@@ -231,6 +244,7 @@ func renderTemplateInternal(w http.ResponseWriter, r *http.Request, name string,
 	// Execute the layout template with the combined data.
 	if err := instance.ExecuteTemplate(w, "layout", data); err != nil {
 		log.Error().Err(err).Msg("Error executing layout template")
+		span.RecordError(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 
@@ -244,6 +258,10 @@ func IsAuthenticated(r *http.Request) bool {
 		Str("remote_addr", r.RemoteAddr).
 		Logger()
 
+	if apiTokenFromRequest(r) != nil {
+		return true
+	}
+
 	stateManager := getStateManager(r)
 	sessionManager := stateManager.GetSessionManager()
 
@@ -352,6 +370,11 @@ func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 func IsAdmin(r *http.Request) bool {
 	log := CreateHandlerLogger("IsAdmin", r)
 
+	// API tokens never carry admin rights
+	if apiTokenFromRequest(r) != nil {
+		return false
+	}
+
 	stateManager := getStateManager(r)
 	if stateManager == nil {
 		return false
@@ -403,6 +426,11 @@ func setSecurityHeaders(w http.ResponseWriter, r *http.Request) {
 // RequireAdminAuth is a middleware that enforces admin authentication for admin routes
 // This function is exported for use by other packages
 func RequireAdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return RequireAdminPermission(PermissionView, next)
+}
+
+// RequireAdminPermission enforces admin authentication and a role granting perm
+func RequireAdminPermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := CreateHandlerLogger("RequireAdminAuth", r).With().
 			Str("remote_addr", r.RemoteAddr).
@@ -431,6 +459,16 @@ func RequireAdminAuth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		if !adminCan(r, perm) {
+			renderPermissionDenied(w, r, perm)
+			return
+		}
+
+		// Record which administrator changes something
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			log.Info().Str("admin", adminAccountName(r)).Str("role", adminRole(r)).Msg("Admin request")
+		}
+
 		// Set security headers for admin routes
 		setSecurityHeaders(w, r)
 
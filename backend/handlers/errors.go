@@ -3,7 +3,6 @@ package handlers
 import (
 	"net/http"
 	"pvmss/i18n"
-	"pvmss/logger"
 
 	i18n_bundle "github.com/nicksnyder/go-i18n/v2/i18n"
 )
@@ -82,10 +81,10 @@ func RespondWithError(w http.ResponseWriter, r *http.Request, errResp ErrorRespo
 		message = errResp.Message
 	}
 
-	logger.Get().Warn().
+	log := CreateHandlerLogger("RespondWithError", r)
+	log.Warn().
 		Int("status_code", errResp.Code).
 		Str("error_key", errResp.Key).
-		Str("path", r.URL.Path).
 		Msg("Error response sent")
 
 	http.Error(w, message, errResp.Code)
@@ -99,12 +98,12 @@ func RespondWithErrorAndLog(w http.ResponseWriter, r *http.Request, errResp Erro
 		message = errResp.Message
 	}
 
-	logger.Get().Error().
+	log := CreateHandlerLogger("RespondWithErrorAndLog", r)
+	log.Error().
 		Err(err).
 		Int("status_code", errResp.Code).
 		Str("error_key", errResp.Key).
 		Str("context", context).
-		Str("path", r.URL.Path).
 		Msg("Error occurred")
 
 	http.Error(w, message, errResp.Code)
@@ -118,10 +117,10 @@ func RespondWithCustomError(w http.ResponseWriter, r *http.Request, statusCode i
 		message = fallbackMsg
 	}
 
-	logger.Get().Warn().
+	log := CreateHandlerLogger("RespondWithCustomError", r)
+	log.Warn().
 		Int("status_code", statusCode).
 		Str("error_key", i18nKey).
-		Str("path", r.URL.Path).
 		Msg("Custom error response sent")
 
 	http.Error(w, message, statusCode)
@@ -52,7 +52,8 @@ func recoverMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rec := recover(); rec != nil {
-				logger.Get().Error().Interface("panic", rec).Str("path", r.URL.Path).Msg("Unhandled panic recovered")
+				log := CreateHandlerLogger("recoverMiddleware", r)
+				log.Error().Interface("panic", rec).Msg("Unhandled panic recovered")
 				RenderErrorPage(w, r, http.StatusInternalServerError, "Internal Server Error")
 			}
 		}()
@@ -117,6 +118,14 @@ func InitHandlers(stateManager state.StateManager) http.Handler {
 		Capacity: constants.LoginRateLimitCapacity,
 		Refill:   constants.LoginRateLimitRefill,
 	})
+	rateLimiter.AddRule("POST", "/admin/login/verify", middleware.Rule{
+		Capacity: constants.LoginRateLimitCapacity,
+		Refill:   constants.LoginRateLimitRefill,
+	})
+	rateLimiter.AddRule("GET", "/auth/oidc/callback", middleware.Rule{
+		Capacity: constants.LoginRateLimitCapacity,
+		Refill:   constants.LoginRateLimitRefill,
+	})
 
 	// Ensure default tag exists
 	if err := EnsureDefaultTag(stateManager); err != nil {
@@ -130,6 +139,7 @@ func InitHandlers(stateManager state.StateManager) http.Handler {
 	// Initialize all handlers
 	adminHandler := NewAdminHandler(stateManager)
 	adminVMsHandler := NewAdminVMsHandler(stateManager)
+	apiV1Handler := NewAPIv1Handler(stateManager)
 	authHandler := NewAuthHandler(stateManager)
 	docsHandler := NewDocsHandler()
 	healthHandler := NewHealthHandler(stateManager)
@@ -147,6 +157,7 @@ func InitHandlers(stateManager state.StateManager) http.Handler {
 	setupRoutes(
 		adminHandler,
 		adminVMsHandler,
+		apiV1Handler,
 		authHandler,
 		docsHandler,
 		healthHandler,
@@ -164,6 +175,10 @@ func InitHandlers(stateManager state.StateManager) http.Handler {
 
 	// Friendly NotFound and MethodNotAllowed handlers (when state is available)
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+			apiNotFound(w, r)
+			return
+		}
 		if getStateManager(r) != nil {
 			RenderErrorPage(w, r, http.StatusNotFound, "Page not found")
 			return
@@ -171,6 +186,10 @@ func InitHandlers(stateManager state.StateManager) http.Handler {
 		http.NotFound(w, r)
 	})
 	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
 		if getStateManager(r) != nil {
 			RenderErrorPage(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 			return
@@ -199,6 +218,7 @@ func InitHandlers(stateManager state.StateManager) http.Handler {
 		// Apply session-dependent middleware only to the app handler
 		appHandler = security.CSRF(appHandler)
 		appHandler = securityMiddleware.Headers(appHandler)
+		appHandler = sessionTrackingMiddleware(sessionBindingFromEnv())(appHandler)
 		appHandler = securityMiddleware.SessionMiddleware(sessionManager)(appHandler)
 		appHandler = sessionDebugMiddleware(appHandler)
 		appHandler = sessionManager.LoadAndSave(appHandler) // Outermost session middleware
@@ -212,8 +232,12 @@ func InitHandlers(stateManager state.StateManager) http.Handler {
 	appHandler = trailingSlashRedirectMiddleware(appHandler)
 	appHandler = recoverMiddleware(appHandler) // Innermost recovery for the app
 
+	// Prometheus metrics, outside the session middleware
+	metricsSessionManager.Store(sessionManager)
+	mux.Handle("/metrics", metricsHandler(os.Getenv("METRICS_TOKEN")))
+
 	// Route requests to the appropriate middleware chain.
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/", httpMetricsMiddleware(router)(tracingMiddleware(router)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Route static assets and /health to the public handler (no session)
 		if isStaticPath(r.URL.Path) || r.URL.Path == "/health" {
 			publicHandler.ServeHTTP(w, r)
@@ -221,9 +245,10 @@ func InitHandlers(stateManager state.StateManager) http.Handler {
 			// All other requests go to the main app handler with the full middleware stack
 			appHandler.ServeHTTP(w, r)
 		}
-	})
+	}))))
 
-	var handler http.Handler = mux
+	// Give each request an ID correlating its log lines, outermost so every response carries it
+	var handler http.Handler = middleware.RequestID(mux)
 
 	log.Info().Msg("HTTP handlers and middleware initialized")
 	return handler
@@ -252,6 +277,7 @@ type handlerRegistrar interface {
 func setupRoutes(
 	adminHandler *AdminHandler,
 	adminVMsHandler *AdminVMsHandler,
+	apiV1Handler *APIv1Handler,
 	authHandler *AuthHandler,
 	docsHandler *DocsHandler,
 	healthHandler *HealthHandler,
@@ -270,6 +296,7 @@ func setupRoutes(
 	handlers := []handlerRegistrar{
 		adminHandler,
 		adminVMsHandler,
+		apiV1Handler,
 		authHandler,
 		docsHandler,
 		healthHandler,
@@ -290,7 +317,18 @@ func setupRoutes(
 
 	// Register additional routes for settings handler
 	settingsHandler.RegisterISORoutes(router)
+	settingsHandler.RegisterTemplateRoutes(router)
 	settingsHandler.RegisterLimitsRoutes(router)
+	settingsHandler.RegisterLeaseRoutes(router)
+
+	// Register the admin session routes
+	userPoolHandler.RegisterSessionRoutes(router)
+
+	// Register the admin account routes
+	adminHandler.RegisterAccountRoutes(router)
+
+	// Register the admin VM request routes
+	vmHandler.RegisterRequestRoutes(router)
 
 	// Home route
 	router.GET("/", IndexRouterHandler)
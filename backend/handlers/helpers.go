@@ -10,6 +10,7 @@ import (
 	"pvmss/logger"
 	"pvmss/security"
 	"pvmss/state"
+	"pvmss/tracing"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/julienschmidt/httprouter"
@@ -39,6 +40,12 @@ func CreateHandlerLogger(handlerName string, r *http.Request) zerolog.Logger {
 		logContext = logContext.
 			Str("method", r.Method).
 			Str("path", r.URL.Path)
+		if id := logger.RequestIDFromContext(r.Context()); id != "" {
+			logContext = logContext.Str("request_id", id)
+		}
+		if span := tracing.SpanFromContext(r.Context()); span != nil {
+			logContext = logContext.Str("trace_id", span.TraceID().String())
+		}
 	}
 
 	return logContext.Logger()
@@ -108,6 +115,12 @@ func RedirectWithError(w http.ResponseWriter, r *http.Request, url, message stri
 	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 }
 
+// responseRequestID returns the request ID the RequestID middleware set on the response, for
+// the writers that are not handed the request
+func responseRequestID(w http.ResponseWriter) string {
+	return w.Header().Get(logger.RequestIDHeader)
+}
+
 // RenderErrorPage renders a friendly error page with status code and message.
 // It also provides navigation options (Back/Home) to help the user recover.
 func RenderErrorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
@@ -116,6 +129,7 @@ func RenderErrorPage(w http.ResponseWriter, r *http.Request, status int, message
 		"Title":      "Error",
 		"StatusCode": status,
 		"Error":      message,
+		"RequestID":  logger.RequestIDFromContext(r.Context()),
 	}
 
 	// Best-effort return URL: prefer Referer, fallback to current path
@@ -173,6 +187,9 @@ func NewHandlerContext(w http.ResponseWriter, r *http.Request, handlerName strin
 
 // IsAuthenticated checks if the current request is authenticated
 func (ctx *HandlerContext) IsAuthenticated() bool {
+	if apiTokenFromRequest(ctx.Request) != nil {
+		return true
+	}
 	if ctx.SessionManager == nil {
 		ctx.Log.Error().Msg("Session manager not available")
 		return false
@@ -184,6 +201,10 @@ func (ctx *HandlerContext) IsAuthenticated() bool {
 
 // IsAdmin checks if the current user is an admin
 func (ctx *HandlerContext) IsAdmin() bool {
+	// API tokens never carry admin rights
+	if apiTokenFromRequest(ctx.Request) != nil {
+		return false
+	}
 	if ctx.SessionManager == nil {
 		return false
 	}
@@ -194,6 +215,9 @@ func (ctx *HandlerContext) IsAdmin() bool {
 
 // GetUsername returns the current username if authenticated
 func (ctx *HandlerContext) GetUsername() string {
+	if token := apiTokenFromRequest(ctx.Request); token != nil {
+		return token.Username
+	}
 	if ctx.SessionManager == nil {
 		return ""
 	}
@@ -336,7 +360,11 @@ func (ctx *HandlerContext) RedirectWithSuccess(path, messageKey string) {
 
 // RedirectWithError redirects with an error message
 func (ctx *HandlerContext) RedirectWithError(path, messageKey string) {
-	msg := ctx.Translate(messageKey)
+	ctx.RedirectWithErrorMessage(path, ctx.Translate(messageKey))
+}
+
+// RedirectWithErrorMessage redirects with an already translated error message
+func (ctx *HandlerContext) RedirectWithErrorMessage(path, msg string) {
 	params := url.Values{}
 	params.Set("error", "1")
 	params.Set("error_msg", msg)
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// newLargeClusterState returns a fake Proxmox with 1000 VMs spread over 4 nodes.
+// Per-VM configs are also served so that config-based lookups would succeed.
+func newLargeClusterState() (*fakeStateManager, *fakeProxmoxClient) {
+	const vmCount = 1000
+	client := newFakeProxmoxClient()
+	nodes := []string{"pve1", "pve2", "pve3", "pve4"}
+
+	nodeList := make([]map[string]interface{}, 0, len(nodes))
+	perNode := make(map[string][]map[string]interface{})
+	for _, n := range nodes {
+		nodeList = append(nodeList, map[string]interface{}{"node": n, "status": "online"})
+	}
+	cluster := make([]map[string]interface{}, 0, vmCount)
+	for i := 0; i < vmCount; i++ {
+		vmid := 1000 + i
+		node := nodes[i%len(nodes)]
+		name := fmt.Sprintf("vm-%d", vmid)
+		tags := "pvmss"
+		if i%3 == 0 {
+			tags = "other"
+		}
+		cluster = append(cluster, map[string]interface{}{
+			"id": fmt.Sprintf("qemu/%d", vmid), "type": "qemu", "vmid": vmid, "name": name, "node": node,
+			"status": "running", "tags": tags, "pool": fmt.Sprintf("pvmss_user%d", i%50),
+			"maxcpu": 2, "maxmem": 2048 * 1024 * 1024,
+		})
+		perNode[node] = append(perNode[node], map[string]interface{}{"vmid": vmid, "name": name, "status": "running"})
+		client.responses[fmt.Sprintf("/nodes/%s/qemu/%d/config", node, vmid)] = map[string]interface{}{
+			"data": map[string]interface{}{"tags": tags, "sockets": 1, "cores": 2, "memory": 2048},
+		}
+	}
+	client.responses["/nodes"] = map[string]interface{}{"data": nodeList}
+	for n, vms := range perNode {
+		client.responses["/nodes/"+n+"/qemu"] = map[string]interface{}{"data": vms}
+	}
+	client.responses["/cluster/resources?type=vm"] = map[string]interface{}{"data": cluster}
+
+	return &fakeStateManager{client: client, settings: &state.AppSettings{}}, client
+}
+
+// reportProxmoxCalls records the number of Proxmox API calls made per benchmark iteration
+func reportProxmoxCalls(b *testing.B, client *fakeProxmoxClient) {
+	client.mu.Lock()
+	calls := len(client.calls)
+	client.mu.Unlock()
+	b.ReportMetric(float64(calls)/float64(b.N), "calls/op")
+}
+
+// benchmarkInventoryConsumer runs fn with a cold inventory on every iteration
+func benchmarkInventoryConsumer(b *testing.B, fn func(ctx context.Context, sm *fakeStateManager, client *fakeProxmoxClient)) {
+	sm, client := newLargeClusterState()
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		proxmox.InvalidateInventory()
+		fn(ctx, sm, client)
+	}
+	b.StopTimer()
+	reportProxmoxCalls(b, client)
+}
+
+func BenchmarkCalculateNodeResourceUsage1000VMs(b *testing.B) {
+	benchmarkInventoryConsumer(b, func(ctx context.Context, sm *fakeStateManager, client *fakeProxmoxClient) {
+		if _, err := CalculateNodeResourceUsage(ctx, client, sm); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkAdminVMsWithPVMSSTag1000VMs(b *testing.B) {
+	benchmarkInventoryConsumer(b, func(ctx context.Context, sm *fakeStateManager, client *fakeProxmoxClient) {
+		h := &AdminVMsHandler{stateManager: sm}
+		if _, msg := h.getVMsWithPVMSSTag(ctx, client); msg != "" {
+			b.Fatal(msg)
+		}
+	})
+}
+
+func BenchmarkSearchVMs1000VMs(b *testing.B) {
+	benchmarkInventoryConsumer(b, func(ctx context.Context, sm *fakeStateManager, client *fakeProxmoxClient) {
+		h := &SearchHandler{stateManager: sm}
+		if _, err := h.searchVMs(ctx, client, "", "vm-19", "user1", false); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkFetchUserVMs1000VMs(b *testing.B) {
+	benchmarkInventoryConsumer(b, func(ctx context.Context, sm *fakeStateManager, client *fakeProxmoxClient) {
+		h := &ProfileHandler{stateManager: sm}
+		if vms := h.fetchUserVMs(ctx, client, "pvmss_user1"); len(vms) == 0 {
+			b.Fatal("expected VMs in pvmss_user1")
+		}
+	})
+}
+
+func TestInventoryConsumersUseSingleClusterCall(t *testing.T) {
+	sm, client := newLargeClusterState()
+	proxmox.InvalidateInventory()
+
+	usage, err := CalculateNodeResourceUsage(context.Background(), client, sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1000 VMs, one in three is not tagged pvmss, 2 cores each, spread over 4 nodes
+	total := 0
+	for _, u := range usage {
+		total += u.TotalVMs
+	}
+	if total != 666 {
+		t.Errorf("Expected 666 pvmss VMs, got %d", total)
+	}
+
+	h := &AdminVMsHandler{stateManager: sm}
+	if vms, msg := h.getVMsWithPVMSSTag(context.Background(), client); msg != "" || len(vms) != 666 {
+		t.Errorf("Expected 666 admin VMs, got %d (%s)", len(vms), msg)
+	}
+
+	// Every VM of pvmss_user1 and every search result would need its config for a description
+	profile := &ProfileHandler{stateManager: sm}
+	if vms := profile.fetchUserVMs(context.Background(), client, "pvmss_user1"); len(vms) != 20 {
+		t.Errorf("Expected the 20 VMs of pvmss_user1, got %d", len(vms))
+	}
+	search := &SearchHandler{stateManager: sm}
+	if results, err := search.searchVMs(context.Background(), client, "", "vm-1", "", true); err != nil || len(results) != 50 {
+		t.Errorf("Expected 50 search results, got %d (%v)", len(results), err)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.calls) == 0 {
+		t.Fatal("Expected the cluster inventory to be read")
+	}
+	for _, call := range client.calls {
+		if strings.HasSuffix(call, "/config") {
+			t.Fatalf("Unexpected per-VM config call %s", call)
+		}
+	}
+}
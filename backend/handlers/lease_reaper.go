@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"pvmss/constants"
+	"pvmss/logger"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// leaseReaperUser is recorded as the initiator of the Proxmox tasks started by the reaper
+const leaseReaperUser = "lease-reaper"
+
+var (
+	leaseReaperMu      sync.Mutex
+	leaseReaperStarted bool
+)
+
+// StartLeaseReaper starts a non-blocking background goroutine that stops VMs whose lease
+// expired and deletes them once the grace period is over. Runs every LeaseReaperInterval.
+func StartLeaseReaper(sm VMStateManager) {
+	leaseReaperMu.Lock()
+	if leaseReaperStarted {
+		leaseReaperMu.Unlock()
+		return
+	}
+	leaseReaperStarted = true
+	leaseReaperMu.Unlock()
+
+	go func() {
+		// Immediate pass so VMs that expired while PVMSS was down are handled
+		reapExpiredLeases(context.Background(), sm, time.Now())
+
+		ticker := time.NewTicker(constants.LeaseReaperInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			reapExpiredLeases(context.Background(), sm, now)
+		}
+	}()
+}
+
+// recordLeaseEvent appends a reaper action unless it repeats the last action on the same VM,
+// so dry runs and failures retried on every pass do not flood the activity log.
+// Actions that ran, not dry runs, also go to the audit log under the system actor.
+func recordLeaseEvent(store *state.VMLeaseStore, audit *state.AuditLogStore, event state.LeaseEvent) {
+	if last, ok := store.LastEvent(event.VMID); ok &&
+		last.Action == event.Action && last.DryRun == event.DryRun && last.Error == event.Error {
+		return
+	}
+	if err := store.Record(event); err != nil {
+		logger.Get().Warn().Err(err).Int("vmid", event.VMID).Msg("Failed to record lease reaper action")
+	}
+	if event.DryRun {
+		return
+	}
+	action := state.AuditActionVMDelete
+	if event.Action == state.LeaseActionStop {
+		action = "vm.stop"
+	}
+	var err error
+	if event.Error != "" {
+		err = errors.New(event.Error)
+	}
+	recordSystemAudit(audit, state.AuditEvent{
+		Action: action,
+		VMID:   event.VMID,
+		Params: map[string]string{"reason": "lease_expired", "owner": event.Username, "name": event.Name},
+	}, err)
+}
+
+// stopExpiredVM force-stops a VM and waits for the stop task
+func stopExpiredVM(ctx context.Context, client proxmox.ClientInterface, vm proxmox.ClusterVM) error {
+	ctx, cancel := context.WithTimeout(ctx, constants.VMTaskWaitTimeout)
+	defer cancel()
+
+	upid, err := proxmox.VMActionWithContext(ctx, client, vm.Node, strconv.Itoa(vm.VMID), "stop")
+	if err != nil {
+		return err
+	}
+	if _, err := proxmox.TrackAndWaitTask(ctx, client, upid, vm.VMID, "stop", leaseReaperUser); err != nil {
+		return err
+	}
+	proxmox.InvalidateInventory()
+	return nil
+}
+
+// reapExpiredLeases makes one pass over the leases: expired VMs are stopped, and stopped VMs
+// are deleted once the grace period is over. Leases of VMs that no longer exist are dropped.
+// In dry-run mode the actions are only recorded, with the expiry standing in for the stop time.
+func reapExpiredLeases(ctx context.Context, sm VMStateManager, now time.Time) {
+	log := logger.Get().With().Str("component", "LeaseReaper").Logger()
+
+	client := sm.GetProxmoxClient()
+	store := sm.GetVMLeaseStore()
+	audit := sm.GetAuditLogStore()
+	if client == nil || store == nil {
+		return
+	}
+	leases := store.List()
+	if len(leases) == 0 {
+		return
+	}
+
+	vms, err := proxmox.GetInventoryVMsWithContext(ctx, client)
+	if err != nil {
+		log.Warn().Err(err).Msg("Unable to list VMs; skipping lease reaping")
+		return
+	}
+	byID := make(map[int]proxmox.ClusterVM, len(vms))
+	for _, vm := range vms {
+		byID[vm.VMID] = vm
+	}
+
+	policy := settingsLeasePolicy(sm.GetSettings())
+	grace := time.Duration(policy.GraceDays) * leaseDay
+
+	for _, lease := range leases {
+		vm, ok := byID[lease.VMID]
+		if !ok || (lease.Name != "" && vm.Name != lease.Name) {
+			// Deleted outside the reaper, or the VMID now belongs to another VM
+			log.Info().Int("vmid", lease.VMID).Str("name", lease.Name).Msg("Dropping the lease of a VM that no longer exists")
+			if err := store.Remove(lease.VMID); err != nil {
+				log.Warn().Err(err).Int("vmid", lease.VMID).Msg("Failed to drop VM lease")
+			}
+			continue
+		}
+		if !lease.Expired(now) {
+			continue
+		}
+
+		event := state.LeaseEvent{Time: now.UTC(), VMID: lease.VMID, Name: lease.Name, Username: lease.Username, DryRun: policy.DryRun}
+
+		if policy.DryRun {
+			// A real run stops the VM on this pass, and deletes it on a later one
+			event.Action = state.LeaseActionStop
+			if grace > 0 && !now.Before(lease.ExpiresAt.Add(grace)) {
+				event.Action = state.LeaseActionDelete
+			}
+			log.Info().Int("vmid", lease.VMID).Str("action", event.Action).Msg("Dry run: expired VM left untouched")
+			recordLeaseEvent(store, audit, event)
+			continue
+		}
+
+		if lease.StoppedAt == nil {
+			event.Action = state.LeaseActionStop
+			if vm.Status == "running" {
+				if err := stopExpiredVM(ctx, client, vm); err != nil {
+					log.Error().Err(err).Int("vmid", lease.VMID).Msg("Failed to stop expired VM")
+					event.Error = err.Error()
+					recordLeaseEvent(store, audit, event)
+					continue
+				}
+			}
+			if err := store.MarkStopped(lease.VMID, now); err != nil {
+				log.Error().Err(err).Int("vmid", lease.VMID).Msg("Failed to record the stop of an expired VM")
+				continue
+			}
+			log.Info().Int("vmid", lease.VMID).Str("node", vm.Node).Msg("Expired VM stopped")
+			recordLeaseEvent(store, audit, event)
+			continue
+		}
+
+		if now.Before(lease.StoppedAt.Add(grace)) {
+			continue
+		}
+		event.Action = state.LeaseActionDelete
+		if _, err := destroyVM(ctx, client, vm.Node, vm.VMID, leaseReaperUser); err != nil {
+			log.Error().Err(err).Int("vmid", lease.VMID).Msg("Failed to delete expired VM")
+			event.Error = err.Error()
+			recordLeaseEvent(store, audit, event)
+			continue
+		}
+		if err := store.Remove(lease.VMID); err != nil {
+			log.Warn().Err(err).Int("vmid", lease.VMID).Msg("Failed to drop the lease of a deleted VM")
+		}
+		if vm.Pool != "" {
+			client.InvalidateCache("/pools/" + url.PathEscape(vm.Pool))
+		}
+		log.Info().Int("vmid", lease.VMID).Str("node", vm.Node).Msg("Expired VM deleted after the grace period")
+		recordLeaseEvent(store, audit, event)
+	}
+}
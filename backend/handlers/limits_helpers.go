@@ -43,68 +43,25 @@ func CalculateNodeResourceUsage(ctx context.Context, client proxmox.ClientInterf
 		}
 	}
 
-	// Get all VMs
-	vms, err := proxmox.GetVMsWithContext(ctx, client)
+	// One inventory call returns the tags and sizing of every VM
+	vms, err := proxmox.GetInventoryVMsWithContext(ctx, client)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get VMs")
 		return usage, nil // Return empty usage instead of error
 	}
 
-	// Iterate through VMs and accumulate resources for pvmss-tagged VMs
+	// Accumulate resources for pvmss-tagged VMs
 	for _, vm := range vms {
-		// Get VM config to check tags
-		cfg, err := proxmox.GetVMConfigWithContext(ctx, client, vm.Node, vm.VMID)
-		if err != nil {
-			log.Warn().Err(err).Str("node", vm.Node).Int("vmid", vm.VMID).Msg("Failed to get VM config")
+		if !vm.HasTag("pvmss") {
 			continue
 		}
-
-		// Check if VM has pvmss tag
-		hasPvmssTag := false
-		if tagsStr, ok := cfg["tags"].(string); ok && tagsStr != "" {
-			// Parse tags (can be separated by semicolon or comma)
-			tags := parseTags(tagsStr)
-			for _, tag := range tags {
-				if strings.EqualFold(strings.TrimSpace(tag), "pvmss") {
-					hasPvmssTag = true
-					break
-				}
-			}
-		}
-
-		if !hasPvmssTag {
+		nodeUsage, ok := usage[vm.Node]
+		if !ok {
 			continue
 		}
-
-		// Get node usage tracker
-		nodeUsage := usage[vm.Node]
 		nodeUsage.TotalVMs++
-
-		// Extract CPU configuration (sockets and cores)
-		vmSockets := 1
-		vmCores := 1
-
-		if socketsRaw, ok := cfg["sockets"]; ok {
-			if socketsFloat, ok := socketsRaw.(float64); ok {
-				vmSockets = int(socketsFloat)
-			}
-		}
-
-		if coresRaw, ok := cfg["cores"]; ok {
-			if coresFloat, ok := coresRaw.(float64); ok {
-				vmCores = int(coresFloat)
-			}
-		}
-
-		// Total cores for this VM = sockets * cores
-		nodeUsage.Cores += vmSockets * vmCores
-
-		// Extract memory (stored in MB in Proxmox)
-		if memRaw, ok := cfg["memory"]; ok {
-			if memFloat, ok := memRaw.(float64); ok {
-				nodeUsage.RamMB += int64(memFloat)
-			}
-		}
+		nodeUsage.Cores += vm.MaxCPU
+		nodeUsage.RamMB += vm.MaxMemMB()
 	}
 
 	// Convert RAM from MB to GB for display
@@ -225,6 +182,18 @@ func ValidateNodeLimitsAgainstCapacity(ctx context.Context, client proxmox.Clien
 
 // ValidateVMResourcesAgainstNodeLimits validates that adding a new VM won't exceed node aggregate limits
 func ValidateVMResourcesAgainstNodeLimits(ctx context.Context, client proxmox.ClientInterface, sm LimitsGetter, node string, sockets, cores int, memoryMB int) error {
+	return validateNodeAggregateLimits(ctx, client, sm, node, 0, sockets, cores, memoryMB)
+}
+
+// ValidateVMResizeAgainstNodeLimits validates that resizing an existing VM won't exceed node aggregate limits.
+// The VM's own current usage is excluded so that only the new size is counted.
+func ValidateVMResizeAgainstNodeLimits(ctx context.Context, client proxmox.ClientInterface, sm LimitsGetter, node string, vmid int, sockets, cores int, memoryMB int) error {
+	return validateNodeAggregateLimits(ctx, client, sm, node, vmid, sockets, cores, memoryMB)
+}
+
+// validateNodeAggregateLimits checks the requested resources against the aggregate limits of a node,
+// excluding the current usage of excludeVMID when it is greater than zero
+func validateNodeAggregateLimits(ctx context.Context, client proxmox.ClientInterface, sm LimitsGetter, node string, excludeVMID int, sockets, cores int, memoryMB int) error {
 	log := logger.Get().With().Str("function", "ValidateVMResourcesAgainstNodeLimits").Logger()
 
 	// Calculate current usage
@@ -249,6 +218,24 @@ func ValidateVMResourcesAgainstNodeLimits(ctx context.Context, client proxmox.Cl
 		return nil
 	}
 
+	action := "adding this VM"
+	if excludeVMID > 0 {
+		action = fmt.Sprintf("resizing VM %d", excludeVMID)
+		// The VM is already counted in the usage when it carries the pvmss tag
+		if vms, err := proxmox.GetInventoryVMsWithContext(ctxWithTimeout, client); err == nil {
+			for _, vm := range vms {
+				if vm.VMID == excludeVMID && vm.Node == node && vm.HasTag("pvmss") {
+					nodeUsage.Cores -= vm.MaxCPU
+					nodeUsage.RamMB -= vm.MaxMemMB()
+					nodeUsage.RamGB = int(nodeUsage.RamMB / 1024)
+					break
+				}
+			}
+		} else {
+			log.Warn().Err(err).Int("vmid", excludeVMID).Msg("Failed to get VM inventory, counting its current usage")
+		}
+	}
+
 	memoryGB := memoryMB / 1024
 
 	// Validate cores
@@ -256,8 +243,8 @@ func ValidateVMResourcesAgainstNodeLimits(ctx context.Context, client proxmox.Cl
 		totalCores := sockets * cores
 		newTotal := nodeUsage.Cores + totalCores
 		if newTotal > nodeUsage.MaxCores {
-			return fmt.Errorf("adding this VM would exceed node '%s' aggregate cores limit (current: %d, requested: %d, max: %d)",
-				node, nodeUsage.Cores, totalCores, nodeUsage.MaxCores)
+			return fmt.Errorf("%s would exceed node '%s' aggregate cores limit (current: %d, requested: %d, max: %d)",
+				action, node, nodeUsage.Cores, totalCores, nodeUsage.MaxCores)
 		}
 	}
 
@@ -265,8 +252,8 @@ func ValidateVMResourcesAgainstNodeLimits(ctx context.Context, client proxmox.Cl
 	if nodeUsage.MaxRamGB > 0 {
 		newTotal := nodeUsage.RamGB + memoryGB
 		if newTotal > nodeUsage.MaxRamGB {
-			return fmt.Errorf("adding this VM would exceed node '%s' aggregate RAM limit (current: %d GB, requested: %d GB, max: %d GB)",
-				node, nodeUsage.RamGB, memoryGB, nodeUsage.MaxRamGB)
+			return fmt.Errorf("%s would exceed node '%s' aggregate RAM limit (current: %d GB, requested: %d GB, max: %d GB)",
+				action, node, nodeUsage.RamGB, memoryGB, nodeUsage.MaxRamGB)
 		}
 	}
 
@@ -274,7 +261,8 @@ func ValidateVMResourcesAgainstNodeLimits(ctx context.Context, client proxmox.Cl
 		Str("node", node).
 		Int("current_cores", nodeUsage.Cores).
 		Int("current_ram_gb", nodeUsage.RamGB).
-		Msg("VM creation validated against aggregate node limits")
+		Int("exclude_vmid", excludeVMID).
+		Msg("VM resources validated against aggregate node limits")
 
 	return nil
 }
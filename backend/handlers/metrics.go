@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/metrics"
+)
+
+// unmatchedRoute labels the requests no route matched, so unknown paths do not create series
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequests = metrics.NewCounterVec("pvmss_http_requests_total",
+		"HTTP requests served, by method, route and status code.",
+		"method", "route", "code")
+	httpRequestDuration = metrics.NewHistogramVec("pvmss_http_request_duration_seconds",
+		"Time spent serving HTTP requests, by method and route.",
+		nil, "method", "route")
+	consoleSessions = metrics.NewGaugeVec("pvmss_console_sessions_active",
+		"Console WebSocket sessions being proxied to Proxmox.")
+	vmCreations = metrics.NewCounterVec("pvmss_vm_creations_total",
+		"VM creations, by result (success or failure).", "result")
+
+	// metricsSessionManager is the session manager whose sessions are counted on each scrape
+	metricsSessionManager atomic.Pointer[scs.SessionManager]
+	_                     = metrics.NewGaugeFunc("pvmss_sessions_active",
+		"Signed-in sessions, users and administrators; 0 when the session store cannot list them.",
+		countActiveSessions)
+)
+
+// countActiveSessions counts the signed-in sessions of the store
+func countActiveSessions() float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n := 0
+	_ = eachTrackedSession(ctx, metricsSessionManager.Load(), func(context.Context, trackedSession) error {
+		n++
+		return nil
+	})
+	return float64(n)
+}
+
+// recordVMCreation counts a VM creation by its outcome
+func recordVMCreation(err error) {
+	if err != nil {
+		vmCreations.Inc("failure")
+		return
+	}
+	vmCreations.Inc("success")
+}
+
+// metricsHandler serves /metrics. When METRICS_TOKEN is set, scrapes must send it as a bearer token.
+func metricsHandler(token string) http.Handler {
+	return metrics.Handler(metrics.Default, token)
+}
+
+// httpMetricsMiddleware counts the requests and their latency by route pattern, such as
+// /vm/details/:vmid, rather than by path
+func httpMetricsMiddleware(router *httprouter.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(ww, r)
+
+			route, _ := matchedRoute(router, r)
+			httpRequestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+			httpRequests.Inc(r.Method, route, strconv.Itoa(ww.status))
+		})
+	}
+}
+
+// matchedRoute returns the route pattern of a request and its parameters, unmatchedRoute when
+// no route matches
+func matchedRoute(router *httprouter.Router, r *http.Request) (string, httprouter.Params) {
+	handle, ps, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return unmatchedRoute, nil
+	}
+	return routePattern(r.URL.Path, ps), ps
+}
+
+// routePattern rebuilds the pattern a path was matched with from the route parameters, which
+// fill whole segments in order, or the rest of the path for a catch-all parameter
+func routePattern(path string, ps httprouter.Params) string {
+	segments := strings.Split(path, "/")
+	next := 0
+	for _, p := range ps {
+		if strings.HasPrefix(p.Value, "/") {
+			prefix := strings.TrimSuffix(strings.Join(segments, "/"), p.Value)
+			return prefix + "/*" + p.Key
+		}
+		for i := next; i < len(segments); i++ {
+			if segments[i] == p.Value {
+				segments[i] = ":" + p.Key
+				next = i + 1
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// statusRecorder captures the status code of a response, keeping WebSocket upgrades working
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher for streamed responses
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker interface for WebSocket support
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.status = http.StatusSwitchingProtocols
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/metrics"
+)
+
+func TestRoutePattern(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		ps   httprouter.Params
+		want string
+	}{
+		{"/vm/details/100", httprouter.Params{{Key: "vmid", Value: "100"}}, "/vm/details/:vmid"},
+		{"/vm/snapshot/rollback/100/snap", httprouter.Params{{Key: "vmid", Value: "100"}, {Key: "snapname", Value: "snap"}}, "/vm/snapshot/rollback/:vmid/:snapname"},
+		{"/css/app/main.css", httprouter.Params{{Key: "filepath", Value: "/app/main.css"}}, "/css/*filepath"},
+		{"/login", nil, "/login"},
+	} {
+		if got := routePattern(tc.path, tc.ps); got != tc.want {
+			t.Errorf("routePattern(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestHTTPMetricsMiddleware(t *testing.T) {
+	router := httprouter.New()
+	router.GET("/metrics-test/:vmid", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := httpMetricsMiddleware(router)(router)
+
+	for _, path := range []string{"/metrics-test/100", "/metrics-test/101", "/metrics-test-missing/102"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var buf bytes.Buffer
+	if _, err := metrics.Default.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, `pvmss_http_requests_total{method="GET",route="/metrics-test/:vmid",code="418"} 2`) {
+		t.Errorf("Expected both requests counted under their route pattern, got:\n%s", out)
+	}
+	if !strings.Contains(out, `pvmss_http_requests_total{method="GET",route="unmatched",code="404"}`) {
+		t.Errorf("Expected the unknown path counted as unmatched, got:\n%s", out)
+	}
+	if strings.Contains(out, "metrics-test-missing") {
+		t.Errorf("Expected no series for the unknown path, got:\n%s", out)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+	_ "time/tzdata" // schedule timezones must resolve in images without zoneinfo
+
+	"pvmss/constants"
+	"pvmss/logger"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// powerSchedulerUser is recorded as the initiator of the Proxmox tasks started by the scheduler
+const powerSchedulerUser = "power-scheduler"
+
+var (
+	powerSchedulerMu      sync.Mutex
+	powerSchedulerStarted bool
+)
+
+// StartPowerScheduler starts a non-blocking background goroutine that runs the VM power
+// schedules that came due. Runs every PowerScheduleInterval.
+func StartPowerScheduler(sm VMStateManager) {
+	powerSchedulerMu.Lock()
+	if powerSchedulerStarted {
+		powerSchedulerMu.Unlock()
+		return
+	}
+	powerSchedulerStarted = true
+	powerSchedulerMu.Unlock()
+
+	go func() {
+		// Immediate pass so schedules due while PVMSS restarted still run
+		runDueSchedules(context.Background(), sm, time.Now())
+
+		ticker := time.NewTicker(constants.PowerScheduleInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runDueSchedules(context.Background(), sm, now)
+		}
+	}()
+}
+
+// runVMPowerAction starts an action on a VM and waits for its task. A shutdown that fails or
+// does not complete within PowerScheduleShutdownTimeout is followed by a forced stop.
+func runVMPowerAction(ctx context.Context, client proxmox.ClientInterface, vm proxmox.ClusterVM, action string) (fallback bool, err error) {
+	vmid := strconv.Itoa(vm.VMID)
+
+	timeout := constants.VMTaskWaitTimeout
+	if action == state.ScheduleActionShutdown {
+		timeout = constants.PowerScheduleShutdownTimeout
+	}
+	actionCtx, cancel := context.WithTimeout(ctx, timeout)
+	upid, err := proxmox.VMActionWithContext(actionCtx, client, vm.Node, vmid, action)
+	if err == nil {
+		_, err = proxmox.TrackAndWaitTask(actionCtx, client, upid, vm.VMID, action, powerSchedulerUser)
+	}
+	cancel()
+	if err == nil || action != state.ScheduleActionShutdown {
+		return false, err
+	}
+
+	logger.Get().Warn().Err(err).Int("vmid", vm.VMID).Msg("Scheduled shutdown did not complete; stopping the VM")
+	stopCtx, cancel := context.WithTimeout(ctx, constants.VMTaskWaitTimeout)
+	defer cancel()
+	upid, err = proxmox.VMActionWithContext(stopCtx, client, vm.Node, vmid, "stop")
+	if err != nil {
+		return true, err
+	}
+	_, err = proxmox.TrackAndWaitTask(stopCtx, client, upid, vm.VMID, "stop", powerSchedulerUser)
+	return true, err
+}
+
+// runSchedule executes one due schedule and records the run
+func runSchedule(ctx context.Context, client proxmox.ClientInterface, store *state.VMScheduleStore, audit *state.AuditLogStore, schedule state.VMSchedule, vm proxmox.ClusterVM, now time.Time) {
+	log := logger.Get().With().Str("component", "PowerScheduler").Str("schedule_id", schedule.ID).Int("vmid", schedule.VMID).Logger()
+	run := state.ScheduleRun{
+		Time:       now.UTC(),
+		ScheduleID: schedule.ID,
+		VMID:       schedule.VMID,
+		Username:   schedule.Username,
+		Action:     schedule.Action,
+		Result:     state.ScheduleRunOK,
+	}
+
+	err := CheckVMOwnership(ctx, client, schedule.Username, false, schedule.VMID)
+	switch {
+	case errors.Is(err, ErrVMAccessDenied):
+		log.Info().Str("username", schedule.Username).Msg("Skipping the schedule of a VM the user no longer owns")
+		run.Result = state.ScheduleRunSkipped
+	case err != nil:
+		log.Warn().Err(err).Msg("Unable to verify VM ownership")
+		run.Result = state.ScheduleRunFailed
+		run.Error = err.Error()
+	case schedule.Action == state.ScheduleActionStart && vm.Status == "running",
+		schedule.Action == state.ScheduleActionShutdown && vm.Status == "stopped":
+		log.Debug().Str("status", vm.Status).Msg("VM already in the scheduled state")
+	default:
+		fallback, err := runVMPowerAction(ctx, client, vm, schedule.Action)
+		run.Fallback = fallback
+		recordSystemAudit(audit, state.AuditEvent{
+			Action: "vm." + schedule.Action,
+			VMID:   schedule.VMID,
+			Pool:   vm.Pool,
+			Params: map[string]string{"node": vm.Node, "schedule": schedule.ID, "owner": schedule.Username, "fallback": strconv.FormatBool(fallback)},
+		}, err)
+		if err != nil {
+			log.Error().Err(err).Str("action", schedule.Action).Msg("Scheduled power action failed")
+			run.Result = state.ScheduleRunFailed
+			run.Error = err.Error()
+		} else {
+			log.Info().Str("action", schedule.Action).Bool("fallback", fallback).Msg("Scheduled power action done")
+		}
+	}
+
+	if err := store.Record(run); err != nil {
+		log.Warn().Err(err).Msg("Failed to record schedule run")
+	}
+}
+
+// runDueSchedules makes one pass over the schedules and runs those whose last occurrence has not
+// run yet. Occurrences older than PowerScheduleMissedWindow are marked without running so a long
+// outage does not replay them. Schedules of VMs that no longer exist are dropped.
+func runDueSchedules(ctx context.Context, sm VMStateManager, now time.Time) {
+	log := logger.Get().With().Str("component", "PowerScheduler").Logger()
+
+	client := sm.GetProxmoxClient()
+	store := sm.GetVMScheduleStore()
+	if client == nil || store == nil {
+		return
+	}
+
+	type dueSchedule struct {
+		schedule state.VMSchedule
+		vm       proxmox.ClusterVM
+	}
+	var due []dueSchedule
+	var byID map[int]proxmox.ClusterVM
+
+	for _, schedule := range store.List() {
+		occurrence, ok := schedule.LastOccurrence(now)
+		if !ok || (schedule.LastRun != nil && !occurrence.After(*schedule.LastRun)) {
+			continue
+		}
+
+		if byID == nil {
+			vms, err := proxmox.GetInventoryVMsWithContext(ctx, client)
+			if err != nil {
+				log.Warn().Err(err).Msg("Unable to list VMs; skipping power schedules")
+				return
+			}
+			byID = make(map[int]proxmox.ClusterVM, len(vms))
+			for _, vm := range vms {
+				byID[vm.VMID] = vm
+			}
+		}
+		vm, ok := byID[schedule.VMID]
+		if !ok || (schedule.Name != "" && vm.Name != schedule.Name) {
+			// Deleted, or the VMID now belongs to another VM
+			log.Info().Str("schedule_id", schedule.ID).Int("vmid", schedule.VMID).Msg("Dropping the schedule of a VM that no longer exists")
+			if err := store.Delete(schedule.ID); err != nil {
+				log.Warn().Err(err).Str("schedule_id", schedule.ID).Msg("Failed to drop VM schedule")
+			}
+			continue
+		}
+
+		// Marked before running so a slow action is never started twice
+		if err := store.MarkRun(schedule.ID, occurrence); err != nil {
+			log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("Failed to record schedule occurrence")
+			continue
+		}
+		if now.Sub(occurrence) > constants.PowerScheduleMissedWindow {
+			log.Info().Str("schedule_id", schedule.ID).Time("occurrence", occurrence).Msg("Schedule occurrence missed; not running it late")
+			continue
+		}
+		due = append(due, dueSchedule{schedule: schedule, vm: vm})
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range due {
+		wg.Add(1)
+		go func(d dueSchedule) {
+			defer wg.Done()
+			runSchedule(ctx, client, store, sm.GetAuditLogStore(), d.schedule, d.vm, now)
+		}(d)
+	}
+	wg.Wait()
+}
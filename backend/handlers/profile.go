@@ -9,6 +9,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 
+	"pvmss/constants"
 	"pvmss/i18n"
 	"pvmss/proxmox"
 	"pvmss/state"
@@ -28,15 +29,19 @@ func NewProfileHandler(sm state.StateManager) *ProfileHandler {
 func (h *ProfileHandler) RegisterRoutes(router *httprouter.Router) {
 	router.GET("/profile", RequireAuthHandle(h.ShowProfile))
 	router.POST("/profile/update-password", RequireAuthHandle(h.UpdatePassword))
+	router.POST("/profile/tokens", RequireAuthHandle(h.CreateAPIToken))
+	router.POST("/profile/tokens/:id/revoke", RequireAuthHandle(h.RevokeAPIToken))
+	router.POST("/profile/sessions/revoke", RequireAuthHandle(h.RevokeSession))
+	router.POST("/profile/sessions/revoke-others", RequireAuthHandle(h.RevokeOtherSessions))
 }
 
 // VMInfo represents a VM in the user's pool
 type VMInfo struct {
-	VMID        int
-	Name        string
-	Description string
-	Node        string
-	Status      string
+	VMID   int
+	Name   string
+	Node   string
+	Status string
+	Lease  *LeaseView // nil when the VM never expires
 }
 
 // ShowProfile renders the user profile page
@@ -64,7 +69,10 @@ func (h *ProfileHandler) ShowProfile(w http.ResponseWriter, r *http.Request, _ h
 	}
 
 	// Derive pool name from username
-	poolName := "pvmss_" + username
+	poolName := userPoolName(username)
+	// Passwords of other realms are managed by their directory
+	_, realm := splitUserID(username)
+	canChangePassword := realm == constants.DefaultLoginRealm
 
 	// Get Proxmox client
 	client := h.stateManager.GetProxmoxClient()
@@ -72,14 +80,16 @@ func (h *ProfileHandler) ShowProfile(w http.ResponseWriter, r *http.Request, _ h
 		ctx.Log.Error().Msg("Proxmox client not available")
 		// Render page without VMs
 		data := map[string]interface{}{
-			"Title":           ctx.Translate("Profile.Title"),
-			"Username":        username,
-			"PoolName":        poolName,
-			"VMs":             []VMInfo{},
-			"ProxmoxError":    true,
-			"Lang":            i18n.GetLanguage(r),
-			"IsAuthenticated": true,
-			"IsAdmin":         ctx.IsAdmin(),
+			"Title":             ctx.Translate("Profile.Title"),
+			"Username":          username,
+			"PoolName":          poolName,
+			"CanChangePassword": canChangePassword,
+			"Realm":             realm,
+			"VMs":               []VMInfo{},
+			"ProxmoxError":      true,
+			"Lang":              i18n.GetLanguage(r),
+			"IsAuthenticated":   true,
+			"IsAdmin":           ctx.IsAdmin(),
 		}
 		ctx.RenderTemplate("profile", data)
 		return
@@ -101,23 +111,73 @@ func (h *ProfileHandler) ShowProfile(w http.ResponseWriter, r *http.Request, _ h
 	// Fetch VMs from the user's pool
 	vms := h.fetchUserVMs(r.Context(), client, poolName)
 
+	// Backups owned by the user, including those of deleted VMs
+	settings := h.stateManager.GetSettings()
+	backupsEnabled := settings != nil && settings.BackupStorage != ""
+	var backups []BackupView
+	if backupsEnabled {
+		if list, err := listUserBackups(r.Context(), client, settings, username, false, 0); err == nil {
+			backups = toBackupViews(list)
+		} else {
+			ctx.Log.Warn().Err(err).Msg("Unable to list user backups")
+		}
+	}
+	backupLimit, backupLimited := perUserLimit(settings, "backups")
+
+	// Resources held by the pool against the user's quota
+	quota := userQuota(settings, username)
+	var quotaView []QuotaLine
+	if quota.Limited() {
+		if usage, err := poolQuotaUsage(r.Context(), client, poolName, 0); err == nil {
+			quotaView = quotaLines(quota, usage)
+		} else {
+			ctx.Log.Warn().Err(err).Msg("Unable to compute quota usage")
+		}
+	}
+
 	// Check for password update messages and form visibility
 	passwordSuccess := r.URL.Query().Get("password_success") == "1"
 	passwordError := r.URL.Query().Get("password_error")
 	showPasswordForm := r.URL.Query().Get("show_password_form") == "1" || passwordError != ""
 
+	// A newly created API token is displayed once, then dropped from the session
+	var newAPIToken string
+	if ctx.SessionManager != nil {
+		newAPIToken = ctx.SessionManager.PopString(r.Context(), constants.SessionKeyNewAPIToken)
+	}
+
+	// Other signed-in sessions of the user, to revoke the ones not recognised
+	sessions, err := listUserSessions(r, ctx.SessionManager, username)
+	if err != nil {
+		ctx.Log.Warn().Err(err).Msg("Unable to list user sessions")
+	}
+
 	// Prepare template data
 	data := map[string]interface{}{
-		"Title":            ctx.Translate("Profile.Title"),
-		"Username":         username,
-		"PoolName":         poolName,
-		"VMs":              vms,
-		"Lang":             i18n.GetLanguage(r),
-		"IsAuthenticated":  true,
-		"IsAdmin":          ctx.IsAdmin(),
-		"PasswordSuccess":  passwordSuccess,
-		"PasswordError":    passwordError,
-		"ShowPasswordForm": showPasswordForm,
+		"Title":             ctx.Translate("Profile.Title"),
+		"Username":          username,
+		"PoolName":          poolName,
+		"VMs":               vms,
+		"Lang":              i18n.GetLanguage(r),
+		"IsAuthenticated":   true,
+		"IsAdmin":           ctx.IsAdmin(),
+		"PasswordSuccess":   passwordSuccess,
+		"PasswordError":     passwordError,
+		"ShowPasswordForm":  showPasswordForm && canChangePassword,
+		"CanChangePassword": canChangePassword,
+		"Realm":             realm,
+		"APITokens":         h.listProfileAPITokens(username),
+		"NewAPIToken":       newAPIToken,
+		"APITokenMaxDays":   constants.MaxAPITokenLifetimeDays,
+		"BackupsEnabled":    backupsEnabled,
+		"Backups":           backups,
+		"BackupLimited":     backupLimited,
+		"BackupLimit":       backupLimit,
+		"Quota":             quotaView,
+		"VMRequests":        userVMRequestViews(h.stateManager, username),
+		"Sessions":          sessions,
+		"SuccessMessage":    r.URL.Query().Get("success_msg"),
+		"ErrorMessage":      r.URL.Query().Get("error_msg"),
 	}
 
 	ctx.RenderTemplate("profile", data)
@@ -131,77 +191,43 @@ func (h *ProfileHandler) fetchUserVMs(ctx context.Context, client proxmox.Client
 	fetchCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
-	// First, get pool members to know which VMIDs belong to this pool
-	var poolResp struct {
-		Data struct {
-			Members []struct {
-				Type     string `json:"type"`
-				VMID     int    `json:"vmid"`
-				Template int    `json:"template"`
-			} `json:"members"`
-		} `json:"data"`
-	}
-
-	if err := client.GetJSON(fetchCtx, "/pools/"+url.PathEscape(poolName), &poolResp); err != nil {
-		log.Error().Err(err).Str("pool", poolName).Msg("Failed to fetch pool members")
-		return []VMInfo{}
-	}
-
-	// Build a set of VMIDs in this pool (excluding templates and non-QEMU)
-	poolVMIDs := make(map[int]bool)
-	for _, member := range poolResp.Data.Members {
-		if member.Template == 1 || member.VMID <= 0 {
-			continue
-		}
-		if strings.EqualFold(member.Type, "qemu") {
-			poolVMIDs[member.VMID] = true
-		}
-	}
-
-	if len(poolVMIDs) == 0 {
-		log.Info().Str("pool", poolName).Msg("No VMs found in pool")
-		return []VMInfo{}
-	}
-
-	// Get all VMs with their status (already populated by GetVMsWithContext)
-	allVMs, err := proxmox.GetVMsWithContext(fetchCtx, client)
+	// The cluster inventory carries the pool of every VM
+	allVMs, err := proxmox.GetInventoryVMsWithContext(fetchCtx, client)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get all VMs")
 		return []VMInfo{}
 	}
 
-	// Filter VMs to only include those in the user's pool
+	// Filter VMs to only include those in the user's pool (excluding templates)
 	vms := make([]VMInfo, 0)
 	for _, vm := range allVMs {
-		if poolVMIDs[vm.VMID] {
-			status := vm.Status
-			if status == "" {
-				// Fallback: if status is empty and uptime is 0, assume stopped
-				if vm.Uptime == 0 {
-					status = "stopped"
-				} else {
-					status = "unknown"
-				}
+		if vm.Pool != poolName || vm.Template == 1 {
+			continue
+		}
+		status := vm.Status
+		if status == "" {
+			// Fallback: if status is empty and uptime is 0, assume stopped
+			if vm.Uptime == 0 {
+				status = "stopped"
+			} else {
+				status = "unknown"
 			}
+		}
 
-			// Get VM description from config
-			var description string
-			if vmConfig, err := proxmox.GetVMConfigWithContext(fetchCtx, client, vm.Node, vm.VMID); err == nil {
-				if desc, exists := vmConfig["description"]; exists {
-					if descStr, ok := desc.(string); ok {
-						description = descStr
-					}
-				}
-			}
+		// The description is only in the VM config, so it is shown on the details page
+		// rather than read for every VM of the list
+		vms = append(vms, VMInfo{
+			VMID:   vm.VMID,
+			Name:   vm.Name,
+			Node:   vm.Node,
+			Status: strings.ToLower(status),
+			Lease:  vmLeaseView(h.stateManager, vm.VMID, false),
+		})
+	}
 
-			vms = append(vms, VMInfo{
-				VMID:        vm.VMID,
-				Name:        vm.Name,
-				Description: description,
-				Node:        vm.Node,
-				Status:      strings.ToLower(status),
-			})
-		}
+	if len(vms) == 0 {
+		log.Info().Str("pool", poolName).Msg("No VMs found in pool")
+		return vms
 	}
 
 	log.Info().
@@ -259,6 +285,13 @@ func (h *ProfileHandler) UpdatePassword(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	user, realm := splitUserID(username)
+	if realm != constants.DefaultLoginRealm {
+		log.Warn().Str("username", username).Msg("Password change attempted for a user outside the pve realm")
+		http.Redirect(w, r, "/profile?password_error="+url.QueryEscape("Your password is managed by the "+realm+" realm"), http.StatusSeeOther)
+		return
+	}
+
 	// Get form values
 	currentPassword := r.FormValue("current_password")
 	newPassword := r.FormValue("new_password")
@@ -307,8 +340,8 @@ func (h *ProfileHandler) UpdatePassword(w http.ResponseWriter, r *http.Request,
 	}
 
 	// Authenticate with current password to verify it's correct
-	ticketResp, err := proxmox.CreateTicket(ctx, cookieClient, username, currentPassword, &proxmox.CreateTicketOptions{
-		Realm: "pve",
+	ticketResp, err := proxmox.CreateTicket(ctx, cookieClient, user, currentPassword, &proxmox.CreateTicketOptions{
+		Realm: realm,
 	})
 	if err != nil {
 		log.Info().Err(err).Str("username", username).Msg("Current password verification failed")
@@ -321,7 +354,9 @@ func (h *ProfileHandler) UpdatePassword(w http.ResponseWriter, r *http.Request,
 	cookieClient.CSRFPreventionToken = ticketResp.CSRFPreventionToken
 
 	// Update password - Proxmox requires current password as confirmation
-	if err := proxmox.UpdateUserPassword(ctx, cookieClient, username, newPassword, currentPassword, "pve"); err != nil {
+	err = proxmox.UpdateUserPassword(ctx, cookieClient, user, newPassword, currentPassword, realm)
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionPasswordChange, Params: map[string]string{"realm": realm}}, err)
+	if err != nil {
 		log.Error().Err(err).Str("username", username).Msg("Failed to update password")
 		http.Redirect(w, r, "/profile?show_password_form=1&password_error="+url.QueryEscape("Failed to update password: "+err.Error()), http.StatusSeeOther)
 		return
@@ -330,8 +365,8 @@ func (h *ProfileHandler) UpdatePassword(w http.ResponseWriter, r *http.Request,
 	log.Info().Str("username", username).Msg("Password updated successfully")
 
 	// Update session with new PVE credentials
-	newTicketResp, err := proxmox.CreateTicket(ctx, cookieClient, username, newPassword, &proxmox.CreateTicketOptions{
-		Realm: "pve",
+	newTicketResp, err := proxmox.CreateTicket(ctx, cookieClient, user, newPassword, &proxmox.CreateTicketOptions{
+		Realm: realm,
 	})
 	if err == nil {
 		sessionManager.Put(r.Context(), "pve_auth_cookie", newTicketResp.Ticket)
@@ -339,6 +374,20 @@ func (h *ProfileHandler) UpdatePassword(w http.ResponseWriter, r *http.Request,
 		sessionManager.Put(r.Context(), "pve_ticket_created", time.Now().Unix())
 	}
 
+	// A password change signs out the other sessions and revokes the API tokens, which may belong
+	// to whoever knew the old password
+	currentID := sessionManager.GetString(r.Context(), constants.SessionKeyID)
+	if n, err := revokeUserSessions(r.Context(), sessionManager, username, currentID); err != nil {
+		log.Warn().Err(err).Str("username", username).Msg("Failed to revoke other sessions after password change")
+	} else if n > 0 {
+		log.Info().Str("username", username).Int("sessions", n).Msg("Other sessions revoked after password change")
+	}
+	if n, err := revokeUserAPITokens(h.stateManager, username); err != nil {
+		log.Warn().Err(err).Str("username", username).Msg("Failed to revoke API tokens after password change")
+	} else if n > 0 {
+		log.Info().Str("username", username).Int("tokens", n).Msg("API tokens revoked after password change")
+	}
+
 	// Redirect with success message
 	http.Redirect(w, r, "/profile?password_success=1", http.StatusSeeOther)
 }
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// quotaUnlimited marks a quota field without a cap
+const quotaUnlimited = -1
+
+// quotaFields are the keys of a quota entry in settings, in display order:
+// number of VMs, total vCPUs (sockets * cores), total RAM in GB and total disk in GB
+var quotaFields = []string{"vms", "cores", "ram", "disk"}
+
+// UserQuota caps the resources a user may hold across all VMs of their pool.
+// A negative value means unlimited.
+type UserQuota struct {
+	VMs    int
+	Cores  int
+	RAMGB  int
+	DiskGB int
+}
+
+// get returns the quota value of a settings key
+func (q UserQuota) get(field string) int {
+	switch field {
+	case "vms":
+		return q.VMs
+	case "cores":
+		return q.Cores
+	case "ram":
+		return q.RAMGB
+	case "disk":
+		return q.DiskGB
+	}
+	return quotaUnlimited
+}
+
+// set changes the quota value of a settings key
+func (q *UserQuota) set(field string, v int) {
+	switch field {
+	case "vms":
+		q.VMs = v
+	case "cores":
+		q.Cores = v
+	case "ram":
+		q.RAMGB = v
+	case "disk":
+		q.DiskGB = v
+	}
+}
+
+// Limited reports whether any field of the quota is capped
+func (q UserQuota) Limited() bool {
+	for _, f := range quotaFields {
+		if q.get(f) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// unlimitedQuota returns a quota without any cap
+func unlimitedQuota() UserQuota {
+	return UserQuota{VMs: quotaUnlimited, Cores: quotaUnlimited, RAMGB: quotaUnlimited, DiskGB: quotaUnlimited}
+}
+
+// quotaInt reads an integer settings value, which is a float64 once loaded from JSON
+func quotaInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
+
+// overlayQuota returns base with the fields present in raw replaced
+func overlayQuota(base UserQuota, raw map[string]interface{}) UserQuota {
+	for _, f := range quotaFields {
+		if v, ok := quotaInt(raw[f]); ok {
+			if v < 0 {
+				v = quotaUnlimited
+			}
+			base.set(f, v)
+		}
+	}
+	return base
+}
+
+// quotaSettings returns the "quotas" limits entry, creating it when create is true
+func quotaSettings(settings *state.AppSettings, create bool) map[string]interface{} {
+	if settings == nil {
+		return nil
+	}
+	if settings.Limits == nil {
+		if !create {
+			return nil
+		}
+		settings.Limits = make(map[string]interface{})
+	}
+	raw, _ := settings.Limits["quotas"].(map[string]interface{})
+	if raw == nil && create {
+		raw = make(map[string]interface{})
+		settings.Limits["quotas"] = raw
+	}
+	return raw
+}
+
+// defaultQuota returns the global quota applied to users without an override
+func defaultQuota(settings *state.AppSettings) UserQuota {
+	q := unlimitedQuota()
+	if raw, ok := quotaSettings(settings, false)["default"].(map[string]interface{}); ok {
+		q = overlayQuota(q, raw)
+	}
+	return q
+}
+
+// quotaUserKey returns the key of a user's quota override: the Proxmox user ID, so alice and
+// alice@pve name the same user, and users of other realms keep their realm and case
+func quotaUserKey(username string) string {
+	return proxmoxUserID(strings.TrimSpace(username))
+}
+
+// quotaOverrides returns the per-user quota overrides keyed by quotaUserKey. Keys saved
+// without a realm are read as pve users; a key with the realm takes precedence over them.
+func quotaOverrides(settings *state.AppSettings) map[string]map[string]interface{} {
+	overrides := make(map[string]map[string]interface{})
+	users, _ := quotaSettings(settings, false)["users"].(map[string]interface{})
+	for username, raw := range users {
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key := quotaUserKey(username)
+		if _, exists := overrides[key]; exists && username != key {
+			continue
+		}
+		overrides[key] = m
+	}
+	return overrides
+}
+
+// userQuota returns the effective quota of a user: the global default with the fields
+// of the user's override applied on top
+func userQuota(settings *state.AppSettings, username string) UserQuota {
+	q := defaultQuota(settings)
+	if override, ok := quotaOverrides(settings)[quotaUserKey(username)]; ok {
+		q = overlayQuota(q, override)
+	}
+	return q
+}
+
+// quotaToMap converts a quota to its settings form, leaving unlimited fields out
+func quotaToMap(q UserQuota) map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, f := range quotaFields {
+		if v := q.get(f); v >= 0 {
+			m[f] = v
+		}
+	}
+	return m
+}
+
+// QuotaUsage is what the VMs of a user's pool currently hold
+type QuotaUsage struct {
+	VMs    int
+	Cores  int
+	RAMMB  int64
+	DiskGB int
+}
+
+// poolQuotaUsage sums the VMs of a pool from the cluster inventory, skipping excludeVMID
+// when it is greater than zero. Disk usage is the size of each VM's boot disk.
+func poolQuotaUsage(ctx context.Context, client proxmox.ClientInterface, pool string, excludeVMID int) (QuotaUsage, error) {
+	var usage QuotaUsage
+	vms, err := proxmox.GetInventoryVMsWithContext(ctx, client)
+	if err != nil {
+		return usage, err
+	}
+	for _, vm := range vms {
+		if vm.Pool != pool || vm.Template == 1 || (excludeVMID > 0 && vm.VMID == excludeVMID) {
+			continue
+		}
+		usage.VMs++
+		usage.Cores += vm.MaxCPU
+		usage.RAMMB += vm.MaxMemMB()
+		usage.DiskGB += int(vm.MaxDisk / (1024 * 1024 * 1024))
+	}
+	return usage, nil
+}
+
+// checkQuota returns an error naming the first quota the requested resources would exceed.
+// newVM counts one more VM, which is not the case for a resize.
+func checkQuota(q UserQuota, usage QuotaUsage, newVM bool, cores, memoryMB, diskGB int) error {
+	if newVM && q.VMs >= 0 && usage.VMs+1 > q.VMs {
+		return fmt.Errorf("VM quota reached (%d of %d VMs)", usage.VMs, q.VMs)
+	}
+	if q.Cores >= 0 && usage.Cores+cores > q.Cores {
+		return fmt.Errorf("vCPU quota exceeded (using %d, requested %d, quota %d)", usage.Cores, cores, q.Cores)
+	}
+	if q.RAMGB >= 0 && usage.RAMMB+int64(memoryMB) > int64(q.RAMGB)*1024 {
+		return fmt.Errorf("RAM quota exceeded (using %d MB, requested %d MB, quota %d GB)", usage.RAMMB, memoryMB, q.RAMGB)
+	}
+	if q.DiskGB >= 0 && usage.DiskGB+diskGB > q.DiskGB {
+		return fmt.Errorf("disk quota exceeded (using %d GB, requested %d GB, quota %d GB)", usage.DiskGB, diskGB, q.DiskGB)
+	}
+	return nil
+}
+
+// validateUserQuota checks a VM of the given size against the quota of username.
+// excludeVMID is the VM being resized, or 0 for a new VM.
+func validateUserQuota(ctx context.Context, client proxmox.ClientInterface, settings *state.AppSettings, username string, excludeVMID, cores, memoryMB, diskGB int) error {
+	q := userQuota(settings, username)
+	if !q.Limited() {
+		return nil
+	}
+	usage, err := poolQuotaUsage(ctx, client, userPoolName(username), excludeVMID)
+	if err != nil {
+		return fmt.Errorf("unable to compute quota usage: %w", err)
+	}
+	return checkQuota(q, usage, excludeVMID == 0, cores, memoryMB, diskGB)
+}
+
+// validateCreateQuota checks a validated creation request against the quota of username
+func (h *VMHandler) validateCreateQuota(ctx context.Context, client proxmox.ClientInterface, username string, spec *VMCreateSpec) error {
+	err := validateUserQuota(ctx, client, h.stateManager.GetSettings(), username, 0, spec.Sockets*spec.Cores, spec.MemoryMB, spec.DiskSizeGB)
+	if err != nil {
+		return &vmCreateError{Status: http.StatusForbidden, Code: "quota_exceeded", Message: err.Error()}
+	}
+	return nil
+}
+
+// QuotaLine is one resource of the profile quota card
+type QuotaLine struct {
+	Key     string // i18n key of the resource label
+	Used    string
+	Max     string
+	Percent int
+	Limited bool
+}
+
+// quotaLines formats a user's usage against their quota for the profile page
+func quotaLines(q UserQuota, usage QuotaUsage) []QuotaLine {
+	line := func(key string, used, max int, unit string) QuotaLine {
+		l := QuotaLine{Key: key, Used: strconv.Itoa(used) + unit, Limited: max >= 0}
+		if l.Limited {
+			l.Max = strconv.Itoa(max) + unit
+			if max > 0 {
+				l.Percent = min(100, used*100/max)
+			} else if used > 0 {
+				l.Percent = 100
+			}
+		}
+		return l
+	}
+	return []QuotaLine{
+		line("Profile.Quota.VMs", usage.VMs, q.VMs, ""),
+		line("Profile.Quota.Cores", usage.Cores, q.Cores, ""),
+		line("Profile.Quota.RAM", int(usage.RAMMB/1024), q.RAMGB, " GB"),
+		line("Profile.Quota.Disk", usage.DiskGB, q.DiskGB, " GB"),
+	}
+}
+
+// QuotaOverrideView is a per-user override listed on the limits page.
+// Empty strings are fields inherited from the default quota.
+type QuotaOverrideView struct {
+	Username string
+	Values   map[string]string
+}
+
+// quotaOverrideViews lists the per-user overrides sorted by username
+func quotaOverrideViews(settings *state.AppSettings) []QuotaOverrideView {
+	overrides := quotaOverrides(settings)
+	views := make([]QuotaOverrideView, 0, len(overrides))
+	for username, raw := range overrides {
+		view := QuotaOverrideView{Username: username, Values: make(map[string]string)}
+		for _, f := range quotaFields {
+			if v, ok := quotaInt(raw[f]); ok {
+				view.Values[f] = quotaValueString(v)
+			}
+		}
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Username < views[j].Username })
+	return views
+}
+
+// quotaValueString renders a quota value for forms; unlimited is "-1"
+func quotaValueString(v int) string {
+	if v < 0 {
+		return strconv.Itoa(quotaUnlimited)
+	}
+	return strconv.Itoa(v)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"pvmss/state"
+)
+
+func quotaTestSettings() *state.AppSettings {
+	return &state.AppSettings{
+		Limits: map[string]interface{}{
+			"quotas": map[string]interface{}{
+				"default": map[string]interface{}{"vms": float64(1), "cores": float64(6)},
+				"users": map[string]interface{}{
+					"bob": map[string]interface{}{"vms": float64(-1), "ram": float64(8)},
+				},
+			},
+		},
+	}
+}
+
+func TestUserQuotaResolution(t *testing.T) {
+	settings := quotaTestSettings()
+
+	if q := userQuota(nil, "alice"); q.Limited() {
+		t.Errorf("Expected no quota without settings, got %+v", q)
+	}
+	alice := userQuota(settings, "alice")
+	if alice.VMs != 1 || alice.Cores != 6 || alice.RAMGB != quotaUnlimited || alice.DiskGB != quotaUnlimited {
+		t.Errorf("Expected the default quota for alice, got %+v", alice)
+	}
+	bob := userQuota(settings, "bob")
+	if bob.VMs != quotaUnlimited || bob.Cores != 6 || bob.RAMGB != 8 {
+		t.Errorf("Expected bob's override on top of the default, got %+v", bob)
+	}
+
+	views := quotaOverrideViews(settings)
+	if len(views) != 1 || views[0].Username != "bob@pve" || views[0].Values["vms"] != "-1" || views[0].Values["cores"] != "" {
+		t.Errorf("Unexpected override views: %+v", views)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	q := UserQuota{VMs: 2, Cores: 8, RAMGB: 4, DiskGB: quotaUnlimited}
+	usage := QuotaUsage{VMs: 1, Cores: 4, RAMMB: 2048, DiskGB: 500}
+
+	tests := []struct {
+		name     string
+		newVM    bool
+		cores    int
+		memoryMB int
+		wantErr  string
+	}{
+		{name: "fits", newVM: true, cores: 4, memoryMB: 2048},
+		{name: "cores", newVM: true, cores: 5, memoryMB: 1024, wantErr: "vCPU quota"},
+		{name: "ram", newVM: true, cores: 1, memoryMB: 3072, wantErr: "RAM quota"},
+		{name: "unlimited disk", newVM: true, cores: 1, memoryMB: 512},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQuota(q, usage, tt.newVM, tt.cores, tt.memoryMB, 100)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("checkQuota() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Expected %q error, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	usage.VMs = 2
+	if err := checkQuota(q, usage, true, 1, 512, 1); err == nil || !strings.Contains(err.Error(), "VM quota") {
+		t.Errorf("Expected the VM count quota to be reached, got %v", err)
+	}
+	if err := checkQuota(q, usage, false, 1, 512, 1); err != nil {
+		t.Errorf("A resize must not count as a new VM, got %v", err)
+	}
+}
+
+func TestAPIv1CreateVMQuota(t *testing.T) {
+	sm, client := newAPITestState(quotaTestSettings())
+	h := NewAPIv1Handler(sm)
+
+	// alice already holds VM 100, which uses her whole default quota of one VM
+	body := `{"name":"web","node":"pve1","sockets":1,"cores":1,"memory":1024,"disk_size":10,"iso":"local:iso/a.iso","bridge":"vmbr0","storage":"local-lvm"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/vms", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := serveAs(sm, "alice", false, h.CreateVM, req, nil)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+	}
+	if got := decodeAPIErrorBody(t, rec); got.Code != "quota_exceeded" {
+		t.Errorf("Expected code quota_exceeded, got %q", got.Code)
+	}
+	if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+		t.Error("VM must not be created over quota")
+	}
+}
+
+func TestVMResizeQuota(t *testing.T) {
+	sm, client := newAPITestState(quotaTestSettings())
+	client.responses["/nodes/pve1/qemu/100/config"] = map[string]interface{}{
+		"data": map[string]interface{}{
+			"sockets": 1, "cores": 4, "memory": 2048,
+			"boot": "order=scsi0", "scsi0": "local-lvm:vm-100-disk-0,size=32G",
+		},
+	}
+	h := NewVMHandler(sm)
+
+	// The resized VM's own usage is not counted, so 6 cores fit the quota but 7 do not
+	values := url.Values{"vmid": {"100"}, "node": {"pve1"}, "sockets": {"1"}, "cores": {"7"}, "memory": {"2048"}, "disk_size": {"32"}}
+	rec := serveAs(sm, "alice", false, h.VMResizeHandler, newResizeRequest(values), nil)
+	if !strings.Contains(rec.Header().Get("Location"), "error=1") {
+		t.Fatalf("Expected a quota error redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if client.called(http.MethodPost, "/nodes/pve1/qemu/100/config") {
+		t.Fatal("VM must not be resized over quota")
+	}
+
+	values.Set("cores", "6")
+	rec = serveAs(sm, "alice", false, h.VMResizeHandler, newResizeRequest(values), nil)
+	if strings.Contains(rec.Header().Get("Location"), "error=1") {
+		t.Fatalf("Expected the resize to fit the quota, got %s", rec.Header().Get("Location"))
+	}
+}
+
+func TestUpdateQuotaSettings(t *testing.T) {
+	settings := quotaTestSettings()
+	form := func(values url.Values) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/admin/limits/update", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+
+	if err := updateQuotaSettings(form(url.Values{"username": {"carol"}, "quota-disk": {"50"}}), settings, "quota_user"); err != nil {
+		t.Fatalf("updateQuotaSettings() error = %v", err)
+	}
+	if q := userQuota(settings, "carol"); q.DiskGB != 50 || q.VMs != 1 {
+		t.Errorf("Expected carol's disk override on top of the default, got %+v", q)
+	}
+
+	if err := updateQuotaSettings(form(url.Values{"username": {"bob"}}), settings, "quota_user_delete"); err != nil {
+		t.Fatalf("updateQuotaSettings() error = %v", err)
+	}
+	if _, ok := quotaOverrides(settings)["bob@pve"]; ok {
+		t.Error("Expected bob's override to be deleted")
+	}
+
+	// Users of other realms keep their case and realm, as in their session username
+	if err := updateQuotaSettings(form(url.Values{"username": {"Dave.Smith@Corp-LDAP"}, "quota-vms": {"5"}}), settings, "quota_user"); err != nil {
+		t.Fatalf("updateQuotaSettings() error = %v", err)
+	}
+	if q := userQuota(settings, "Dave.Smith@Corp-LDAP"); q.VMs != 5 {
+		t.Errorf("Expected the override to apply to Dave.Smith@Corp-LDAP, got %+v", q)
+	}
+	if q := userQuota(settings, "dave.smith@corp-ldap"); q.VMs != 1 {
+		t.Errorf("Expected another user to keep the default quota, got %+v", q)
+	}
+	for _, bad := range []string{"bad user", "alice@", "alice@pve/x", "a:b"} {
+		if err := updateQuotaSettings(form(url.Values{"username": {bad}, "quota-vms": {"5"}}), settings, "quota_user"); err == nil {
+			t.Errorf("Expected %q to be refused", bad)
+		}
+	}
+
+	if err := updateQuotaSettings(form(url.Values{"quota-vms": {"3"}}), settings, "quotas"); err != nil {
+		t.Fatalf("updateQuotaSettings() error = %v", err)
+	}
+	if q := defaultQuota(settings); q.VMs != 3 || q.Cores != quotaUnlimited {
+		t.Errorf("Expected blank default fields to become unlimited, got %+v", q)
+	}
+
+	if err := updateQuotaSettings(form(url.Values{"quota-vms": {"-5"}}), settings, "quotas"); err == nil {
+		t.Error("Expected an invalid quota value to be rejected")
+	}
+}
+
+func TestAPIv1CreateVMWaitsForQuotaLock(t *testing.T) {
+	sm, _ := newAPITestState(quotaTestSettings())
+	h := NewAPIv1Handler(sm)
+
+	// Another creation of alice holds her quota until its VM is counted
+	unlock := lockUserLimits("alice")
+	done := make(chan int)
+	go func() {
+		body := `{"name":"web","node":"pve1","sockets":1,"cores":1,"memory":1024,"disk_size":10,"iso":"local:iso/a.iso","bridge":"vmbr0","storage":"local-lvm"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/vms", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		done <- serveAs(sm, "alice", false, h.CreateVM, req, nil).Code
+	}()
+
+	select {
+	case code := <-done:
+		unlock()
+		t.Fatalf("Creation must wait for the quota lock, got status %d", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if code := <-done; code != http.StatusForbidden {
+		t.Errorf("Expected status %d once the lock is released, got %d", http.StatusForbidden, code)
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pvmss/logger"
+	"pvmss/middleware"
+)
+
+func TestRequestIDCorrelation(t *testing.T) {
+	var logs bytes.Buffer
+	prev := *logger.Get()
+	logger.SetOutput(&logs)
+	defer func() { *logger.Get() = prev }()
+
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := CreateHandlerLogger("TestHandler", r)
+		log.Warn().Msg("request-id-test")
+		writeAPIError(w, http.StatusNotFound, "not_found", "VM not found")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/vms/100", nil)
+	req.Header.Set(logger.RequestIDHeader, "support-ticket-1234")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get(logger.RequestIDHeader); got != "support-ticket-1234" {
+		t.Errorf("Expected the incoming request ID echoed, got %q", got)
+	}
+	var body APIError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RequestID != "support-ticket-1234" {
+		t.Errorf("Expected the request ID in the JSON error, got %q", body.RequestID)
+	}
+	if !strings.Contains(logs.String(), `"request_id":"support-ticket-1234"`) {
+		t.Errorf("Expected the request ID in the log line, got %s", logs.String())
+	}
+
+	for _, incoming := range []string{"", "bad id\nforged", strings.Repeat("a", 129)} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(logger.RequestIDHeader, incoming)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got := w.Header().Get(logger.RequestIDHeader); len(got) != 32 || got == incoming {
+			t.Errorf("Expected a generated request ID for %q, got %q", incoming, got)
+		}
+	}
+}
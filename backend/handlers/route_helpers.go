@@ -28,9 +28,9 @@ func registerRoute(router *httprouter.Router, method, path string, handler httpr
 	}
 }
 
-// RegisterAdminRoute registers an admin-protected route
-func (rh *RouteHelpers) RegisterAdminRoute(router *httprouter.Router, method, path string, handler func(w http.ResponseWriter, r *http.Request, ps httprouter.Params)) {
-	wrappedHandler := HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+// RegisterAdminRoute registers an admin-protected route open to the roles granting perm
+func (rh *RouteHelpers) RegisterAdminRoute(router *httprouter.Router, method, path string, perm Permission, handler func(w http.ResponseWriter, r *http.Request, ps httprouter.Params)) {
+	wrappedHandler := HandlerFuncToHTTPrHandle(RequireAdminPermission(perm, func(w http.ResponseWriter, r *http.Request) {
 		handler(w, r, httprouter.ParamsFromContext(r.Context()))
 	}))
 	registerRoute(router, method, path, wrappedHandler)
@@ -39,7 +39,7 @@ func (rh *RouteHelpers) RegisterAdminRoute(router *httprouter.Router, method, pa
 // RegisterAdminRouteWithRedirect registers an admin route and its trailing-slash redirect variant
 func (rh *RouteHelpers) RegisterAdminRouteWithRedirect(router *httprouter.Router, path string, handler func(w http.ResponseWriter, r *http.Request, ps httprouter.Params)) {
 	// Main route
-	rh.RegisterAdminRoute(router, "GET", path, handler)
+	rh.RegisterAdminRoute(router, "GET", path, PermissionView, handler)
 
 	// Trailing-slash redirect
 	redirectPath := path + "/"
@@ -60,8 +60,9 @@ func NewAdminPageRoutes() *AdminPageRoutes {
 	}
 }
 
-// RegisterCRUDRoutes registers common CRUD routes for an admin resource
-func (apr *AdminPageRoutes) RegisterCRUDRoutes(router *httprouter.Router, basePath string, handlers map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params)) {
+// RegisterCRUDRoutes registers common CRUD routes for an admin resource. Every role can view
+// the page; the update, toggle, create and delete routes need perm.
+func (apr *AdminPageRoutes) RegisterCRUDRoutes(router *httprouter.Router, basePath string, perm Permission, handlers map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params)) {
 	// Main page (with redirect)
 	if pageHandler, exists := handlers["page"]; exists {
 		apr.helpers.RegisterAdminRouteWithRedirect(router, basePath, pageHandler)
@@ -69,21 +70,21 @@ func (apr *AdminPageRoutes) RegisterCRUDRoutes(router *httprouter.Router, basePa
 
 	// Update handler
 	if updateHandler, exists := handlers["update"]; exists {
-		apr.helpers.RegisterAdminRoute(router, "POST", basePath+"/update", updateHandler)
+		apr.helpers.RegisterAdminRoute(router, "POST", basePath+"/update", perm, updateHandler)
 	}
 
 	// Toggle handler
 	if toggleHandler, exists := handlers["toggle"]; exists {
-		apr.helpers.RegisterAdminRoute(router, "POST", basePath+"/toggle", toggleHandler)
+		apr.helpers.RegisterAdminRoute(router, "POST", basePath+"/toggle", perm, toggleHandler)
 	}
 
 	// Create handler
 	if createHandler, exists := handlers["create"]; exists {
-		apr.helpers.RegisterAdminRoute(router, "POST", basePath+"/create", createHandler)
+		apr.helpers.RegisterAdminRoute(router, "POST", basePath+"/create", perm, createHandler)
 	}
 
 	// Delete handler
 	if deleteHandler, exists := handlers["delete"]; exists {
-		apr.helpers.RegisterAdminRoute(router, "POST", basePath+"/delete", deleteHandler)
+		apr.helpers.RegisterAdminRoute(router, "POST", basePath+"/delete", perm, deleteHandler)
 	}
 }
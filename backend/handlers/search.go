@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -165,24 +164,15 @@ func (h *SearchHandler) searchVMs(ctx context.Context, client proxmox.ClientInte
 		Bool("is_admin", isAdmin).
 		Logger()
 
-	// Get all VMs from Proxmox
-	allVMs, err := proxmox.GetVMsWithContext(ctx, client)
+	// Get all VMs from the cluster inventory (tags and pools included)
+	allVMs, err := proxmox.GetInventoryVMsWithContext(ctx, client)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get VMs: %w", err)
 	}
 
 	log.Info().Int("total_vms", len(allVMs)).Msg("Retrieved all VMs")
 
-	// For non-admin users, get their pool VMs
-	var userPoolVMIDs map[int]bool
-	if !isAdmin && username != "" {
-		poolName := "pvmss_" + username
-		userPoolVMIDs = h.getPoolVMIDs(ctx, client, poolName)
-		log.Info().
-			Str("pool", poolName).
-			Int("pool_vm_count", len(userPoolVMIDs)).
-			Msg("Retrieved user pool VMs")
-	}
+	poolName := userPoolName(username)
 
 	// Filter VMs
 	results := []map[string]interface{}{}
@@ -191,26 +181,12 @@ func (h *SearchHandler) searchVMs(ctx context.Context, client proxmox.ClientInte
 
 	for _, vm := range allVMs {
 		// Check 1: Pool membership for non-admin users
-		if !isAdmin && userPoolVMIDs != nil {
-			if !userPoolVMIDs[vm.VMID] {
-				continue // VM not in user's pool
-			}
+		if !isAdmin && vm.Pool != poolName {
+			continue // VM not in user's pool
 		}
 
-		// Check 2: Get VM config and check for "pvmss" tag
-		cfg, err := proxmox.GetVMConfigWithContext(ctx, client, vm.Node, vm.VMID)
-		if err != nil {
-			log.Debug().Err(err).Int("vmid", vm.VMID).Msg("Failed to get VM config, skipping")
-			continue
-		}
-
-		// Check for pvmss tag
-		if !h.hasTag(cfg, "pvmss") {
-			log.Info().
-				Int("vmid", vm.VMID).
-				Str("name", vm.Name).
-				Interface("tags", cfg["tags"]).
-				Msg("SEARCH V2: VM does not have pvmss tag, skipping")
+		// Check 2: "pvmss" tag
+		if !vm.HasTag("pvmss") {
 			continue
 		}
 
@@ -239,29 +215,24 @@ func (h *SearchHandler) searchVMs(ctx context.Context, client proxmox.ClientInte
 			}
 		}
 
-		// VM passed all filters, add to results
-		description := ""
-		if desc, ok := cfg["description"].(string); ok {
-			description = desc
-		}
-
+		// VM passed all filters. The description is only in the VM config, so it is
+		// shown on the details page rather than read for every result.
 		status := vm.Status
 		if status == "" {
 			status = "unknown"
 		}
 
 		results = append(results, map[string]interface{}{
-			"vmid":        vm.VMID,
-			"name":        vm.Name,
-			"description": description,
-			"node":        vm.Node,
-			"status":      strings.ToLower(status),
+			"vmid":   vm.VMID,
+			"name":   vm.Name,
+			"node":   vm.Node,
+			"status": strings.ToLower(status),
 		})
 
-		log.Info().
+		log.Debug().
 			Int("vmid", vm.VMID).
 			Str("name", vm.Name).
-			Msg("SEARCH V2: VM matched all criteria")
+			Msg("VM matched all search criteria")
 
 		// Limit results to 50
 		if len(results) >= 50 {
@@ -276,67 +247,3 @@ func (h *SearchHandler) searchVMs(ctx context.Context, client proxmox.ClientInte
 
 	return results, nil
 }
-
-// getPoolVMIDs retrieves VM IDs from a Proxmox pool
-func (h *SearchHandler) getPoolVMIDs(ctx context.Context, client proxmox.ClientInterface, poolName string) map[int]bool {
-	log := logger.Get().With().
-		Str("function", "getPoolVMIDs").
-		Str("pool", poolName).
-		Logger()
-
-	vmids := make(map[int]bool)
-
-	var poolResp struct {
-		Data struct {
-			Members []struct {
-				Type     string `json:"type"`
-				VMID     int    `json:"vmid"`
-				Template int    `json:"template"`
-			} `json:"members"`
-		} `json:"data"`
-	}
-
-	if err := client.GetJSON(ctx, "/pools/"+url.PathEscape(poolName), &poolResp); err != nil {
-		log.Warn().Err(err).Msg("Failed to fetch pool members")
-		return vmids
-	}
-
-	for _, member := range poolResp.Data.Members {
-		if member.Template == 1 || member.VMID <= 0 {
-			continue
-		}
-		if strings.EqualFold(member.Type, "qemu") {
-			vmids[member.VMID] = true
-		}
-	}
-
-	log.Debug().Int("vm_count", len(vmids)).Msg("Pool members retrieved")
-	return vmids
-}
-
-// hasTag checks if a VM config contains a specific tag
-func (h *SearchHandler) hasTag(cfg map[string]interface{}, targetTag string) bool {
-	tagsStr, ok := cfg["tags"].(string)
-	if !ok || tagsStr == "" {
-		return false
-	}
-
-	targetTag = strings.ToLower(strings.TrimSpace(targetTag))
-
-	// Proxmox can use either semicolon or comma as delimiter
-	// Try both separators
-	var tags []string
-	if strings.Contains(tagsStr, ";") {
-		tags = strings.Split(tagsStr, ";")
-	} else {
-		tags = strings.Split(tagsStr, ",")
-	}
-
-	for _, tag := range tags {
-		if strings.ToLower(strings.TrimSpace(tag)) == targetTag {
-			return true
-		}
-	}
-
-	return false
-}
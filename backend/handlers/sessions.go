@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/middleware"
+	"pvmss/security"
+	"pvmss/state"
+)
+
+// sessionTimeFormat is the layout of the session times shown on the profile and admin pages
+const sessionTimeFormat = "2006-01-02 15:04"
+
+// Errors of the session listing and revocation
+var (
+	errSessionsNotListable = errors.New("the session store cannot list sessions")
+	errSessionNotFound     = errors.New("session not found")
+)
+
+// sessionBinding tells which client attributes a signed-in session is bound to. A request whose
+// attributes differ from the ones recorded at login ends the session.
+type sessionBinding struct {
+	IP        bool
+	UserAgent bool
+}
+
+// sessionBindingFromEnv reads SESSION_BIND_IP and SESSION_BIND_USER_AGENT; both are off by default
+// since addresses change behind mobile networks and some proxies
+func sessionBindingFromEnv() sessionBinding {
+	return sessionBinding{
+		IP:        os.Getenv("SESSION_BIND_IP") == "true",
+		UserAgent: os.Getenv("SESSION_BIND_USER_AGENT") == "true",
+	}
+}
+
+// SessionView is a signed-in user session as listed on the profile and admin pages
+type SessionView struct {
+	ID        string
+	Username  string
+	CreatedAt string
+	LastSeen  string
+	IP        string
+	UserAgent string
+	Current   bool
+}
+
+// trackedSession is the tracking data of a session read from the store
+type trackedSession struct {
+	id        string
+	username  string
+	isAdmin   bool
+	createdAt time.Time
+	lastSeen  time.Time
+	ip        string
+	userAgent string
+}
+
+// sessionUserAgent returns the user agent of a request, cut to the recorded length
+func sessionUserAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > constants.MaxSessionUserAgentLength {
+		ua = ua[:constants.MaxSessionUserAgentLength]
+	}
+	return ua
+}
+
+// trackSession records a new identifier, the sign-in time and the client of the session of r
+func trackSession(r *http.Request, sessionManager *scs.SessionManager) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	sessionManager.Put(r.Context(), constants.SessionKeyID, hex.EncodeToString(b))
+	sessionManager.Put(r.Context(), constants.SessionKeyCreatedAt, now)
+	sessionManager.Put(r.Context(), constants.SessionKeyLastSeen, now)
+	sessionManager.Put(r.Context(), constants.SessionKeyClientIP, middleware.ClientIP(r))
+	sessionManager.Put(r.Context(), constants.SessionKeyUserAgent, sessionUserAgent(r))
+	return nil
+}
+
+// sessionTrackingMiddleware records the last activity of signed-in sessions and ends the ones
+// whose client no longer matches the binding. It must run after the session middleware.
+func sessionTrackingMiddleware(binding sessionBinding) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sessionManager := security.GetSession(r)
+			if sessionManager == nil || !sessionManager.GetBool(r.Context(), constants.SessionKeyAuthenticated) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			log := CreateHandlerLogger("sessionTrackingMiddleware", r)
+
+			// Sessions signed in before tracking existed get their identifier now
+			if sessionManager.GetString(r.Context(), constants.SessionKeyID) == "" {
+				if err := trackSession(r, sessionManager); err != nil {
+					log.Error().Err(err).Msg("Failed to track session")
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ip := middleware.ClientIP(r)
+			ipChanged := ip != sessionManager.GetString(r.Context(), constants.SessionKeyClientIP)
+			if (binding.IP && ipChanged) ||
+				(binding.UserAgent && sessionUserAgent(r) != sessionManager.GetString(r.Context(), constants.SessionKeyUserAgent)) {
+				log.Warn().
+					Str("username", sessionManager.GetString(r.Context(), constants.SessionKeyUsername)).
+					Str("client_ip", ip).
+					Msg("Session used from another client, signing it out")
+				if err := sessionManager.Destroy(r.Context()); err != nil {
+					log.Error().Err(err).Msg("Failed to destroy session")
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The last activity is written at most once per interval to spare the store
+			lastSeen := time.Unix(sessionManager.GetInt64(r.Context(), constants.SessionKeyLastSeen), 0)
+			if ipChanged || time.Since(lastSeen) >= constants.SessionActivityInterval {
+				sessionManager.Put(r.Context(), constants.SessionKeyLastSeen, time.Now().Unix())
+				sessionManager.Put(r.Context(), constants.SessionKeyClientIP, ip)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// eachTrackedSession calls fn with the context and tracking data of every signed-in session of
+// the store. Calling Destroy on the context ends that session.
+func eachTrackedSession(ctx context.Context, sessionManager *scs.SessionManager, fn func(context.Context, trackedSession) error) error {
+	if sessionManager == nil {
+		return errSessionsNotListable
+	}
+	if _, ok := sessionManager.Store.(scs.IterableStore); !ok {
+		return errSessionsNotListable
+	}
+	return sessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if !sessionManager.GetBool(ctx, constants.SessionKeyAuthenticated) {
+			return nil
+		}
+		return fn(ctx, trackedSession{
+			id:        sessionManager.GetString(ctx, constants.SessionKeyID),
+			username:  sessionManager.GetString(ctx, constants.SessionKeyUsername),
+			isAdmin:   sessionManager.GetBool(ctx, constants.SessionKeyIsAdmin),
+			createdAt: time.Unix(sessionManager.GetInt64(ctx, constants.SessionKeyCreatedAt), 0),
+			lastSeen:  time.Unix(sessionManager.GetInt64(ctx, constants.SessionKeyLastSeen), 0),
+			ip:        sessionManager.GetString(ctx, constants.SessionKeyClientIP),
+			userAgent: sessionManager.GetString(ctx, constants.SessionKeyUserAgent),
+		})
+	})
+}
+
+// listUserSessions returns the tracked sessions of username, or of every user when username is
+// empty, most recently active first. Administrator sessions are left out.
+func listUserSessions(r *http.Request, sessionManager *scs.SessionManager, username string) ([]SessionView, error) {
+	currentID := ""
+	if sessionManager != nil {
+		currentID = sessionManager.GetString(r.Context(), constants.SessionKeyID)
+	}
+
+	var sessions []trackedSession
+	err := eachTrackedSession(r.Context(), sessionManager, func(_ context.Context, s trackedSession) error {
+		if s.isAdmin || s.id == "" || s.username == "" || (username != "" && s.username != username) {
+			return nil
+		}
+		sessions = append(sessions, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].lastSeen.Equal(sessions[j].lastSeen) {
+			return sessions[i].lastSeen.After(sessions[j].lastSeen)
+		}
+		return sessions[i].id < sessions[j].id
+	})
+	views := make([]SessionView, 0, len(sessions))
+	for _, s := range sessions {
+		views = append(views, SessionView{
+			ID:        s.id,
+			Username:  s.username,
+			CreatedAt: s.createdAt.Local().Format(sessionTimeFormat),
+			LastSeen:  s.lastSeen.Local().Format(sessionTimeFormat),
+			IP:        s.ip,
+			UserAgent: s.userAgent,
+			Current:   s.id == currentID,
+		})
+	}
+	return views, nil
+}
+
+// revokeUserSessions ends the sessions of username, keeping the session identified by exceptID,
+// and returns how many it ended. Sessions are matched on the session username, so administrator
+// sessions are never ended.
+func revokeUserSessions(ctx context.Context, sessionManager *scs.SessionManager, username, exceptID string) (int, error) {
+	return revokeSessions(ctx, sessionManager, func(s trackedSession) bool {
+		return s.username == username && (exceptID == "" || s.id != exceptID)
+	})
+}
+
+// revokeUserSession ends the session of username identified by id, or of any user when username is
+// empty, and reports whether it was found
+func revokeUserSession(ctx context.Context, sessionManager *scs.SessionManager, username, id string) (bool, error) {
+	n, err := revokeSessions(ctx, sessionManager, func(s trackedSession) bool {
+		return s.id == id && s.username != "" && (username == "" || s.username == username)
+	})
+	return n > 0, err
+}
+
+// revokeSessions ends the user sessions matching match
+func revokeSessions(ctx context.Context, sessionManager *scs.SessionManager, match func(trackedSession) bool) (int, error) {
+	revoked := 0
+	err := eachTrackedSession(ctx, sessionManager, func(ctx context.Context, s trackedSession) error {
+		if s.isAdmin || !match(s) {
+			return nil
+		}
+		if err := sessionManager.Destroy(ctx); err != nil {
+			return err
+		}
+		revoked++
+		return nil
+	})
+	return revoked, err
+}
+
+// RevokeSession handles the revocation of one of the other sessions of the user from the profile page
+func (h *ProfileHandler) RevokeSession(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "ProfileHandler.RevokeSession")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+	username := ctx.GetUsername()
+	if username == "" {
+		http.Redirect(w, r, "/profile?error=session_expired", http.StatusSeeOther)
+		return
+	}
+
+	// The current session ends with a logout, not from the list
+	id := strings.TrimSpace(r.FormValue("id"))
+	if id == "" || id == ctx.SessionManager.GetString(r.Context(), constants.SessionKeyID) {
+		ctx.RedirectWithError("/profile", "Profile.SessionRevokeFailed")
+		return
+	}
+	found, err := revokeUserSession(r.Context(), ctx.SessionManager, username, id)
+	if err == nil && !found {
+		err = errSessionNotFound
+	}
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionSessionRevoke, Params: map[string]string{"sessions": "1"}}, err)
+	if err != nil {
+		ctx.Log.Warn().Err(err).Str("username", username).Msg("Failed to revoke session")
+		ctx.RedirectWithError("/profile", "Profile.SessionRevokeFailed")
+		return
+	}
+	ctx.Log.Info().Str("username", username).Msg("Session revoked by its user")
+	ctx.RedirectWithSuccess("/profile", "Profile.SessionRevoked")
+}
+
+// RevokeOtherSessions handles the revocation of every session of the user but the current one
+func (h *ProfileHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "ProfileHandler.RevokeOtherSessions")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+	username := ctx.GetUsername()
+	if username == "" {
+		http.Redirect(w, r, "/profile?error=session_expired", http.StatusSeeOther)
+		return
+	}
+
+	currentID := ctx.SessionManager.GetString(r.Context(), constants.SessionKeyID)
+	n, err := revokeUserSessions(r.Context(), ctx.SessionManager, username, currentID)
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionSessionRevoke, Params: map[string]string{"sessions": strconv.Itoa(n)}}, err)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("username", username).Msg("Failed to revoke sessions")
+		ctx.RedirectWithError("/profile", "Profile.SessionRevokeFailed")
+		return
+	}
+	ctx.Log.Info().Str("username", username).Int("sessions", n).Msg("Other sessions revoked by their user")
+	ctx.RedirectWithSuccess("/profile", "Profile.SessionsRevoked")
+}
+
+// SessionsPage renders the admin page listing the signed-in user sessions
+func (h *UserPoolHandler) SessionsPage(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("SessionsPage", r)
+
+	successMsg := ""
+	switch r.URL.Query().Get("success") {
+	case "revoke":
+		successMsg = "Session revoked"
+	case "revoke_user":
+		successMsg = fmt.Sprintf("Revoked %s session(s) and %s API token(s) of '%s'", r.URL.Query().Get("count"), r.URL.Query().Get("tokens"), r.URL.Query().Get("user"))
+	case "revoke_token":
+		successMsg = "API token revoked"
+	}
+	data := AdminPageDataWithMessage("Sessions", "sessions", successMsg, r.URL.Query().Get("error"))
+
+	sessions, err := listUserSessions(r, h.stateManager.GetSessionManager(), "")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list sessions")
+		data["Error"] = true
+		data["ErrorMessage"] = err.Error()
+	}
+	users := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, s := range sessions {
+		if !seen[s.Username] {
+			seen[s.Username] = true
+			users = append(users, s.Username)
+		}
+	}
+	var tokens []ProfileAPIToken
+	if store := h.stateManager.GetAPITokenStore(); store != nil {
+		tokens = formatAPITokens(store.ListAll())
+	}
+	for _, t := range tokens {
+		if !seen[t.Username] {
+			seen[t.Username] = true
+			users = append(users, t.Username)
+		}
+	}
+	sort.Strings(users)
+	binding := sessionBindingFromEnv()
+
+	data["Sessions"] = sessions
+	data["APITokens"] = tokens
+	data["SessionUsers"] = users
+	data["BindIP"] = binding.IP
+	data["BindUserAgent"] = binding.UserAgent
+
+	// Session lists must reflect the store, not a cached page
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	renderTemplateInternal(w, r, "admin_sessions", data)
+}
+
+// RevokeSessionsHandler ends one user session, or every session and API token of a user
+func (h *UserPoolHandler) RevokeSessionsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("RevokeSessionsHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	redirectError := func(msg string) {
+		http.Redirect(w, r, "/admin/sessions?error="+url.QueryEscape(msg), http.StatusSeeOther)
+	}
+
+	sessionManager := h.stateManager.GetSessionManager()
+	if id := strings.TrimSpace(r.FormValue("id")); id != "" {
+		found, err := revokeUserSession(r.Context(), sessionManager, "", id)
+		if err == nil && !found {
+			err = errSessionNotFound
+		}
+		recordAudit(r, state.AuditEvent{Action: state.AuditActionSessionRevoke, Params: map[string]string{"sessions": "1"}}, err)
+		if errors.Is(err, errSessionNotFound) {
+			redirectError("Session not found; it may have ended already")
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to revoke session")
+			redirectError("Failed to revoke session: " + err.Error())
+			return
+		}
+		log.Info().Str("admin", adminAccountName(r)).Msg("User session revoked by admin")
+		http.Redirect(w, r, "/admin/sessions?success=revoke", http.StatusSeeOther)
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	if username == "" {
+		redirectError("A session or a user is required")
+		return
+	}
+	n, err := revokeUserSessions(r.Context(), sessionManager, username, "")
+	params := map[string]string{"user": username, "sessions": strconv.Itoa(n)}
+	if err != nil {
+		recordAudit(r, state.AuditEvent{Action: state.AuditActionSessionRevoke, Params: params}, err)
+		log.Error().Err(err).Str("username", username).Msg("Failed to revoke user sessions")
+		redirectError("Failed to revoke sessions: " + err.Error())
+		return
+	}
+	tokens, err := revokeUserAPITokens(h.stateManager, username)
+	params["tokens"] = strconv.Itoa(tokens)
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionSessionRevoke, Params: params}, err)
+	if err != nil {
+		log.Error().Err(err).Str("username", username).Msg("Failed to revoke user API tokens")
+		redirectError("Failed to revoke API tokens: " + err.Error())
+		return
+	}
+	log.Info().Str("admin", adminAccountName(r)).Str("username", username).Int("sessions", n).Int("tokens", tokens).Msg("User sessions revoked by admin")
+	http.Redirect(w, r, "/admin/sessions?success=revoke_user&count="+strconv.Itoa(n)+"&tokens="+strconv.Itoa(tokens)+"&user="+url.QueryEscape(username), http.StatusSeeOther)
+}
+
+// RevokeAPITokenHandler deletes an API token of any user
+func (h *UserPoolHandler) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("RevokeAPITokenHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	id := strings.TrimSpace(r.FormValue("id"))
+	store := h.stateManager.GetAPITokenStore()
+	if username == "" || id == "" || store == nil {
+		http.Redirect(w, r, "/admin/sessions?error="+url.QueryEscape("API token not found"), http.StatusSeeOther)
+		return
+	}
+	err := store.Revoke(username, id)
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionTokenRevoke, Params: map[string]string{"user": username, "token": id}}, err)
+	if err != nil {
+		log.Warn().Err(err).Str("username", username).Str("token_id", id).Msg("Failed to revoke API token")
+		http.Redirect(w, r, "/admin/sessions?error="+url.QueryEscape("Failed to revoke API token: "+err.Error()), http.StatusSeeOther)
+		return
+	}
+	log.Info().Str("admin", adminAccountName(r)).Str("username", username).Str("token_id", id).Msg("API token revoked by admin")
+	http.Redirect(w, r, "/admin/sessions?success=revoke_token", http.StatusSeeOther)
+}
+
+// RegisterSessionRoutes registers the admin session routes
+func (h *UserPoolHandler) RegisterSessionRoutes(router *httprouter.Router) {
+	routeHelpers := NewAdminPageRoutes()
+
+	routeHelpers.helpers.RegisterAdminRouteWithRedirect(router, "/admin/sessions", h.SessionsPage)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/sessions/revoke", PermissionOperate, h.RevokeSessionsHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/sessions/tokens/revoke", PermissionOperate, h.RevokeAPITokenHandler)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/middleware"
+)
+
+// userSessionCookies signs a browser in as username and returns its session cookies
+func userSessionCookies(t *testing.T, sm *fakeStateManager, username, userAgent string) []*http.Cookie {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set("User-Agent", userAgent)
+	rec := serveWithCookies(sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if err := establishSession(w, r, false, username); err != nil {
+			t.Fatal(err)
+		}
+	}, req, nil)
+	return rec.Result().Cookies()
+}
+
+// serveTracked runs handler behind the session tracking middleware
+func serveTracked(sm *fakeStateManager, binding sessionBinding, req *http.Request, cookies []*http.Cookie, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	return serveWithCookies(sm, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		sessionTrackingMiddleware(binding)(handler).ServeHTTP(w, r)
+	}, req, cookies)
+}
+
+func TestListAndRevokeUserSessions(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	laptop := userSessionCookies(t, sm, "alice", "laptop")
+	phone := userSessionCookies(t, sm, "alice", "phone")
+	bob := userSessionCookies(t, sm, "bob", "laptop")
+	admin := adminSessionCookies(sm, "")
+
+	var sessions []SessionView
+	serveTracked(sm, sessionBinding{}, httptest.NewRequest(http.MethodGet, "/profile", nil), laptop, func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if sessions, err = listUserSessions(r, sm.sessions, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(sessions) != 2 {
+		t.Fatalf("Expected the two sessions of alice, got %+v", sessions)
+	}
+	var currentID string
+	for _, s := range sessions {
+		if s.Username != "alice" || s.ID == "" || s.CreatedAt == "" || s.IP == "" {
+			t.Errorf("Expected a tracked session of alice, got %+v", s)
+		}
+		if s.Current {
+			currentID = s.ID
+			if s.UserAgent != "laptop" {
+				t.Errorf("Expected the current session to be the laptop, got %+v", s)
+			}
+		}
+	}
+	if currentID == "" {
+		t.Fatal("Expected the current session to be marked")
+	}
+
+	// Revoking the other sessions of alice keeps hers and those of other users
+	n, err := revokeUserSessions(t.Context(), sm.sessions, "alice", currentID)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one session revoked, got %d (%v)", n, err)
+	}
+	authenticated := func(cookies []*http.Cookie) bool {
+		var ok bool
+		serveTracked(sm, sessionBinding{}, httptest.NewRequest(http.MethodGet, "/profile", nil), cookies, func(w http.ResponseWriter, r *http.Request) {
+			ok = IsAuthenticated(r)
+		})
+		return ok
+	}
+	if authenticated(phone) {
+		t.Error("Expected the revoked session to be signed out")
+	}
+	if !authenticated(laptop) || !authenticated(bob) || !authenticated(admin) {
+		t.Error("Expected the other sessions to stay signed in")
+	}
+
+	// Administrator sessions are neither listed nor revoked
+	var all []SessionView
+	serveTracked(sm, sessionBinding{}, httptest.NewRequest(http.MethodGet, "/admin/sessions", nil), admin, func(w http.ResponseWriter, r *http.Request) {
+		all, _ = listUserSessions(r, sm.sessions, "")
+	})
+	if len(all) != 2 {
+		t.Errorf("Expected the sessions of alice and bob, got %+v", all)
+	}
+	for _, s := range all {
+		if s.Username != "bob" {
+			continue
+		}
+		if found, _ := revokeUserSession(t.Context(), sm.sessions, "alice", s.ID); found || !authenticated(bob) {
+			t.Error("Expected a user to only revoke their own sessions")
+		}
+	}
+}
+
+func TestSessionBinding(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	cookies := userSessionCookies(t, sm, "alice", "laptop")
+
+	request := func(binding sessionBinding, userAgent, remoteAddr string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = remoteAddr
+		var ok bool
+		serveTracked(sm, binding, req, cookies, func(w http.ResponseWriter, r *http.Request) {
+			ok = IsAuthenticated(r)
+		})
+		return ok
+	}
+
+	// Without binding, the session follows its user to another address
+	if !request(sessionBinding{}, "laptop", "198.51.100.7:1234") {
+		t.Fatal("Expected an unbound session to survive a new address")
+	}
+	if !request(sessionBinding{IP: true}, "laptop", "198.51.100.7:4321") {
+		t.Fatal("Expected a bound session to accept its recorded address")
+	}
+	if request(sessionBinding{IP: true, UserAgent: true}, "another browser", "198.51.100.7:4321") {
+		t.Fatal("Expected a session bound to its user agent to end with another one")
+	}
+	if request(sessionBinding{}, "laptop", "198.51.100.7:4321") {
+		t.Error("Expected the ended session to stay signed out")
+	}
+}
+
+func TestSessionBindingForwardedFor(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	cookies := userSessionCookies(t, sm, "alice", "laptop") // signed in from httptest's 192.0.2.1
+
+	// A stolen cookie replayed from elsewhere cannot claim the victim's address
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.Header.Set("User-Agent", "laptop")
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	req.Header.Set("X-Real-IP", "192.0.2.1")
+	req.RemoteAddr = "198.51.100.66:4000"
+	ok := true
+	serveTracked(sm, sessionBinding{IP: true}, req, cookies, func(w http.ResponseWriter, r *http.Request) {
+		ok = IsAuthenticated(r)
+	})
+	if ok {
+		t.Error("Expected forwarding headers from an untrusted client to be ignored")
+	}
+
+	// Behind a trusted proxy, the client is the rightmost address the proxies did not add
+	if err := middleware.SetTrustedProxies("10.0.0.0/8, 192.168.1.10"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = middleware.SetTrustedProxies("") })
+	tests := []struct {
+		remoteAddr, forwardedFor, want string
+	}{
+		{"10.0.0.5:80", "203.0.113.9", "203.0.113.9"},
+		{"10.0.0.5:80", "192.0.2.1, 203.0.113.9, 10.1.2.3", "203.0.113.9"},
+		{"192.168.1.10:80", "", "192.168.1.10"},
+		{"198.51.100.66:4000", "203.0.113.9", "198.51.100.66"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		if got := middleware.ClientIP(req); got != tt.want {
+			t.Errorf("ClientIP(%s, %q) = %q, want %q", tt.remoteAddr, tt.forwardedFor, got, tt.want)
+		}
+	}
+	if err := middleware.SetTrustedProxies("proxy.example.com"); err == nil {
+		t.Error("Expected a host name to be refused")
+	}
+}
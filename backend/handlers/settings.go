@@ -17,8 +17,9 @@ func sendSettingsJSONResponse(w http.ResponseWriter, settings *state.AppSettings
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":  "error",
-			"message": "Settings not available",
+			"status":     "error",
+			"message":    "Settings not available",
+			"request_id": responseRequestID(w),
 		}); err != nil {
 			logger.Get().Error().Err(err).Msg("Failed to encode JSON error response")
 		}
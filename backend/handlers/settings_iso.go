@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -260,7 +261,9 @@ func (h *SettingsHandler) ToggleISOHandler(w http.ResponseWriter, r *http.Reques
 
 	// Update settings
 	settings.ISOs = newISOs
-	if err := h.stateManager.SetSettings(settings); err != nil {
+	err := h.stateManager.SetSettings(settings)
+	auditSettings(r, "isos", map[string]string{"volid": volid, "enabled": strconv.FormatBool(enabled)}, err)
+	if err != nil {
 		log.Error().Err(err).Msg("Failed to save settings")
 		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
 		return
@@ -277,7 +280,7 @@ func (h *SettingsHandler) RegisterISORoutes(router *httprouter.Router) {
 	routeHelpers := NewAdminPageRoutes()
 
 	// Register admin ISO routes using helper
-	routeHelpers.RegisterCRUDRoutes(router, "/admin/iso", map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/iso", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
 		"page":   h.ISOPageHandler,
 		"toggle": h.ToggleISOHandler,
 	})
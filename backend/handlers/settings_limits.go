@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"pvmss/proxmox"
+	"pvmss/state"
 )
 
 // LimitsPageHandler renders the Resource Limits page (server-rendered)
@@ -31,6 +33,14 @@ func (h *SettingsHandler) LimitsPageHandler(w http.ResponseWriter, r *http.Reque
 		switch entity {
 		case "vm":
 			successMsg = "VM limits updated"
+		case "snapshots":
+			successMsg = "Snapshot limit updated"
+		case "backups":
+			successMsg = "Backup retention limit updated"
+		case "quotas":
+			successMsg = "User quotas updated"
+		case "auto_approve":
+			successMsg = "Auto-approve limits updated"
 		case "nodes":
 			if nodeParam != "" {
 				successMsg = "Limits updated for node '" + nodeParam + "'"
@@ -52,6 +62,21 @@ func (h *SettingsHandler) LimitsPageHandler(w http.ResponseWriter, r *http.Reque
 
 	// Add limits data
 	data["Limits"] = settings.Limits
+	data["SnapshotsMax"] = ""
+	if max, limited := perUserLimit(settings, "snapshots"); limited {
+		data["SnapshotsMax"] = max
+	}
+	data["BackupsMax"] = ""
+	if max, limited := perUserLimit(settings, "backups"); limited {
+		data["BackupsMax"] = max
+	}
+	quotaDefault := make(map[string]string)
+	for field, v := range quotaToMap(defaultQuota(settings)) {
+		quotaDefault[field] = strconv.Itoa(v.(int))
+	}
+	data["QuotaDefault"] = quotaDefault
+	data["QuotaOverrides"] = quotaOverrideViews(settings)
+	data["AutoApprove"] = autoApproveFormValues(settings)
 
 	// Add selected node from query params
 	nodeParam := r.URL.Query().Get("node")
@@ -118,7 +143,7 @@ func (h *SettingsHandler) UpdateLimitsFormHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	entity := r.FormValue("entityId") // "vm" or "node"
+	entity := r.FormValue("entityId") // "vm", "node", "snapshots", "backups", "auto_approve" or one of the quota entities
 	if entity == "" {
 		redirect := "/admin/limits?error=1&errorMsg=" + url.QueryEscape("Missing entity type")
 		http.Redirect(w, r, redirect, http.StatusSeeOther)
@@ -183,6 +208,31 @@ func (h *SettingsHandler) UpdateLimitsFormHandler(w http.ResponseWriter, r *http
 		entityMap["disk"] = map[string]int{"min": diskMin, "max": diskMax}
 		settings.Limits["vm"] = entityMap
 
+	case "snapshots", "backups":
+		// Per-user snapshot or backup limit; 0 disables the feature for regular users
+		maxPerUser, err := strconv.Atoi(r.FormValue(entity + "-max"))
+		if err != nil || maxPerUser < 0 {
+			redirect := "/admin/limits?error=1&entity=" + entity + "&errorMsg=" + url.QueryEscape("Invalid "+strings.TrimSuffix(entity, "s")+" limit")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+		settings.Limits[entity] = map[string]interface{}{"max_per_user": maxPerUser}
+
+	case "quotas", "quota_user", "quota_user_delete":
+		if err := updateQuotaSettings(r, settings, entity); err != nil {
+			redirect := "/admin/limits?error=1&entity=quotas&errorMsg=" + url.QueryEscape(err.Error())
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+		entity = "quotas" // normalize for redirect
+
+	case "auto_approve":
+		if err := updateAutoApproveSettings(r, settings); err != nil {
+			redirect := "/admin/limits?error=1&entity=auto_approve&errorMsg=" + url.QueryEscape(err.Error())
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+
 	case "node", "nodes":
 		// Per-node limits under limits.nodes[<nodeName>]
 		nodeName := strings.TrimSpace(r.FormValue("nodeName"))
@@ -227,7 +277,9 @@ func (h *SettingsHandler) UpdateLimitsFormHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	if err := h.stateManager.SetSettings(settings); err != nil {
+	err := h.stateManager.SetSettings(settings)
+	auditSettings(r, "limits", map[string]string{"entity": entity, "node": strings.TrimSpace(r.FormValue("nodeName"))}, err)
+	if err != nil {
 		log.Error().Err(err).Msg("Failed to save limits settings")
 		redirect := "/admin/limits?error=1&entity=" + entity
 		if entity == "nodes" {
@@ -251,8 +303,73 @@ func (h *SettingsHandler) RegisterLimitsRoutes(router *httprouter.Router) {
 	routeHelpers := NewAdminPageRoutes()
 
 	// Register admin limits routes using helper
-	routeHelpers.RegisterCRUDRoutes(router, "/admin/limits", map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/limits", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
 		"page":   h.LimitsPageHandler,
 		"update": h.UpdateLimitsFormHandler,
 	})
 }
+
+// parseQuotaForm reads the quota-<field> inputs of a quota form. Blank fields are left out,
+// -1 means unlimited and any other value must be a non-negative integer.
+func parseQuotaForm(r *http.Request) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, field := range quotaFields {
+		raw := strings.TrimSpace(r.FormValue("quota-" + field))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < quotaUnlimited {
+			return nil, fmt.Errorf("invalid %s quota", field)
+		}
+		values[field] = n
+	}
+	return values, nil
+}
+
+// updateQuotaSettings applies a quota form: the global default ("quotas"), a per-user
+// override ("quota_user") or the removal of an override ("quota_user_delete")
+func updateQuotaSettings(r *http.Request, settings *state.AppSettings, entity string) error {
+	quotas := quotaSettings(settings, true)
+
+	if entity == "quotas" {
+		values, err := parseQuotaForm(r)
+		if err != nil {
+			return err
+		}
+		// The default has nothing to inherit from, so blank and -1 both mean unlimited
+		quotas["default"] = quotaToMap(overlayQuota(unlimitedQuota(), values))
+		return nil
+	}
+
+	// Keyed like the lookups, by Proxmox user ID, so the name is validated rather than rewritten
+	username := strings.TrimSpace(r.FormValue("username"))
+	if username == "" {
+		return fmt.Errorf("missing username")
+	}
+	if !validUserID(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+	key := quotaUserKey(username)
+	users, _ := quotas["users"].(map[string]interface{})
+	if users == nil {
+		users = make(map[string]interface{})
+	}
+	// Replace the keys naming the same user, such as one saved without its realm
+	for existing := range users {
+		if quotaUserKey(existing) == key {
+			delete(users, existing)
+		}
+	}
+
+	values, err := parseQuotaForm(r)
+	if err != nil {
+		return err
+	}
+	// An override without any field only inherits the default, so it is dropped
+	if entity != "quota_user_delete" && len(values) > 0 {
+		users[key] = values
+	}
+	quotas["users"] = users
+	return nil
+}
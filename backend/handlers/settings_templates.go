@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// templateOSFamilies lists the OS families an offering can be tagged with
+var templateOSFamilies = []string{"linux", "windows", "bsd", "other"}
+
+// TemplateEntry is a Proxmox template VM as displayed on the admin templates page
+type TemplateEntry struct {
+	VMID         int
+	Node         string
+	ProxmoxName  string
+	DiskGB       int
+	Offering     *state.VMTemplateOffering
+	MissingInPVE bool // offered in settings but no longer a template in Proxmox
+}
+
+// isValidOSFamily reports whether family is one of templateOSFamilies
+func isValidOSFamily(family string) bool {
+	for _, f := range templateOSFamilies {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// bytesToGBCeil converts a disk size in bytes to whole GB, rounding up
+func bytesToGBCeil(b int64) int {
+	const gb = 1024 * 1024 * 1024
+	if b <= 0 {
+		return 0
+	}
+	return int((b + gb - 1) / gb)
+}
+
+// fetchProxmoxTemplates returns all template VMs across nodes, keyed by VMID
+func fetchProxmoxTemplates(ctx context.Context, client proxmox.ClientInterface) (map[int]proxmox.VM, error) {
+	vms, err := proxmox.GetVMsWithContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	templates := make(map[int]proxmox.VM)
+	for _, vm := range vms {
+		if vm.Template == 1 {
+			templates[vm.VMID] = vm
+		}
+	}
+	return templates, nil
+}
+
+// TemplatesPageHandler renders the template offerings page (server-rendered, no JS required)
+func (h *SettingsHandler) TemplatesPageHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("TemplatesPageHandler", r)
+
+	settings := h.stateManager.GetSettings()
+
+	successMsg := ""
+	if r.URL.Query().Get("success") != "" {
+		switch r.URL.Query().Get("action") {
+		case "update":
+			successMsg = "Template '" + r.URL.Query().Get("template") + "' saved"
+		case "delete":
+			successMsg = "Template '" + r.URL.Query().Get("template") + "' removed from offerings"
+		default:
+			successMsg = "Template settings updated"
+		}
+	}
+	errorMsg := r.URL.Query().Get("error")
+
+	data := AdminPageDataWithMessage("Template Management", "templates", successMsg, errorMsg)
+	data["OSFamilies"] = templateOSFamilies
+	data["Templates"] = []TemplateEntry{}
+
+	offerings := make(map[int]*state.VMTemplateOffering)
+	if settings != nil {
+		for i := range settings.Templates {
+			offerings[settings.Templates[i].VMID] = &settings.Templates[i]
+		}
+	}
+
+	proxmoxTemplates := map[int]proxmox.VM{}
+	fetched := false
+	proxmoxConnected, _ := h.stateManager.GetProxmoxStatus()
+	client := h.stateManager.GetProxmoxClient()
+	if !proxmoxConnected || client == nil {
+		data["Warning"] = "Proxmox connection unavailable. Only saved offerings are displayed."
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		list, err := fetchProxmoxTemplates(ctx, client)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch templates for page")
+			data["Warning"] = "Failed to fetch templates from Proxmox."
+		} else {
+			proxmoxTemplates = list
+			fetched = true
+		}
+	}
+
+	entries := make([]TemplateEntry, 0, len(proxmoxTemplates)+len(offerings))
+	for vmid, vm := range proxmoxTemplates {
+		entries = append(entries, TemplateEntry{
+			VMID:        vmid,
+			Node:        vm.Node,
+			ProxmoxName: vm.Name,
+			DiskGB:      bytesToGBCeil(vm.MaxDisk),
+			Offering:    offerings[vmid],
+		})
+	}
+	for vmid, offering := range offerings {
+		if _, ok := proxmoxTemplates[vmid]; ok {
+			continue
+		}
+		entries = append(entries, TemplateEntry{
+			VMID:         vmid,
+			Node:         offering.Node,
+			DiskGB:       offering.MinDiskGB,
+			Offering:     offering,
+			MissingInPVE: fetched,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Node == entries[j].Node {
+			return entries[i].VMID < entries[j].VMID
+		}
+		return strings.ToLower(entries[i].Node) < strings.ToLower(entries[j].Node)
+	})
+	data["Templates"] = entries
+
+	log.Debug().Int("template_count", len(entries)).Msg("Templates page rendered")
+	renderTemplateInternal(w, r, "admin_templates", data)
+}
+
+// UpdateTemplateHandler adds a template to the offerings or updates an existing offering
+func (h *SettingsHandler) UpdateTemplateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("UpdateTemplateHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(strings.TrimSpace(r.FormValue("vmid")))
+	if err != nil || vmid <= 0 {
+		http.Error(w, "Invalid vmid parameter", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	osFamily := strings.TrimSpace(r.FormValue("os_family"))
+	minDisk, err := strconv.Atoi(strings.TrimSpace(r.FormValue("min_disk_gb")))
+	if name == "" || !isValidOSFamily(osFamily) || err != nil || minDisk < 1 {
+		http.Redirect(w, r, "/admin/templates?error="+url.QueryEscape("Invalid template parameters"), http.StatusSeeOther)
+		return
+	}
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		http.Error(w, "Proxmox client unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Only real Proxmox templates can be offered; the node is taken from Proxmox, not the form
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	templates, err := fetchProxmoxTemplates(ctx, client)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch templates")
+		http.Error(w, "Failed to fetch templates from Proxmox", http.StatusBadGateway)
+		return
+	}
+	vm, ok := templates[vmid]
+	if !ok {
+		http.Redirect(w, r, "/admin/templates?error="+url.QueryEscape("VM "+strconv.Itoa(vmid)+" is not a template"), http.StatusSeeOther)
+		return
+	}
+	if diskGB := bytesToGBCeil(vm.MaxDisk); minDisk < diskGB {
+		// A clone cannot be smaller than its template
+		minDisk = diskGB
+	}
+
+	settings := h.stateManager.GetSettings()
+	if settings == nil {
+		http.Error(w, "Settings not available", http.StatusInternalServerError)
+		return
+	}
+
+	offering := state.VMTemplateOffering{VMID: vmid, Node: vm.Node, Name: name, OSFamily: osFamily, MinDiskGB: minDisk}
+	if existing := settings.FindTemplate(vmid); existing != nil {
+		*existing = offering
+	} else {
+		settings.Templates = append(settings.Templates, offering)
+	}
+
+	err = h.stateManager.SetSettings(settings)
+	auditSettings(r, "templates", map[string]string{"vmid": strconv.Itoa(vmid), "name": name, "os_family": osFamily, "min_disk_gb": strconv.Itoa(minDisk)}, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save settings")
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Int("vmid", vmid).Str("name", name).Str("os_family", osFamily).Int("min_disk_gb", minDisk).Msg("Template offering saved")
+	http.Redirect(w, r, "/admin/templates?success=1&action=update&template="+url.QueryEscape(name), http.StatusSeeOther)
+}
+
+// DeleteTemplateHandler removes a template from the offerings (the Proxmox template is untouched)
+func (h *SettingsHandler) DeleteTemplateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("DeleteTemplateHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(strings.TrimSpace(r.FormValue("vmid")))
+	if err != nil {
+		http.Error(w, "Invalid vmid parameter", http.StatusBadRequest)
+		return
+	}
+
+	settings := h.stateManager.GetSettings()
+	if settings == nil {
+		http.Error(w, "Settings not available", http.StatusInternalServerError)
+		return
+	}
+
+	name := ""
+	kept := make([]state.VMTemplateOffering, 0, len(settings.Templates))
+	for _, t := range settings.Templates {
+		if t.VMID == vmid {
+			name = t.Name
+			continue
+		}
+		kept = append(kept, t)
+	}
+	settings.Templates = kept
+
+	err = h.stateManager.SetSettings(settings)
+	auditSettings(r, "templates", map[string]string{"vmid": strconv.Itoa(vmid), "removed": "true"}, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save settings")
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Int("vmid", vmid).Msg("Template offering removed")
+	http.Redirect(w, r, "/admin/templates?success=1&action=delete&template="+url.QueryEscape(name), http.StatusSeeOther)
+}
+
+// RegisterTemplateRoutes registers template offering routes
+func (h *SettingsHandler) RegisterTemplateRoutes(router *httprouter.Router) {
+	routeHelpers := NewAdminPageRoutes()
+
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/templates", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+		"page":   h.TemplatesPageHandler,
+		"update": h.UpdateTemplateHandler,
+		"delete": h.DeleteTemplateHandler,
+	})
+}
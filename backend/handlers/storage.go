@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 	"sort"
@@ -28,6 +29,16 @@ func buildSuccessMessage(r *http.Request) string {
 		return "Storage '" + storage + "' enabled"
 	case "disable":
 		return "Storage '" + storage + "' disabled"
+	case "backup":
+		if storage == "" {
+			return "Backups disabled"
+		}
+		return "Backup storage set to '" + storage + "'"
+	case "snippets":
+		if storage == "" {
+			return "Custom Cloud-Init user-data disabled"
+		}
+		return "Cloud-Init snippets limited to '" + storage + "'"
 	default:
 		return "Storage settings updated"
 	}
@@ -123,7 +134,9 @@ func (h *StorageHandler) ToggleStorageHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	if changed {
-		if err := h.stateManager.SetSettings(settings); err != nil {
+		err := h.stateManager.SetSettings(settings)
+		auditSettings(r, "storages", map[string]string{"storage": storageName, "action": action}, err)
+		if err != nil {
 			log.Error().Err(err).Msg("Error saving settings")
 			http.Error(w, "Error saving settings", http.StatusInternalServerError)
 			return
@@ -135,6 +148,87 @@ func (h *StorageHandler) ToggleStorageHandler(w http.ResponseWriter, r *http.Req
 	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 }
 
+// UpdateBackupStorageHandler sets the storage that receives user-triggered backups.
+// An empty value disables backups for users.
+func (h *StorageHandler) UpdateBackupStorageHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("UpdateBackupStorageHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	storageName := strings.TrimSpace(r.FormValue("backup_storage"))
+	if storageName != "" {
+		client := h.stateManager.GetProxmoxClient()
+		if client == nil {
+			http.Error(w, "Proxmox client not available", http.StatusServiceUnavailable)
+			return
+		}
+		names, err := fetchBackupStorageNames(r.Context(), client)
+		if err != nil {
+			log.Error().Err(err).Msg("Error retrieving backup storages")
+			http.Error(w, "Error retrieving storages", http.StatusBadGateway)
+			return
+		}
+		valid := false
+		for _, name := range names {
+			if name == storageName {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			http.Error(w, "Storage cannot hold backups", http.StatusBadRequest)
+			return
+		}
+	}
+
+	settings := h.stateManager.GetSettings()
+	settings.BackupStorage = storageName
+	err := h.stateManager.SetSettings(settings)
+	auditSettings(r, "backup_storage", map[string]string{"storage": storageName}, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving settings")
+		http.Error(w, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Str("storage", storageName).Msg("Backup storage updated")
+	http.Redirect(w, r, "/admin/storage?success=1&action=backup&storage="+url.QueryEscape(storageName), http.StatusSeeOther)
+}
+
+// UpdateSnippetPrefixHandler sets the snippet storage, and optionally directory, holding the
+// Cloud-Init user-data users may attach to their VMs. An empty value disables custom user-data.
+func (h *StorageHandler) UpdateSnippetPrefixHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("UpdateSnippetPrefixHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	prefix := strings.TrimSpace(r.FormValue("snippet_prefix"))
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if prefix != "" && (!snippetPrefixRegex.MatchString(prefix) || strings.Contains(prefix, "/../") || strings.Contains(prefix, "/./")) {
+		http.Error(w, "Snippet location must look like local:snippets/ or local:snippets/pvmss/", http.StatusBadRequest)
+		return
+	}
+
+	settings := h.stateManager.GetSettings()
+	settings.SnippetPrefix = prefix
+	err := h.stateManager.SetSettings(settings)
+	auditSettings(r, "snippet_prefix", map[string]string{"prefix": prefix}, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving settings")
+		http.Error(w, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Str("prefix", prefix).Msg("Cloud-Init snippet location updated")
+	http.Redirect(w, r, "/admin/storage?success=1&action=snippets&storage="+url.QueryEscape(prefix), http.StatusSeeOther)
+}
+
 // StorageHandler handles storage-related operations.
 type StorageHandler struct {
 	stateManager state.StateManager
@@ -167,6 +261,9 @@ func (h *StorageHandler) StoragePageHandler(w http.ResponseWriter, r *http.Reque
 		data := AdminPageDataWithMessage("Storage Management", "storage", successMsg, "")
 		data["Storages"] = []map[string]interface{}{}
 		data["EnabledStorages"] = enabledMap
+		data["BackupStorage"] = settings.BackupStorage
+		data["BackupStorages"] = []string{}
+		data["SnippetPrefix"] = settings.SnippetPrefix
 
 		// Add translations and render
 		renderTemplateInternal(w, r, "admin_storage", data)
@@ -190,10 +287,19 @@ func (h *StorageHandler) StoragePageHandler(w http.ResponseWriter, r *http.Reque
 
 	successMsg := buildSuccessMessage(r)
 
+	backupStorages, err := fetchBackupStorageNames(r.Context(), client)
+	if err != nil {
+		log.Warn().Err(err).Msg("Unable to retrieve backup storages")
+		backupStorages = []string{}
+	}
+
 	data := AdminPageDataWithMessage("Storage Management", "storage", successMsg, "")
 	data["Node"] = chosenNode
 	data["Storages"] = storages
 	data["EnabledMap"] = enabledMap
+	data["BackupStorage"] = settings.BackupStorage
+	data["BackupStorages"] = backupStorages
+	data["SnippetPrefix"] = settings.SnippetPrefix
 
 	renderTemplateInternal(w, r, "admin_storage", data)
 }
@@ -203,10 +309,12 @@ func (h *StorageHandler) RegisterRoutes(router *httprouter.Router) {
 	routeHelpers := NewAdminPageRoutes()
 
 	// Register admin storage routes using helper
-	routeHelpers.RegisterCRUDRoutes(router, "/admin/storage", map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/storage", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
 		"page":   h.StoragePageHandler,
 		"toggle": h.ToggleStorageHandler,
+		"update": h.UpdateBackupStorageHandler,
 	})
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/storage/snippets", PermissionManage, h.UpdateSnippetPrefixHandler)
 }
 
 // Storage utility functions (moved from storage_utils.go)
@@ -252,6 +360,35 @@ func canHoldVMDisks(s proxmox.Storage) bool {
 	return false
 }
 
+// canHoldBackups reports whether a storage can receive vzdump backups
+func canHoldBackups(s proxmox.Storage) bool {
+	if strings.EqualFold(s.Type, "pbs") {
+		return true
+	}
+	for _, content := range strings.Split(s.Content, ",") {
+		if strings.TrimSpace(content) == "backup" {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchBackupStorageNames returns the names of the storages that can hold backups, sorted
+func fetchBackupStorageNames(ctx context.Context, client proxmox.ClientInterface) ([]string, error) {
+	storages, err := proxmox.GetStoragesWithContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(storages))
+	for _, s := range storages {
+		if canHoldBackups(s) {
+			names = append(names, s.Storage)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 // FetchRenderableStorages fetches, merges, filters and prepares storages for rendering.
 // - If node is empty, the first available node is used.
 // - If refresh is true, bypass the short-lived cache.
@@ -127,7 +127,9 @@ func (h *TagsHandler) CreateTagHandler(w http.ResponseWriter, r *http.Request, _
 	}
 
 	settings.Tags = append(settings.Tags, tagName)
-	if err := h.stateManager.SetSettings(settings); err != nil {
+	err := h.stateManager.SetSettings(settings)
+	auditSettings(r, "tags", map[string]string{"tag": tagName, "action": "create"}, err)
+	if err != nil {
 		log.Error().Err(err).Msg("Failed to save settings")
 		http.Error(w, "Internal server error.", http.StatusInternalServerError)
 		return
@@ -156,7 +158,9 @@ func (h *TagsHandler) DeleteTagHandler(w http.ResponseWriter, r *http.Request, _
 	// Remove the tag from settings
 	settings.Tags = removeTag(settings.Tags, tagName)
 
-	if err := h.stateManager.SetSettings(settings); err != nil {
+	err := h.stateManager.SetSettings(settings)
+	auditSettings(r, "tags", map[string]string{"tag": tagName, "action": "delete"}, err)
+	if err != nil {
 		log.Error().Err(err).Msg("Failed to save settings after deletion")
 		http.Error(w, "Internal server error.", http.StatusInternalServerError)
 		return
@@ -194,33 +198,15 @@ func (h *TagsHandler) TagsPageHandler(w http.ResponseWriter, r *http.Request, _
 	// Proxmox status for consistent UI (even if tags don't need Proxmox)
 	proxmoxConnected, proxmoxMsg := h.stateManager.GetProxmoxStatus()
 
-	// Build usage counts per tag by inspecting VMs' tags when Proxmox is available
+	// Build usage counts per tag from the cluster inventory when Proxmox is available.
 	// Proxmox typically separates tags with ';' but some environments may contain
-	// comma-separated lists inside a single part (e.g. "pvmss,test"). We split on
-	// both ';' and ',' to ensure each individual tag is counted.
+	// comma-separated lists inside a single part (e.g. "pvmss,test"); TagList splits on both.
 	tagCounts := make(map[string]int)
 	if client := h.stateManager.GetProxmoxClient(); client != nil {
-		if vms, err := proxmox.GetVMsWithContext(r.Context(), client); err == nil {
-			for i := range vms {
-				if cfg, err := proxmox.GetVMConfigWithContext(r.Context(), client, vms[i].Node, vms[i].VMID); err == nil {
-					if v, ok := cfg["tags"].(string); ok && v != "" {
-						// First split by ';'
-						semiParts := strings.Split(v, ";")
-						for _, sp := range semiParts {
-							sp = strings.TrimSpace(sp)
-							if sp == "" {
-								continue
-							}
-							// Then split each part by ','
-							commaParts := strings.Split(sp, ",")
-							for _, cp := range commaParts {
-								t := strings.TrimSpace(cp)
-								if t != "" {
-									tagCounts[t]++
-								}
-							}
-						}
-					}
+		if vms, err := proxmox.GetInventoryVMsWithContext(r.Context(), client); err == nil {
+			for _, vm := range vms {
+				for _, t := range vm.TagList() {
+					tagCounts[t]++
 				}
 			}
 		}
@@ -261,25 +247,25 @@ func (h *TagsHandler) RegisterRoutes(router *httprouter.Router) {
 	routeHelpers := NewAdminPageRoutes()
 
 	// Register admin tags routes using helper
-	routeHelpers.RegisterCRUDRoutes(router, "/admin/tags", map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/tags", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
 		"page": h.TagsPageHandler,
 	})
 
 	// Register delete confirmation page
-	router.GET("/admin/tags/delete", HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+	router.GET("/admin/tags/delete", HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 		h.DeleteTagConfirmHandler(w, r, httprouter.ParamsFromContext(r.Context()))
 	})))
 
 	// Admin tag creation with CSRF protection
 	router.POST("/tags", SecureFormHandler("CreateTag",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.CreateTagHandler(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
 
 	// Admin tag deletion with CSRF protection
 	router.POST("/tags/delete", SecureFormHandler("DeleteTag",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.DeleteTagHandler(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
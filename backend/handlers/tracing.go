@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/logger"
+	"pvmss/middleware"
+	"pvmss/tracing"
+)
+
+// tracingMiddleware records a server span for each request, named after its route pattern and
+// continuing the trace of the caller's traceparent header. The node and VMID of the request,
+// from its route or query parameters, are span attributes.
+func tracingMiddleware(router *httprouter.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !tracing.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			route, ps := matchedRoute(router, r)
+			attrs := []tracing.Attribute{
+				tracing.String("http.request.method", r.Method),
+				tracing.String("http.route", route),
+				tracing.String("url.path", r.URL.Path),
+				tracing.String("client.address", middleware.ClientIP(r)),
+				tracing.String("user_agent.original", r.UserAgent()),
+			}
+			if id := logger.RequestIDFromContext(r.Context()); id != "" {
+				attrs = append(attrs, tracing.String("pvmss.request_id", id))
+			}
+			for _, key := range []string{"node", "vmid"} {
+				value := ps.ByName(key)
+				if value == "" {
+					value = r.URL.Query().Get(key)
+				}
+				if value != "" {
+					attrs = append(attrs, tracing.String("pvmss."+key, value))
+				}
+			}
+
+			ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+route, tracing.KindServer, attrs...)
+			defer span.End()
+
+			ww := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			span.SetAttributes(tracing.Int("http.response.status_code", ww.status))
+			if ww.status >= http.StatusInternalServerError {
+				span.SetStatus(tracing.StatusError, http.StatusText(ww.status))
+			}
+		})
+	}
+}
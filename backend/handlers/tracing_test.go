@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/tracing"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	rec := tracing.NewRecorder()
+	prev := tracing.SetExporter(rec)
+	defer tracing.SetExporter(prev)
+
+	router := httprouter.New()
+	router.GET("/vm/details/:vmid", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		_, span := tracing.Start(r.Context(), "render vm_details", tracing.KindInternal)
+		span.End()
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	handler := tracingMiddleware(router)(router)
+
+	req := httptest.NewRequest(http.MethodGet, "/vm/details/100?node=pve1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name != "GET /vm/details/:vmid" || server.Kind != tracing.KindServer {
+		t.Errorf("Unexpected server span %q of kind %d", server.Name, server.Kind)
+	}
+	if server.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Error("Expected the caller's trace to continue")
+	}
+	if child.TraceID != server.TraceID || child.ParentSpanID != server.SpanID {
+		t.Error("Expected the handler's span to be a child of the request span")
+	}
+	for key, want := range map[string]any{
+		"pvmss.vmid":                "100",
+		"pvmss.node":                "pve1",
+		"http.route":                "/vm/details/:vmid",
+		"url.path":                  "/vm/details/100",
+		"http.response.status_code": int64(http.StatusBadGateway),
+	} {
+		if got := server.Attribute(key); got != want {
+			t.Errorf("Expected %s = %v, got %v", key, want, got)
+		}
+	}
+	if server.StatusCode != tracing.StatusError {
+		t.Errorf("Expected an error status for a 502, got %d", server.StatusCode)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,19 +13,11 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 
+	"pvmss/constants"
 	"pvmss/proxmox"
 	"pvmss/state"
 )
 
-// deriveUserFromPool extracts username from pool ID (pvmss_username)
-func deriveUserFromPool(poolID string) string {
-	username := strings.TrimPrefix(poolID, "pvmss_")
-	if username != "" && !strings.Contains(username, "@") {
-		return username + "@pve"
-	}
-	return username
-}
-
 // buildUserPoolSuccessMessage creates success message from query parameters
 func buildUserPoolSuccessMessage(r *http.Request) string {
 	if r.URL.Query().Get("success") == "" {
@@ -46,6 +39,10 @@ func buildUserPoolSuccessMessage(r *http.Request) string {
 			return fmt.Sprintf("Deleted pool, user, and VMs for '%s'", pool)
 		}
 		return "User/pool deleted"
+	case "realms":
+		return "Login realms updated"
+	case "password":
+		return fmt.Sprintf("Password of '%s' reset", user)
 	default:
 		return "User/pool updated"
 	}
@@ -86,12 +83,23 @@ func (h *UserPoolHandler) DeleteUserPool(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), constants.PoolDeletionTimeout)
 	defer cancel()
 
 	// Derive user from pool id
 	userID := deriveUserFromPool(poolID)
 
+	// The deletion is audited whatever its outcome, including when it stops halfway
+	var auditErr error
+	deletedVMs := make([]string, 0)
+	defer func() {
+		recordAudit(r, state.AuditEvent{
+			Action: state.AuditActionPoolDelete,
+			Pool:   poolID,
+			Params: map[string]string{"user": userID, "deleted_vms": strings.Join(deletedVMs, ",")},
+		}, auditErr)
+	}()
+
 	// Always stop and delete all VMs in the pool first (purge)
 	var detailResp struct {
 		Data struct {
@@ -103,11 +111,12 @@ func (h *UserPoolHandler) DeleteUserPool(w http.ResponseWriter, r *http.Request,
 		} `json:"data"`
 	}
 	if err := client.GetJSON(ctx, "/pools/"+url.PathEscape(poolID), &detailResp); err != nil {
+		auditErr = err
 		log.Error().Err(err).Str("pool", poolID).Msg("Failed to get pool members before deletion")
 		http.Error(w, "failed to resolve pool members: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// First, stop each guest in bulk (concurrently), then wait a short fixed delay
+	// First, stop each guest in bulk (concurrently) and wait for the stop tasks to finish
 	{
 		var wg sync.WaitGroup
 		for _, m := range detailResp.Data.Members {
@@ -124,8 +133,13 @@ func (h *UserPoolHandler) DeleteUserPool(w http.ResponseWriter, r *http.Request,
 				defer wg.Done()
 				switch strings.ToLower(m.Type) {
 				case "qemu":
-					if _, err := proxmox.VMActionWithContext(ctx, client, m.Node, strconv.Itoa(m.VMID), "stop"); err != nil {
+					upid, err := proxmox.VMActionWithContext(ctx, client, m.Node, strconv.Itoa(m.VMID), "stop")
+					if err != nil {
 						log.Warn().Err(err).Int("vmid", m.VMID).Str("node", m.Node).Msg("Failed to stop QEMU VM; continuing")
+						return
+					}
+					if _, err := proxmox.TrackAndWaitTask(ctx, client, upid, m.VMID, "stop", userID); err != nil {
+						log.Warn().Err(err).Int("vmid", m.VMID).Str("upid", upid).Msg("QEMU VM stop task did not complete; continuing")
 					}
 				default:
 					// ignore other member types
@@ -133,11 +147,9 @@ func (h *UserPoolHandler) DeleteUserPool(w http.ResponseWriter, r *http.Request,
 			}()
 		}
 		wg.Wait()
-		// Fixed small wait to give Proxmox time to transition state
-		time.Sleep(3 * time.Second)
 	}
 
-	// Then delete each guest (qemu + lxc)
+	// Then delete each guest (qemu + lxc), waiting for each deletion task
 	for _, m := range detailResp.Data.Members {
 		if m.VMID <= 0 {
 			continue
@@ -149,43 +161,45 @@ func (h *UserPoolHandler) DeleteUserPool(w http.ResponseWriter, r *http.Request,
 		switch strings.ToLower(m.Type) {
 		case "qemu":
 			path := "/nodes/" + url.PathEscape(m.Node) + "/qemu/" + url.PathEscape(strconv.Itoa(m.VMID)) + "?purge=1"
-			if _, err := client.DeleteWithContext(ctx, path, nil); err != nil {
+			resp, err := client.DeleteWithContext(ctx, path, nil)
+			if err != nil {
+				auditErr = err
 				log.Error().Err(err).Str("path", path).Msg("Failed to delete VM")
 				http.Error(w, "failed to delete VM "+strconv.Itoa(m.VMID)+": "+err.Error(), http.StatusInternalServerError)
 				return
 			}
+			if upid := proxmox.UPIDFromResponse(resp); upid != "" {
+				if _, err := proxmox.TrackAndWaitTask(ctx, client, upid, m.VMID, "delete", userID); err != nil {
+					auditErr = err
+					log.Error().Err(err).Str("upid", upid).Msg("VM deletion task failed")
+					http.Error(w, "failed to delete VM "+strconv.Itoa(m.VMID)+": "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			proxmox.ForgetVMTasks(m.VMID)
+			deletedVMs = append(deletedVMs, strconv.Itoa(m.VMID))
 		default:
 			// ignore other member types
 		}
 	}
+	proxmox.InvalidateInventory()
 
-	// Verify pool is now empty before attempting to delete it (short polling with cache invalidation)
-	{
-		emptyDeadline := time.Now().Add(15 * time.Second)
-		for {
-			if c, ok := client.(*proxmox.Client); ok && c != nil {
-				c.InvalidateCache("/pools/" + poolID)
-			}
-			var check struct {
-				Data struct {
-					Members []any `json:"members"`
-				} `json:"data"`
-			}
-			if err := client.GetJSON(ctx, "/pools/"+url.PathEscape(poolID), &check); err == nil {
-				if len(check.Data.Members) == 0 {
-					break
-				}
-			}
-			if time.Now().After(emptyDeadline) {
-				log.Warn().Str("pool", poolID).Msg("Pool still not empty after deletions; proceeding to try delete anyway")
-				break
-			}
-			time.Sleep(1 * time.Second)
-		}
+	// The deletion tasks have finished, so the pool should now be empty
+	if c, ok := client.(*proxmox.Client); ok && c != nil {
+		c.InvalidateCache("/pools/" + poolID)
+	}
+	var check struct {
+		Data struct {
+			Members []any `json:"members"`
+		} `json:"data"`
+	}
+	if err := client.GetJSON(ctx, "/pools/"+url.PathEscape(poolID), &check); err == nil && len(check.Data.Members) > 0 {
+		log.Warn().Str("pool", poolID).Int("members", len(check.Data.Members)).Msg("Pool still not empty after deletions; proceeding to try delete anyway")
 	}
 
 	// Delete the pool first
 	if _, err := client.DeleteWithContext(ctx, "/pools/"+url.PathEscape(poolID), nil); err != nil {
+		auditErr = err
 		log.Error().Err(err).Str("pool", poolID).Msg("Failed to delete pool")
 		http.Error(w, "failed to delete pool "+poolID+": "+err.Error(), http.StatusInternalServerError)
 		return
@@ -201,6 +215,20 @@ func (h *UserPoolHandler) DeleteUserPool(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
+	// The user is gone, so are its sessions and API tokens
+	if owner, ok := poolOwner(poolID); ok {
+		if n, err := revokeUserSessions(r.Context(), h.stateManager.GetSessionManager(), owner, ""); err != nil {
+			log.Warn().Err(err).Str("user", owner).Msg("Failed to revoke the sessions of the deleted user")
+		} else if n > 0 {
+			log.Info().Str("user", owner).Int("sessions", n).Msg("Sessions of the deleted user revoked")
+		}
+		if n, err := revokeUserAPITokens(h.stateManager, owner); err != nil {
+			log.Warn().Err(err).Str("user", owner).Msg("Failed to revoke the API tokens of the deleted user")
+		} else if n > 0 {
+			log.Info().Str("user", owner).Int("tokens", n).Msg("API tokens of the deleted user revoked")
+		}
+	}
+
 	// Redirect with success
 	redir := "/admin/userpool?success=1&action=delete&pool=" + url.QueryEscape(poolID)
 	http.Redirect(w, r, redir, http.StatusSeeOther)
@@ -220,7 +248,7 @@ func (h *UserPoolHandler) DeleteUserPoolConfirmHandler(w http.ResponseWriter, r
 
 	data := AdminPageDataWithMessage("Delete User & Pool", "userpool_delete", "", "")
 	data["Pool"] = poolID
-	data["User"] = strings.TrimPrefix(poolID, "pvmss_")
+	data["User"], _ = poolOwner(poolID)
 
 	renderTemplateInternal(w, r, "admin_userpool_delete", data)
 }
@@ -230,56 +258,58 @@ func (h *UserPoolHandler) RegisterRoutes(router *httprouter.Router) {
 	routeHelpers := NewAdminPageRoutes()
 
 	// Register admin user pool routes using helper
-	routeHelpers.RegisterCRUDRoutes(router, "/admin/userpool", map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/userpool", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
 		"page": h.UserPoolPage,
 	})
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/userpool/realms", PermissionManage, h.UpdateLoginRealmsHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/userpool/password", PermissionOperate, h.ResetUserPasswordHandler)
 
 	// Register delete confirmation page (with and without lang prefixes)
-	router.GET("/admin/userpool/delete", HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+	router.GET("/admin/userpool/delete", HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 		h.DeleteUserPoolConfirmHandler(w, r, httprouter.ParamsFromContext(r.Context()))
 	})))
-	router.GET("/en/admin/userpool/delete", HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+	router.GET("/en/admin/userpool/delete", HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 		h.DeleteUserPoolConfirmHandler(w, r, httprouter.ParamsFromContext(r.Context()))
 	})))
-	router.GET("/fr/admin/userpool/delete", HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+	router.GET("/fr/admin/userpool/delete", HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 		h.DeleteUserPoolConfirmHandler(w, r, httprouter.ParamsFromContext(r.Context()))
 	})))
 
 	// Admin user pool creation with CSRF protection (without lang prefix)
 	router.POST("/userpool/create", SecureFormHandler("CreateUserPool",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.CreateUserPool(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
 
 	// Admin user pool deletion with CSRF protection (without lang prefix)
 	router.POST("/userpool/delete", SecureFormHandler("DeleteUserPool",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.DeleteUserPool(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
 
 	// Also register with lang prefixes for compatibility
 	router.POST("/en/userpool/create", SecureFormHandler("CreateUserPool",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.CreateUserPool(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
 
 	router.POST("/fr/userpool/create", SecureFormHandler("CreateUserPool",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.CreateUserPool(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
 
 	router.POST("/en/userpool/delete", SecureFormHandler("DeleteUserPool",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.DeleteUserPool(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
 
 	router.POST("/fr/userpool/delete", SecureFormHandler("DeleteUserPool",
-		HandlerFuncToHTTPrHandle(RequireAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		HandlerFuncToHTTPrHandle(RequireAdminPermission(PermissionManage, func(w http.ResponseWriter, r *http.Request) {
 			h.DeleteUserPool(w, r, httprouter.ParamsFromContext(r.Context()))
 		})),
 	))
@@ -295,7 +325,7 @@ func (h *UserPoolHandler) UserPoolPage(w http.ResponseWriter, r *http.Request, _
 	w.Header().Set("Expires", "0")
 
 	// Build base template data
-	data := AdminPageDataWithMessage("Proxmox Users & Pools", "userpool", successMsg, "")
+	data := AdminPageDataWithMessage("Proxmox Users & Pools", "userpool", successMsg, r.URL.Query().Get("error"))
 
 	// Fetch pools that match pattern pvmss_*
 	client := h.stateManager.GetProxmoxClient()
@@ -332,7 +362,8 @@ func (h *UserPoolHandler) UserPoolPage(w http.ResponseWriter, r *http.Request, _
 			var wg sync.WaitGroup
 
 			for _, p := range listResp.Data {
-				if !strings.HasPrefix(p.PoolID, "pvmss_") {
+				owner, ok := poolOwner(p.PoolID)
+				if !ok {
 					continue
 				}
 
@@ -344,7 +375,7 @@ func (h *UserPoolHandler) UserPoolPage(w http.ResponseWriter, r *http.Request, _
 					defer func() { <-sem }()
 
 					row := poolTableRow{
-						User:    strings.TrimPrefix(p.PoolID, "pvmss_"),
+						User:    owner,
 						Pool:    p.PoolID,
 						Comment: p.Comment,
 					}
@@ -387,13 +418,154 @@ func (h *UserPoolHandler) UserPoolPage(w http.ResponseWriter, r *http.Request, _
 			if len(rows) > 0 {
 				data["UserPools"] = rows
 			}
+
+			// Only users of the pve realm have a password kept by Proxmox
+			passwordUsers := make([]string, 0, len(rows))
+			for _, row := range rows {
+				if _, realm := splitUserID(row.User); realm == constants.DefaultLoginRealm {
+					passwordUsers = append(passwordUsers, row.User)
+				}
+			}
+			sort.Strings(passwordUsers)
+			data["PasswordUsers"] = passwordUsers
+		}
+	}
+
+	// Realms of the cluster, flagged when users can sign in with them
+	settings := h.stateManager.GetSettings()
+	data["LoginRealms"] = allowedLoginRealms(settings)
+	if client != nil {
+		type realmRow struct {
+			ID      string
+			Type    string
+			Comment string
+			Allowed bool
+		}
+		if realms, err := proxmox.GetRealms(r.Context(), client); err == nil {
+			rows := make([]realmRow, 0, len(realms))
+			for _, realm := range realms {
+				if !realmIDPattern.MatchString(realm.Realm) {
+					continue
+				}
+				rows = append(rows, realmRow{ID: realm.Realm, Type: realm.Type, Comment: realm.Comment, Allowed: isLoginRealmAllowed(settings, realm.Realm)})
+			}
+			data["Realms"] = rows
 		}
 	}
 
 	renderTemplateInternal(w, r, "admin_userpool", data)
 }
 
-// CreateUserPool handles POST to create a user in PVE realm, create pool pvmss_<username>, and grant ACL
+// UpdateLoginRealmsHandler saves the realms users can sign in with
+func (h *UserPoolHandler) UpdateLoginRealmsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("UpdateLoginRealmsHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	realms := make([]string, 0, len(r.Form["realms"]))
+	seen := make(map[string]bool)
+	for _, realm := range r.Form["realms"] {
+		realm = strings.TrimSpace(realm)
+		if !realmIDPattern.MatchString(realm) {
+			http.Error(w, "invalid realm "+realm, http.StatusBadRequest)
+			return
+		}
+		if !seen[realm] {
+			seen[realm] = true
+			realms = append(realms, realm)
+		}
+	}
+	if len(realms) == 0 {
+		http.Error(w, "at least one realm is required", http.StatusBadRequest)
+		return
+	}
+
+	settings := h.stateManager.GetSettings()
+	if settings == nil {
+		http.Error(w, "Settings not available", http.StatusInternalServerError)
+		return
+	}
+	settings.LoginRealms = realms
+	err := h.stateManager.SetSettings(settings)
+	auditSettings(r, "login_realms", map[string]string{"realms": strings.Join(realms, ",")}, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save settings")
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Strs("realms", realms).Msg("Login realms updated")
+	http.Redirect(w, r, "/admin/userpool?success=1&action=realms", http.StatusSeeOther)
+}
+
+// ResetUserPasswordHandler sets a new Proxmox password for a user of the pve realm, for a user who
+// forgot it. Helpdesk administrators can use it.
+func (h *UserPoolHandler) ResetUserPasswordHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("ResetUserPasswordHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	redirectError := func(msg string) {
+		http.Redirect(w, r, "/admin/userpool?error="+url.QueryEscape(msg), http.StatusSeeOther)
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	user, realm := splitUserID(username)
+	if user == "" || realm != constants.DefaultLoginRealm {
+		redirectError("Only users of the " + constants.DefaultLoginRealm + " realm have a password managed by PVMSS")
+		return
+	}
+	if password != r.FormValue("confirm_password") {
+		redirectError("Passwords do not match")
+		return
+	}
+	if len(password) < 5 {
+		redirectError("Password must be at least 5 characters")
+		return
+	}
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		redirectError("Proxmox client not available")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	err := proxmox.UpdateUserPassword(ctx, client, user, password, "", realm)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionPasswordReset,
+		Pool:   userPoolName(sessionUsername(user, realm)),
+		Params: map[string]string{"user": username},
+	}, err)
+	if err != nil {
+		log.Error().Err(err).Str("username", username).Msg("Failed to reset user password")
+		redirectError("Failed to reset password: " + err.Error())
+		return
+	}
+
+	log.Info().Str("admin", adminAccountName(r)).Str("username", username).Msg("User password reset by admin")
+	// Sessions and API tokens obtained with the old password end too
+	if n, err := revokeUserSessions(r.Context(), h.stateManager.GetSessionManager(), sessionUsername(user, realm), ""); err != nil {
+		log.Warn().Err(err).Str("username", username).Msg("Failed to revoke the sessions of the user")
+	} else if n > 0 {
+		log.Info().Str("username", username).Int("sessions", n).Msg("Sessions of the user revoked after password reset")
+	}
+	if n, err := revokeUserAPITokens(h.stateManager, sessionUsername(user, realm)); err != nil {
+		log.Warn().Err(err).Str("username", username).Msg("Failed to revoke the API tokens of the user")
+	} else if n > 0 {
+		log.Info().Str("username", username).Int("tokens", n).Msg("API tokens of the user revoked after password reset")
+	}
+	http.Redirect(w, r, "/admin/userpool?success=1&action=password&user="+url.QueryEscape(username), http.StatusSeeOther)
+}
+
+// CreateUserPool handles POST to create a user in one of the login realms, create the user's pool, and grant ACL
 func (h *UserPoolHandler) CreateUserPool(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	log := CreateHandlerLogger("CreateUserPool", r)
 
@@ -403,6 +575,10 @@ func (h *UserPoolHandler) CreateUserPool(w http.ResponseWriter, r *http.Request,
 
 	username := strings.TrimSpace(r.FormValue("username"))
 	password := r.FormValue("password")
+	realm := strings.TrimSpace(r.FormValue("realm"))
+	if realm == "" {
+		realm = constants.DefaultLoginRealm
+	}
 	email := strings.TrimSpace(r.FormValue("email"))
 	comment := strings.TrimSpace(r.FormValue("comment"))
 	role := strings.TrimSpace(r.FormValue("role"))
@@ -411,10 +587,15 @@ func (h *UserPoolHandler) CreateUserPool(w http.ResponseWriter, r *http.Request,
 	}
 	propagate := r.FormValue("propagate") == "true" || r.FormValue("propagate") == "1" || strings.EqualFold(r.FormValue("propagate"), "on")
 
-	if username == "" || password == "" {
+	// Users of other realms authenticate against their directory and have no Proxmox password
+	if username == "" || (password == "" && realm == constants.DefaultLoginRealm) {
 		http.Error(w, "username and password are required", http.StatusBadRequest)
 		return
 	}
+	if strings.Contains(username, "@") || !isLoginRealmAllowed(h.stateManager.GetSettings(), realm) {
+		http.Error(w, "invalid username or realm", http.StatusBadRequest)
+		return
+	}
 
 	client := h.stateManager.GetProxmoxClient()
 	if client == nil {
@@ -425,13 +606,30 @@ func (h *UserPoolHandler) CreateUserPool(w http.ResponseWriter, r *http.Request,
 	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 	defer cancel()
 
-	// Ensure user
-	if err := proxmox.EnsureUser(ctx, client, username, password, email, comment, "pve", true); err != nil {
-		log.Error().Err(err).Str("username", username).Msg("EnsureUser failed")
-		http.Error(w, "failed to ensure user: "+err.Error(), http.StatusInternalServerError)
+	userID, poolID, err := provisionUserPool(ctx, client, username, realm, password, email, comment, role, propagate)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionPoolCreate,
+		Pool:   userPoolName(sessionUsername(username, realm)),
+		Params: map[string]string{"user": username + "@" + realm, "role": role, "propagate": strconv.FormatBool(propagate)},
+	}, err)
+	if err != nil {
+		log.Error().Err(err).Str("username", username).Str("role", role).Msg("User provisioning failed")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Redirect with success banner
+	redir := "/admin/userpool?success=1&action=create&user=" + url.QueryEscape(userID) + "&pool=" + url.QueryEscape(poolID)
+	http.Redirect(w, r, redir, http.StatusSeeOther)
+}
+
+// provisionUserPool creates the Proxmox user in realm, the PVMSSUser role, the user's pool and the
+// ACL granting role on the pool. Every step is idempotent.
+func provisionUserPool(ctx context.Context, client proxmox.ClientInterface, username, realm, password, email, comment, role string, propagate bool) (userID, poolID string, err error) {
+	if err := proxmox.EnsureUser(ctx, client, username, password, email, comment, realm, true); err != nil {
+		return "", "", fmt.Errorf("failed to ensure user: %w", err)
+	}
+
 	// Ensure custom role with VM management permissions exists
 	roleID := "PVMSSUser"
 	privileges := []string{
@@ -442,33 +640,19 @@ func (h *UserPoolHandler) CreateUserPool(w http.ResponseWriter, r *http.Request,
 		"Pool.Audit",      // View pool contents
 	}
 	if err := proxmox.EnsureRole(ctx, client, roleID, privileges); err != nil {
-		log.Error().Err(err).Str("role", roleID).Msg("EnsureRole failed")
-		http.Error(w, "failed to ensure role: "+err.Error(), http.StatusInternalServerError)
-		return
+		return "", "", fmt.Errorf("failed to ensure role: %w", err)
 	}
 
-	// Ensure pool
-	poolID := "pvmss_" + sanitizeID(username)
-	if err := proxmox.EnsurePool(ctx, client, poolID, "PVMSS pool for "+username); err != nil {
-		log.Error().Err(err).Str("pool", poolID).Msg("EnsurePool failed")
-		http.Error(w, "failed to ensure pool: "+err.Error(), http.StatusInternalServerError)
-		return
+	userID = username + "@" + realm
+	poolID = userPoolName(sessionUsername(username, realm))
+	if err := proxmox.EnsurePool(ctx, client, poolID, "PVMSS pool for "+userID); err != nil {
+		return "", "", fmt.Errorf("failed to ensure pool: %w", err)
 	}
 
-	// Grant ACL on pool to user
-	userID := username
-	if !strings.Contains(userID, "@") {
-		userID = userID + "@pve"
-	}
 	if err := proxmox.EnsurePoolACL(ctx, client, userID, poolID, role, propagate); err != nil {
-		log.Error().Err(err).Str("user", userID).Str("pool", poolID).Str("role", role).Msg("EnsurePoolACL failed")
-		http.Error(w, "failed to grant pool ACL: "+err.Error(), http.StatusInternalServerError)
-		return
+		return "", "", fmt.Errorf("failed to grant pool ACL: %w", err)
 	}
-
-	// Redirect with success banner
-	redir := "/admin/userpool?success=1&action=create&user=" + url.QueryEscape(userID) + "&pool=" + url.QueryEscape(poolID)
-	http.Redirect(w, r, redir, http.StatusSeeOther)
+	return userID, poolID, nil
 }
 
 func sanitizeID(s string) string {
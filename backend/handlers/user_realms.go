@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"pvmss/constants"
+	"pvmss/logger"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// Pools holding the VMs of users: pvmss_<user> for the pve realm, which keeps the pools created
+// before realms were supported, and pvmss-<realm>_<user> for the other realms. Realm IDs allowed
+// for login cannot contain '_', so the owner of a pool is never ambiguous.
+const (
+	userPoolPrefix      = "pvmss_"
+	realmUserPoolPrefix = "pvmss-"
+)
+
+// realmIDPattern is the subset of Proxmox realm IDs PVMSS accepts in the login allow-list
+var realmIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9.-]*$`)
+
+// userNamePattern matches the user part of a Proxmox user ID: no whitespace, ':' or '/'
+var userNamePattern = regexp.MustCompile(`^[^\s:/]{1,64}$`)
+
+// splitUserID splits a user ID into user name and realm; a user ID without realm is in the pve realm
+func splitUserID(userID string) (user, realm string) {
+	if i := strings.LastIndex(userID, "@"); i >= 0 {
+		return userID[:i], userID[i+1:]
+	}
+	return userID, constants.DefaultLoginRealm
+}
+
+// sessionUsername returns the username stored in the session: the bare user name for the pve realm,
+// user@realm for the other realms
+func sessionUsername(user, realm string) string {
+	if realm == "" || realm == constants.DefaultLoginRealm {
+		return user
+	}
+	return user + "@" + realm
+}
+
+// validUserID reports whether username, with or without @realm, is a valid Proxmox user ID
+func validUserID(username string) bool {
+	user, realm := splitUserID(username)
+	return userNamePattern.MatchString(user) && realmIDPattern.MatchString(realm)
+}
+
+// proxmoxUserID returns the Proxmox user ID (user@realm) of a session username
+func proxmoxUserID(username string) string {
+	user, realm := splitUserID(username)
+	return user + "@" + realm
+}
+
+// userPoolName returns the Proxmox pool that holds a user's VMs: pvmss_<user> for the pve realm,
+// pvmss-<realm>_<user> for the other realms
+func userPoolName(username string) string {
+	user, realm := splitUserID(username)
+	if realm == constants.DefaultLoginRealm {
+		return userPoolPrefix + user
+	}
+	return realmUserPoolPrefix + realm + "_" + user
+}
+
+// poolOwner returns the session username owning a user pool, and false for other pools
+func poolOwner(poolID string) (string, bool) {
+	if user, ok := strings.CutPrefix(poolID, userPoolPrefix); ok && user != "" {
+		return user, true
+	}
+	if rest, ok := strings.CutPrefix(poolID, realmUserPoolPrefix); ok {
+		if realm, user, found := strings.Cut(rest, "_"); found && realm != "" && user != "" {
+			return sessionUsername(user, realm), true
+		}
+	}
+	return "", false
+}
+
+// deriveUserFromPool returns the Proxmox user ID owning a user pool, or "" for other pools
+func deriveUserFromPool(poolID string) string {
+	owner, ok := poolOwner(poolID)
+	if !ok {
+		return ""
+	}
+	return proxmoxUserID(owner)
+}
+
+// allowedLoginRealms returns the realms users can sign in with; only pve unless configured
+func allowedLoginRealms(settings *state.AppSettings) []string {
+	if settings == nil || len(settings.LoginRealms) == 0 {
+		return []string{constants.DefaultLoginRealm}
+	}
+	return settings.LoginRealms
+}
+
+// isLoginRealmAllowed reports whether users can sign in with realm
+func isLoginRealmAllowed(settings *state.AppSettings, realm string) bool {
+	for _, allowed := range allowedLoginRealms(settings) {
+		if allowed == realm {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginRealm is a realm offered on the login form
+type LoginRealm struct {
+	ID    string
+	Label string
+}
+
+// loginRealmOptions returns the allowed realms that exist on the cluster, labelled with their
+// Proxmox comment. The allow-list is used as is when the realms cannot be listed.
+func loginRealmOptions(ctx context.Context, sm state.StateManager) []LoginRealm {
+	allowed := allowedLoginRealms(sm.GetSettings())
+	options := make([]LoginRealm, 0, len(allowed))
+
+	var realms []proxmox.Realm
+	if client := sm.GetProxmoxClient(); client != nil {
+		listCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		var err error
+		if realms, err = proxmox.GetRealms(listCtx, client); err != nil {
+			logger.Get().Warn().Err(err).Msg("Unable to list Proxmox realms; offering the allow-list as is")
+			realms = nil
+		}
+	}
+	if realms == nil {
+		for _, id := range allowed {
+			options = append(options, LoginRealm{ID: id, Label: id})
+		}
+		return options
+	}
+
+	byID := make(map[string]proxmox.Realm, len(realms))
+	for _, realm := range realms {
+		byID[realm.Realm] = realm
+	}
+	for _, id := range allowed {
+		realm, ok := byID[id]
+		if !ok {
+			continue
+		}
+		label := id
+		if realm.Comment != "" {
+			label = realm.Comment + " (" + id + ")"
+		}
+		options = append(options, LoginRealm{ID: id, Label: label})
+	}
+	return options
+}
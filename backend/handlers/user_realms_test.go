@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"testing"
+
+	"pvmss/state"
+)
+
+func TestUserPoolNamesDoNotCollideAcrossRealms(t *testing.T) {
+	for _, tc := range []struct {
+		username, pool, userID string
+	}{
+		{"alice", "pvmss_alice", "alice@pve"},
+		{"alice@pve", "pvmss_alice", "alice@pve"},
+		{"alice@ad", "pvmss-ad_alice", "alice@ad"},
+		{"alice@corp.ldap", "pvmss-corp.ldap_alice", "alice@corp.ldap"},
+		{"j.doe_ext@ad", "pvmss-ad_j.doe_ext", "j.doe_ext@ad"},
+	} {
+		pool := userPoolName(tc.username)
+		if pool != tc.pool {
+			t.Errorf("userPoolName(%q) = %q, expected %q", tc.username, pool, tc.pool)
+		}
+		if got := deriveUserFromPool(pool); got != tc.userID {
+			t.Errorf("deriveUserFromPool(%q) = %q, expected %q", pool, got, tc.userID)
+		}
+		owner, ok := poolOwner(pool)
+		if !ok || proxmoxUserID(owner) != tc.userID {
+			t.Errorf("poolOwner(%q) = %q, %v, expected the owner of %s", pool, owner, ok, tc.userID)
+		}
+	}
+
+	for _, pool := range []string{"pvmss_", "pvmss-ad_", "pvmss-_alice", "pvmss-ad", "shared", "templates"} {
+		if owner, ok := poolOwner(pool); ok {
+			t.Errorf("Expected %q not to be a user pool, got owner %q", pool, owner)
+		}
+		if got := deriveUserFromPool(pool); got != "" {
+			t.Errorf("deriveUserFromPool(%q) = %q, expected none", pool, got)
+		}
+	}
+}
+
+func TestLoginRealmAllowList(t *testing.T) {
+	if !isLoginRealmAllowed(nil, "pve") || isLoginRealmAllowed(&state.AppSettings{}, "ad") {
+		t.Error("Expected only the pve realm to be allowed by default")
+	}
+	settings := &state.AppSettings{LoginRealms: []string{"ad", "pam"}}
+	if isLoginRealmAllowed(settings, "pve") || !isLoginRealmAllowed(settings, "ad") {
+		t.Errorf("Expected the allow-list %v to be enforced", settings.LoginRealms)
+	}
+}
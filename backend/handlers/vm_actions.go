@@ -12,6 +12,7 @@ import (
 
 	"pvmss/i18n"
 	"pvmss/proxmox"
+	"pvmss/state"
 )
 
 // Helper function to build VM details URL with refresh
@@ -54,7 +55,17 @@ func (h *VMHandler) UpdateVMDescriptionHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if err := proxmox.UpdateVMConfigWithContext(r.Context(), client, node, vmidInt, map[string]string{"description": desc}); err != nil {
+	if !requireVMOwnership(w, r, client, vmidInt) {
+		return
+	}
+
+	err = proxmox.UpdateVMConfigWithContext(r.Context(), client, node, vmidInt, map[string]string{"description": desc})
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMDescription,
+		VMID:   vmidInt,
+		Params: map[string]string{"node": node, "description": auditParam(desc)},
+	}, err)
+	if err != nil {
 		ctx.Log.Error().Err(err).Msg("update description failed")
 		ctx.RedirectWithError(buildVMDetailsURL(vmid), "Message.ActionFailed")
 		return
@@ -92,8 +103,18 @@ func (h *VMHandler) UpdateVMTagsHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	if !requireVMOwnership(w, r, client, vmidInt) {
+		return
+	}
+
 	// Update tags in Proxmox
-	if err := proxmox.UpdateVMConfigWithContext(r.Context(), client, node, vmidInt, map[string]string{"tags": tagsStr}); err != nil {
+	err = proxmox.UpdateVMConfigWithContext(r.Context(), client, node, vmidInt, map[string]string{"tags": tagsStr})
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMTags,
+		VMID:   vmidInt,
+		Params: map[string]string{"node": node, "tags": strings.Join(selectedTags, ",")},
+	}, err)
+	if err != nil {
 		ctx.Log.Error().Err(err).Msg("update tags failed")
 		ctx.RedirectWithError(buildVMDetailsURL(vmid), "Message.ActionFailed")
 		return
@@ -143,20 +164,31 @@ func (h *VMHandler) VMActionHandler(w http.ResponseWriter, r *http.Request, _ ht
 		return
 	}
 
+	if !requireVMOwnership(w, r, client, vmidInt) {
+		return
+	}
+
 	log.Info().Str("action", action).Int("vmid", vmidInt).Msg("executing VM action")
 
 	// Execute the action using VMActionWithContext
-	_, err = proxmox.VMActionWithContext(r.Context(), client, node, vmid, action)
+	ctx := NewHandlerContext(w, r, "VMActionHandler")
+	upid, err := proxmox.VMActionWithContext(r.Context(), client, node, vmid, action)
+	recordAudit(r, state.AuditEvent{
+		Action: "vm." + action,
+		VMID:   vmidInt,
+		Params: map[string]string{"node": node},
+		UPID:   upid,
+	}, err)
 	if err != nil {
 		log.Error().Err(err).Str("action", action).Int("vmid", vmidInt).Msg("VM action failed")
-		ctx := NewHandlerContext(w, r, "VMActionHandler")
 		ctx.RedirectWithError(buildVMDetailsURL(vmid), "Message.ActionFailed")
 		return
 	}
 
-	log.Info().Str("action", action).Int("vmid", vmidInt).Msg("VM action completed successfully")
+	// The task runs asynchronously; its outcome shows up in the recent tasks panel
+	proxmox.TrackTask(client, upid, vmidInt, action, ctx.GetUsername())
+	log.Info().Str("action", action).Int("vmid", vmidInt).Str("upid", upid).Msg("VM action started")
 
-	ctx := NewHandlerContext(w, r, "VMActionHandler")
 	ctx.RedirectWithParams(buildVMDetailsURL(vmid), map[string]string{
 		"success":     "1",
 		"success_msg": ctx.Translate("VMDetails.Action.Success"),
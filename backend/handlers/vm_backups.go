@@ -0,0 +1,647 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/logger"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// backupOwnerPrefix marks the owner of a backup in its notes, since VMIDs can be reused
+const backupOwnerPrefix = "pvmss-owner="
+
+var (
+	// errBackupsDisabled is returned when no backup storage is configured
+	errBackupsDisabled = errors.New("backups are disabled")
+	// errBackupNotFound is returned when a volume is not a backup visible to the user
+	errBackupNotFound = errors.New("backup not found")
+	// errBackupLimitReached is returned when a user already holds the maximum number of backups
+	errBackupLimitReached = errors.New("backup limit reached")
+)
+
+// BackupView is a backup archive as displayed to users
+type BackupView struct {
+	VolID     string
+	VMID      int
+	Node      string
+	CreatedAt string
+	Size      string
+	Owner     string
+}
+
+// backupOwner returns the username recorded in a backup's notes, or an empty string
+func backupOwner(notes string) string {
+	for _, line := range strings.Split(notes, "\n") {
+		if owner, ok := strings.CutPrefix(strings.TrimSpace(line), backupOwnerPrefix); ok {
+			return owner
+		}
+	}
+	return ""
+}
+
+// listBackups lists the backups of the configured backup storage on every node, newest first.
+// Shared storages report the same archives on each node; they are listed once.
+func listBackups(ctx context.Context, client proxmox.ClientInterface, storage string, vmid int) ([]proxmox.Backup, error) {
+	if storage == "" {
+		return nil, errBackupsDisabled
+	}
+	nodes, err := proxmox.GetNodeNamesWithContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var backups []proxmox.Backup
+	var lastErr error
+	listed := false
+	for _, node := range nodes {
+		nodeBackups, err := proxmox.GetBackupsWithContext(ctx, client, node, storage, vmid)
+		if err != nil {
+			// Node-local storages are not available on every node
+			lastErr = err
+			continue
+		}
+		listed = true
+		for _, b := range nodeBackups {
+			if !seen[b.VolID] {
+				seen[b.VolID] = true
+				backups = append(backups, b)
+			}
+		}
+	}
+	if !listed && lastErr != nil {
+		return nil, lastErr
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CTime > backups[j].CTime })
+	return backups, nil
+}
+
+// listUserBackups lists the backups visible to a user: their own, or all of them for admins
+func listUserBackups(ctx context.Context, client proxmox.ClientInterface, settings *state.AppSettings, username string, isAdmin bool, vmid int) ([]proxmox.Backup, error) {
+	if settings == nil {
+		return nil, errBackupsDisabled
+	}
+	backups, err := listBackups(ctx, client, settings.BackupStorage, vmid)
+	if err != nil || isAdmin {
+		return backups, err
+	}
+	owned := backups[:0]
+	for _, b := range backups {
+		if username != "" && backupOwner(b.Notes) == username {
+			owned = append(owned, b)
+		}
+	}
+	return owned, nil
+}
+
+// findUserBackup returns the backup with the given volume ID if the user may use it
+func findUserBackup(ctx context.Context, client proxmox.ClientInterface, settings *state.AppSettings, username string, isAdmin bool, volid string) (*proxmox.Backup, error) {
+	if settings == nil || settings.BackupStorage == "" {
+		return nil, errBackupsDisabled
+	}
+	if !strings.HasPrefix(volid, settings.BackupStorage+":") {
+		return nil, errBackupNotFound
+	}
+	backups, err := listUserBackups(ctx, client, settings, username, isAdmin, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := range backups {
+		if backups[i].VolID == volid {
+			return &backups[i], nil
+		}
+	}
+	return nil, errBackupNotFound
+}
+
+// checkBackupLimit returns errBackupLimitReached when username cannot create another backup.
+// Admins are not limited.
+func checkBackupLimit(ctx context.Context, client proxmox.ClientInterface, settings *state.AppSettings, username string, isAdmin bool) error {
+	if isAdmin {
+		return nil
+	}
+	max, limited := perUserLimit(settings, "backups")
+	if !limited {
+		return nil
+	}
+	// The listing is read past the cache, and backups still running are not listed yet
+	nodes, err := proxmox.GetNodeNamesWithContext(ctx, client)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		proxmox.InvalidateBackups(client, node, settings.BackupStorage)
+	}
+	backups, err := listUserBackups(ctx, client, settings, username, false, 0)
+	if err != nil {
+		return err
+	}
+	if len(backups)+proxmox.CountRunningTasks(backupTaskAction, username) >= max {
+		return errBackupLimitReached
+	}
+	return nil
+}
+
+// toBackupViews formats backups for the templates
+func toBackupViews(backups []proxmox.Backup) []BackupView {
+	views := make([]BackupView, 0, len(backups))
+	for _, b := range backups {
+		view := BackupView{VolID: b.VolID, VMID: b.VMID, Node: b.Node, Size: FormatBytes(b.Size), Owner: backupOwner(b.Notes)}
+		if b.CTime > 0 {
+			view.CreatedAt = time.Unix(b.CTime, 0).Local().Format("2006-01-02 15:04")
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// backupReturnPath returns the page to go back to after a backup action: the VM details page
+// when the form was posted from it, the profile page otherwise
+func backupReturnPath(r *http.Request) string {
+	if vmid, err := strconv.Atoi(r.FormValue("return_vmid")); err == nil && vmid > 0 {
+		return "/vm/details/" + strconv.Itoa(vmid)
+	}
+	return "/profile"
+}
+
+// VMBackupCreateHandler starts a backup of a VM to the admin-designated backup storage
+func (h *VMHandler) VMBackupCreateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "VMBackupCreateHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(r.FormValue("vmid"))
+	node := strings.TrimSpace(r.FormValue("node"))
+	if err != nil || vmid <= 0 || node == "" {
+		http.Error(w, "Invalid VM ID or node", http.StatusBadRequest)
+		return
+	}
+	details := "/vm/details/" + strconv.Itoa(vmid)
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.Log.Error().Msg("Proxmox client not available")
+		http.Error(w, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	settings := h.stateManager.GetSettings()
+	if settings == nil || settings.BackupStorage == "" {
+		ctx.RedirectWithError(details, "VMDetails.Backups.Disabled")
+		return
+	}
+
+	username := ctx.GetUsername()
+	unlock := lockUserLimits(username)
+	defer unlock()
+	if err := checkBackupLimit(r.Context(), client, settings, username, ctx.IsAdmin()); err != nil {
+		if errors.Is(err, errBackupLimitReached) {
+			ctx.RedirectWithError(details, "VMDetails.Backups.LimitReached")
+			return
+		}
+		ctx.Log.Error().Err(err).Msg("Unable to count user backups")
+		ctx.RedirectWithError(details, "VMDetails.Backups.CreateFailed")
+		return
+	}
+
+	upid, err := proxmox.CreateVMBackupWithContext(r.Context(), client, node, vmid, settings.BackupStorage, backupOwnerPrefix+username)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMBackupCreate,
+		VMID:   vmid,
+		Params: map[string]string{"node": node, "storage": settings.BackupStorage},
+		UPID:   upid,
+	}, err)
+	if err != nil {
+		ctx.RedirectWithError(details, "VMDetails.Backups.CreateFailed")
+		return
+	}
+	proxmox.TrackTask(client, upid, vmid, backupTaskAction, username)
+
+	ctx.Log.Info().Int("vmid", vmid).Str("storage", settings.BackupStorage).Str("upid", upid).Msg("VM backup requested")
+	ctx.RedirectWithSuccess(details, "VMDetails.Backups.Created")
+}
+
+// VMBackupRestoreConfirmHandler shows a confirmation page before restoring a backup over a VM
+func (h *VMHandler) VMBackupRestoreConfirmHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("VMBackupRestoreConfirmHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodGet) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(r.URL.Query().Get("vmid"))
+	if err != nil || vmid <= 0 {
+		http.Error(w, "Invalid VM ID", http.StatusBadRequest)
+		return
+	}
+	volid := r.URL.Query().Get("volid")
+
+	stateManager := getStateManager(r)
+	client := stateManager.GetProxmoxClient()
+	if client == nil {
+		log.Error().Msg("Proxmox client not available")
+		http.Error(w, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	handlerCtx := NewHandlerContext(w, r, "VMBackupRestoreConfirmHandler")
+	backup, err := findUserBackup(r.Context(), client, stateManager.GetSettings(), handlerCtx.GetUsername(), handlerCtx.IsAdmin(), volid)
+	if err != nil {
+		log.Warn().Err(err).Str("volid", volid).Msg("Backup not available for restore")
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+
+	vms, err := proxmox.GetVMsWithContext(r.Context(), client)
+	if err != nil {
+		log.Error().Err(err).Int("vmid", vmid).Msg("Failed to get VMs")
+		http.Error(w, "Failed to get VMs", http.StatusInternalServerError)
+		return
+	}
+	vm := findVMByID(vms, vmid)
+	if vm == nil {
+		http.Error(w, "VM not found", http.StatusNotFound)
+		return
+	}
+
+	csrfToken, _ := handlerCtx.GetCSRFToken()
+	custom := map[string]interface{}{
+		"VM":        vm,
+		"Backup":    toBackupViews([]proxmox.Backup{*backup})[0],
+		"CSRFToken": csrfToken,
+	}
+
+	th := NewTemplateHelpers()
+	th.RenderUserPage(w, r, "vm_backup_restore_confirm", "Confirm Backup Restore", stateManager, custom)
+}
+
+// restoreSpec sizes a restore as a new VM from the config stored in the archive, so it goes through
+// the same quota, approval and lease as a creation. A config that cannot be read only counts the VM.
+func (h *VMHandler) restoreSpec(ctx context.Context, client proxmox.ClientInterface, backup *proxmox.Backup, pool string) *VMCreateSpec {
+	spec := &VMCreateSpec{Node: backup.Node, Pool: pool}
+	if cfg, err := proxmox.GetBackupConfigWithContext(ctx, client, backup.Node, backup.VolID); err != nil {
+		logger.Get().Warn().Err(err).Str("volid", backup.VolID).Msg("Backup config unavailable; the restore only counts one VM")
+	} else {
+		size := currentVMSize(cfg)
+		spec.Sockets, spec.Cores, spec.MemoryMB, spec.DiskSizeGB = size.Sockets, size.Cores, size.MemoryMB, size.DiskGB
+		spec.Name, _ = cfg["name"].(string)
+		if tags, ok := cfg["tags"].(string); ok {
+			spec.Tags = parseTags(tags)
+		}
+	}
+	spec.LeaseDays = leaseDaysForTags(settingsLeasePolicy(h.stateManager.GetSettings()), ensureMandatoryTag(spec.Tags))
+	return spec
+}
+
+// restoreAsNewVM restores a backup into the next free VMID, in spec.Pool when it is set,
+// and records the lease of the restored VM. It returns the VMID that was used.
+func (h *VMHandler) restoreAsNewVM(ctx context.Context, client proxmox.ClientInterface, backup *proxmox.Backup, spec *VMCreateSpec) (int, error) {
+	vmid, err := proxmox.GetNextVMID(ctx, client)
+	if err != nil {
+		return 0, fmt.Errorf("unable to allocate a VMID: %w", err)
+	}
+	proxmox.ForgetVMTasks(vmid)
+
+	opts := proxmox.RestoreOptions{Unique: true, Pool: spec.Pool}
+	upid, err := proxmox.RestoreVMBackupWithContext(ctx, client, backup.Node, vmid, backup.VolID, opts)
+	if err != nil {
+		return 0, err
+	}
+	proxmox.InvalidateInventory()
+	logger.Get().Info().Str("volid", backup.VolID).Int("vmid", vmid).Str("upid", upid).Msg("VM restore as a new VM requested")
+	h.recordVMLease(spec, vmid)
+	return vmid, nil
+}
+
+// validateRestoreLimits checks the VM a backup restores against the per-VM limits and the quota of
+// username. excludeVMID is the VM the restore overwrites, whose usage it replaces; 0 for a new VM.
+func (h *VMHandler) validateRestoreLimits(ctx context.Context, client proxmox.ClientInterface, username string, spec *VMCreateSpec, excludeVMID int) error {
+	settings := h.stateManager.GetSettings()
+	// A restore whose config cannot be read has no size to check, only a VM to count
+	if spec.Sockets > 0 {
+		if err := validateVMSizeLimits(settings, spec.Node, spec.Sockets, spec.Cores, spec.MemoryMB, spec.DiskSizeGB); err != nil {
+			return err
+		}
+	}
+	return validateUserQuota(ctx, client, settings, username, excludeVMID, spec.Sockets*spec.Cores, spec.MemoryMB, spec.DiskSizeGB)
+}
+
+// findStoppedUserVM returns the VM a backup of username is restored over. It must still be in
+// the user's pool and be stopped.
+func findStoppedUserVM(ctx context.Context, client proxmox.ClientInterface, username string, vmid int) (*proxmox.VM, error) {
+	if err := CheckVMOwnership(ctx, client, username, false, vmid); err != nil {
+		return nil, err
+	}
+	vms, err := proxmox.GetVMsWithContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	target := findVMByID(vms, vmid)
+	if target == nil {
+		return nil, fmt.Errorf("VM %d not found", vmid)
+	}
+	if target.Status != "stopped" {
+		return nil, fmt.Errorf("VM %d must be stopped before it is restored", vmid)
+	}
+	return target, nil
+}
+
+// approveRestoreRequest restores an approved backup request into the requester's pool, or over
+// the VM it targets, checking their limits again since they may have changed while the request
+// was pending
+func (h *VMHandler) approveRestoreRequest(ctx context.Context, client proxmox.ClientInterface, username string, form VMCreateFormData) (int, error) {
+	backup, err := findUserBackup(ctx, client, h.stateManager.GetSettings(), username, false, form.Restore)
+	if err != nil {
+		return 0, err
+	}
+	spec := h.restoreSpec(ctx, client, backup, userPoolName(username))
+	if form.VMID == "" {
+		if err := h.validateRestoreLimits(ctx, client, username, spec, 0); err != nil {
+			return 0, err
+		}
+		return h.restoreAsNewVM(ctx, client, backup, spec)
+	}
+
+	vmid, err := strconv.Atoi(form.VMID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid VM ID %q", form.VMID)
+	}
+	target, err := findStoppedUserVM(ctx, client, username, vmid)
+	if err != nil {
+		return 0, err
+	}
+	spec.Node = target.Node
+	if err := h.validateRestoreLimits(ctx, client, username, spec, vmid); err != nil {
+		return 0, err
+	}
+	upid, err := proxmox.RestoreVMBackupWithContext(ctx, client, target.Node, vmid, backup.VolID, proxmox.RestoreOptions{Force: true})
+	if err != nil {
+		return 0, err
+	}
+	logger.Get().Info().Str("volid", backup.VolID).Int("vmid", vmid).Str("upid", upid).Msg("Approved VM restore requested")
+	return vmid, nil
+}
+
+// VMBackupRestoreHandler restores a backup either over an existing VM or as a new VM in the user's pool.
+// A new VM counts against the user's quota and waits for approval when it exceeds the auto-approve envelope.
+func (h *VMHandler) VMBackupRestoreHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "VMBackupRestoreHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	volid := r.FormValue("volid")
+	mode := r.FormValue("mode")
+	if volid == "" || (mode != "overwrite" && mode != "new") {
+		http.Error(w, "Invalid restore request", http.StatusBadRequest)
+		return
+	}
+	returnPath := backupReturnPath(r)
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.Log.Error().Msg("Proxmox client not available")
+		http.Error(w, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+
+	var target *proxmox.VM
+	if mode == "overwrite" {
+		vmid, err := strconv.Atoi(r.FormValue("vmid"))
+		if err != nil || vmid <= 0 {
+			http.Error(w, "Invalid VM ID", http.StatusBadRequest)
+			return
+		}
+		if !requireVMOwnership(w, r, client, vmid) {
+			return
+		}
+		vms, err := proxmox.GetVMsWithContext(r.Context(), client)
+		if err != nil {
+			ctx.Log.Error().Err(err).Msg("Failed to get VMs")
+			ctx.RedirectWithError(returnPath, "VMDetails.Backups.RestoreFailed")
+			return
+		}
+		if target = findVMByID(vms, vmid); target == nil {
+			http.Error(w, "VM not found", http.StatusNotFound)
+			return
+		}
+		returnPath = "/vm/details/" + strconv.Itoa(vmid)
+		if target.Status != "stopped" {
+			ctx.RedirectWithError(returnPath, "VMDetails.Backups.StopFirst")
+			return
+		}
+	}
+
+	username := ctx.GetUsername()
+	backup, err := findUserBackup(r.Context(), client, h.stateManager.GetSettings(), username, ctx.IsAdmin(), volid)
+	if err != nil {
+		if errors.Is(err, errBackupNotFound) {
+			http.Error(w, "Backup not found", http.StatusNotFound)
+			return
+		}
+		ctx.Log.Error().Err(err).Msg("Unable to look up backup")
+		ctx.RedirectWithError(returnPath, "VMDetails.Backups.RestoreFailed")
+		return
+	}
+
+	if target == nil {
+		h.restoreBackupAsNew(ctx, r, client, backup, returnPath)
+		return
+	}
+
+	// The restore replaces the VM's config with the archived one, so a regular user's restore goes
+	// through the same limits, quota and approval as a restore as a new VM
+	if !ctx.IsAdmin() && !h.checkRestoreOverVM(ctx, r, client, backup, target, returnPath) {
+		return
+	}
+
+	upid, err := proxmox.RestoreVMBackupWithContext(r.Context(), client, target.Node, target.VMID, backup.VolID, proxmox.RestoreOptions{Force: true})
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMBackupRestore,
+		VMID:   target.VMID,
+		Params: map[string]string{"node": target.Node, "volid": backup.VolID, "mode": mode},
+		UPID:   upid,
+	}, err)
+	if err != nil {
+		ctx.RedirectWithError(returnPath, "VMDetails.Backups.RestoreFailed")
+		return
+	}
+
+	ctx.Log.Info().Str("volid", backup.VolID).Int("vmid", target.VMID).Str("mode", mode).Str("upid", upid).Msg("VM restore requested")
+	ctx.RedirectWithSuccess(returnPath, "VMDetails.Backups.Restored")
+}
+
+// checkRestoreOverVM checks a regular user's restore over target against their limits and quota,
+// the VM's own usage excluded. A restore above the auto-approve envelope is submitted for approval.
+// It returns true when the restore may run now, and otherwise redirects.
+func (h *VMHandler) checkRestoreOverVM(ctx *HandlerContext, r *http.Request, client proxmox.ClientInterface, backup *proxmox.Backup, target *proxmox.VM, returnPath string) bool {
+	username := ctx.GetUsername()
+	spec := h.restoreSpec(r.Context(), client, backup, userPoolName(username))
+	spec.Node = target.Node
+	if spec.Sockets == 0 {
+		ctx.Log.Warn().Str("volid", backup.VolID).Int("vmid", target.VMID).Msg("Restore over a VM refused: the backup size is unknown")
+		ctx.RedirectWithError(returnPath, "VMDetails.Backups.SizeUnknown")
+		return false
+	}
+	if err := h.validateRestoreLimits(r.Context(), client, username, spec, target.VMID); err != nil {
+		ctx.Log.Warn().Err(err).Str("volid", backup.VolID).Int("vmid", target.VMID).Msg("Restore exceeds user limits")
+		ctx.RedirectWithErrorMessage(returnPath, ctx.Translate("VMDetails.Backups.QuotaExceeded")+" "+err.Error())
+		return false
+	}
+
+	if exceeds := autoApproveLimits(h.stateManager.GetSettings()).exceededBy(spec); exceeds != "" {
+		form := restoreRequestForm(spec, backup)
+		form.VMID = strconv.Itoa(target.VMID)
+		h.submitRestoreRequest(ctx, username, form, exceeds, returnPath)
+		return false
+	}
+	return true
+}
+
+// restoreRequestForm describes a restore as a VM request, whose sizes are read from the archive
+func restoreRequestForm(spec *VMCreateSpec, backup *proxmox.Backup) VMCreateFormData {
+	return VMCreateFormData{
+		Name:     spec.Name,
+		Node:     spec.Node,
+		Pool:     spec.Pool,
+		Sockets:  strconv.Itoa(spec.Sockets),
+		Cores:    strconv.Itoa(spec.Cores),
+		Memory:   strconv.Itoa(spec.MemoryMB),
+		DiskSize: strconv.Itoa(spec.DiskSizeGB),
+		Tags:     spec.Tags,
+		Restore:  backup.VolID,
+	}
+}
+
+// submitRestoreRequest stores a restore above the auto-approve envelope for approval
+func (h *VMHandler) submitRestoreRequest(ctx *HandlerContext, username string, form VMCreateFormData, exceeds, returnPath string) {
+	req, err := h.submitVMRequest(ctx.Request, username, form, exceeds)
+	if err != nil {
+		ctx.Log.Error().Err(err).Msg("Failed to store restore request")
+		ctx.RedirectWithError(returnPath, "VMDetails.Backups.RestoreFailed")
+		return
+	}
+	ctx.Log.Info().Str("vm_request_id", req.ID).Str("exceeds", exceeds).Msg("Restore submitted for approval")
+	ctx.Redirect("/profile?success_msg=" + url.QueryEscape(ctx.Translate("VMDetails.Backups.RestoreSubmitted")))
+}
+
+// restoreBackupAsNew handles the "new" mode of VMBackupRestoreHandler. Regular users restore into
+// their own pool, within their quota, and submit restores above the auto-approve envelope for approval.
+func (h *VMHandler) restoreBackupAsNew(ctx *HandlerContext, r *http.Request, client proxmox.ClientInterface, backup *proxmox.Backup, returnPath string) {
+	if ctx.IsAdmin() {
+		vmid, err := h.restoreAsNewVM(r.Context(), client, backup, h.restoreSpec(r.Context(), client, backup, ""))
+		auditRestoreAsNew(r, backup, vmid, "", err)
+		if err != nil {
+			ctx.Log.Error().Err(err).Str("volid", backup.VolID).Msg("Restore as a new VM failed")
+			ctx.RedirectWithError(returnPath, "VMDetails.Backups.RestoreFailed")
+			return
+		}
+		ctx.Log.Info().Str("volid", backup.VolID).Int("vmid", vmid).Msg("Backup restored as a new VM")
+		ctx.RedirectWithSuccess(returnPath, "VMDetails.Backups.RestoredAsNew")
+		return
+	}
+
+	username := ctx.GetUsername()
+	unlock := lockUserLimits(username)
+	defer unlock()
+
+	spec := h.restoreSpec(r.Context(), client, backup, userPoolName(username))
+	if err := h.validateRestoreLimits(r.Context(), client, username, spec, 0); err != nil {
+		ctx.Log.Warn().Err(err).Str("volid", backup.VolID).Msg("Restore exceeds user limits")
+		ctx.RedirectWithErrorMessage(returnPath, ctx.Translate("VMDetails.Backups.QuotaExceeded")+" "+err.Error())
+		return
+	}
+
+	if exceeds := autoApproveLimits(h.stateManager.GetSettings()).exceededBy(spec); exceeds != "" {
+		h.submitRestoreRequest(ctx, username, restoreRequestForm(spec, backup), exceeds, returnPath)
+		return
+	}
+
+	vmid, err := h.restoreAsNewVM(r.Context(), client, backup, spec)
+	auditRestoreAsNew(r, backup, vmid, spec.Pool, err)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("volid", backup.VolID).Msg("Restore as a new VM failed")
+		ctx.RedirectWithError(returnPath, "VMDetails.Backups.RestoreFailed")
+		return
+	}
+	ctx.Log.Info().Str("volid", backup.VolID).Int("vmid", vmid).Msg("Backup restored as a new VM")
+	ctx.RedirectWithSuccess(returnPath, "VMDetails.Backups.RestoredAsNew")
+}
+
+// auditRestoreAsNew records the restore of backup as the new VM vmid, in pool when it is set
+func auditRestoreAsNew(r *http.Request, backup *proxmox.Backup, vmid int, pool string, err error) {
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMBackupRestore,
+		VMID:   vmid,
+		Pool:   pool,
+		Params: map[string]string{"node": backup.Node, "volid": backup.VolID, "mode": "new"},
+	}, err)
+}
+
+// VMBackupDeleteHandler deletes one of the user's backups
+func (h *VMHandler) VMBackupDeleteHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "VMBackupDeleteHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	volid := r.FormValue("volid")
+	returnPath := backupReturnPath(r)
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.Log.Error().Msg("Proxmox client not available")
+		http.Error(w, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+
+	settings := h.stateManager.GetSettings()
+	backup, err := findUserBackup(r.Context(), client, settings, ctx.GetUsername(), ctx.IsAdmin(), volid)
+	if err != nil {
+		if errors.Is(err, errBackupNotFound) {
+			http.Error(w, "Backup not found", http.StatusNotFound)
+			return
+		}
+		ctx.Log.Error().Err(err).Msg("Unable to look up backup")
+		ctx.RedirectWithError(returnPath, "VMDetails.Backups.DeleteFailed")
+		return
+	}
+
+	upid, err := proxmox.DeleteBackupWithContext(r.Context(), client, backup.Node, settings.BackupStorage, backup.VolID)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMBackupDelete,
+		VMID:   backup.VMID,
+		Params: map[string]string{"node": backup.Node, "volid": backup.VolID},
+		UPID:   upid,
+	}, err)
+	if err != nil {
+		ctx.RedirectWithError(returnPath, "VMDetails.Backups.DeleteFailed")
+		return
+	}
+
+	ctx.Log.Info().Str("volid", backup.VolID).Str("upid", upid).Msg("Backup deletion requested")
+	ctx.RedirectWithSuccess(returnPath, "VMDetails.Backups.Deleted")
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"pvmss/state"
+)
+
+const testBackupVolID = "backup:backup/vzdump-qemu-100-2026_01_01-10_00_00.vma.zst"
+
+func newBackupTestState(maxPerUser int) (*fakeStateManager, *fakeProxmoxClient) {
+	settings := &state.AppSettings{
+		BackupStorage: "backup",
+		Limits:        map[string]interface{}{"backups": map[string]interface{}{"max_per_user": maxPerUser}},
+	}
+	sm, client := newAPITestState(settings)
+	client.responses["/nodes/pve1/storage/backup/content"] = map[string]interface{}{
+		"data": []map[string]interface{}{
+			{"volid": testBackupVolID, "content": "backup", "vmid": 100, "ctime": 1767261600, "notes": backupOwnerPrefix + "alice"},
+			{"volid": "backup:backup/vzdump-qemu-200-2026_01_01-10_00_00.vma.zst", "content": "backup", "vmid": 200, "ctime": 1767261600, "notes": backupOwnerPrefix + "bob"},
+			{"volid": "backup:iso/debian.iso", "content": "iso"},
+		},
+	}
+	return sm, client
+}
+
+func backupForm(target string, values url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestBackupOwner(t *testing.T) {
+	tests := map[string]string{
+		backupOwnerPrefix + "alice":               "alice",
+		"nightly\n" + backupOwnerPrefix + "bob\n": "bob",
+		"owner: alice":                            "",
+		"":                                        "",
+	}
+	for notes, want := range tests {
+		if got := backupOwner(notes); got != want {
+			t.Errorf("backupOwner(%q) = %q, want %q", notes, got, want)
+		}
+	}
+}
+
+func TestVMBackupCreateLimit(t *testing.T) {
+	sm, client := newBackupTestState(1)
+	h := NewVMHandler(sm)
+
+	req := backupForm("/vm/backup/create", url.Values{"vmid": {"100"}, "node": {"pve1"}})
+	rec := serveAs(sm, "alice", false, h.VMBackupCreateHandler, req, nil)
+	if rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "error=1") {
+		t.Fatalf("Expected an error redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if client.called(http.MethodPost, "/nodes/pve1/vzdump") {
+		t.Error("Backup must not start once the user limit is reached")
+	}
+
+	sm.settings.Limits["backups"] = map[string]interface{}{"max_per_user": 2}
+	req = backupForm("/vm/backup/create", url.Values{"vmid": {"100"}, "node": {"pve1"}})
+	rec = serveAs(sm, "alice", false, h.VMBackupCreateHandler, req, nil)
+	if !strings.Contains(rec.Header().Get("Location"), "success=1") {
+		t.Fatalf("Expected a success redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if !client.called(http.MethodPost, "/nodes/pve1/vzdump") {
+		t.Error("Expected a vzdump task to be started")
+	}
+}
+
+func TestVMBackupRestoreAccess(t *testing.T) {
+	restore := url.Values{"volid": {testBackupVolID}, "mode": {"new"}}
+
+	t.Run("non-owner", func(t *testing.T) {
+		sm, client := newBackupTestState(5)
+		rec := serveAs(sm, "bob", false, NewVMHandler(sm).VMBackupRestoreHandler, backupForm("/vm/backup/restore", restore), nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+		}
+		if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Error("Restore must not happen for a backup owned by another user")
+		}
+	})
+
+	t.Run("owner as new VM", func(t *testing.T) {
+		sm, client := newBackupTestState(5)
+		rec := serveAs(sm, "alice", false, NewVMHandler(sm).VMBackupRestoreHandler, backupForm("/vm/backup/restore", restore), nil)
+		if !strings.Contains(rec.Header().Get("Location"), "success=1") {
+			t.Fatalf("Expected a success redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if !client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Error("Expected a restore into a new VM")
+		}
+	})
+
+	t.Run("overwrite running VM", func(t *testing.T) {
+		sm, client := newBackupTestState(5)
+		values := url.Values{"volid": {testBackupVolID}, "mode": {"overwrite"}, "vmid": {"100"}}
+		rec := serveAs(sm, "alice", false, NewVMHandler(sm).VMBackupRestoreHandler, backupForm("/vm/backup/restore", values), nil)
+		if !strings.Contains(rec.Header().Get("Location"), "error=1") {
+			t.Fatalf("Expected an error redirect for a running VM, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Error("Restore must not overwrite a running VM")
+		}
+	})
+}
+
+func TestVMBackupRestoreAsNewQuotaAndApproval(t *testing.T) {
+	restore := url.Values{"volid": {testBackupVolID}, "mode": {"new"}}
+	configPath := "/nodes/pve1/vzdump/extractconfig?volume=" + url.QueryEscape(testBackupVolID)
+	archiveConfig := map[string]interface{}{
+		"data": "#restored\nname: web\nsockets: 1\ncores: 4\nmemory: 4096\nscsi0: local-lvm:vm-100-disk-0,size=20G\nboot: order=scsi0\n",
+	}
+
+	t.Run("quota", func(t *testing.T) {
+		sm, client := newBackupTestState(5)
+		client.responses[configPath] = archiveConfig
+		sm.settings.Limits["quotas"] = map[string]interface{}{"default": map[string]interface{}{"vms": 1}}
+		rec := serveAs(sm, "alice", false, NewVMHandler(sm).VMBackupRestoreHandler, backupForm("/vm/backup/restore", restore), nil)
+		if !strings.Contains(rec.Header().Get("Location"), "error=1") {
+			t.Fatalf("Expected an error redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Error("Restore must not exceed the user's quota")
+		}
+	})
+
+	t.Run("approval", func(t *testing.T) {
+		sm, client := newBackupTestState(5)
+		client.responses[configPath] = archiveConfig
+		sm.settings.Limits["auto_approve"] = map[string]interface{}{"cores": 2}
+		h := NewVMHandler(sm)
+		rec := serveAs(sm, "alice", false, h.VMBackupRestoreHandler, backupForm("/vm/backup/restore", restore), nil)
+		if !strings.HasPrefix(rec.Header().Get("Location"), "/profile?success_msg=") {
+			t.Fatalf("Expected a redirect to the profile, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Fatal("Restore must wait for approval")
+		}
+		requests := sm.GetVMRequestStore().ListForUser("alice")
+		if len(requests) != 1 || requests[0].Status != state.VMRequestPending {
+			t.Fatalf("Expected a pending request, got %+v", requests)
+		}
+		if view := toVMRequestView(requests[0]); !view.Restore || view.Cores != "4" || view.DiskSize != "20" {
+			t.Errorf("Expected the archive sizes in the request, got %+v", view)
+		}
+
+		rec = serveAs(sm, "", true, h.ApproveVMRequestHandler, newVMRequestForm(url.Values{"id": {requests[0].ID}, "cores": {"2"}}), nil)
+		if !strings.Contains(rec.Header().Get("Location"), "error=") {
+			t.Errorf("Expected the sizes of a restore to be read-only, got %s", rec.Header().Get("Location"))
+		}
+		rec = serveAs(sm, "", true, h.ApproveVMRequestHandler, newVMRequestForm(url.Values{"id": {requests[0].ID}}), nil)
+		if !strings.Contains(rec.Header().Get("Location"), "success=approve") {
+			t.Fatalf("Expected the approval to succeed, got %s", rec.Header().Get("Location"))
+		}
+		if !client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Error("Expected the approved restore to run")
+		}
+	})
+
+	t.Run("lease", func(t *testing.T) {
+		sm, client := newBackupTestState(5)
+		client.responses[configPath] = archiveConfig
+		sm.settings.Leases = &state.LeasePolicy{DefaultDays: 7}
+		serveAs(sm, "alice", false, NewVMHandler(sm).VMBackupRestoreHandler, backupForm("/vm/backup/restore", restore), nil)
+		lease, ok := sm.GetVMLeaseStore().Get(201)
+		if !ok || lease.Username != "alice@pve" || lease.Name != "web" {
+			t.Errorf("Expected a lease for the restored VM, got %+v (found %v)", lease, ok)
+		}
+	})
+}
+
+func TestVMBackupRestoreOverwriteLimits(t *testing.T) {
+	overwrite := url.Values{"volid": {testBackupVolID}, "mode": {"overwrite"}, "vmid": {"100"}}
+	configPath := "/nodes/pve1/vzdump/extractconfig?volume=" + url.QueryEscape(testBackupVolID)
+	newState := func() (*fakeStateManager, *fakeProxmoxClient) {
+		sm, client := newBackupTestState(5)
+		client.responses["/nodes/pve1/qemu"] = map[string]interface{}{
+			"data": []map[string]interface{}{{"vmid": 100, "name": "alice-vm", "status": "stopped"}},
+		}
+		client.responses[configPath] = map[string]interface{}{
+			"data": "name: web\nsockets: 1\ncores: 4\nmemory: 4096\nscsi0: local-lvm:vm-100-disk-0,size=20G\n",
+		}
+		return sm, client
+	}
+
+	t.Run("size limits", func(t *testing.T) {
+		sm, client := newState()
+		sm.settings.Limits["vm"] = map[string]interface{}{"cores": map[string]interface{}{"min": float64(1), "max": float64(2)}}
+		rec := serveAs(sm, "alice", false, NewVMHandler(sm).VMBackupRestoreHandler, backupForm("/vm/backup/restore", overwrite), nil)
+		if !strings.Contains(rec.Header().Get("Location"), "error=1") {
+			t.Fatalf("Expected an error redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Error("Restore must not grow a VM beyond the VM limits")
+		}
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		sm, client := newState()
+		delete(client.responses, configPath)
+		rec := serveAs(sm, "alice", false, NewVMHandler(sm).VMBackupRestoreHandler, backupForm("/vm/backup/restore", overwrite), nil)
+		if !strings.Contains(rec.Header().Get("Location"), "error=1") || client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Errorf("Expected a restore of unknown size to be refused, got %s", rec.Header().Get("Location"))
+		}
+	})
+
+	t.Run("approval", func(t *testing.T) {
+		sm, client := newState()
+		sm.settings.Limits["auto_approve"] = map[string]interface{}{"cores": 2}
+		h := NewVMHandler(sm)
+		rec := serveAs(sm, "alice", false, h.VMBackupRestoreHandler, backupForm("/vm/backup/restore", overwrite), nil)
+		if !strings.HasPrefix(rec.Header().Get("Location"), "/profile?success_msg=") {
+			t.Fatalf("Expected a redirect to the profile, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Fatal("Restore must wait for approval")
+		}
+		requests := sm.GetVMRequestStore().ListForUser("alice")
+		if len(requests) != 1 {
+			t.Fatalf("Expected a pending request, got %+v", requests)
+		}
+		if view := toVMRequestView(requests[0]); !strings.HasSuffix(view.Source, "over VM 100") {
+			t.Errorf("Expected the request to target VM 100, got %q", view.Source)
+		}
+
+		rec = serveAs(sm, "", true, h.ApproveVMRequestHandler, newVMRequestForm(url.Values{"id": {requests[0].ID}}), nil)
+		if !strings.Contains(rec.Header().Get("Location"), "success=approve") {
+			t.Fatalf("Expected the approval to succeed, got %s", rec.Header().Get("Location"))
+		}
+		if !client.called(http.MethodPost, "/nodes/pve1/qemu") {
+			t.Error("Expected the approved restore to run")
+		}
+		if _, ok := sm.GetVMLeaseStore().Get(201); ok {
+			t.Error("Expected the restore to overwrite VM 100, not to create a VM")
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"pvmss/constants"
+	"pvmss/logger"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// diskBuses lists the config keys probed, in order, when a template has no boot order
+var diskBuses = []string{"scsi", "virtio", "sata", "ide"}
+
+// cloneVM starts a full or linked clone of the spec's template into vmid.
+// The clone task can outlive the request, so the VM is resized, configured and
+// started in the background once Proxmox reports the task as finished.
+func (h *VMHandler) cloneVM(ctx context.Context, client proxmox.ClientInterface, spec *VMCreateSpec, vmid int) error {
+	log := logger.Get().With().Str("component", "cloneVM").Logger()
+
+	opts := proxmox.CloneVMOptions{
+		Name: spec.Name,
+		Pool: spec.Pool,
+		Full: !spec.LinkedClone,
+	}
+	// Linked clones must stay on the template storage
+	if opts.Full {
+		opts.Storage = spec.Storage
+	}
+
+	upid, err := proxmox.CloneVMWithContext(ctx, client, spec.Node, spec.Template.VMID, vmid, opts)
+	if err != nil {
+		return &vmCreateError{Status: http.StatusBadGateway, Code: "proxmox_error", Key: "Proxmox.ConnectionError", Message: "VM clone failed in Proxmox"}
+	}
+	proxmox.InvalidateInventory()
+	log.Info().Int("template", spec.Template.VMID).Int("vmid", vmid).Bool("linked", spec.LinkedClone).Str("upid", upid).Msg("VM clone started")
+
+	go func() {
+		bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), constants.CloneTaskTimeout)
+		defer cancel()
+		if err := finishClone(bgCtx, client, spec, vmid, upid); err != nil {
+			log.Error().Err(err).Int("vmid", vmid).Str("node", spec.Node).Msg("Failed to finish VM clone")
+		}
+	}()
+	return nil
+}
+
+// finishClone waits for the clone task, applies the requested resources, grows the boot
+// disk to the requested size and starts the VM.
+func finishClone(ctx context.Context, client proxmox.ClientInterface, spec *VMCreateSpec, vmid int, upid string) error {
+	log := logger.Get().With().Str("component", "finishClone").Int("vmid", vmid).Logger()
+	node := spec.Node
+
+	if _, err := proxmox.TrackAndWaitTask(ctx, client, upid, vmid, "clone", ""); err != nil {
+		return fmt.Errorf("clone task: %w", err)
+	}
+
+	cfg, err := proxmox.GetVMConfigWithContext(ctx, client, node, vmid)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"sockets": strconv.Itoa(spec.Sockets),
+		"cores":   strconv.Itoa(spec.Cores),
+		"memory":  strconv.Itoa(spec.MemoryMB), // MB
+		"agent":   "enabled=1",
+	}
+	if len(spec.Tags) > 0 {
+		params["tags"] = strings.Join(spec.Tags, ",")
+	}
+	if spec.Description != "" {
+		params["description"] = spec.Description
+	}
+	if spec.Bridge != "" {
+		params["net0"] = "virtio,bridge=" + spec.Bridge
+	}
+	if spec.CloudInit != nil && spec.Storage != "" {
+		// Cloud-Init ready templates already carry their own drive
+		slot := ""
+		if !hasCloudInitDrive(cfg) {
+			if slot = freeCloudInitSlot(cfg); slot == "" {
+				return fmt.Errorf("no free IDE or SATA slot for the Cloud-Init drive")
+			}
+		}
+		for k, v := range spec.CloudInit.params(slot, spec.Storage) {
+			params[k] = v
+		}
+	}
+	if err := proxmox.UpdateVMConfigWithContext(ctx, client, node, vmid, params); err != nil {
+		return err
+	}
+
+	if disk := bootDiskKey(cfg); disk != "" {
+		current, _ := cfg[disk].(string)
+		if spec.DiskSizeGB > diskSizeGB(current) {
+			if err := proxmox.ResizeVMDiskWithContext(ctx, client, node, vmid, disk, spec.DiskSizeGB); err != nil {
+				return err
+			}
+		}
+	} else {
+		log.Warn().Msg("No boot disk found on cloned VM; disk size left unchanged")
+	}
+
+	if upid, err := proxmox.VMActionWithContext(ctx, client, node, strconv.Itoa(vmid), "start"); err != nil {
+		log.Warn().Err(err).Msg("Failed to start VM after clone")
+	} else {
+		proxmox.TrackTask(client, upid, vmid, "start", "")
+	}
+
+	client.InvalidateCache("/nodes/" + url.PathEscape(node) + "/qemu")
+	if spec.Pool != "" {
+		client.InvalidateCache("/pools/" + url.PathEscape(spec.Pool))
+	}
+	log.Info().Str("node", node).Msg("VM clone finished")
+	return nil
+}
+
+// isDataDisk reports whether a drive config value is a disk rather than a CD-ROM or Cloud-Init drive
+func isDataDisk(value string) bool {
+	return value != "" && !strings.Contains(value, "media=cdrom") && !strings.Contains(value, "cloudinit")
+}
+
+// hasCloudInitDrive reports whether a VM config already has a Cloud-Init drive
+func hasCloudInitDrive(cfg map[string]interface{}) bool {
+	for _, bus := range diskBuses {
+		for i := 0; i < 32; i++ {
+			if v, ok := cfg[bus+strconv.Itoa(i)].(string); ok && strings.Contains(v, "cloudinit") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cloudInitSlots are the drive slots a Cloud-Init drive may use, in order of preference
+var cloudInitSlots = []string{"ide0", "ide1", "ide2", "ide3", "sata0", "sata1", "sata2", "sata3", "sata4", "sata5"}
+
+// freeCloudInitSlot returns the first Cloud-Init slot the VM config leaves unused, or ""
+func freeCloudInitSlot(cfg map[string]interface{}) string {
+	for _, slot := range cloudInitSlots {
+		if _, used := cfg[slot]; !used {
+			return slot
+		}
+	}
+	return ""
+}
+
+// templateMinDiskGB returns the smallest disk a clone of tmpl may ask for: the size of the
+// template's boot disk, or the offering's minimum when it is larger
+func templateMinDiskGB(ctx context.Context, client proxmox.ClientInterface, tmpl *state.VMTemplateOffering) (int, error) {
+	cfg, err := proxmox.GetVMConfigWithContext(ctx, client, tmpl.Node, tmpl.VMID)
+	if err != nil {
+		return 0, err
+	}
+	minGB := tmpl.MinDiskGB
+	if disk := bootDiskKey(cfg); disk != "" {
+		value, _ := cfg[disk].(string)
+		minGB = max(minGB, diskSizeGB(value))
+	}
+	return minGB, nil
+}
+
+// bootDiskKey returns the config key (e.g. scsi0) of the VM's boot disk
+func bootDiskKey(cfg map[string]interface{}) string {
+	// boot: order=scsi0;ide2;net0
+	if boot, ok := cfg["boot"].(string); ok {
+		if order, found := strings.CutPrefix(boot, "order="); found {
+			for _, key := range strings.Split(order, ";") {
+				if v, ok := cfg[key].(string); ok && isDataDisk(v) {
+					return key
+				}
+			}
+		}
+	}
+	if key, ok := cfg["bootdisk"].(string); ok {
+		if v, ok := cfg[key].(string); ok && isDataDisk(v) {
+			return key
+		}
+	}
+	for _, bus := range diskBuses {
+		key := bus + "0"
+		if v, ok := cfg[key].(string); ok && isDataDisk(v) {
+			return key
+		}
+	}
+	return ""
+}
+
+// diskSizeGB extracts the size option of a drive config value in GB, rounding up
+func diskSizeGB(value string) int {
+	for _, opt := range strings.Split(value, ",") {
+		raw, ok := strings.CutPrefix(opt, "size=")
+		if !ok || raw == "" {
+			continue
+		}
+		unit := raw[len(raw)-1]
+		n, err := strconv.ParseFloat(strings.TrimRight(raw, "KMGT"), 64)
+		if err != nil {
+			return 0
+		}
+		switch unit {
+		case 'T':
+			n *= 1024
+		case 'M':
+			n /= 1024
+		case 'K':
+			n /= 1024 * 1024
+		case 'G':
+		default:
+			// Bare numbers are bytes
+			n /= 1024 * 1024 * 1024
+		}
+		gb := int(n)
+		if float64(gb) < n {
+			gb++
+		}
+		return gb
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"pvmss/state"
+)
+
+func TestValidateVMCreateTemplate(t *testing.T) {
+	settings := &state.AppSettings{
+		Templates: []state.VMTemplateOffering{{VMID: 9000, Node: "pve1", Name: "Debian 12", OSFamily: "linux", MinDiskGB: 8}},
+	}
+	sm, client := newAPITestState(settings)
+	client.responses["/nodes/pve1/qemu/9000/config"] = map[string]interface{}{
+		"data": map[string]interface{}{"boot": "order=scsi0", "scsi0": "local-lvm:base-9000-disk-0,size=10G"},
+	}
+	h := NewVMHandler(sm)
+
+	form := VMCreateFormData{
+		Name: "web", Sockets: "1", Cores: "2", Memory: "2048", DiskSize: "16",
+		Storage: "local-lvm", Bridge: "vmbr0", Node: "pve1", Template: "9000", LinkedClone: true,
+		ISO: "local:iso/ignored.iso",
+	}
+	if missing := requiredVMCreateFields(form); len(missing) != 0 {
+		t.Fatalf("requiredVMCreateFields() = %v, ISO must not be required for a template", missing)
+	}
+
+	spec, err := h.validateVMCreate(context.Background(), client, form)
+	if err != nil {
+		t.Fatalf("validateVMCreate() error = %v", err)
+	}
+	if spec.Template == nil || spec.Template.VMID != 9000 || !spec.LinkedClone || spec.ISO != "" {
+		t.Errorf("Unexpected spec: template=%+v linked=%v iso=%q", spec.Template, spec.LinkedClone, spec.ISO)
+	}
+
+	var createErr *vmCreateError
+	small := form
+	small.DiskSize = "4"
+	if _, err := h.validateVMCreate(context.Background(), client, small); !errors.As(err, &createErr) || createErr.Code != "limit_exceeded" {
+		t.Errorf("Expected limit_exceeded for a disk below the template minimum, got %v", err)
+	}
+	// Above the offering's minimum but below the template's own 10 GB disk
+	small.DiskSize = "9"
+	if _, err := h.validateVMCreate(context.Background(), client, small); !errors.As(err, &createErr) || createErr.Code != "limit_exceeded" {
+		t.Errorf("Expected limit_exceeded for a disk below the template disk, got %v", err)
+	}
+
+	withCloudInit := form
+	withCloudInit.CloudInit = CloudInitFormData{Enabled: true}
+	if spec, err := h.validateVMCreate(context.Background(), client, withCloudInit); err != nil || spec.CloudInit.Hostname != "web" {
+		t.Errorf("Expected the Cloud-Init hostname to default to the VM name, got %+v (%v)", spec, err)
+	}
+	withCloudInit.CloudInit.Hostname = "db"
+	if _, err := h.validateVMCreate(context.Background(), client, withCloudInit); !errors.As(err, &createErr) || createErr.Code != "invalid_parameter" {
+		t.Errorf("Expected invalid_parameter for a hostname that differs from the VM name, got %v", err)
+	}
+
+	unknown := form
+	unknown.Template = "9001"
+	if _, err := h.validateVMCreate(context.Background(), client, unknown); !errors.As(err, &createErr) || createErr.Code != "invalid_parameter" {
+		t.Errorf("Expected invalid_parameter for a template that is not offered, got %v", err)
+	}
+}
+
+func TestFinishClone(t *testing.T) {
+	const upid = "UPID:pve1:00000001:00000001:00000001:qmclone:9000:root@pam:"
+	client := newFakeProxmoxClient()
+	client.responses["/nodes/pve1/tasks/"+url.PathEscape(upid)+"/status"] = map[string]interface{}{
+		"data": map[string]interface{}{"status": "stopped", "exitstatus": "OK"},
+	}
+	client.responses["/nodes/pve1/qemu/101/config"] = map[string]interface{}{
+		"data": map[string]interface{}{
+			"boot":  "order=scsi0;ide2;net0",
+			"ide2":  "local-lvm:vm-101-cloudinit,media=cdrom",
+			"scsi0": "local-lvm:base-9000-disk-0/vm-101-disk-0,size=8G",
+		},
+	}
+
+	spec := &VMCreateSpec{
+		Name: "web", Sockets: 1, Cores: 2, MemoryMB: 2048, DiskSizeGB: 16,
+		Node: "pve1", Pool: "pvmss_alice", Storage: "local-lvm", Bridge: "vmbr0",
+		Template:  &state.VMTemplateOffering{VMID: 9000, Node: "pve1", MinDiskGB: 8},
+		CloudInit: &CloudInitSpec{Hostname: "web"},
+	}
+	if err := finishClone(context.Background(), client, spec, 101, upid); err != nil {
+		t.Fatalf("finishClone() error = %v", err)
+	}
+
+	for _, call := range [][2]string{
+		{http.MethodPost, "/nodes/pve1/qemu/101/config"},
+		{http.MethodPut, "/nodes/pve1/qemu/101/resize"},
+		{http.MethodPost, "/nodes/pve1/qemu/101/status/start"},
+	} {
+		if !client.called(call[0], call[1]) {
+			t.Errorf("Expected call %s %s", call[0], call[1])
+		}
+	}
+}
+
+func TestCloneDiskHelpers(t *testing.T) {
+	cfg := map[string]interface{}{
+		"boot":    "order=ide2;virtio0",
+		"ide2":    "local:iso/debian.iso,media=cdrom",
+		"virtio0": "local-lvm:vm-101-disk-0,size=512M",
+	}
+	if got := bootDiskKey(cfg); got != "virtio0" {
+		t.Errorf("bootDiskKey() = %q, want virtio0", got)
+	}
+	if hasCloudInitDrive(cfg) {
+		t.Error("hasCloudInitDrive() = true, want false")
+	}
+	cfg["ide0"] = "none,media=cdrom"
+	if got := freeCloudInitSlot(cfg); got != "ide1" {
+		t.Errorf("freeCloudInitSlot() = %q, want ide1", got)
+	}
+
+	sizes := map[string]int{
+		"local-lvm:vm-101-disk-0,size=32G":        32,
+		"local-lvm:vm-101-disk-0,size=512M":       1,
+		"local-lvm:vm-101-disk-0,size=1T":         1024,
+		"local-lvm:vm-101-disk-0,iothread=1":      0,
+		"local-lvm:vm-101-disk-0,size=2147483648": 2,
+	}
+	for value, want := range sizes {
+		if got := diskSizeGB(value); got != want {
+			t.Errorf("diskSizeGB(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxCloudInitNICs is the number of network interfaces the create flow attaches (net0)
+const maxCloudInitNICs = 1
+
+var (
+	// hostnameRegex matches an RFC 1123 hostname label
+	hostnameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	// ciUserRegex matches a POSIX-style user name
+	ciUserRegex = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	// snippetVolidRegex matches a Proxmox snippet volume such as local:snippets/user-data.yaml
+	snippetVolidRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*:snippets/[a-zA-Z0-9._/-]+$`)
+	// snippetPrefixRegex matches the snippet storage, and optionally directory, users may pick from,
+	// such as local:snippets/ or local:snippets/pvmss/
+	snippetPrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*:snippets/([a-zA-Z0-9._-]+/)*$`)
+	// searchDomainRegex matches a DNS domain name
+	searchDomainRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+)
+
+// sshKeyTypes lists the public key types accepted in sshkeys
+var sshKeyTypes = []string{"ssh-rsa", "ssh-ed25519", "ecdsa-sha2-", "sk-ssh-ed25519@openssh.com", "sk-ecdsa-sha2-"}
+
+// CloudInitIPConfig is the IPv4 configuration of one NIC (ipconfigN)
+type CloudInitIPConfig struct {
+	Mode    string `json:"mode"`              // "dhcp" or "static"
+	Address string `json:"address,omitempty"` // CIDR, static only
+	Gateway string `json:"gateway,omitempty"` // optional, static only
+}
+
+// CloudInitFormData holds the raw Cloud-Init fields of the create form (gob-serializable)
+type CloudInitFormData struct {
+	Enabled      bool
+	Hostname     string
+	User         string
+	SSHKeys      string
+	Networks     []CloudInitIPConfig
+	Nameservers  string
+	SearchDomain string
+	UserData     string
+}
+
+// CloudInitSpec holds validated Cloud-Init settings
+type CloudInitSpec struct {
+	Hostname     string // the VM name; Proxmox has no separate hostname setting
+	User         string
+	SSHKeys      []string
+	Networks     []CloudInitIPConfig
+	Nameservers  []string
+	SearchDomain string
+	UserData     string // snippet volid passed as cicustom user=
+}
+
+// parseCloudInitForm reads the Cloud-Init fields of the create form
+func parseCloudInitForm(r *http.Request) CloudInitFormData {
+	data := CloudInitFormData{
+		Enabled:      r.FormValue("cloudinit") == "1",
+		Hostname:     strings.TrimSpace(r.FormValue("ci_hostname")),
+		User:         strings.TrimSpace(r.FormValue("ci_user")),
+		SSHKeys:      r.FormValue("ci_sshkeys"),
+		Nameservers:  strings.TrimSpace(r.FormValue("ci_nameservers")),
+		SearchDomain: strings.TrimSpace(r.FormValue("ci_searchdomain")),
+		UserData:     strings.TrimSpace(r.FormValue("ci_userdata")),
+	}
+	for i := 0; i < maxCloudInitNICs; i++ {
+		prefix := "ci_ipconfig" + strconv.Itoa(i) + "_"
+		mode := r.FormValue(prefix + "mode")
+		if mode == "" {
+			continue
+		}
+		data.Networks = append(data.Networks, CloudInitIPConfig{
+			Mode:    mode,
+			Address: strings.TrimSpace(r.FormValue(prefix + "address")),
+			Gateway: strings.TrimSpace(r.FormValue(prefix + "gateway")),
+		})
+	}
+	return data
+}
+
+// formValues returns the Cloud-Init fields keyed by form field name, to re-populate the create form
+func (c CloudInitFormData) formValues() map[string]interface{} {
+	values := map[string]interface{}{
+		"cloudinit":       c.Enabled,
+		"ci_hostname":     c.Hostname,
+		"ci_user":         c.User,
+		"ci_sshkeys":      c.SSHKeys,
+		"ci_nameservers":  c.Nameservers,
+		"ci_searchdomain": c.SearchDomain,
+		"ci_userdata":     c.UserData,
+	}
+	for i, n := range c.Networks {
+		prefix := "ci_ipconfig" + strconv.Itoa(i) + "_"
+		values[prefix+"mode"] = n.Mode
+		values[prefix+"address"] = n.Address
+		values[prefix+"gateway"] = n.Gateway
+	}
+	return values
+}
+
+// validateCloudInit checks the Cloud-Init form fields and returns the resulting spec. The
+// user-data snippet must be under snippetPrefix, the one set by an administrator; custom
+// user-data is refused when it is empty. It returns nil, nil when Cloud-Init is not enabled.
+func validateCloudInit(form CloudInitFormData, snippetPrefix string) (*CloudInitSpec, error) {
+	if !form.Enabled {
+		return nil, nil
+	}
+	invalid := func(format string, args ...interface{}) error {
+		return &vmCreateError{Status: http.StatusBadRequest, Code: "invalid_parameter", Message: "cloud-init: " + fmt.Sprintf(format, args...)}
+	}
+
+	spec := &CloudInitSpec{
+		Hostname:     form.Hostname,
+		User:         form.User,
+		SearchDomain: form.SearchDomain,
+		UserData:     form.UserData,
+	}
+
+	if spec.Hostname != "" && !hostnameRegex.MatchString(spec.Hostname) {
+		return nil, invalid("invalid hostname %q", spec.Hostname)
+	}
+	if spec.User != "" && !ciUserRegex.MatchString(spec.User) {
+		return nil, invalid("invalid user %q", spec.User)
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(form.SSHKeys, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isSSHPublicKey(line) {
+			return nil, invalid("invalid SSH public key %q", truncate(line, 32))
+		}
+		spec.SSHKeys = append(spec.SSHKeys, line)
+	}
+
+	if len(form.Networks) > maxCloudInitNICs {
+		return nil, invalid("at most %d network interface(s) can be configured", maxCloudInitNICs)
+	}
+	for i, n := range form.Networks {
+		switch n.Mode {
+		case "dhcp":
+			spec.Networks = append(spec.Networks, CloudInitIPConfig{Mode: "dhcp"})
+		case "static":
+			ip, ipNet, err := net.ParseCIDR(n.Address)
+			if err != nil || ip.To4() == nil {
+				return nil, invalid("net%d: static address must be an IPv4 CIDR such as 192.0.2.10/24", i)
+			}
+			if n.Gateway != "" {
+				gw := net.ParseIP(n.Gateway)
+				if gw == nil || gw.To4() == nil || !ipNet.Contains(gw) {
+					return nil, invalid("net%d: gateway %q is not in %s", i, n.Gateway, ipNet)
+				}
+			}
+			spec.Networks = append(spec.Networks, CloudInitIPConfig{Mode: "static", Address: n.Address, Gateway: n.Gateway})
+		default:
+			return nil, invalid("net%d: mode must be dhcp or static", i)
+		}
+	}
+
+	for _, ns := range strings.FieldsFunc(form.Nameservers, func(r rune) bool { return r == ',' || r == ' ' || r == ';' }) {
+		if net.ParseIP(ns) == nil {
+			return nil, invalid("invalid DNS server %q", ns)
+		}
+		spec.Nameservers = append(spec.Nameservers, ns)
+	}
+	if spec.SearchDomain != "" && !searchDomainRegex.MatchString(spec.SearchDomain) {
+		return nil, invalid("invalid search domain %q", spec.SearchDomain)
+	}
+	if spec.UserData != "" {
+		if snippetPrefix == "" {
+			return nil, invalid("custom user-data is not enabled")
+		}
+		if !validSnippetVolid(spec.UserData, snippetPrefix) {
+			return nil, invalid("user-data must be a snippet volume under %s", snippetPrefix)
+		}
+	}
+
+	return spec, nil
+}
+
+// validSnippetVolid reports whether volid is a snippet volume under prefix, without any ".."
+// segment that would climb out of it
+func validSnippetVolid(volid, prefix string) bool {
+	if !snippetVolidRegex.MatchString(volid) || !strings.HasPrefix(volid, prefix) {
+		return false
+	}
+	_, path, _ := strings.Cut(volid, ":")
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." || segment == "." {
+			return false
+		}
+	}
+	return true
+}
+
+// isSSHPublicKey reports whether line looks like an OpenSSH public key
+func isSSHPublicKey(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return false
+	}
+	for _, t := range sshKeyTypes {
+		if strings.HasPrefix(fields[0], t) {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// params returns the Proxmox config parameters for a Cloud-Init drive in the drive slot
+// (e.g. ide0) on storage, or without a drive when slot is empty.
+// Proxmox derives the guest hostname from the VM name, which validateVMCreate checks Hostname against.
+func (c *CloudInitSpec) params(slot, storage string) map[string]string {
+	params := map[string]string{}
+	if slot != "" {
+		params[slot] = storage + ":cloudinit"
+	}
+	if c.User != "" {
+		params["ciuser"] = c.User
+	}
+	if len(c.SSHKeys) > 0 {
+		// Proxmox expects sshkeys to be URL-encoded itself, with %20 rather than + for spaces
+		params["sshkeys"] = strings.ReplaceAll(url.QueryEscape(strings.Join(c.SSHKeys, "\n")), "+", "%20")
+	}
+	for i, n := range c.Networks {
+		value := "ip=dhcp"
+		if n.Mode == "static" {
+			value = "ip=" + n.Address
+			if n.Gateway != "" {
+				value += ",gw=" + n.Gateway
+			}
+		}
+		params["ipconfig"+strconv.Itoa(i)] = value
+	}
+	if len(c.Nameservers) > 0 {
+		params["nameserver"] = strings.Join(c.Nameservers, " ")
+	}
+	if c.SearchDomain != "" {
+		params["searchdomain"] = c.SearchDomain
+	}
+	if c.UserData != "" {
+		params["cicustom"] = "user=" + c.UserData
+	}
+	return params
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestValidateCloudInit(t *testing.T) {
+	const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIExample alice@laptop"
+
+	tests := []struct {
+		name    string
+		form    CloudInitFormData
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "disabled",
+			form: CloudInitFormData{Hostname: "web-01"},
+		},
+		{
+			name: "dhcp with keys and dns",
+			form: CloudInitFormData{
+				Enabled:      true,
+				User:         "debian",
+				SSHKeys:      key + "\r\n\r\n# comment\n",
+				Networks:     []CloudInitIPConfig{{Mode: "dhcp"}},
+				Nameservers:  "192.0.2.53, 198.51.100.53",
+				SearchDomain: "example.org",
+				UserData:     "local:snippets/pvmss/user-data.yaml",
+			},
+			want: map[string]string{
+				"ide0":         "local-lvm:cloudinit",
+				"ciuser":       "debian",
+				"sshkeys":      "ssh-ed25519%20AAAAC3NzaC1lZDI1NTE5AAAAIExample%20alice%40laptop",
+				"ipconfig0":    "ip=dhcp",
+				"nameserver":   "192.0.2.53 198.51.100.53",
+				"searchdomain": "example.org",
+				"cicustom":     "user=local:snippets/pvmss/user-data.yaml",
+			},
+		},
+		{
+			name: "static address",
+			form: CloudInitFormData{
+				Enabled:  true,
+				Networks: []CloudInitIPConfig{{Mode: "static", Address: "192.0.2.10/24", Gateway: "192.0.2.1"}},
+			},
+			want: map[string]string{
+				"ide0":      "local-lvm:cloudinit",
+				"ipconfig0": "ip=192.0.2.10/24,gw=192.0.2.1",
+			},
+		},
+		{
+			name:    "gateway outside subnet",
+			form:    CloudInitFormData{Enabled: true, Networks: []CloudInitIPConfig{{Mode: "static", Address: "192.0.2.10/24", Gateway: "198.51.100.1"}}},
+			wantErr: true,
+		},
+		{
+			name:    "too many NICs",
+			form:    CloudInitFormData{Enabled: true, Networks: []CloudInitIPConfig{{Mode: "dhcp"}, {Mode: "dhcp"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid ssh key",
+			form:    CloudInitFormData{Enabled: true, SSHKeys: "not-a-key"},
+			wantErr: true,
+		},
+		{
+			name:    "user-data outside snippets",
+			form:    CloudInitFormData{Enabled: true, UserData: "local:iso/user-data.yaml"},
+			wantErr: true,
+		},
+		{
+			name:    "user-data outside the snippet prefix",
+			form:    CloudInitFormData{Enabled: true, UserData: "local:snippets/admin/secrets.yaml"},
+			wantErr: true,
+		},
+		{
+			name:    "user-data climbing out of the snippet prefix",
+			form:    CloudInitFormData{Enabled: true, UserData: "local:snippets/pvmss/../admin/secrets.yaml"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := validateCloudInit(tt.form, "local:snippets/pvmss/")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected a validation error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateCloudInit() error = %v", err)
+			}
+			if tt.want == nil {
+				if spec != nil {
+					t.Errorf("Expected no Cloud-Init spec, got %+v", spec)
+				}
+				return
+			}
+
+			got := spec.params("ide0", "local-lvm")
+			if len(got) != len(tt.want) {
+				t.Errorf("params() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("params()[%q] = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCloudInitUserDataDisabled(t *testing.T) {
+	form := CloudInitFormData{Enabled: true, UserData: "local:snippets/user-data.yaml"}
+	if _, err := validateCloudInit(form, ""); err == nil {
+		t.Error("Expected custom user-data to be refused when no snippet location is set")
+	}
+	form.UserData = ""
+	if _, err := validateCloudInit(form, ""); err != nil {
+		t.Errorf("Expected Cloud-Init without user-data to be accepted, got %v", err)
+	}
+}
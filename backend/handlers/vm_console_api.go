@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -12,6 +14,9 @@ func sendVNCJSONResponse(w http.ResponseWriter, statusCode int, success bool, da
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	response := map[string]interface{}{"success": success}
+	if !success {
+		response["request_id"] = responseRequestID(w)
+	}
 	for k, v := range data {
 		response[k] = v
 	}
@@ -34,6 +39,14 @@ func (h *VMHandler) GetVNCTicketHandler(w http.ResponseWriter, r *http.Request,
 
 	// Check Proxmox ticket validity
 	if !IsProxmoxTicketValid(r) {
+		// Single sign-on users have no Proxmox password to get a ticket with
+		if isOIDCSession(r) {
+			log.Info().Msg("Console requested by a single sign-on session")
+			sendVNCJSONResponse(w, http.StatusForbidden, false, map[string]interface{}{
+				"error": "The console is not available when signed in with single sign-on.",
+			})
+			return
+		}
 		log.Warn().Msg("Proxmox ticket expired or invalid")
 		sendVNCJSONResponse(w, http.StatusUnauthorized, false, map[string]interface{}{
 			"error": "Proxmox authentication expired. Please log in again.",
@@ -53,20 +66,41 @@ func (h *VMHandler) GetVNCTicketHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	vmidInt, err := strconv.Atoi(vmid)
+	if err != nil {
+		log.Warn().Str("vmid", vmid).Msg("Invalid VM ID")
+		sendVNCJSONResponse(w, http.StatusBadRequest, false, map[string]interface{}{
+			"error": "Invalid vmid parameter",
+		})
+		return
+	}
+
+	if err := authorizeVMAccess(r, h.stateManager.GetProxmoxClient(), vmidInt); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrVMAccessDenied) {
+			status = http.StatusForbidden
+		}
+		LogVNCConsoleAccess(r, vmid, node, err)
+		sendVNCJSONResponse(w, status, false, map[string]interface{}{
+			"error": LocalizeErrorWithFallback(r, "Error.VMAccessDenied", "You do not have access to this virtual machine."),
+		})
+		return
+	}
+
 	log.Info().Str("vmid", vmid).Str("node", node).Msg("Requesting VNC proxy ticket")
 
 	// Get VNC proxy ticket using stored user credentials
 	ticket, port, err := GetVNCProxyTicket(r, node, vmid)
 	if err != nil {
 		log.Error().Err(err).Str("vmid", vmid).Str("node", node).Msg("Failed to get VNC proxy ticket")
-		LogVNCConsoleAccess(r, vmid, node, false)
+		LogVNCConsoleAccess(r, vmid, node, err)
 		sendVNCJSONResponse(w, http.StatusInternalServerError, false, map[string]interface{}{
 			"error": "Failed to create console session. Please ensure you have permission to access this VM.",
 		})
 		return
 	}
 
-	LogVNCConsoleAccess(r, vmid, node, true)
+	LogVNCConsoleAccess(r, vmid, node, nil)
 
 	log.Info().
 		Str("vmid", vmid).
@@ -11,6 +11,7 @@ import (
 	"pvmss/logger"
 	"pvmss/proxmox"
 	"pvmss/security"
+	"pvmss/state"
 )
 
 // GetVNCProxyTicket creates a VNC proxy ticket for the specified VM using the user's stored Proxmox credentials.
@@ -101,11 +102,12 @@ func getUsernameFromSession(r *http.Request) string {
 	return "anonymous"
 }
 
-// LogVNCConsoleAccess logs VNC console access attempts for auditing
-func LogVNCConsoleAccess(r *http.Request, vmid, node string, success bool) {
+// LogVNCConsoleAccess logs VNC console access attempts and records them in the audit log;
+// err is why the access was refused, nil when the console session was opened
+func LogVNCConsoleAccess(r *http.Request, vmid, node string, err error) {
 	log := logger.Get()
 	event := log.Info()
-	if !success {
+	if err != nil {
 		event = log.Warn()
 	}
 
@@ -113,7 +115,14 @@ func LogVNCConsoleAccess(r *http.Request, vmid, node string, success bool) {
 		Str("username", getUsernameFromSession(r)).
 		Str("vmid", vmid).
 		Str("node", node).
-		Bool("success", success).
+		Bool("success", err == nil).
 		Str("remote_addr", r.RemoteAddr).
 		Msg("VNC console access attempt")
+
+	vmidInt, _ := strconv.Atoi(vmid)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMConsole,
+		VMID:   vmidInt,
+		Params: map[string]string{"node": node},
+	}, err)
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -13,6 +14,8 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog"
+
+	"pvmss/logger"
 )
 
 // forwardWebSocketMessages reads from source and writes to destination
@@ -84,6 +87,21 @@ func (h *VMHandler) VMConsoleWebSocketHandler(w http.ResponseWriter, r *http.Req
 	// r.URL.Query().Get() automatically decodes it once, which is what we want.
 	// Do NOT decode it again - just pass it to buildProxmoxWebSocketURL which will re-encode it properly.
 
+	vmidInt, err := strconv.Atoi(vmid)
+	if err != nil {
+		log.Warn().Str("vmid", vmid).Msg("Invalid VM ID")
+		http.Error(w, "Invalid vmid", http.StatusBadRequest)
+		return
+	}
+	if err := authorizeVMAccess(r, h.stateManager.GetProxmoxClient(), vmidInt); err != nil {
+		if errors.Is(err, ErrVMAccessDenied) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	port, err := strconv.Atoi(portStr)
 	if err != nil || port < 5900 || port > 5999 {
 		log.Warn().Str("port", portStr).Msg("Invalid VNC port number")
@@ -219,6 +237,9 @@ func proxyVNCWebSocket(w http.ResponseWriter, r *http.Request, proxmoxWSURL, pve
 	// This is different from the VNC-level authentication that happens after the WebSocket is established.
 	proxmoxHeaders := http.Header{}
 	proxmoxHeaders.Set("Cookie", fmt.Sprintf("PVEAuthCookie=%s", pveTicket))
+	if id := logger.RequestIDFromContext(r.Context()); id != "" {
+		proxmoxHeaders.Set(logger.RequestIDHeader, id)
+	}
 
 	// Connect to Proxmox WebSocket
 	// Log the URL for debugging (mask sensitive parts)
@@ -243,6 +264,8 @@ func proxyVNCWebSocket(w http.ResponseWriter, r *http.Request, proxmoxWSURL, pve
 	defer func() { _ = proxmoxConn.Close() }()
 
 	log.Info().Msg("Proxmox WebSocket connection established, starting bidirectional proxy")
+	consoleSessions.Inc()
+	defer consoleSessions.Dec()
 
 	// Set connection timeouts
 	_ = clientConn.SetReadDeadline(time.Time{})  // No read deadline
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -12,9 +13,12 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 
+	"pvmss/constants"
 	"pvmss/i18n"
+	"pvmss/logger"
 	"pvmss/proxmox"
 	"pvmss/security"
+	"pvmss/state"
 )
 
 // readMinMax extracts min/max values from a nested map structure
@@ -99,6 +103,11 @@ type VMCreateFormData struct {
 	Pool        string
 	Storage     string
 	Tags        []string
+	CloudInit   CloudInitFormData
+	Template    string // VMID of a template offering; empty for an ISO install
+	LinkedClone bool
+	LeaseDays   string // empty applies the default lease
+	Restore     string // backup volume restored instead of a creation, over VMID when it is set
 }
 
 // Register VMCreateFormData with gob for session serialization
@@ -190,7 +199,7 @@ func (h *VMHandler) CreateVMPage(w http.ResponseWriter, r *http.Request, _ httpr
 
 	if sessionManager := security.GetSession(r); sessionManager != nil {
 		if username, ok := sessionManager.Get(ctx, "username").(string); ok && username != "" {
-			defaultPool = fmt.Sprintf("pvmss_%s", username)
+			defaultPool = userPoolName(username)
 		}
 
 		// Check for validation errors from previous submission
@@ -217,6 +226,12 @@ func (h *VMHandler) CreateVMPage(w http.ResponseWriter, r *http.Request, _ httpr
 				"storage":     savedFormData.Storage,
 				"tags":        savedFormData.Tags,
 			}
+			formData["template"] = savedFormData.Template
+			formData["linked_clone"] = savedFormData.LinkedClone
+			formData["lease_days"] = savedFormData.LeaseDays
+			for k, v := range savedFormData.CloudInit.formValues() {
+				formData[k] = v
+			}
 			sessionManager.Remove(ctx, "vm_create_form_data") // Clear after reading
 		}
 	}
@@ -274,6 +289,8 @@ func (h *VMHandler) CreateVMPage(w http.ResponseWriter, r *http.Request, _ httpr
 	data := map[string]interface{}{
 		"Title":              "Create VM",
 		"ISOs":               settings.ISOs,
+		"Templates":          settings.Templates,
+		"SnippetPrefix":      settings.SnippetPrefix,
 		"Bridges":            settings.VMBRs,
 		"BridgeDetails":      bridgeDetails,
 		"BridgeDescriptions": bridgeDescriptions,
@@ -286,6 +303,7 @@ func (h *VMHandler) CreateVMPage(w http.ResponseWriter, r *http.Request, _ httpr
 		"DefaultPool":        defaultPool,
 		"FormData":           formData,
 		"ValidationError":    validationError,
+		"Lease":              newLeaseFormView(settings),
 	}
 
 	// Get available storages for the selected node
@@ -324,261 +342,410 @@ func (h *VMHandler) CreateVMPage(w http.ResponseWriter, r *http.Request, _ httpr
 	RenderTemplate(w, r, "create_vm", data)
 }
 
-// CreateVMHandler processes POST /api/vm/create to create a VM in Proxmox
-// Validates form data, applies limits from settings, and creates the VM via Proxmox API
-func (h *VMHandler) CreateVMHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	log := CreateHandlerLogger("CreateVMHandler", r)
+// vmCreateError describes why a VM creation request was rejected.
+// Key is an optional i18n key used by the HTML flow instead of Message.
+type vmCreateError struct {
+	Status  int
+	Code    string
+	Key     string
+	Message string
+}
 
-	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
-		return
-	}
+func (e *vmCreateError) Error() string {
+	return e.Message
+}
 
-	// Extract form fields
-	name := r.FormValue("name")
-	description := r.FormValue("description")
-	vmidStr := r.FormValue("vmid")
-	socketsStr := r.FormValue("sockets")
-	coresStr := r.FormValue("cores")
-	memoryMBStr := r.FormValue("memory") // MB
-	diskSizeGBStr := r.FormValue("disk_size")
-	isoPath := r.FormValue("iso") // settings provides full volid or path string
-	bridgeName := r.FormValue("bridge")
-	selectedNode := r.FormValue("node")
-	poolName := r.FormValue("pool")
-	selectedTags := r.Form["tags"]
-	selectedStorage := r.FormValue("storage")
-	tags := ensureMandatoryTag(selectedTags)
+// VMCreateSpec holds the validated parameters of a VM creation request
+type VMCreateSpec struct {
+	Name        string
+	Description string
+	VMID        int
+	Sockets     int
+	Cores       int
+	MemoryMB    int
+	DiskSizeGB  int
+	ISO         string
+	Bridge      string
+	Node        string
+	Pool        string
+	Storage     string
+	Tags        []string
+	CloudInit   *CloudInitSpec
+	Template    *state.VMTemplateOffering // clone source; nil for an ISO install
+	LinkedClone bool
+	LeaseDays   int // 0 when the VM never expires
+}
 
-	// Validate mandatory fields
-	validationErrors := validateRequiredFields(map[string]string{
-		"VM name":        name,
-		"Proxmox node":   selectedNode,
-		"CPU sockets":    socketsStr,
-		"CPU cores":      coresStr,
-		"Memory":         memoryMBStr,
-		"Disk size":      diskSizeGBStr,
-		"Storage":        selectedStorage,
-		"ISO image":      isoPath,
-		"Network bridge": bridgeName,
-	})
+// auditEvent describes the creation of the VM for the audit log
+func (spec *VMCreateSpec) auditEvent(vmid int) state.AuditEvent {
+	params := map[string]string{
+		"name":    spec.Name,
+		"node":    spec.Node,
+		"sockets": strconv.Itoa(spec.Sockets),
+		"cores":   strconv.Itoa(spec.Cores),
+		"memory":  strconv.Itoa(spec.MemoryMB),
+		"disk":    strconv.Itoa(spec.DiskSizeGB),
+	}
+	if spec.Template != nil {
+		params["template"] = strconv.Itoa(spec.Template.VMID)
+	}
+	for k, v := range map[string]string{"iso": spec.ISO, "bridge": spec.Bridge, "storage": spec.Storage, "tags": strings.Join(spec.Tags, ",")} {
+		if v != "" {
+			params[k] = v
+		}
+	}
+	if spec.LeaseDays > 0 {
+		params["lease_days"] = strconv.Itoa(spec.LeaseDays)
+	}
+	return state.AuditEvent{Action: state.AuditActionVMCreate, VMID: vmid, Pool: spec.Pool, Params: params}
+}
 
-	// If validation fails, redirect back to form with errors
-	if len(validationErrors) > 0 {
-		log.Warn().Strs("validation_errors", validationErrors).Msg("VM creation validation failed")
+// requiredVMCreateFields returns the missing mandatory fields of a creation request.
+// The ISO image is only required when the VM is not cloned from a template.
+func requiredVMCreateFields(form VMCreateFormData) []string {
+	fields := map[string]string{
+		"VM name":        form.Name,
+		"Proxmox node":   form.Node,
+		"CPU sockets":    form.Sockets,
+		"CPU cores":      form.Cores,
+		"Memory":         form.Memory,
+		"Disk size":      form.DiskSize,
+		"Storage":        form.Storage,
+		"Network bridge": form.Bridge,
+	}
+	if form.Template == "" {
+		fields["ISO image"] = form.ISO
+	}
+	return validateRequiredFields(fields)
+}
 
-		// Store form data and errors in session for re-display
-		if session := security.GetSession(r); session != nil {
-			ctx := r.Context()
-			session.Put(ctx, "vm_create_errors", strings.Join(validationErrors, "; "))
-			// Preserve form data using concrete struct (gob-serializable)
-			formData := VMCreateFormData{
-				Name:        name,
-				Description: description,
-				VMID:        vmidStr,
-				Sockets:     socketsStr,
-				Cores:       coresStr,
-				Memory:      memoryMBStr,
-				DiskSize:    diskSizeGBStr,
-				ISO:         isoPath,
-				Bridge:      bridgeName,
-				Node:        selectedNode,
-				Pool:        poolName,
-				Storage:     selectedStorage,
-				Tags:        selectedTags,
+// validateVMSizeLimits checks a VM size against the "vm" limits and the optional per-VM caps of its node.
+// The disk check is skipped when diskSizeGB is 0, e.g. when a resize leaves the disk unchanged.
+func validateVMSizeLimits(settings *state.AppSettings, node string, sockets, cores, memoryMB, diskSizeGB int) error {
+	if settings == nil || settings.Limits == nil {
+		return nil
+	}
+	// VM limits
+	if rawVM, ok := settings.Limits["vm"].(map[string]interface{}); ok {
+		if min, max, ok2 := readMinMax(rawVM, "sockets"); ok2 {
+			if sockets < min || sockets > max {
+				return fmt.Errorf("sockets must be between %d and %d", min, max)
+			}
+		}
+		if min, max, ok2 := readMinMax(rawVM, "cores"); ok2 {
+			if cores < min || cores > max {
+				return fmt.Errorf("cores must be between %d and %d", min, max)
+			}
+		}
+		if minGB, maxGB, ok2 := readMinMax(rawVM, "ram"); ok2 {
+			minMB := minGB * 1024
+			maxMB := maxGB * 1024
+			if memoryMB < minMB || memoryMB > maxMB {
+				return fmt.Errorf("memory must be between %d and %d MB", minMB, maxMB)
+			}
+		}
+		if min, max, ok2 := readMinMax(rawVM, "disk"); ok2 && diskSizeGB != 0 {
+			if diskSizeGB < min || diskSizeGB > max {
+				return fmt.Errorf("disk size must be between %d and %d GB", min, max)
 			}
-			session.Put(ctx, "vm_create_form_data", formData)
 		}
-
-		// Redirect back to form
-		http.Redirect(w, r, "/vm/create", http.StatusSeeOther)
-		return
 	}
 
-	client := h.stateManager.GetProxmoxClient()
-	if client == nil {
-		log.Error().Msg("Proxmox client not initialized")
-		localizer := i18n.GetLocalizerFromRequest(r)
-		http.Error(w, i18n.Localize(localizer, "Error.InternalServer"), http.StatusInternalServerError)
-		return
+	// Node-specific caps (optional) - per-VM limits
+	if rawNodes, ok := settings.Limits["nodes"].(map[string]interface{}); ok {
+		if rawNode, ok2 := rawNodes[node].(map[string]interface{}); ok2 {
+			if _, max, ok3 := readMinMax(rawNode, "sockets"); ok3 {
+				// Enforce only upper bound from node limits; VM lower bound is validated earlier
+				if sockets > max {
+					return fmt.Errorf("sockets exceed node '%s' max (%d)", node, max)
+				}
+			}
+			if _, max, ok3 := readMinMax(rawNode, "cores"); ok3 {
+				// Enforce only upper bound from node limits; VM lower bound is validated earlier
+				if cores > max {
+					return fmt.Errorf("cores exceed node '%s' max (%d)", node, max)
+				}
+			}
+			if _, maxGB, ok3 := readMinMax(rawNode, "ram"); ok3 {
+				// Enforce only upper bound from node limits; VM lower bound is validated earlier
+				maxMB := maxGB * 1024
+				if memoryMB > maxMB {
+					return fmt.Errorf("memory exceeds node '%s' max (%d MB)", node, maxMB)
+				}
+			}
+		}
 	}
+	return nil
+}
 
-	ctx := r.Context()
-
+// validateVMCreate resolves the target node, parses numeric fields and checks them against
+// the VM limits, the optional per-node caps and the aggregate node limits from settings.
+func (h *VMHandler) validateVMCreate(ctx context.Context, client proxmox.ClientInterface, form VMCreateFormData) (*VMCreateSpec, error) {
 	// Determine node: use selected if provided, otherwise pick the first available node
 	nodes, err := proxmox.GetNodeNamesWithContext(ctx, client)
 	if err != nil || len(nodes) == 0 {
-		log.Error().Err(err).Msg("unable to get Proxmox nodes")
-		localizer := i18n.GetLocalizerFromRequest(r)
-		http.Error(w, i18n.Localize(localizer, "Proxmox.ConnectionError"), http.StatusBadGateway)
-		return
+		return nil, &vmCreateError{Status: http.StatusBadGateway, Code: "proxmox_unavailable", Key: "Proxmox.ConnectionError", Message: "unable to get Proxmox nodes"}
 	}
 	node := nodes[0]
-	if selectedNode != "" {
+	if form.Node != "" {
 		// ensure selected node exists
 		for _, n := range nodes {
-			if n == selectedNode {
-				node = selectedNode
+			if n == form.Node {
+				node = form.Node
+				break
+			}
+		}
+	}
+
+	badRequest := func(msg string) error {
+		return &vmCreateError{Status: http.StatusBadRequest, Code: "invalid_parameter", Message: msg}
+	}
+
+	// Template clones run on the node that holds the template
+	var tmpl *state.VMTemplateOffering
+	if form.Template != "" {
+		id, err := strconv.Atoi(form.Template)
+		if err != nil {
+			return nil, badRequest("invalid template")
+		}
+		offering := h.stateManager.GetSettings().FindTemplate(id)
+		if offering == nil {
+			return nil, badRequest("template is not offered")
+		}
+		tmplCopy := *offering
+		tmpl = &tmplCopy
+		node = ""
+		for _, n := range nodes {
+			if n == tmpl.Node {
+				node = n
 				break
 			}
 		}
+		if node == "" {
+			return nil, &vmCreateError{Status: http.StatusBadGateway, Code: "proxmox_unavailable", Message: "template node '" + tmpl.Node + "' is not available"}
+		}
 	}
 
 	// Parse numeric fields
-	sockets, err := strconv.Atoi(socketsStr)
+	sockets, err := strconv.Atoi(form.Sockets)
 	if err != nil {
-		http.Error(w, "invalid sockets", http.StatusBadRequest)
-		return
+		return nil, badRequest("invalid sockets")
 	}
-	cores, err := strconv.Atoi(coresStr)
+	cores, err := strconv.Atoi(form.Cores)
 	if err != nil {
-		http.Error(w, "invalid cores", http.StatusBadRequest)
-		return
+		return nil, badRequest("invalid cores")
 	}
-	memoryMB, err := strconv.Atoi(memoryMBStr)
+	memoryMB, err := strconv.Atoi(form.Memory)
 	if err != nil {
-		http.Error(w, "invalid memory", http.StatusBadRequest)
-		return
+		return nil, badRequest("invalid memory")
 	}
-	diskSizeGB, err := strconv.Atoi(diskSizeGBStr)
-	if err != nil {
-		http.Error(w, "invalid disk size", http.StatusBadRequest)
-		return
+	diskSizeGB, err := strconv.Atoi(form.DiskSize)
+	if err != nil || diskSizeGB <= 0 {
+		return nil, badRequest("invalid disk size")
 	}
 
-	// Validate against settings limits (vm and optional node-specific)
-	if settings := h.stateManager.GetSettings(); settings != nil && settings.Limits != nil {
-		// VM limits
-		if rawVM, ok := settings.Limits["vm"].(map[string]interface{}); ok {
-			if min, max, ok2 := readMinMax(rawVM, "sockets"); ok2 {
-				if sockets < min || sockets > max {
-					http.Error(w, fmt.Sprintf("sockets must be between %d and %d", min, max), http.StatusBadRequest)
-					return
-				}
-			}
-			if min, max, ok2 := readMinMax(rawVM, "cores"); ok2 {
-				if cores < min || cores > max {
-					http.Error(w, fmt.Sprintf("cores must be between %d and %d", min, max), http.StatusBadRequest)
-					return
-				}
-			}
-			if minGB, maxGB, ok2 := readMinMax(rawVM, "ram"); ok2 {
-				minMB := minGB * 1024
-				maxMB := maxGB * 1024
-				if memoryMB < minMB || memoryMB > maxMB {
-					http.Error(w, fmt.Sprintf("memory must be between %d and %d MB", minMB, maxMB), http.StatusBadRequest)
-					return
-				}
-			}
-			if min, max, ok2 := readMinMax(rawVM, "disk"); ok2 {
-				if diskSizeGB < min || diskSizeGB > max {
-					http.Error(w, fmt.Sprintf("disk size must be between %d and %d GB", min, max), http.StatusBadRequest)
-					return
-				}
-			}
+	limitExceeded := func(msg string) error {
+		return &vmCreateError{Status: http.StatusBadRequest, Code: "limit_exceeded", Message: msg}
+	}
+
+	// A clone's disk is only ever grown, so it cannot be smaller than the template's own disk
+	if tmpl != nil {
+		minDiskGB, err := templateMinDiskGB(ctx, client, tmpl)
+		if err != nil {
+			logger.Get().Error().Err(err).Int("template", tmpl.VMID).Msg("Failed to read template configuration")
+			return nil, &vmCreateError{Status: http.StatusBadGateway, Code: "proxmox_error", Key: "Proxmox.ConnectionError", Message: "unable to read the template configuration"}
+		}
+		if diskSizeGB < minDiskGB {
+			return nil, limitExceeded(fmt.Sprintf("disk size must be at least %d GB for template '%s'", minDiskGB, tmpl.Name))
 		}
+	}
 
-		// Node-specific caps (optional) - per-VM limits
-		if rawNodes, ok := settings.Limits["nodes"].(map[string]interface{}); ok {
-			if rawNode, ok2 := rawNodes[node].(map[string]interface{}); ok2 {
-				if _, max, ok3 := readMinMax(rawNode, "sockets"); ok3 {
-					// Enforce only upper bound from node limits; VM lower bound is validated earlier
-					if sockets > max {
-						http.Error(w, fmt.Sprintf("sockets exceed node '%s' max (%d)", node, max), http.StatusBadRequest)
-						return
-					}
-				}
-				if _, max, ok3 := readMinMax(rawNode, "cores"); ok3 {
-					// Enforce only upper bound from node limits; VM lower bound is validated earlier
-					if cores > max {
-						http.Error(w, fmt.Sprintf("cores exceed node '%s' max (%d)", node, max), http.StatusBadRequest)
-						return
-					}
-				}
-				if _, maxGB, ok3 := readMinMax(rawNode, "ram"); ok3 {
-					// Enforce only upper bound from node limits; VM lower bound is validated earlier
-					maxMB := maxGB * 1024
-					if memoryMB > maxMB {
-						http.Error(w, fmt.Sprintf("memory exceeds node '%s' max (%d MB)", node, maxMB), http.StatusBadRequest)
-						return
-					}
-				}
-			}
+	// Validate against settings limits (vm and optional node-specific)
+	if settings := h.stateManager.GetSettings(); settings != nil && settings.Limits != nil {
+		if err := validateVMSizeLimits(settings, node, sockets, cores, memoryMB, diskSizeGB); err != nil {
+			return nil, limitExceeded(err.Error())
 		}
 
 		// Validate aggregate node limits (sum of all pvmss VMs)
 		if err := ValidateVMResourcesAgainstNodeLimits(ctx, client, h.stateManager, node, sockets, cores, memoryMB); err != nil {
-			log.Warn().Err(err).Str("node", node).Msg("VM creation would exceed aggregate node limits")
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return nil, limitExceeded(err.Error())
 		}
 	}
 
-	// Ensure VMID
+	snippetPrefix := ""
+	if settings := h.stateManager.GetSettings(); settings != nil {
+		snippetPrefix = settings.SnippetPrefix
+	}
+	cloudInit, err := validateCloudInit(form.CloudInit, snippetPrefix)
+	if err != nil {
+		return nil, err
+	}
+	// Proxmox uses the VM name as the guest hostname, so a different hostname cannot be applied
+	if cloudInit != nil {
+		if cloudInit.Hostname == "" {
+			cloudInit.Hostname = form.Name
+		} else if !strings.EqualFold(cloudInit.Hostname, form.Name) {
+			return nil, &vmCreateError{Status: http.StatusBadRequest, Code: "invalid_parameter",
+				Message: fmt.Sprintf("cloud-init: hostname %q must match the VM name %q", cloudInit.Hostname, form.Name)}
+		}
+	}
+
+	// Clones boot from the template disk; the ISO only applies to empty VMs
+	iso := form.ISO
+	if tmpl != nil {
+		iso = ""
+	}
+
+	leaseDays, err := resolveLeaseDays(settingsLeasePolicy(h.stateManager.GetSettings()), form.Tags, form.LeaseDays)
+	if err != nil {
+		return nil, err
+	}
+
 	vmid := 0
-	if vmidStr != "" {
-		if v, err := strconv.Atoi(vmidStr); err == nil {
+	if form.VMID != "" {
+		if v, err := strconv.Atoi(form.VMID); err == nil {
 			vmid = v
 		}
 	}
+
+	return &VMCreateSpec{
+		Name:        form.Name,
+		Description: form.Description,
+		VMID:        vmid,
+		Sockets:     sockets,
+		Cores:       cores,
+		MemoryMB:    memoryMB,
+		DiskSizeGB:  diskSizeGB,
+		ISO:         iso,
+		Bridge:      form.Bridge,
+		Node:        node,
+		Pool:        form.Pool,
+		Storage:     form.Storage,
+		Tags:        ensureMandatoryTag(form.Tags),
+		CloudInit:   cloudInit,
+		Template:    tmpl,
+		LinkedClone: tmpl != nil && form.LinkedClone,
+		LeaseDays:   leaseDays,
+	}, nil
+}
+
+// provisionVM creates a validated VM in Proxmox, starts it and invalidates the caches
+// so the new VM appears immediately. It returns the VMID that was used.
+func (h *VMHandler) provisionVM(ctx context.Context, client proxmox.ClientInterface, spec *VMCreateSpec) (_ int, err error) {
+	defer func() { recordVMCreation(err) }()
+	log := logger.Get().With().Str("component", "provisionVM").Logger()
+
+	// Ensure VMID
+	vmid := spec.VMID
 	if vmid == 0 {
 		v, err := proxmox.GetNextVMID(ctx, client)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to get next VMID")
-			localizer := i18n.GetLocalizerFromRequest(r)
-			http.Error(w, i18n.Localize(localizer, "Error.InternalServer"), http.StatusInternalServerError)
-			return
+			return 0, &vmCreateError{Status: http.StatusInternalServerError, Code: "internal_error", Key: "Error.InternalServer", Message: "failed to allocate a VMID"}
 		}
 		vmid = v
 	}
+	// The VMID may have been freed by a deletion made outside PVMSS: drop the tasks of its
+	// previous VM so the new owner does not see them
+	proxmox.ForgetVMTasks(vmid)
+
+	node := spec.Node
+	if spec.Template != nil {
+		if err := h.cloneVM(ctx, client, spec, vmid); err != nil {
+			return 0, err
+		}
+	} else if err := h.createEmptyVM(ctx, client, spec, vmid); err != nil {
+		return 0, err
+	}
+
+	// Optional: ensure VM is running. Query current status and start if needed.
+	// Clones are started by cloneVM once the clone task has finished.
+	if spec.Template != nil {
+		log.Debug().Int("vmid", vmid).Msg("Clone in progress; start deferred")
+	} else if cur, err := proxmox.GetVMCurrentWithContext(ctx, client, node, vmid); err != nil {
+		log.Warn().Err(err).Int("vmid", vmid).Str("node", node).Msg("Could not fetch VM current status after creation")
+	} else if strings.ToLower(cur.Status) != "running" {
+		if upid, err := proxmox.VMActionWithContext(ctx, client, node, strconv.Itoa(vmid), "start"); err != nil {
+			log.Warn().Err(err).Int("vmid", vmid).Str("node", node).Msg("Failed to start VM after creation")
+		} else {
+			proxmox.TrackTask(client, upid, vmid, "start", "")
+			log.Info().Int("vmid", vmid).Str("node", node).Msg("VM started after creation")
+		}
+	}
+
+	h.recordVMLease(spec, vmid)
+
+	// Invalidate caches so the new VM appears immediately in profile and search
+	client.InvalidateCache("/nodes/" + url.PathEscape(node) + "/qemu")
+	if spec.Pool != "" {
+		client.InvalidateCache("/pools/" + url.PathEscape(spec.Pool))
+		log.Info().Str("pool", spec.Pool).Msg("Invalidated pool cache after VM creation")
+	}
+
+	return vmid, nil
+}
+
+// createEmptyVM creates a new VM with an empty disk and the selected ISO attached
+func (h *VMHandler) createEmptyVM(ctx context.Context, client proxmox.ClientInterface, spec *VMCreateSpec, vmid int) error {
+	log := logger.Get().With().Str("component", "createEmptyVM").Logger()
 
 	// Build Proxmox create parameters
 	params := map[string]string{
 		"vmid":    strconv.Itoa(vmid),
-		"name":    name,
-		"sockets": strconv.Itoa(sockets),
-		"cores":   strconv.Itoa(cores),
-		"memory":  strconv.Itoa(memoryMB), // MB
+		"name":    spec.Name,
+		"sockets": strconv.Itoa(spec.Sockets),
+		"cores":   strconv.Itoa(spec.Cores),
+		"memory":  strconv.Itoa(spec.MemoryMB), // MB
 	}
 
 	params["agent"] = "enabled=1"
 
 	// Assign to pool if provided
-	if poolName != "" {
-		params["pool"] = poolName
+	if spec.Pool != "" {
+		params["pool"] = spec.Pool
 	}
 
 	// Tags (Proxmox supports 'tags': csv)
-	if len(tags) > 0 {
-		params["tags"] = strings.Join(tags, ",")
+	if len(spec.Tags) > 0 {
+		params["tags"] = strings.Join(spec.Tags, ",")
 	}
-	if description != "" {
-		params["description"] = description
+	if spec.Description != "" {
+		params["description"] = spec.Description
 	}
 
 	// Attach ISO if provided (ide2 with media=cdrom)
-	if isoPath != "" {
+	if spec.ISO != "" {
 		// Expect iso to be a Proxmox volid like 'local:iso/debian.iso'
-		params["ide2"] = isoPath + ",media=cdrom"
+		params["ide2"] = spec.ISO + ",media=cdrom"
 	}
 
 	// Network: virtio on selected bridge
-	if bridgeName != "" {
-		params["net0"] = "virtio,bridge=" + bridgeName
+	if spec.Bridge != "" {
+		params["net0"] = "virtio,bridge=" + spec.Bridge
 	}
 
 	// Disk: use selected storage for VM disk
-	if selectedStorage != "" && diskSizeGBStr != "" {
-		params["scsi0"] = selectedStorage + ":" + strconv.Itoa(diskSizeGB)
+	if spec.Storage != "" && spec.DiskSizeGB > 0 {
+		params["scsi0"] = spec.Storage + ":" + strconv.Itoa(spec.DiskSizeGB)
 		params["scsihw"] = "virtio-scsi-pci"
 		params["bootdisk"] = "scsi0"
-		if isoPath != "" {
+		if spec.ISO != "" {
 			params["boot"] = "order=scsi0;ide2"
 		} else {
 			params["boot"] = "order=scsi0"
 		}
 	}
 
+	// Cloud-Init drive on the selected storage, on ide0 since ide2 holds the installer ISO.
+	// Proxmox uses the VM name as the guest hostname.
+	if spec.CloudInit != nil && spec.Storage != "" {
+		for k, v := range spec.CloudInit.params("ide0", spec.Storage) {
+			params[k] = v
+		}
+	}
+
 	// Perform API call: POST /nodes/{node}/qemu
+	node := spec.Node
 	path := "/nodes/" + url.PathEscape(node) + "/qemu"
 
 	values := make(url.Values)
@@ -586,29 +753,149 @@ func (h *VMHandler) CreateVMHandler(w http.ResponseWriter, r *http.Request, _ ht
 		values.Set(k, v)
 	}
 
-	if _, err := client.PostFormWithContext(ctx, path, values); err != nil {
+	resp, err := client.PostFormWithContext(ctx, path, values)
+	if err != nil {
 		log.Error().Err(err).Str("node", node).Msg("VM create API call failed")
+		return &vmCreateError{Status: http.StatusBadGateway, Code: "proxmox_error", Key: "Proxmox.ConnectionError", Message: "VM creation failed in Proxmox"}
+	}
+	proxmox.InvalidateInventory()
+
+	// Wait for the create task so the VM is no longer locked when it is started
+	if upid := proxmox.UPIDFromResponse(resp); upid != "" {
+		waitCtx, cancel := context.WithTimeout(ctx, constants.VMTaskWaitTimeout)
+		defer cancel()
+		task, err := proxmox.TrackAndWaitTask(waitCtx, client, upid, vmid, "create", "")
+		if err != nil && task.Status == "stopped" {
+			log.Error().Err(err).Str("node", node).Str("upid", upid).Msg("VM create task failed")
+			return &vmCreateError{Status: http.StatusBadGateway, Code: "proxmox_error", Key: "Proxmox.ConnectionError", Message: "VM creation failed in Proxmox"}
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("upid", upid).Msg("VM create task not finished yet; continuing")
+		}
+	}
+	return nil
+}
+
+// writeVMCreateError reports a creation failure on the HTML flow as a plain-text error
+func writeVMCreateError(w http.ResponseWriter, r *http.Request, err error) {
+	var createErr *vmCreateError
+	if !errors.As(err, &createErr) {
 		localizer := i18n.GetLocalizerFromRequest(r)
-		http.Error(w, i18n.Localize(localizer, "Proxmox.ConnectionError"), http.StatusBadGateway)
+		http.Error(w, i18n.Localize(localizer, "Error.InternalServer"), http.StatusInternalServerError)
 		return
 	}
+	message := createErr.Message
+	if createErr.Key != "" {
+		localizer := i18n.GetLocalizerFromRequest(r)
+		message = i18n.Localize(localizer, createErr.Key)
+	}
+	http.Error(w, message, createErr.Status)
+}
 
-	// Optional: ensure VM is running. Query current status and start if needed.
-	if cur, err := proxmox.GetVMCurrentWithContext(ctx, client, node, vmid); err != nil {
-		log.Warn().Err(err).Int("vmid", vmid).Str("node", node).Msg("Could not fetch VM current status after creation")
-	} else if strings.ToLower(cur.Status) != "running" {
-		if _, err := proxmox.VMActionWithContext(ctx, client, node, strconv.Itoa(vmid), "start"); err != nil {
-			log.Warn().Err(err).Int("vmid", vmid).Str("node", node).Msg("Failed to start VM after creation")
-		} else {
-			log.Info().Int("vmid", vmid).Str("node", node).Msg("VM started after creation")
+// CreateVMHandler processes POST /api/vm/create to create a VM in Proxmox
+// Validates form data, applies limits from settings, and creates the VM via Proxmox API
+func (h *VMHandler) CreateVMHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("CreateVMHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	// Extract form fields
+	formData := VMCreateFormData{
+		Name:        r.FormValue("name"),
+		Description: r.FormValue("description"),
+		VMID:        r.FormValue("vmid"),
+		Sockets:     r.FormValue("sockets"),
+		Cores:       r.FormValue("cores"),
+		Memory:      r.FormValue("memory"), // MB
+		DiskSize:    r.FormValue("disk_size"),
+		ISO:         r.FormValue("iso"), // settings provides full volid or path string
+		Bridge:      r.FormValue("bridge"),
+		Node:        r.FormValue("node"),
+		Pool:        r.FormValue("pool"),
+		Storage:     r.FormValue("storage"),
+		Tags:        r.Form["tags"],
+		CloudInit:   parseCloudInitForm(r),
+		Template:    r.FormValue("template"),
+		LinkedClone: r.FormValue("linked_clone") == "1",
+		LeaseDays:   strings.TrimSpace(r.FormValue("lease_days")),
+	}
+
+	// Validate mandatory fields
+	validationErrors := requiredVMCreateFields(formData)
+
+	// If validation fails, redirect back to form with errors
+	if len(validationErrors) > 0 {
+		log.Warn().Strs("validation_errors", validationErrors).Msg("VM creation validation failed")
+
+		// Store form data and errors in session for re-display
+		if session := security.GetSession(r); session != nil {
+			ctx := r.Context()
+			session.Put(ctx, "vm_create_errors", strings.Join(validationErrors, "; "))
+			// Preserve form data using concrete struct (gob-serializable)
+			session.Put(ctx, "vm_create_form_data", formData)
 		}
+
+		// Redirect back to form
+		http.Redirect(w, r, "/vm/create", http.StatusSeeOther)
+		return
 	}
 
-	// Invalidate caches so the new VM appears immediately in profile and search
-	client.InvalidateCache("/nodes/" + url.PathEscape(node) + "/qemu")
-	if poolName != "" {
-		client.InvalidateCache("/pools/" + url.PathEscape(poolName))
-		log.Info().Str("pool", poolName).Msg("Invalidated pool cache after VM creation")
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		log.Error().Msg("Proxmox client not initialized")
+		localizer := i18n.GetLocalizerFromRequest(r)
+		http.Error(w, i18n.Localize(localizer, "Error.InternalServer"), http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Regular users always create into their own pool, which their quota is computed from
+	handlerCtx := NewHandlerContext(w, r, "CreateVMHandler")
+	username, isAdmin := handlerCtx.GetUsername(), handlerCtx.IsAdmin()
+	if !isAdmin {
+		formData.Pool = userPoolName(username)
+	}
+
+	// The quota is held until the VM is created and the inventory invalidated, so two creations
+	// of the same user cannot both fit in what is left of it
+	if !isAdmin {
+		unlock := lockUserLimits(username)
+		defer unlock()
+	}
+
+	spec, err := h.validateVMCreate(ctx, client, formData)
+	if err == nil && !isAdmin {
+		err = h.validateCreateQuota(ctx, client, username, spec)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("node", formData.Node).Msg("VM creation rejected")
+		writeVMCreateError(w, r, err)
+		return
+	}
+
+	// VMs larger than the auto-approve envelope wait for an administrator
+	if !isAdmin {
+		if exceeds := autoApproveLimits(h.stateManager.GetSettings()).exceededBy(spec); exceeds != "" {
+			req, err := h.submitVMRequest(r, username, formData, exceeds)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to store VM request")
+				writeVMCreateError(w, r, err)
+				return
+			}
+			log.Info().Str("vm_request_id", req.ID).Str("exceeds", exceeds).Msg("VM creation submitted for approval")
+			http.Redirect(w, r, "/profile?success_msg="+url.QueryEscape(handlerCtx.Translate("Profile.Requests.Submitted")), http.StatusSeeOther)
+			return
+		}
+	}
+
+	vmid, err := h.provisionVM(ctx, client, spec)
+	recordAudit(r, spec.auditEvent(vmid), err)
+	if err != nil {
+		writeVMCreateError(w, r, err)
+		return
 	}
 
 	// Redirect to details
@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/julienschmidt/httprouter"
 
+	"pvmss/constants"
 	"pvmss/i18n"
+	"pvmss/logger"
 	"pvmss/proxmox"
 	"pvmss/security"
+	"pvmss/state"
 )
 
 // findVMByID finds a VM in a list by its ID
@@ -22,6 +25,48 @@ func findVMByID(vms []proxmox.VM, vmid int) *proxmox.VM {
 	return nil
 }
 
+// destroyVM force-stops a VM (ignoring errors if it is already stopped) and then deletes it,
+// waiting for each Proxmox task to finish so the VM is really gone when it returns.
+// It returns the UPID of the delete task, if one was started.
+func destroyVM(ctx context.Context, client proxmox.ClientInterface, node string, vmid int, username string) (string, error) {
+	log := logger.Get().With().Str("component", "destroyVM").Int("vmid", vmid).Str("node", node).Logger()
+
+	ctx, cancel := context.WithTimeout(ctx, constants.VMTaskWaitTimeout)
+	defer cancel()
+
+	// Step 1: Force stop the VM (ignore errors if already stopped)
+	log.Info().Msg("forcing VM stop")
+	upid, stopErr := proxmox.VMActionWithContext(ctx, client, node, strconv.Itoa(vmid), "stop")
+	if stopErr != nil {
+		log.Warn().Err(stopErr).Msg("VM stop failed (may already be stopped)")
+	} else if _, err := proxmox.TrackAndWaitTask(ctx, client, upid, vmid, "stop", username); err != nil {
+		// A failed stop makes the deletion fail with a clearer error, so keep going
+		log.Warn().Err(err).Str("upid", upid).Msg("VM stop task did not complete")
+	} else {
+		log.Info().Str("upid", upid).Msg("VM stopped successfully")
+	}
+
+	// Step 2: Delete the VM
+	log.Info().Msg("deleting VM")
+	upid, err := proxmox.DeleteVMWithContext(ctx, client, node, vmid)
+	if err != nil {
+		log.Error().Err(err).Msg("VM deletion failed")
+		return "", err
+	}
+	if upid == "" {
+		proxmox.ForgetVMTasks(vmid)
+		return "", nil
+	}
+	if _, err := proxmox.TrackAndWaitTask(ctx, client, upid, vmid, "delete", username); err != nil {
+		log.Error().Err(err).Str("upid", upid).Msg("VM deletion task failed")
+		return upid, err
+	}
+	proxmox.InvalidateInventory()
+	proxmox.ForgetVMTasks(vmid)
+	log.Info().Str("upid", upid).Msg("VM deleted successfully")
+	return upid, nil
+}
+
 // VMDeleteConfirmHandler shows a confirmation page before deleting a VM
 func (h *VMHandler) VMDeleteConfirmHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	log := CreateHandlerLogger("VMDeleteConfirmHandler", r)
@@ -52,6 +97,10 @@ func (h *VMHandler) VMDeleteConfirmHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !requireVMOwnership(w, r, client, vmidInt) {
+		return
+	}
+
 	// Get all VMs and find the one we want
 	vms, err := proxmox.GetVMsWithContext(r.Context(), client)
 	if err != nil {
@@ -124,23 +173,20 @@ func (h *VMHandler) VMDeleteHandler(w http.ResponseWriter, r *http.Request, _ ht
 		return
 	}
 
-	log.Info().Int("vmid", vmidInt).Str("node", node).Msg("starting VM deletion process")
-
-	// Step 1: Force stop the VM (ignore errors if already stopped)
-	log.Info().Int("vmid", vmidInt).Str("node", node).Msg("forcing VM stop")
-	_, stopErr := proxmox.VMActionWithContext(r.Context(), client, node, vmid, "stop")
-	if stopErr != nil {
-		log.Warn().Err(stopErr).Int("vmid", vmidInt).Msg("VM stop failed (may already be stopped)")
-	} else {
-		log.Info().Int("vmid", vmidInt).Msg("VM stopped successfully")
-		// Wait a moment for the stop to complete
-		time.Sleep(2 * time.Second)
+	if !requireVMOwnership(w, r, client, vmidInt) {
+		return
 	}
 
-	// Step 2: Delete the VM
-	log.Info().Int("vmid", vmidInt).Str("node", node).Msg("deleting VM")
-	if err := proxmox.DeleteVMWithContext(r.Context(), client, node, vmidInt); err != nil {
-		log.Error().Err(err).Int("vmid", vmidInt).Msg("VM deletion failed")
+	log.Info().Int("vmid", vmidInt).Str("node", node).Msg("starting VM deletion process")
+
+	upid, err := destroyVM(r.Context(), client, node, vmidInt, NewHandlerContext(w, r, "VMDeleteHandler").GetUsername())
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMDelete,
+		VMID:   vmidInt,
+		Params: map[string]string{"node": node},
+		UPID:   upid,
+	}, err)
+	if err != nil {
 		ctx := NewHandlerContext(w, r, "VMDeleteHandler")
 		ctx.RedirectWithError("/vm/details/"+vmid, "VMDelete.Error")
 		return
@@ -151,7 +197,7 @@ func (h *VMHandler) VMDeleteHandler(w http.ResponseWriter, r *http.Request, _ ht
 	// Invalidate pool cache to ensure profile page shows updated VM list
 	if sessionManager := security.GetSession(r); sessionManager != nil {
 		if username, ok := sessionManager.Get(r.Context(), "username").(string); ok && username != "" {
-			poolName := "pvmss_" + username
+			poolName := userPoolName(username)
 			client.InvalidateCache("/pools/" + poolName)
 			log.Info().Str("pool", poolName).Msg("Invalidated pool cache after VM deletion")
 		}
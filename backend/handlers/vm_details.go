@@ -128,6 +128,10 @@ type VMStateManager interface {
 	GetProxmoxClient() proxmox.ClientInterface
 	GetSettings() *state.AppSettings
 	GetProxmoxStatus() (bool, string)
+	GetVMRequestStore() *state.VMRequestStore
+	GetVMLeaseStore() *state.VMLeaseStore
+	GetVMScheduleStore() *state.VMScheduleStore
+	GetAuditLogStore() *state.AuditLogStore
 }
 
 // VMHandler handles VM-related pages and API endpoints
@@ -145,37 +149,77 @@ func NewVMHandler(stateManager VMStateManager) *VMHandler {
 // RegisterRoutes registers VM-related routes
 func (h *VMHandler) RegisterRoutes(router *httprouter.Router) {
 	// VM creation routes
-	router.GET("/vm/create", HandlerFuncToHTTPrHandle(RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+	router.GET("/vm/create", restrictAdminRole(PermissionManage, HandlerFuncToHTTPrHandle(RequireAuth(func(w http.ResponseWriter, r *http.Request) {
 		h.CreateVMPage(w, r, httprouter.ParamsFromContext(r.Context()))
-	})))
+	}))))
 
 	// VM creation with CSRF protection
 	router.POST("/api/vm/create", SecureFormHandler("CreateVM",
-		HandlerFuncToHTTPrHandle(RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		restrictAdminRole(PermissionManage, HandlerFuncToHTTPrHandle(RequireAuth(func(w http.ResponseWriter, r *http.Request) {
 			h.CreateVMHandler(w, r, httprouter.ParamsFromContext(r.Context()))
-		})),
+		}))),
 	))
 
-	// VM details and actions routes
+	// VM details and actions routes; administrators need a role granting each action
 	router.GET("/vm/details/:vmid", RequireAuthHandle(h.VMDetailsHandler))
 
 	router.POST("/vm/update/description", SecureFormHandler("UpdateVMDescription",
-		RequireAuthHandle(h.UpdateVMDescriptionHandler),
+		RequireAuthHandle(restrictAdminRole(PermissionManage, h.UpdateVMDescriptionHandler)),
 	))
 	router.POST("/vm/update/tags", SecureFormHandler("UpdateVMTags",
-		RequireAuthHandle(h.UpdateVMTagsHandler),
+		RequireAuthHandle(restrictAdminRole(PermissionManage, h.UpdateVMTagsHandler)),
 	))
 	router.POST("/vm/action", SecureFormHandler("VMAction",
-		RequireAuthHandle(h.VMActionHandler),
+		RequireAuthHandle(restrictAdminRole(PermissionOperate, h.VMActionHandler)),
+	))
+	router.GET("/api/vm/tasks/:vmid", RequireAuthHandle(h.VMTasksHandler))
+
+	// VM snapshot routes
+	router.POST("/vm/snapshot/create", SecureFormHandler("CreateVMSnapshot",
+		RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMSnapshotCreateHandler)),
+	))
+	router.GET("/vm/snapshot/rollback/:vmid/:snapname", RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMSnapshotRollbackConfirmHandler)))
+	router.POST("/vm/snapshot/rollback", SecureFormHandler("RollbackVMSnapshot",
+		RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMSnapshotRollbackHandler)),
+	))
+	router.POST("/vm/snapshot/delete", SecureFormHandler("DeleteVMSnapshot",
+		RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMSnapshotDeleteHandler)),
+	))
+
+	// VM backup routes
+	router.POST("/vm/backup/create", SecureFormHandler("CreateVMBackup",
+		RequireAuthHandle(restrictAdminRole(PermissionOperate, h.VMBackupCreateHandler)),
+	))
+	router.GET("/vm/backup/restore", RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMBackupRestoreConfirmHandler)))
+	router.POST("/vm/backup/restore", SecureFormHandler("RestoreVMBackup",
+		RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMBackupRestoreHandler)),
+	))
+	router.POST("/vm/backup/delete", SecureFormHandler("DeleteVMBackup",
+		RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMBackupDeleteHandler)),
+	))
+
+	router.POST("/vm/resize", SecureFormHandler("ResizeVM",
+		RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMResizeHandler)),
+	))
+	router.POST("/vm/lease/extend", SecureFormHandler("ExtendVMLease",
+		RequireAuthHandle(restrictAdminRole(PermissionOperate, h.VMLeaseExtendHandler)),
+	))
+
+	// VM power schedule routes
+	router.POST("/vm/schedule/create", SecureFormHandler("CreateVMSchedule",
+		RequireAuthHandle(restrictAdminRole(PermissionOperate, h.VMScheduleCreateHandler)),
+	))
+	router.POST("/vm/schedule/delete", SecureFormHandler("DeleteVMSchedule",
+		RequireAuthHandle(restrictAdminRole(PermissionOperate, h.VMScheduleDeleteHandler)),
 	))
 
 	// VM deletion routes
-	router.GET("/vm/delete/:vmid", RequireAuthHandle(h.VMDeleteConfirmHandler))
-	router.POST("/vm/delete", RequireAuthHandle(h.VMDeleteHandler))
+	router.GET("/vm/delete/:vmid", RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMDeleteConfirmHandler)))
+	router.POST("/vm/delete", RequireAuthHandle(restrictAdminRole(PermissionManage, h.VMDeleteHandler)))
 
 	// VM console routes
-	router.POST("/api/vm/vnc-ticket", RequireAuthHandle(h.GetVNCTicketHandler))
-	router.GET("/vm/console/websocket", RequireAuthHandle(h.VMConsoleWebSocketHandler))
+	router.POST("/api/vm/vnc-ticket", RequireAuthHandle(restrictAdminRole(PermissionOperate, h.GetVNCTicketHandler)))
+	router.GET("/vm/console/websocket", RequireAuthHandle(restrictAdminRole(PermissionOperate, h.VMConsoleWebSocketHandler)))
 }
 
 // VMDetailsHandler displays detailed information about a specific VM
@@ -208,6 +252,10 @@ func (h *VMHandler) VMDetailsHandler(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
+	if !requireVMOwnership(w, r, client, vmidInt) {
+		return
+	}
+
 	// If 'refresh=1' is present, proactively invalidate caches for nodes and VM lists
 	// to avoid race conditions right after VM creation where cached lists don't include the new VM yet.
 	if r.URL.Query().Get("refresh") == "1" {
@@ -344,6 +392,7 @@ func (h *VMHandler) VMDetailsHandler(w http.ResponseWriter, r *http.Request, ps
 	// Check for edit modes
 	showDescriptionEditor := r.URL.Query().Get("edit") == "description"
 	showTagsEditor := r.URL.Query().Get("edit") == "tags"
+	showResizeEditor := r.URL.Query().Get("edit") == "resize"
 
 	// Get available tags from settings
 	settings := stateManager.GetSettings()
@@ -361,6 +410,46 @@ func (h *VMHandler) VMDetailsHandler(w http.ResponseWriter, r *http.Request, ps
 		descriptionHTML = string(markdown.ToHTML([]byte(description), nil, nil))
 	}
 
+	// Snapshots and the user's remaining snapshot quota
+	snapshots, err := listSnapshotViews(r.Context(), client, vm.Node, vm.VMID)
+	if err != nil {
+		log.Warn().Err(err).Int("vmid", vm.VMID).Msg("Unable to list VM snapshots")
+	}
+	snapshotMax, snapshotLimited := perUserLimit(settings, "snapshots")
+	snapshotLimited = snapshotLimited && !handlerCtx.IsAdmin()
+	snapshotCount := 0
+	if snapshotLimited {
+		if snapshotCount, err = countUserSnapshots(r.Context(), client, handlerCtx.GetUsername()); err != nil {
+			log.Warn().Err(err).Msg("Unable to count user snapshots")
+		}
+	}
+
+	// Backups of this VM visible to the user
+	var backups []BackupView
+	if settings.BackupStorage != "" {
+		if list, err := listUserBackups(r.Context(), client, settings, handlerCtx.GetUsername(), handlerCtx.IsAdmin(), vm.VMID); err == nil {
+			backups = toBackupViews(list)
+		} else {
+			log.Warn().Err(err).Int("vmid", vm.VMID).Msg("Unable to list VM backups")
+		}
+	}
+
+	// Current size, limits and CPU/memory changes waiting for a restart
+	var resize *ResizeView
+	if cfgErr == nil && cfg != nil {
+		var pending []proxmox.PendingChange
+		if vm.Status == "running" {
+			if pending, err = proxmox.GetVMPendingWithContext(r.Context(), client, vm.Node, vm.VMID); err != nil {
+				log.Warn().Err(err).Int("vmid", vm.VMID).Msg("Unable to read VM pending changes")
+			}
+		}
+		view := newResizeView(settings, vm.Node, cfg, pending)
+		resize = &view
+	}
+
+	// Power schedules and their recent runs
+	schedules, scheduleRuns := vmScheduleViews(stateManager, vm.VMID)
+
 	// Build custom data for template
 	custom := map[string]interface{}{
 		"VM":                    vm,
@@ -372,12 +461,27 @@ func (h *VMHandler) VMDetailsHandler(w http.ResponseWriter, r *http.Request, ps
 		"CSRFToken":             csrfToken,
 		"ShowDescriptionEditor": showDescriptionEditor,
 		"ShowTagsEditor":        showTagsEditor,
+		"ShowResizeEditor":      showResizeEditor && resize != nil,
+		"Resize":                resize,
 		"CurrentTags":           tags,
 		"AllTags":               allTags,
 		"FormattedMaxMem":       FormatBytes(vm.MaxMem),
 		"FormattedMaxDisk":      FormatBytes(vm.MaxDisk),
 		"FormattedMem":          FormatBytes(vm.Mem),
 		"FormattedUptime":       FormatUptime(vm.Uptime, r),
+		"Snapshots":             snapshots,
+		"SnapshotLimited":       snapshotLimited,
+		"SnapshotLimit":         snapshotMax,
+		"SnapshotCount":         snapshotCount,
+		"CanSnapshot":           !snapshotLimited || snapshotCount < snapshotMax,
+		"BackupsEnabled":        settings.BackupStorage != "",
+		"Backups":               backups,
+		"RecentTasks":           recentTaskViews(vm.VMID),
+		"Lease":                 vmLeaseView(stateManager, vm.VMID, handlerCtx.IsAdmin()),
+		"Schedules":             schedules,
+		"ScheduleRuns":          scheduleRuns,
+		"ScheduleWeekdays":      []int{1, 2, 3, 4, 5, 6, 0},
+		"ScheduleTimezone":      defaultScheduleTimezone(),
 	}
 
 	// Render using standardized user page helper to include Success/Warning/Error messages
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/state"
+)
+
+// leaseDay is the unit of every lease duration
+const leaseDay = 24 * time.Hour
+
+// leaseTimeFormat is how lease dates are displayed
+const leaseTimeFormat = "2006-01-02 15:04"
+
+// settingsLeasePolicy returns the configured lease policy; without one, VMs never expire
+func settingsLeasePolicy(settings *state.AppSettings) state.LeasePolicy {
+	if settings == nil || settings.Leases == nil {
+		return state.LeasePolicy{}
+	}
+	return *settings.Leases
+}
+
+// leaseDaysForTags returns the default lease of a VM with the given tags: the shortest
+// matching tag duration, or the global default when no tag has one
+func leaseDaysForTags(policy state.LeasePolicy, tags []string) int {
+	days := 0
+	for _, tag := range tags {
+		if d := policy.TagDays[tag]; d > 0 && (days == 0 || d < days) {
+			days = d
+		}
+	}
+	if days == 0 {
+		days = policy.DefaultDays
+	}
+	return days
+}
+
+// resolveLeaseDays validates the lease picked at creation. An empty value applies the
+// default lease of the tags; a chosen lease cannot exceed the policy's MaxDays.
+func resolveLeaseDays(policy state.LeasePolicy, tags []string, raw string) (int, error) {
+	if raw == "" {
+		return leaseDaysForTags(policy, ensureMandatoryTag(tags)), nil
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 1 {
+		return 0, &vmCreateError{Status: http.StatusBadRequest, Code: "invalid_parameter", Message: "invalid lease duration"}
+	}
+	if policy.MaxDays > 0 && days > policy.MaxDays {
+		return 0, &vmCreateError{Status: http.StatusBadRequest, Code: "limit_exceeded", Message: fmt.Sprintf("lease cannot exceed %d days", policy.MaxDays)}
+	}
+	return days, nil
+}
+
+// recordVMLease stores the lease of a newly provisioned VM. A VM without a lease drops any
+// record left by a deleted VM with the same VMID so the reaper never touches it.
+func (h *VMHandler) recordVMLease(spec *VMCreateSpec, vmid int) {
+	store := h.stateManager.GetVMLeaseStore()
+	if store == nil {
+		return
+	}
+	log := CreateHandlerLogger("recordVMLease", nil)
+
+	if spec.LeaseDays <= 0 {
+		if err := store.Remove(vmid); err != nil {
+			log.Warn().Err(err).Int("vmid", vmid).Msg("Failed to clear a stale VM lease")
+		}
+		return
+	}
+
+	name := spec.Name
+	if spec.CloudInit != nil && spec.CloudInit.Hostname != "" {
+		name = spec.CloudInit.Hostname
+	}
+	lease := state.VMLease{
+		VMID:      vmid,
+		Name:      name,
+		Username:  deriveUserFromPool(spec.Pool),
+		ExpiresAt: time.Now().UTC().Add(time.Duration(spec.LeaseDays) * leaseDay),
+	}
+	if err := store.Set(lease); err != nil {
+		log.Error().Err(err).Int("vmid", vmid).Msg("Failed to store VM lease")
+	}
+}
+
+// LeaseFormView describes the lease field of the create form
+type LeaseFormView struct {
+	Enabled     bool
+	DefaultDays int
+	MaxDays     int
+}
+
+// newLeaseFormView reads the lease policy for the create form
+func newLeaseFormView(settings *state.AppSettings) LeaseFormView {
+	if settings == nil || settings.Leases == nil {
+		return LeaseFormView{}
+	}
+	policy := *settings.Leases
+	return LeaseFormView{Enabled: true, DefaultDays: policy.DefaultDays, MaxDays: policy.MaxDays}
+}
+
+// LeaseView is the lease of a VM as displayed on the details and profile pages
+type LeaseView struct {
+	ExpiresAt      string
+	Expired        bool
+	Stopped        bool   // stopped by the reaper, deleted after the grace period
+	DeleteAt       string // when the stopped VM will be deleted
+	Extensions     int
+	MaxExtensions  int // -1 when extensions are unlimited
+	ExtensionDays  int
+	CanExtend      bool
+	ExtensionsLeft int
+}
+
+// newLeaseView formats a lease; administrators can extend without limit
+func newLeaseView(policy state.LeasePolicy, lease state.VMLease, isAdmin bool, now time.Time) *LeaseView {
+	view := &LeaseView{
+		ExpiresAt:     lease.ExpiresAt.Local().Format(leaseTimeFormat),
+		Expired:       lease.Expired(now),
+		Stopped:       lease.StoppedAt != nil,
+		Extensions:    lease.Extensions,
+		MaxExtensions: policy.MaxExtensions,
+		ExtensionDays: policy.ExtensionDays,
+	}
+	if lease.StoppedAt != nil {
+		view.DeleteAt = lease.StoppedAt.Add(time.Duration(policy.GraceDays) * leaseDay).Local().Format(leaseTimeFormat)
+	}
+	if isAdmin {
+		view.MaxExtensions = -1
+	}
+	if view.MaxExtensions >= 0 {
+		view.ExtensionsLeft = view.MaxExtensions - lease.Extensions
+		if view.ExtensionsLeft < 0 {
+			view.ExtensionsLeft = 0
+		}
+	}
+	view.CanExtend = policy.ExtensionDays > 0 && (view.MaxExtensions < 0 || view.ExtensionsLeft > 0)
+	return view
+}
+
+// vmLeaseView returns the lease of a VM for display, or nil when it never expires
+func vmLeaseView(sm VMStateManager, vmid int, isAdmin bool) *LeaseView {
+	store := sm.GetVMLeaseStore()
+	if store == nil {
+		return nil
+	}
+	lease, ok := store.Get(vmid)
+	if !ok {
+		return nil
+	}
+	return newLeaseView(settingsLeasePolicy(sm.GetSettings()), lease, isAdmin, time.Now())
+}
+
+// VMLeaseExtendHandler pushes back the expiry of a VM by the policy's extension duration
+func (h *VMHandler) VMLeaseExtendHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "VMLeaseExtendHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(r.FormValue("vmid"))
+	if err != nil || vmid <= 0 {
+		ctx.HandleError(err, "Invalid VM ID", http.StatusBadRequest)
+		return
+	}
+	details := buildVMDetailsURL(strconv.Itoa(vmid))
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.HandleError(nil, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	policy := settingsLeasePolicy(h.stateManager.GetSettings())
+	if policy.ExtensionDays <= 0 {
+		ctx.RedirectWithError(details, "VMDetails.Lease.ExtendDisabled")
+		return
+	}
+	maxExtensions := policy.MaxExtensions
+	if ctx.IsAdmin() {
+		maxExtensions = -1
+	}
+
+	lease, err := h.stateManager.GetVMLeaseStore().Extend(vmid, time.Duration(policy.ExtensionDays)*leaseDay, maxExtensions, time.Now())
+	params := map[string]string{"days": strconv.Itoa(policy.ExtensionDays)}
+	if lease != nil {
+		params["expires_at"] = lease.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionVMLeaseExtend, VMID: vmid, Params: params}, err)
+	switch {
+	case errors.Is(err, state.ErrVMLeaseNotFound):
+		ctx.RedirectWithError(details, "VMDetails.Lease.None")
+		return
+	case errors.Is(err, state.ErrVMLeaseExtensionLimit):
+		ctx.RedirectWithError(details, "VMDetails.Lease.LimitReached")
+		return
+	case err != nil:
+		ctx.Log.Error().Err(err).Int("vmid", vmid).Msg("VM lease extension failed")
+		ctx.RedirectWithError(details, "Message.ActionFailed")
+		return
+	}
+
+	ctx.RedirectWithSuccess(details, "VMDetails.Lease.Extended")
+}
+
+// LeaseTagView is the lease duration of a tag on the admin leases page; blank uses the default
+type LeaseTagView struct {
+	Tag  string
+	Days string
+}
+
+// LeaseRowView is an active lease on the admin leases page
+type LeaseRowView struct {
+	VMID       int
+	Name       string
+	Username   string
+	ExpiresAt  string
+	Extensions int
+	Expired    bool
+	Stopped    bool
+	DeleteAt   string
+}
+
+// LeaseEventView is a reaper action on the admin leases page
+type LeaseEventView struct {
+	Time     string
+	VMID     int
+	Name     string
+	Username string
+	Action   string
+	DryRun   bool
+	Error    string
+}
+
+// maxDisplayedLeaseEvents bounds the activity shown on the admin leases page
+const maxDisplayedLeaseEvents = 100
+
+// LeasesPageHandler renders the lease policy, the active leases and the reaper activity
+func (h *SettingsHandler) LeasesPageHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("LeasesPageHandler", r)
+
+	successMsg := ""
+	switch r.URL.Query().Get("success") {
+	case "policy":
+		successMsg = "Lease policy updated"
+	case "release":
+		successMsg = "Lease removed from VM " + r.URL.Query().Get("vmid")
+	}
+	data := AdminPageDataWithMessage("VM Leases", "leases", successMsg, r.URL.Query().Get("error"))
+
+	settings := h.stateManager.GetSettings()
+	policy := settingsLeasePolicy(settings)
+	data["Policy"] = policy
+
+	tags := make([]LeaseTagView, 0)
+	if settings != nil {
+		for _, tag := range settings.Tags {
+			view := LeaseTagView{Tag: tag}
+			if d := policy.TagDays[tag]; d > 0 {
+				view.Days = strconv.Itoa(d)
+			}
+			tags = append(tags, view)
+		}
+	}
+	data["LeaseTags"] = tags
+
+	now := time.Now()
+	store := h.stateManager.GetVMLeaseStore()
+	leases := make([]LeaseRowView, 0)
+	for _, lease := range store.List() {
+		view := newLeaseView(policy, lease, true, now)
+		leases = append(leases, LeaseRowView{
+			VMID:       lease.VMID,
+			Name:       lease.Name,
+			Username:   lease.Username,
+			ExpiresAt:  view.ExpiresAt,
+			Extensions: lease.Extensions,
+			Expired:    view.Expired,
+			Stopped:    view.Stopped,
+			DeleteAt:   view.DeleteAt,
+		})
+	}
+	data["Leases"] = leases
+
+	events := make([]LeaseEventView, 0)
+	for i, event := range store.Events() {
+		if i == maxDisplayedLeaseEvents {
+			break
+		}
+		events = append(events, LeaseEventView{
+			Time:     event.Time.Local().Format(leaseTimeFormat),
+			VMID:     event.VMID,
+			Name:     event.Name,
+			Username: event.Username,
+			Action:   event.Action,
+			DryRun:   event.DryRun,
+			Error:    event.Error,
+		})
+	}
+	data["LeaseEvents"] = events
+
+	log.Debug().Int("leases", len(leases)).Int("events", len(events)).Msg("Leases page rendered")
+	renderTemplateInternal(w, r, "admin_leases", data)
+}
+
+// parseLeasePolicyForm reads the lease policy form; blank durations are 0
+func parseLeasePolicyForm(r *http.Request, tags []string) (*state.LeasePolicy, error) {
+	field := func(name string) (int, error) {
+		raw := strings.TrimSpace(r.FormValue(name))
+		if raw == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s value", strings.ReplaceAll(name, "_", " "))
+		}
+		return n, nil
+	}
+
+	policy := &state.LeasePolicy{DryRun: r.FormValue("dry_run") == "1"}
+	for name, target := range map[string]*int{
+		"default_days":   &policy.DefaultDays,
+		"max_days":       &policy.MaxDays,
+		"extension_days": &policy.ExtensionDays,
+		"max_extensions": &policy.MaxExtensions,
+		"grace_days":     &policy.GraceDays,
+	} {
+		n, err := field(name)
+		if err != nil {
+			return nil, err
+		}
+		*target = n
+	}
+
+	for _, tag := range tags {
+		n, err := field("tag_" + tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease for tag '%s'", tag)
+		}
+		if n > 0 {
+			if policy.TagDays == nil {
+				policy.TagDays = make(map[string]int)
+			}
+			policy.TagDays[tag] = n
+		}
+	}
+	return policy, nil
+}
+
+// UpdateLeasePolicyHandler saves the lease policy
+func (h *SettingsHandler) UpdateLeasePolicyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("UpdateLeasePolicyHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	settings := h.stateManager.GetSettings()
+	if settings == nil {
+		http.Error(w, "Settings not available", http.StatusInternalServerError)
+		return
+	}
+
+	policy, err := parseLeasePolicyForm(r, settings.Tags)
+	if err != nil {
+		http.Redirect(w, r, "/admin/leases?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	settings.Leases = policy
+
+	err = h.stateManager.SetSettings(settings)
+	auditSettings(r, "leases", map[string]string{"default_days": strconv.Itoa(policy.DefaultDays), "max_days": strconv.Itoa(policy.MaxDays), "grace_days": strconv.Itoa(policy.GraceDays), "dry_run": strconv.FormatBool(policy.DryRun)}, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save settings")
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Int("default_days", policy.DefaultDays).Int("grace_days", policy.GraceDays).Bool("dry_run", policy.DryRun).Msg("Lease policy updated")
+	http.Redirect(w, r, "/admin/leases?success=policy", http.StatusSeeOther)
+}
+
+// ReleaseLeaseHandler removes the lease of a VM so it never expires
+func (h *SettingsHandler) ReleaseLeaseHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("ReleaseLeaseHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(strings.TrimSpace(r.FormValue("vmid")))
+	if err != nil {
+		http.Error(w, "Invalid vmid parameter", http.StatusBadRequest)
+		return
+	}
+	err = h.stateManager.GetVMLeaseStore().Remove(vmid)
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionVMLeaseRelease, VMID: vmid}, err)
+	if err != nil {
+		log.Error().Err(err).Int("vmid", vmid).Msg("Failed to remove VM lease")
+		http.Redirect(w, r, "/admin/leases?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	log.Info().Int("vmid", vmid).Msg("VM lease removed")
+	http.Redirect(w, r, "/admin/leases?success=release&vmid="+strconv.Itoa(vmid), http.StatusSeeOther)
+}
+
+// RegisterLeaseRoutes registers the admin lease routes
+func (h *SettingsHandler) RegisterLeaseRoutes(router *httprouter.Router) {
+	routeHelpers := NewAdminPageRoutes()
+
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/leases", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+		"page":   h.LeasesPageHandler,
+		"update": h.UpdateLeasePolicyHandler,
+	})
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/leases/release", PermissionOperate, h.ReleaseLeaseHandler)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+func TestResolveLeaseDays(t *testing.T) {
+	policy := state.LeasePolicy{DefaultDays: 30, TagDays: map[string]int{"lab": 7, "demo": 3}, MaxDays: 60}
+
+	if days, err := resolveLeaseDays(policy, []string{"lab", "demo"}, ""); err != nil || days != 3 {
+		t.Errorf("Expected the shortest tag lease, got %d (%v)", days, err)
+	}
+	if days, err := resolveLeaseDays(policy, []string{"web"}, ""); err != nil || days != 30 {
+		t.Errorf("Expected the default lease, got %d (%v)", days, err)
+	}
+	if days, err := resolveLeaseDays(policy, nil, "45"); err != nil || days != 45 {
+		t.Errorf("Expected the chosen lease, got %d (%v)", days, err)
+	}
+
+	var createErr *vmCreateError
+	if _, err := resolveLeaseDays(policy, nil, "90"); !errors.As(err, &createErr) || createErr.Code != "limit_exceeded" {
+		t.Errorf("Expected a lease over MaxDays to be refused, got %v", err)
+	}
+	if _, err := resolveLeaseDays(policy, nil, "0"); !errors.As(err, &createErr) || createErr.Code != "invalid_parameter" {
+		t.Errorf("Expected a zero lease to be refused, got %v", err)
+	}
+	if days, _ := resolveLeaseDays(state.LeasePolicy{}, nil, ""); days != 0 {
+		t.Errorf("Expected VMs to never expire without a policy, got %d", days)
+	}
+}
+
+func TestVMLeaseExtensionLimit(t *testing.T) {
+	store, _ := state.NewVMLeaseStore("")
+	now := time.Now()
+	if err := store.Set(state.VMLease{VMID: 100, Name: "alice-vm", ExpiresAt: now.Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := store.Extend(100, 2*leaseDay, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lease.ExpiresAt.Equal(now.Add(2 * leaseDay).UTC()) {
+		t.Errorf("Expected an expired lease to be extended from now, got %s", lease.ExpiresAt)
+	}
+	if _, err := store.Extend(100, 2*leaseDay, 1, now); !errors.Is(err, state.ErrVMLeaseExtensionLimit) {
+		t.Errorf("Expected the extension limit to be enforced, got %v", err)
+	}
+	if _, err := store.Extend(100, 2*leaseDay, -1, now); err != nil {
+		t.Errorf("Expected unlimited extensions for administrators, got %v", err)
+	}
+
+	view := newLeaseView(state.LeasePolicy{ExtensionDays: 2, MaxExtensions: 1}, state.VMLease{Extensions: 1}, false, now)
+	if view.CanExtend || view.ExtensionsLeft != 0 {
+		t.Errorf("Expected no extension left, got %+v", view)
+	}
+}
+
+func TestReapExpiredLeases(t *testing.T) {
+	policy := &state.LeasePolicy{DefaultDays: 1, DryRun: true}
+	sm, client := newAPITestState(&state.AppSettings{Leases: policy})
+	store := sm.GetVMLeaseStore()
+	now := time.Now()
+	proxmox.InvalidateInventory()
+
+	_ = store.Set(state.VMLease{VMID: 100, Name: "alice-vm", Username: "alice", ExpiresAt: now.Add(-time.Minute)})
+	_ = store.Set(state.VMLease{VMID: 200, Name: "bob-vm", ExpiresAt: now.Add(time.Hour)})
+	_ = store.Set(state.VMLease{VMID: 300, Name: "gone", ExpiresAt: now.Add(-time.Hour)})
+
+	reapExpiredLeases(context.Background(), sm, now)
+	if client.called(http.MethodPost, "/nodes/pve1/qemu/100/status/stop") {
+		t.Fatal("Dry run must not stop the VM")
+	}
+	if events := store.Events(); len(events) != 1 || !events[0].DryRun || events[0].Action != state.LeaseActionStop {
+		t.Errorf("Expected a recorded dry-run stop, got %+v", events)
+	}
+	if _, ok := store.Get(300); ok {
+		t.Error("Expected the lease of a missing VM to be dropped")
+	}
+
+	policy.DryRun = false
+	reapExpiredLeases(context.Background(), sm, now)
+	if !client.called(http.MethodPost, "/nodes/pve1/qemu/100/status/stop") {
+		t.Fatal("Expected the expired VM to be stopped")
+	}
+	if lease, _ := store.Get(100); lease.StoppedAt == nil {
+		t.Error("Expected the stop to start the grace period")
+	}
+	if client.called(http.MethodDelete, "/nodes/pve1/qemu/100") {
+		t.Fatal("VM must not be deleted on the pass that stopped it")
+	}
+
+	// No grace period: the next pass deletes the stopped VM
+	reapExpiredLeases(context.Background(), sm, now.Add(time.Minute))
+	if !client.called(http.MethodDelete, "/nodes/pve1/qemu/100") {
+		t.Fatal("Expected the stopped VM to be deleted after the grace period")
+	}
+	if _, ok := store.Get(100); ok {
+		t.Error("Expected the lease of the deleted VM to be removed")
+	}
+	if _, ok := store.Get(200); !ok {
+		t.Error("Expected the unexpired lease to be kept")
+	}
+	if events := store.Events(); len(events) != 3 || events[0].Action != state.LeaseActionDelete {
+		t.Errorf("Expected stop and delete to be recorded, got %+v", events)
+	}
+
+	// The dry run is not audited; the stop and the delete are, under the system actor
+	audit := sm.GetAuditLogStore().Query(state.AuditFilter{Actor: state.AuditActorSystem})
+	if len(audit) != 2 || audit[0].Action != state.AuditActionVMDelete || audit[1].Action != "vm.stop" ||
+		audit[0].VMID != 100 || audit[0].Params["owner"] != "alice" {
+		t.Errorf("Expected the reaper stop and delete in the audit log, got %+v", audit)
+	}
+}
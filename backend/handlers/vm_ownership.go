@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"pvmss/proxmox"
+)
+
+// ErrVMAccessDenied is returned when a user tries to act on a VM outside of their pool
+var ErrVMAccessDenied = errors.New("vm is not a member of the user's pool")
+
+// getPoolVMNodes returns the qemu members of a pool as a VMID to node map.
+// When bypassCache is true the cached pool listing is dropped before fetching.
+func getPoolVMNodes(ctx context.Context, client proxmox.ClientInterface, poolName string, bypassCache bool) (map[int]string, error) {
+	path := "/pools/" + url.PathEscape(poolName)
+	if bypassCache {
+		client.InvalidateCache(path)
+	}
+
+	var poolResp struct {
+		Data struct {
+			Members []struct {
+				Type string `json:"type"`
+				VMID int    `json:"vmid"`
+				Node string `json:"node"`
+			} `json:"members"`
+		} `json:"data"`
+	}
+	if err := client.GetJSON(ctx, path, &poolResp); err != nil {
+		return nil, fmt.Errorf("failed to fetch pool %s: %w", poolName, err)
+	}
+
+	members := make(map[int]string, len(poolResp.Data.Members))
+	for _, m := range poolResp.Data.Members {
+		if m.Type == "qemu" && m.VMID > 0 {
+			members[m.VMID] = m.Node
+		}
+	}
+	return members, nil
+}
+
+// getPoolVMIDs returns the VMIDs of the qemu members of a pool.
+// When bypassCache is true the cached pool listing is dropped before fetching.
+func getPoolVMIDs(ctx context.Context, client proxmox.ClientInterface, poolName string, bypassCache bool) (map[int]struct{}, error) {
+	members, err := getPoolVMNodes(ctx, client, poolName, bypassCache)
+	if err != nil {
+		return nil, err
+	}
+	vmids := make(map[int]struct{}, len(members))
+	for vmid := range members {
+		vmids[vmid] = struct{}{}
+	}
+	return vmids, nil
+}
+
+// poolHasVM reports whether vmid is a qemu member of the given pool
+func poolHasVM(ctx context.Context, client proxmox.ClientInterface, poolName string, vmid int, bypassCache bool) (bool, error) {
+	vmids, err := getPoolVMIDs(ctx, client, poolName, bypassCache)
+	if err != nil {
+		return false, err
+	}
+	_, ok := vmids[vmid]
+	return ok, nil
+}
+
+// CheckVMOwnership verifies that username may act on vmid.
+// Admins are allowed explicitly; regular users must have the VM in their pvmss_<username> pool.
+// A negative answer from the cache is re-checked against Proxmox so freshly created VMs are not refused.
+func CheckVMOwnership(ctx context.Context, client proxmox.ClientInterface, username string, isAdmin bool, vmid int) error {
+	if isAdmin {
+		return nil
+	}
+	if username == "" {
+		return ErrVMAccessDenied
+	}
+	if client == nil {
+		return fmt.Errorf("proxmox client not available")
+	}
+
+	poolName := userPoolName(username)
+	owned, err := poolHasVM(ctx, client, poolName, vmid, false)
+	if err == nil && !owned {
+		owned, err = poolHasVM(ctx, client, poolName, vmid, true)
+	}
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return ErrVMAccessDenied
+	}
+	return nil
+}
+
+// authorizeVMAccess runs CheckVMOwnership for the user of the current session
+func authorizeVMAccess(r *http.Request, client proxmox.ClientInterface, vmid int) error {
+	ctx := NewHandlerContext(nil, r, "authorizeVMAccess")
+	isAdmin := ctx.IsAdmin()
+	username := ctx.GetUsername()
+
+	err := CheckVMOwnership(r.Context(), client, username, isAdmin, vmid)
+	switch {
+	case err == nil && isAdmin:
+		ctx.Log.Debug().Int("vmid", vmid).Msg("VM access granted to admin")
+	case errors.Is(err, ErrVMAccessDenied):
+		ctx.Log.Warn().Str("username", username).Int("vmid", vmid).Msg("VM access denied: VM is not in user's pool")
+	case err != nil:
+		ctx.Log.Error().Err(err).Str("username", username).Int("vmid", vmid).Msg("Unable to verify VM ownership")
+	}
+	return err
+}
+
+// requireVMOwnership renders an error page and returns false if the session user may not act on vmid.
+// It must be called before any Proxmox call that reads or modifies the VM.
+func requireVMOwnership(w http.ResponseWriter, r *http.Request, client proxmox.ClientInterface, vmid int) bool {
+	err := authorizeVMAccess(r, client, vmid)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, ErrVMAccessDenied) {
+		RenderErrorPageWithI18n(w, r, http.StatusForbidden, "Error.VMAccessDenied", "You do not have access to this virtual machine.")
+		return false
+	}
+	RenderErrorPageWithI18n(w, r, http.StatusInternalServerError, "Error.InternalServer", "Internal server error")
+	return false
+}
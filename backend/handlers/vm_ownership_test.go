@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/proxmox"
+	"pvmss/security"
+	"pvmss/state"
+)
+
+// fakeProxmoxClient is an in-memory proxmox.ClientInterface serving canned GET responses
+// and recording every call made through it.
+type fakeProxmoxClient struct {
+	mu        sync.Mutex
+	responses map[string]interface{}
+	calls     []string
+}
+
+func newFakeProxmoxClient() *fakeProxmoxClient {
+	return &fakeProxmoxClient{responses: make(map[string]interface{})}
+}
+
+func (c *fakeProxmoxClient) record(method, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, method+" "+path)
+}
+
+// called reports whether a call with the given method was made on a path starting with prefix
+func (c *fakeProxmoxClient) called(method, prefix string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, call := range c.calls {
+		if strings.HasPrefix(call, method+" "+prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *fakeProxmoxClient) setPool(pool string, vmids ...int) {
+	members := make([]map[string]interface{}, 0, len(vmids))
+	for _, id := range vmids {
+		members = append(members, map[string]interface{}{"type": "qemu", "vmid": id, "node": "pve1"})
+	}
+	c.responses["/pools/"+url.PathEscape(pool)] = map[string]interface{}{
+		"data": map[string]interface{}{"poolid": pool, "members": members},
+	}
+}
+
+func (c *fakeProxmoxClient) GetJSON(_ context.Context, path string, target interface{}) error {
+	c.record(http.MethodGet, path)
+	resp, ok := c.responses[path]
+	if !ok && strings.Contains(path, "/tasks/") && strings.HasSuffix(path, "/status") {
+		// Tasks without a canned status finish immediately
+		resp, ok = map[string]interface{}{"data": map[string]interface{}{"status": "stopped", "exitstatus": "OK"}}, true
+	}
+	if !ok {
+		return fmt.Errorf("fake: no response for %s", path)
+	}
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, target)
+}
+
+func (c *fakeProxmoxClient) GetWithContext(ctx context.Context, path string) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.GetJSON(ctx, path, &out)
+	return out, err
+}
+
+func (c *fakeProxmoxClient) Get(path string) (map[string]interface{}, error) {
+	return c.GetWithContext(context.Background(), path)
+}
+
+// fakeTaskSeq numbers the UPIDs handed out by fake clients, which share the global task tracker
+var fakeTaskSeq atomic.Int64
+
+func (c *fakeProxmoxClient) PostFormAndGetJSON(_ context.Context, path string, _ url.Values, v interface{}) error {
+	c.record(http.MethodPost, path)
+	upid := fmt.Sprintf("UPID:pve1:%08X:00000001:00000001:qmtask:0:root@pam:", fakeTaskSeq.Add(1))
+	return json.Unmarshal([]byte(`{"data":"`+upid+`"}`), v)
+}
+
+func (c *fakeProxmoxClient) PostFormWithContext(_ context.Context, path string, _ url.Values) (map[string]interface{}, error) {
+	c.record(http.MethodPost, path)
+	return map[string]interface{}{"data": nil}, nil
+}
+
+func (c *fakeProxmoxClient) PutFormWithContext(_ context.Context, path string, _ url.Values) (map[string]interface{}, error) {
+	c.record(http.MethodPut, path)
+	return map[string]interface{}{"data": nil}, nil
+}
+
+func (c *fakeProxmoxClient) DeleteWithContext(_ context.Context, path string, _ url.Values) (map[string]interface{}, error) {
+	c.record(http.MethodDelete, path)
+	return map[string]interface{}{"data": nil}, nil
+}
+
+func (c *fakeProxmoxClient) InvalidateCache(string)         {}
+func (c *fakeProxmoxClient) GetApiUrl() string              { return "https://pve.test:8006/api2/json" }
+func (c *fakeProxmoxClient) GetCSRFPreventionToken() string { return "" }
+func (c *fakeProxmoxClient) GetPVEAuthCookie() string       { return "" }
+func (c *fakeProxmoxClient) GetTimeout() time.Duration      { return time.Second }
+func (c *fakeProxmoxClient) SetTimeout(time.Duration)       {}
+
+// fakeStateManager overrides the few StateManager methods the VM handlers use.
+type fakeStateManager struct {
+	state.StateManager
+	client    proxmox.ClientInterface
+	sessions  *scs.SessionManager
+	settings  *state.AppSettings
+	tokens    *state.APITokenStore
+	requests  *state.VMRequestStore
+	leases    *state.VMLeaseStore
+	schedules *state.VMScheduleStore
+	admins    *state.AdminAccountStore
+	audit     *state.AuditLogStore
+}
+
+func (s *fakeStateManager) GetProxmoxClient() proxmox.ClientInterface { return s.client }
+func (s *fakeStateManager) GetSessionManager() *scs.SessionManager    { return s.sessions }
+func (s *fakeStateManager) GetSettings() *state.AppSettings {
+	if s.settings != nil {
+		return s.settings
+	}
+	return &state.AppSettings{}
+}
+func (s *fakeStateManager) GetProxmoxStatus() (bool, string)       { return true, "" }
+func (s *fakeStateManager) GetTemplates() *template.Template       { return nil }
+func (s *fakeStateManager) GetAPITokenStore() *state.APITokenStore { return s.tokens }
+func (s *fakeStateManager) GetVMRequestStore() *state.VMRequestStore {
+	if s.requests == nil {
+		s.requests, _ = state.NewVMRequestStore("")
+	}
+	return s.requests
+}
+func (s *fakeStateManager) GetVMLeaseStore() *state.VMLeaseStore {
+	if s.leases == nil {
+		s.leases, _ = state.NewVMLeaseStore("")
+	}
+	return s.leases
+}
+func (s *fakeStateManager) GetVMScheduleStore() *state.VMScheduleStore {
+	if s.schedules == nil {
+		s.schedules, _ = state.NewVMScheduleStore("")
+	}
+	return s.schedules
+}
+func (s *fakeStateManager) GetAdminAccountStore() *state.AdminAccountStore {
+	if s.admins == nil {
+		s.admins, _ = state.NewAdminAccountStore("")
+	}
+	return s.admins
+}
+func (s *fakeStateManager) GetAuditLogStore() *state.AuditLogStore {
+	if s.audit == nil {
+		s.audit, _ = state.NewAuditLogStore("")
+	}
+	return s.audit
+}
+
+// serveAs runs handler with a session populated for the given identity.
+func serveAs(sm *fakeStateManager, username string, isAdmin bool, handler httprouter.Handle, req *http.Request, ps httprouter.Params) *httptest.ResponseRecorder {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sm.sessions.Put(r.Context(), "authenticated", true)
+		sm.sessions.Put(r.Context(), "is_admin", isAdmin)
+		sm.sessions.Put(r.Context(), "username", username)
+		sm.sessions.Put(r.Context(), "pve_auth_cookie", "PVE:"+username+"@pve:TICKET")
+		sm.sessions.Put(r.Context(), "pve_csrf_token", "csrf")
+		sm.sessions.Put(r.Context(), "pve_ticket_created", time.Now().Unix())
+		handler(w, r, ps)
+	})
+	ctx := context.WithValue(req.Context(), StateManagerKey, state.StateManager(sm))
+	req = req.WithContext(security.WithSessionManager(ctx, sm.sessions))
+	rec := httptest.NewRecorder()
+	sm.sessions.LoadAndSave(inner).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCheckVMOwnership(t *testing.T) {
+	client := newFakeProxmoxClient()
+	client.setPool("pvmss_alice", 100, 101)
+	client.setPool("pvmss_bob", 200)
+
+	tests := []struct {
+		name     string
+		username string
+		isAdmin  bool
+		vmid     int
+		wantErr  error
+	}{
+		{"owner", "alice", false, 100, nil},
+		{"non-owner", "bob", false, 100, ErrVMAccessDenied},
+		{"admin", "", true, 100, nil},
+		{"no username", "", false, 100, ErrVMAccessDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckVMOwnership(context.Background(), client, tt.username, tt.isAdmin, tt.vmid)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckVMOwnership() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := CheckVMOwnership(context.Background(), client, "carol", false, 100); err == nil {
+		t.Error("Expected an error when the user's pool cannot be read")
+	}
+}
+
+func TestVMHandlersOwnershipMatrix(t *testing.T) {
+	type route struct {
+		name    string
+		handler func(h *VMHandler) httprouter.Handle
+		request func() *http.Request
+		params  httprouter.Params
+		// proxmoxCall is the method and path prefix of the call that must not happen for non-owners
+		proxmoxCall [2]string
+	}
+
+	form := func(target string, values url.Values) func() *http.Request {
+		return func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req
+		}
+	}
+
+	routes := []route{
+		{
+			name:        "action",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.VMActionHandler },
+			request:     form("/vm/action", url.Values{"vmid": {"100"}, "node": {"pve1"}, "action": {"start"}}),
+			proxmoxCall: [2]string{http.MethodPost, "/nodes/pve1/qemu/100/status/start"},
+		},
+		{
+			name:        "delete",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.VMDeleteHandler },
+			request:     form("/vm/delete", url.Values{"vmid": {"100"}, "node": {"pve1"}}),
+			proxmoxCall: [2]string{http.MethodPost, "/nodes/pve1/qemu/100/status/stop"},
+		},
+		{
+			name:        "tags",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.UpdateVMTagsHandler },
+			request:     form("/vm/update/tags", url.Values{"vmid": {"100"}, "node": {"pve1"}, "tags": {"pvmss"}}),
+			proxmoxCall: [2]string{http.MethodPut, "/nodes/pve1/qemu/100/config"},
+		},
+		{
+			name:        "description",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.UpdateVMDescriptionHandler },
+			request:     form("/vm/update/description", url.Values{"vmid": {"100"}, "node": {"pve1"}, "description": {"x"}}),
+			proxmoxCall: [2]string{http.MethodPut, "/nodes/pve1/qemu/100/config"},
+		},
+		{
+			name:    "details",
+			handler: func(h *VMHandler) httprouter.Handle { return h.VMDetailsHandler },
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/vm/details/100", nil)
+			},
+			params:      httprouter.Params{{Key: "vmid", Value: "100"}},
+			proxmoxCall: [2]string{http.MethodGet, "/nodes"},
+		},
+		{
+			name:    "tasks",
+			handler: func(h *VMHandler) httprouter.Handle { return h.VMTasksHandler },
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/api/vm/tasks/100", nil)
+			},
+			params:      httprouter.Params{{Key: "vmid", Value: "100"}},
+			proxmoxCall: [2]string{http.MethodGet, "/nodes/pve1/tasks/"},
+		},
+		{
+			name:        "snapshot create",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.VMSnapshotCreateHandler },
+			request:     form("/vm/snapshot/create", url.Values{"vmid": {"100"}, "node": {"pve1"}, "snapname": {"before-upgrade"}}),
+			proxmoxCall: [2]string{http.MethodPost, "/nodes/pve1/qemu/100/snapshot"},
+		},
+		{
+			name:        "snapshot rollback",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.VMSnapshotRollbackHandler },
+			request:     form("/vm/snapshot/rollback", url.Values{"vmid": {"100"}, "node": {"pve1"}, "snapname": {"before-upgrade"}}),
+			proxmoxCall: [2]string{http.MethodPost, "/nodes/pve1/qemu/100/snapshot/before-upgrade/rollback"},
+		},
+		{
+			name:        "snapshot delete",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.VMSnapshotDeleteHandler },
+			request:     form("/vm/snapshot/delete", url.Values{"vmid": {"100"}, "node": {"pve1"}, "snapname": {"before-upgrade"}}),
+			proxmoxCall: [2]string{http.MethodDelete, "/nodes/pve1/qemu/100/snapshot/before-upgrade"},
+		},
+		{
+			name:        "backup create",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.VMBackupCreateHandler },
+			request:     form("/vm/backup/create", url.Values{"vmid": {"100"}, "node": {"pve1"}}),
+			proxmoxCall: [2]string{http.MethodPost, "/nodes/pve1/vzdump"},
+		},
+		{
+			name:        "resize",
+			handler:     func(h *VMHandler) httprouter.Handle { return h.VMResizeHandler },
+			request:     form("/vm/resize", url.Values{"vmid": {"100"}, "node": {"pve1"}, "sockets": {"1"}, "cores": {"2"}, "memory": {"2048"}, "disk_size": {"32"}}),
+			proxmoxCall: [2]string{http.MethodGet, "/nodes/pve1/qemu/100/config"},
+		},
+		{
+			name:    "vnc ticket",
+			handler: func(h *VMHandler) httprouter.Handle { return h.GetVNCTicketHandler },
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/api/vm/vnc-ticket?vmid=100&node=pve1", nil)
+			},
+			proxmoxCall: [2]string{http.MethodPost, "/nodes/pve1/qemu/100/vncproxy"},
+		},
+	}
+
+	identities := []struct {
+		name     string
+		username string
+		isAdmin  bool
+		denied   bool
+	}{
+		{"owner", "alice", false, false},
+		{"non-owner", "bob", false, true},
+		{"admin", "admin", true, false},
+	}
+
+	for _, rt := range routes {
+		for _, id := range identities {
+			t.Run(rt.name+"/"+id.name, func(t *testing.T) {
+				client := newFakeProxmoxClient()
+				client.setPool("pvmss_alice", 100)
+				client.setPool("pvmss_bob", 200)
+				sm := &fakeStateManager{client: client, sessions: scs.New()}
+				h := NewVMHandler(sm)
+
+				rec := serveAs(sm, id.username, id.isAdmin, rt.handler(h), rt.request(), rt.params)
+
+				if id.denied {
+					if rec.Code != http.StatusForbidden {
+						t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+					}
+					if client.called(rt.proxmoxCall[0], rt.proxmoxCall[1]) {
+						t.Errorf("Proxmox call %s %s must not happen for a non-owner", rt.proxmoxCall[0], rt.proxmoxCall[1])
+					}
+					return
+				}
+				if rec.Code == http.StatusForbidden {
+					t.Errorf("Expected access to be granted, got %d", rec.Code)
+				}
+				if id.isAdmin && client.called(http.MethodGet, "/pools/") {
+					t.Error("Admin access should not require a pool lookup")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// approvalFields are the keys of the "auto_approve" limits entry: total vCPUs (sockets * cores),
+// RAM in GB and disk in GB of a single VM
+var approvalFields = []string{"cores", "ram", "disk"}
+
+// AutoApproveLimits is the largest VM a regular user can create without an administrator's approval.
+// A negative value means the resource never requires approval.
+type AutoApproveLimits struct {
+	Cores  int
+	RAMGB  int
+	DiskGB int
+}
+
+// autoApproveLimits reads the "auto_approve" limits entry; absent fields are not capped
+func autoApproveLimits(settings *state.AppSettings) AutoApproveLimits {
+	limits := AutoApproveLimits{Cores: quotaUnlimited, RAMGB: quotaUnlimited, DiskGB: quotaUnlimited}
+	if settings == nil || settings.Limits == nil {
+		return limits
+	}
+	raw, _ := settings.Limits["auto_approve"].(map[string]interface{})
+	if v, ok := quotaInt(raw["cores"]); ok && v >= 0 {
+		limits.Cores = v
+	}
+	if v, ok := quotaInt(raw["ram"]); ok && v >= 0 {
+		limits.RAMGB = v
+	}
+	if v, ok := quotaInt(raw["disk"]); ok && v >= 0 {
+		limits.DiskGB = v
+	}
+	return limits
+}
+
+// exceededBy describes why a VM needs approval, or returns "" when it is within the envelope
+func (l AutoApproveLimits) exceededBy(spec *VMCreateSpec) string {
+	var reasons []string
+	if vcpus := spec.Sockets * spec.Cores; l.Cores >= 0 && vcpus > l.Cores {
+		reasons = append(reasons, fmt.Sprintf("%d vCPUs > %d", vcpus, l.Cores))
+	}
+	if l.RAMGB >= 0 && spec.MemoryMB > l.RAMGB*1024 {
+		reasons = append(reasons, fmt.Sprintf("%d MB RAM > %d GB", spec.MemoryMB, l.RAMGB))
+	}
+	if l.DiskGB >= 0 && spec.DiskSizeGB > l.DiskGB {
+		reasons = append(reasons, fmt.Sprintf("%d GB disk > %d GB", spec.DiskSizeGB, l.DiskGB))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// autoApproveFormValues renders the envelope for the limits page; uncapped fields are blank
+func autoApproveFormValues(settings *state.AppSettings) map[string]string {
+	limits := autoApproveLimits(settings)
+	values := make(map[string]string)
+	for field, v := range map[string]int{"cores": limits.Cores, "ram": limits.RAMGB, "disk": limits.DiskGB} {
+		if v >= 0 {
+			values[field] = strconv.Itoa(v)
+		}
+	}
+	return values
+}
+
+// updateAutoApproveSettings applies the auto-approve form of the limits page. Blank fields remove the cap.
+func updateAutoApproveSettings(r *http.Request, settings *state.AppSettings) error {
+	envelope := make(map[string]interface{})
+	for _, field := range approvalFields {
+		raw := strings.TrimSpace(r.FormValue("approve-" + field))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid auto-approve %s value", field)
+		}
+		envelope[field] = n
+	}
+	settings.Limits["auto_approve"] = envelope
+	return nil
+}
+
+// submitVMRequest stores a creation request that needs approval instead of creating the VM
+func (h *VMHandler) submitVMRequest(r *http.Request, username string, form VMCreateFormData, exceeds string) (*state.VMRequest, error) {
+	store := h.stateManager.GetVMRequestStore()
+	if store == nil {
+		return nil, fmt.Errorf("vm request store not available")
+	}
+	raw, err := json.Marshal(form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode vm request: %w", err)
+	}
+	req, err := store.Create(username, raw)
+	params := map[string]string{"name": form.Name, "node": form.Node, "exceeds": exceeds}
+	if req != nil {
+		params["request"] = req.ID
+	}
+	if form.Restore != "" {
+		params["restore"] = form.Restore
+	}
+	recordAudit(r, state.AuditEvent{Action: state.AuditActionVMRequestSubmit, Pool: form.Pool, Params: params}, err)
+	return req, err
+}
+
+// decodeVMRequestForm returns the creation form stored in a request
+func decodeVMRequestForm(req state.VMRequest) (VMCreateFormData, error) {
+	var form VMCreateFormData
+	if err := json.Unmarshal(req.Form, &form); err != nil {
+		return form, fmt.Errorf("invalid vm request form: %w", err)
+	}
+	return form, nil
+}
+
+// approveVMRequest runs an approved request through the same validation, quota check and
+// provisioning as CreateVMHandler. The requester's pool was set when the request was submitted.
+func (h *VMHandler) approveVMRequest(ctx context.Context, client proxmox.ClientInterface, id, admin string) (int, error) {
+	store := h.stateManager.GetVMRequestStore()
+	req, err := store.StartProvisioning(id)
+	if err != nil {
+		return 0, err
+	}
+
+	// The requester's quota is checked again, under the same lock as their own creations
+	unlock := lockUserLimits(req.Username)
+	defer unlock()
+
+	vmid := 0
+	form, err := decodeVMRequestForm(*req)
+	if err == nil && form.Restore != "" {
+		vmid, err = h.approveRestoreRequest(ctx, client, req.Username, form)
+	} else if err == nil {
+		var spec *VMCreateSpec
+		spec, err = h.validateVMCreate(ctx, client, form)
+		if err == nil {
+			err = h.validateCreateQuota(ctx, client, req.Username, spec)
+		}
+		if err == nil {
+			vmid, err = h.provisionVM(ctx, client, spec)
+		}
+	}
+
+	if finishErr := store.FinishProvisioning(id, admin, vmid, err); finishErr != nil && err == nil {
+		err = finishErr
+	}
+	return vmid, err
+}
+
+// VMRequestView is a VM request as displayed on the admin requests page and the profile
+type VMRequestView struct {
+	ID        string
+	Username  string
+	Status    string
+	Name      string
+	Node      string
+	Source    string // ISO volume or template VMID
+	Sockets   string
+	Cores     string
+	Memory    string // MB
+	DiskSize  string // GB
+	CreatedAt string
+	DecidedAt string
+	DecidedBy string
+	Reason    string
+	VMID      int
+	LastError string
+	Exceeds   string // why the request needs approval under the current envelope
+	Restore   bool   // restore of a backup, whose sizes come from the archive and cannot be edited
+}
+
+// toVMRequestView formats a request; exceeds is computed by the caller when it is relevant
+func toVMRequestView(req state.VMRequest) VMRequestView {
+	view := VMRequestView{
+		ID:        req.ID,
+		Username:  req.Username,
+		Status:    req.Status,
+		CreatedAt: req.CreatedAt.Local().Format("2006-01-02 15:04"),
+		DecidedBy: req.DecidedBy,
+		Reason:    req.Reason,
+		VMID:      req.VMID,
+		LastError: req.LastError,
+	}
+	if req.DecidedAt != nil {
+		view.DecidedAt = req.DecidedAt.Local().Format("2006-01-02 15:04")
+	}
+	if form, err := decodeVMRequestForm(req); err == nil {
+		view.Name = form.Name
+		view.Node = form.Node
+		view.Sockets = form.Sockets
+		view.Cores = form.Cores
+		view.Memory = form.Memory
+		view.DiskSize = form.DiskSize
+		view.Source = form.ISO
+		if form.Template != "" {
+			view.Source = "template " + form.Template
+		}
+		if form.Restore != "" {
+			view.Source = "backup " + form.Restore
+			if form.VMID != "" {
+				view.Source += " over VM " + form.VMID
+			}
+			view.Restore = true
+		}
+	}
+	return view
+}
+
+// userVMRequestViews lists the requests of a user for the profile page
+func userVMRequestViews(sm state.StateManager, username string) []VMRequestView {
+	store := sm.GetVMRequestStore()
+	if store == nil {
+		return nil
+	}
+	requests := store.ListForUser(username)
+	views := make([]VMRequestView, 0, len(requests))
+	for _, req := range requests {
+		views = append(views, toVMRequestView(req))
+	}
+	return views
+}
+
+// requestAdminName identifies the administrator deciding a request: their admin account, or
+// "admin" for the shared bootstrap login
+func requestAdminName(r *http.Request) string {
+	if account := adminAccountName(r); account != "" {
+		return account
+	}
+	return "admin"
+}
+
+// VMRequestsPageHandler renders the admin page listing pending and decided VM requests
+func (h *VMHandler) VMRequestsPageHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("VMRequestsPageHandler", r)
+
+	successMsg := ""
+	switch r.URL.Query().Get("success") {
+	case "approve":
+		successMsg = "Request approved, VM " + r.URL.Query().Get("vmid") + " created"
+	case "update":
+		successMsg = "Request updated"
+	case "reject":
+		successMsg = "Request rejected"
+	}
+	data := AdminPageDataWithMessage("VM Requests", "requests", successMsg, r.URL.Query().Get("error"))
+
+	settings := h.stateManager.GetSettings()
+	limits := autoApproveLimits(settings)
+	pending := make([]VMRequestView, 0)
+	decided := make([]VMRequestView, 0)
+	for _, req := range h.stateManager.GetVMRequestStore().List() {
+		view := toVMRequestView(req)
+		if req.Status == state.VMRequestApproved || req.Status == state.VMRequestRejected {
+			decided = append(decided, view)
+			continue
+		}
+		if form, err := decodeVMRequestForm(req); err == nil {
+			sockets, _ := strconv.Atoi(form.Sockets)
+			cores, _ := strconv.Atoi(form.Cores)
+			memory, _ := strconv.Atoi(form.Memory)
+			disk, _ := strconv.Atoi(form.DiskSize)
+			view.Exceeds = limits.exceededBy(&VMCreateSpec{Sockets: sockets, Cores: cores, MemoryMB: memory, DiskSizeGB: disk})
+		}
+		pending = append(pending, view)
+	}
+	data["PendingRequests"] = pending
+	data["DecidedRequests"] = decided
+
+	log.Debug().Int("pending", len(pending)).Int("decided", len(decided)).Msg("VM requests page rendered")
+	renderTemplateInternal(w, r, "admin_requests", data)
+}
+
+// applyVMRequestEdits saves the sizes an administrator changed on a pending request.
+// The edited form is checked against the hard limits before it is stored.
+func (h *VMHandler) applyVMRequestEdits(ctx context.Context, client proxmox.ClientInterface, r *http.Request, id string) error {
+	store := h.stateManager.GetVMRequestStore()
+	req, ok := store.Get(id)
+	if !ok {
+		return state.ErrVMRequestNotFound
+	}
+	form, err := decodeVMRequestForm(req)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for field, target := range map[string]*string{"sockets": &form.Sockets, "cores": &form.Cores, "memory": &form.Memory, "disk_size": &form.DiskSize} {
+		v := strings.TrimSpace(r.FormValue(field))
+		if v == "" || v == *target {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+			return fmt.Errorf("invalid %s", field)
+		}
+		*target = v
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if form.Restore != "" {
+		return fmt.Errorf("the size of a restored VM comes from its backup and cannot be edited")
+	}
+
+	if _, err := h.validateVMCreate(ctx, client, form); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(form)
+	if err != nil {
+		return err
+	}
+	err = store.UpdateForm(id, raw)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMRequestEdit,
+		Params: map[string]string{"request": id, "sockets": form.Sockets, "cores": form.Cores, "memory": form.Memory, "disk_size": form.DiskSize},
+	}, err)
+	return err
+}
+
+// vmRequestRedirect sends the administrator back to the requests page with a message
+func vmRequestRedirect(w http.ResponseWriter, r *http.Request, params url.Values) {
+	http.Redirect(w, r, "/admin/requests?"+params.Encode(), http.StatusSeeOther)
+}
+
+// UpdateVMRequestHandler saves an administrator's edits to a pending request
+func (h *VMHandler) UpdateVMRequestHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := CreateHandlerLogger("UpdateVMRequestHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		http.Error(w, "Proxmox client unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := r.FormValue("id")
+	if err := h.applyVMRequestEdits(r.Context(), client, r, id); err != nil {
+		log.Warn().Err(err).Str("vm_request_id", id).Msg("VM request edit rejected")
+		vmRequestRedirect(w, r, url.Values{"error": {err.Error()}})
+		return
+	}
+	vmRequestRedirect(w, r, url.Values{"success": {"update"}})
+}
+
+// ApproveVMRequestHandler applies the administrator's edits, then creates the requested VM
+func (h *VMHandler) ApproveVMRequestHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "ApproveVMRequestHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		http.Error(w, "Proxmox client unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := r.FormValue("id")
+	if err := h.applyVMRequestEdits(r.Context(), client, r, id); err != nil {
+		ctx.Log.Warn().Err(err).Str("vm_request_id", id).Msg("VM request edit rejected")
+		vmRequestRedirect(w, r, url.Values{"error": {err.Error()}})
+		return
+	}
+
+	// Provisioning waits for Proxmox tasks, so it is not bound to a short request deadline
+	provisionCtx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), constants.VMTaskWaitTimeout*2)
+	defer cancel()
+	vmid, err := h.approveVMRequest(provisionCtx, client, id, requestAdminName(r))
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMCreate,
+		VMID:   vmid,
+		Params: map[string]string{"request": id},
+	}, err)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("vm_request_id", id).Msg("VM request approval failed")
+		message := err.Error()
+		if errors.Is(err, state.ErrVMRequestNotPending) {
+			message = "This request has already been decided"
+		}
+		vmRequestRedirect(w, r, url.Values{"error": {message}})
+		return
+	}
+	vmRequestRedirect(w, r, url.Values{"success": {"approve"}, "vmid": {strconv.Itoa(vmid)}})
+}
+
+// RejectVMRequestHandler refuses a pending request; the reason is shown to the requester
+func (h *VMHandler) RejectVMRequestHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "RejectVMRequestHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	id := r.FormValue("id")
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		vmRequestRedirect(w, r, url.Values{"error": {"A reason is required to reject a request"}})
+		return
+	}
+	if runes := []rune(reason); len(runes) > 500 {
+		reason = string(runes[:500])
+	}
+
+	err := h.stateManager.GetVMRequestStore().Reject(id, requestAdminName(r), reason)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMRequestReject,
+		Params: map[string]string{"request": id, "reason": auditParam(reason)},
+	}, err)
+	if err != nil {
+		ctx.Log.Warn().Err(err).Str("vm_request_id", id).Msg("VM request rejection failed")
+		vmRequestRedirect(w, r, url.Values{"error": {err.Error()}})
+		return
+	}
+	vmRequestRedirect(w, r, url.Values{"success": {"reject"}})
+}
+
+// RegisterRequestRoutes registers the admin VM request routes. Editing and approving a request
+// create resources, so they need a full administrator; helpdesk can still turn a request down.
+func (h *VMHandler) RegisterRequestRoutes(router *httprouter.Router) {
+	routeHelpers := NewAdminPageRoutes()
+
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/requests", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+		"page":   h.VMRequestsPageHandler,
+		"update": h.UpdateVMRequestHandler,
+	})
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/requests/approve", PermissionManage, h.ApproveVMRequestHandler)
+	routeHelpers.helpers.RegisterAdminRoute(router, http.MethodPost, "/admin/requests/reject", PermissionOperate, h.RejectVMRequestHandler)
+}
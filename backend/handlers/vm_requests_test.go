@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/state"
+)
+
+func newVMRequestForm(values url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/admin/requests", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestAutoApproveLimits(t *testing.T) {
+	if exceeds := autoApproveLimits(nil).exceededBy(&VMCreateSpec{Sockets: 8, Cores: 8, MemoryMB: 65536, DiskSizeGB: 500}); exceeds != "" {
+		t.Errorf("Expected no approval without an envelope, got %q", exceeds)
+	}
+
+	settings := &state.AppSettings{Limits: map[string]interface{}{
+		"auto_approve": map[string]interface{}{"cores": float64(4), "ram": float64(8)},
+	}}
+	limits := autoApproveLimits(settings)
+	if exceeds := limits.exceededBy(&VMCreateSpec{Sockets: 2, Cores: 2, MemoryMB: 8192, DiskSizeGB: 500}); exceeds != "" {
+		t.Errorf("Expected a VM at the envelope to be auto-approved, got %q", exceeds)
+	}
+	exceeds := limits.exceededBy(&VMCreateSpec{Sockets: 1, Cores: 6, MemoryMB: 16384, DiskSizeGB: 10})
+	if !strings.Contains(exceeds, "6 vCPUs") || !strings.Contains(exceeds, "16384 MB") {
+		t.Errorf("Expected the vCPU and RAM excess, got %q", exceeds)
+	}
+}
+
+func TestVMRequestWorkflow(t *testing.T) {
+	settings := &state.AppSettings{Limits: map[string]interface{}{
+		"auto_approve": map[string]interface{}{"cores": float64(2)},
+	}}
+	sm, client := newAPITestState(settings)
+	api := NewAPIv1Handler(sm)
+	h := NewVMHandler(sm)
+
+	submit := func() string {
+		t.Helper()
+		body := `{"name":"big","node":"pve1","sockets":1,"cores":4,"memory":2048,"disk_size":10,"iso":"local:iso/a.iso","bridge":"vmbr0","storage":"local-lvm"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/vms", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := serveAs(sm, "alice", false, api.CreateVM, req, nil)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+		}
+		requests := sm.GetVMRequestStore().ListForUser("alice")
+		if len(requests) == 0 || requests[0].Status != state.VMRequestPending {
+			t.Fatalf("Expected a pending request, got %+v", requests)
+		}
+		// request_id is the correlation ID of API responses, not the approval queue's
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Data["vm_request_id"] != requests[0].ID {
+			t.Fatalf("Expected the request ID as vm_request_id, got %s", rec.Body.String())
+		}
+		return requests[0].ID
+	}
+
+	rejected := submit()
+	if client.called(http.MethodPost, "/nodes/pve1/qemu") {
+		t.Fatal("VM must not be created before approval")
+	}
+
+	rec := serveAs(sm, "", true, h.RejectVMRequestHandler, newVMRequestForm(url.Values{"id": {rejected}}), nil)
+	if !strings.Contains(rec.Header().Get("Location"), "error=") {
+		t.Errorf("Expected a rejection without reason to fail, got %s", rec.Header().Get("Location"))
+	}
+	serveAs(sm, "", true, h.RejectVMRequestHandler, newVMRequestForm(url.Values{"id": {rejected}, "reason": {"Too large"}}), nil)
+	if req, _ := sm.GetVMRequestStore().Get(rejected); req.Status != state.VMRequestRejected || req.Reason != "Too large" || req.DecidedBy != "admin" {
+		t.Errorf("Expected the request to be rejected with its reason, got %+v", req)
+	}
+
+	// The administrator lowers the cores before approving
+	approved := submit()
+	rec = serveWithCookies(sm, h.ApproveVMRequestHandler, newVMRequestForm(url.Values{"id": {approved}, "cores": {"2"}}), adminSessionCookies(sm, "carol"))
+	if !strings.Contains(rec.Header().Get("Location"), "success=approve") {
+		t.Fatalf("Expected the approval to succeed, got %s", rec.Header().Get("Location"))
+	}
+	if !client.called(http.MethodPost, "/nodes/pve1/qemu") {
+		t.Fatal("Expected the VM to be created on approval")
+	}
+	req, _ := sm.GetVMRequestStore().Get(approved)
+	if req.Status != state.VMRequestApproved || req.VMID == 0 || req.DecidedBy != "carol" {
+		t.Errorf("Expected the request to be approved by carol with the new VMID, got %+v", req)
+	}
+	if form, _ := decodeVMRequestForm(req); form.Cores != "2" || form.Pool != "pvmss_alice" {
+		t.Errorf("Expected the edited form in alice's pool, got %+v", form)
+	}
+
+	// A decided request cannot be approved again
+	rec = serveAs(sm, "", true, h.ApproveVMRequestHandler, newVMRequestForm(url.Values{"id": {approved}}), nil)
+	if !strings.Contains(rec.Header().Get("Location"), "error=") {
+		t.Errorf("Expected a second approval to fail, got %s", rec.Header().Get("Location"))
+	}
+
+	views := userVMRequestViews(sm, "alice")
+	if len(views) != 2 || views[0].Status != state.VMRequestApproved {
+		t.Errorf("Expected alice to see both requests, newest first, got %+v", views)
+	}
+}
+
+func TestVMRequestRoutePermissions(t *testing.T) {
+	sm, _ := newAPITestState(nil)
+	if err := sm.GetAdminAccountStore().Create("helpdesk", testAdminPassword, state.AdminRoleHelpdesk); err != nil {
+		t.Fatal(err)
+	}
+	router := httprouter.New()
+	NewVMHandler(sm).RegisterRequestRoutes(router)
+	serve := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) { router.ServeHTTP(w, r) }
+	cookies := adminSessionCookies(sm, "helpdesk")
+
+	for _, path := range []string{"/admin/requests/update", "/admin/requests/approve"} {
+		rec := serveWithCookies(sm, serve, httptest.NewRequest(http.MethodPost, path, nil), cookies)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected helpdesk to be refused %s, got %d", path, rec.Code)
+		}
+	}
+	rec := serveWithCookies(sm, serve, httptest.NewRequest(http.MethodGet, "/admin/requests", nil), cookies)
+	if rec.Code == http.StatusForbidden {
+		t.Error("Expected helpdesk to list requests")
+	}
+	rec = serveWithCookies(sm, serve, httptest.NewRequest(http.MethodPost, "/admin/requests/reject", nil), cookies)
+	if rec.Code == http.StatusForbidden {
+		t.Error("Expected helpdesk to reject requests")
+	}
+}
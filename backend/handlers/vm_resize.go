@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/i18n"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// restartKeys are the config keys changed by a resize that may only apply after a VM restart
+var restartKeys = map[string]bool{"sockets": true, "cores": true, "memory": true}
+
+// ResizeView is the current size of a VM and the upper bounds shown by the resize form.
+// A max of 0 means no limit is configured.
+type ResizeView struct {
+	Sockets        int
+	Cores          int
+	MemoryMB       int
+	DiskKey        string
+	DiskGB         int
+	MaxSockets     int
+	MaxCores       int
+	MaxMemoryMB    int
+	MaxDiskGB      int
+	PendingRestart bool
+}
+
+// currentVMSize reads sockets, cores, memory and the boot disk size from a VM config
+func currentVMSize(cfg map[string]interface{}) ResizeView {
+	view := ResizeView{Sockets: 1, Cores: 1, MemoryMB: 512}
+	if v, ok := cfg["sockets"].(float64); ok {
+		view.Sockets = int(v)
+	}
+	if v, ok := cfg["cores"].(float64); ok {
+		view.Cores = int(v)
+	}
+	if v, ok := cfg["memory"].(float64); ok {
+		view.MemoryMB = int(v)
+	}
+	if key := bootDiskKey(cfg); key != "" {
+		view.DiskKey = key
+		view.DiskGB = diskSizeGB(cfg[key].(string))
+	}
+	return view
+}
+
+// newResizeView builds the resize form data from a VM config, the size limits and the pending changes
+func newResizeView(settings *state.AppSettings, node string, cfg map[string]interface{}, pending []proxmox.PendingChange) ResizeView {
+	view := currentVMSize(cfg)
+	if settings != nil && settings.Limits != nil {
+		if rawVM, ok := settings.Limits["vm"].(map[string]interface{}); ok {
+			if _, max, ok2 := readMinMax(rawVM, "sockets"); ok2 {
+				view.MaxSockets = max
+			}
+			if _, max, ok2 := readMinMax(rawVM, "cores"); ok2 {
+				view.MaxCores = max
+			}
+			if _, maxGB, ok2 := readMinMax(rawVM, "ram"); ok2 {
+				view.MaxMemoryMB = maxGB * 1024
+			}
+			if _, max, ok2 := readMinMax(rawVM, "disk"); ok2 {
+				view.MaxDiskGB = max
+			}
+		}
+		// Per-VM node caps only lower the bounds
+		if rawNodes, ok := settings.Limits["nodes"].(map[string]interface{}); ok {
+			if rawNode, ok2 := rawNodes[node].(map[string]interface{}); ok2 {
+				if _, max, ok3 := readMinMax(rawNode, "sockets"); ok3 && (view.MaxSockets == 0 || max < view.MaxSockets) {
+					view.MaxSockets = max
+				}
+				if _, max, ok3 := readMinMax(rawNode, "cores"); ok3 && (view.MaxCores == 0 || max < view.MaxCores) {
+					view.MaxCores = max
+				}
+				if _, maxGB, ok3 := readMinMax(rawNode, "ram"); ok3 && (view.MaxMemoryMB == 0 || maxGB*1024 < view.MaxMemoryMB) {
+					view.MaxMemoryMB = maxGB * 1024
+				}
+			}
+		}
+	}
+	view.PendingRestart = needsRestart(pending)
+	return view
+}
+
+// needsRestart reports whether a CPU or memory change is waiting for the VM to restart
+func needsRestart(pending []proxmox.PendingChange) bool {
+	for _, key := range proxmox.PendingKeys(pending) {
+		if restartKeys[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// validateVMResize checks a new VM size against the same limits as VM creation.
+// The VM's own current usage is excluded from the aggregate node limits.
+func (h *VMHandler) validateVMResize(ctx context.Context, client proxmox.ClientInterface, node string, vmid, sockets, cores, memoryMB, diskGB int) error {
+	settings := h.stateManager.GetSettings()
+	if settings == nil || settings.Limits == nil {
+		return nil
+	}
+	if err := validateVMSizeLimits(settings, node, sockets, cores, memoryMB, diskGB); err != nil {
+		return err
+	}
+	return ValidateVMResizeAgainstNodeLimits(ctx, client, h.stateManager, node, vmid, sockets, cores, memoryMB)
+}
+
+// VMResizeHandler changes the sockets, cores and memory of a VM and grows its boot disk
+func (h *VMHandler) VMResizeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "VMResizeHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(r.FormValue("vmid"))
+	node := strings.TrimSpace(r.FormValue("node"))
+	if err != nil || vmid <= 0 || node == "" {
+		localizer := i18n.GetLocalizerFromRequest(r)
+		http.Error(w, i18n.Localize(localizer, "Error.Generic"), http.StatusBadRequest)
+		return
+	}
+	details := "/vm/details/" + strconv.Itoa(vmid)
+	editor := details + "?edit=resize"
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.Log.Error().Msg("Proxmox client not available")
+		http.Error(w, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	sockets, errSockets := strconv.Atoi(r.FormValue("sockets"))
+	cores, errCores := strconv.Atoi(r.FormValue("cores"))
+	memoryMB, errMemory := strconv.Atoi(r.FormValue("memory"))
+	diskGB, errDisk := strconv.Atoi(r.FormValue("disk_size"))
+	if errSockets != nil || errCores != nil || errMemory != nil || errDisk != nil ||
+		sockets <= 0 || cores <= 0 || memoryMB <= 0 || diskGB <= 0 {
+		ctx.RedirectWithError(editor, "VMDetails.Resize.Invalid")
+		return
+	}
+
+	// The config also confirms that the VM lives on the submitted node
+	cfg, err := proxmox.GetVMConfigWithContext(r.Context(), client, node, vmid)
+	if err != nil {
+		ctx.RedirectWithError(details, "VMDetails.Resize.Failed")
+		return
+	}
+	current := currentVMSize(cfg)
+
+	if diskGB < current.DiskGB {
+		ctx.RedirectWithError(editor, "VMDetails.Resize.DiskShrink")
+		return
+	}
+	growDisk := diskGB > current.DiskGB
+	if growDisk && current.DiskKey == "" {
+		ctx.RedirectWithError(editor, "VMDetails.Resize.NoDisk")
+		return
+	}
+
+	params := map[string]string{}
+	if sockets != current.Sockets {
+		params["sockets"] = strconv.Itoa(sockets)
+	}
+	if cores != current.Cores {
+		params["cores"] = strconv.Itoa(cores)
+	}
+	if memoryMB != current.MemoryMB {
+		params["memory"] = strconv.Itoa(memoryMB)
+	}
+	if len(params) == 0 && !growDisk {
+		ctx.RedirectWithWarning(details, "VMDetails.Resize.NoChange")
+		return
+	}
+
+	// An unchanged disk is not checked so that VMs above the disk limit can still change CPU or memory
+	checkDiskGB := 0
+	if growDisk {
+		checkDiskGB = diskGB
+	}
+	if err := h.validateVMResize(r.Context(), client, node, vmid, sockets, cores, memoryMB, checkDiskGB); err != nil {
+		ctx.Log.Warn().Err(err).Int("vmid", vmid).Msg("VM resize exceeds limits")
+		ctx.RedirectWithErrorMessage(editor, ctx.Translate("VMDetails.Resize.LimitExceeded")+" "+err.Error())
+		return
+	}
+	// The VM's current size is replaced by the new one in the owner's quota usage
+	if !ctx.IsAdmin() {
+		if err := validateUserQuota(r.Context(), client, h.stateManager.GetSettings(), ctx.GetUsername(), vmid, sockets*cores, memoryMB, diskGB); err != nil {
+			ctx.Log.Warn().Err(err).Int("vmid", vmid).Msg("VM resize exceeds user quota")
+			ctx.RedirectWithErrorMessage(editor, ctx.Translate("VMDetails.Resize.QuotaExceeded")+" "+err.Error())
+			return
+		}
+	}
+
+	audit := func(err error) {
+		recordAudit(r, state.AuditEvent{
+			Action: state.AuditActionVMResize,
+			VMID:   vmid,
+			Params: map[string]string{
+				"node":      node,
+				"sockets":   strconv.Itoa(sockets),
+				"cores":     strconv.Itoa(cores),
+				"memory":    strconv.Itoa(memoryMB),
+				"disk_size": strconv.Itoa(diskGB),
+			},
+		}, err)
+	}
+	if len(params) > 0 {
+		if err := proxmox.UpdateVMConfigWithContext(r.Context(), client, node, vmid, params); err != nil {
+			audit(err)
+			ctx.RedirectWithError(details, "VMDetails.Resize.Failed")
+			return
+		}
+	}
+	if growDisk {
+		if err := proxmox.ResizeVMDiskWithContext(r.Context(), client, node, vmid, current.DiskKey, diskGB); err != nil {
+			audit(err)
+			ctx.RedirectWithError(details, "VMDetails.Resize.Failed")
+			return
+		}
+	}
+	audit(nil)
+
+	// A running VM keeps CPU and memory changes pending unless they can be hot-plugged
+	restart := false
+	if len(params) > 0 {
+		pending, err := proxmox.GetVMPendingWithContext(r.Context(), client, node, vmid)
+		if err != nil {
+			ctx.Log.Warn().Err(err).Int("vmid", vmid).Msg("Unable to read pending changes after resize")
+		}
+		restart = needsRestart(pending)
+	}
+
+	ctx.Log.Info().Int("vmid", vmid).Int("sockets", sockets).Int("cores", cores).Int("memory_mb", memoryMB).
+		Int("disk_gb", diskGB).Bool("restart_required", restart).Msg("VM resized")
+	if restart {
+		ctx.RedirectWithWarning(details, "VMDetails.Resize.RestartRequired")
+		return
+	}
+	ctx.RedirectWithSuccess(details, "VMDetails.Resize.Applied")
+}
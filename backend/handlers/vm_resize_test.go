@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"pvmss/state"
+)
+
+func newResizeRequest(values url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/vm/resize", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestVMResizeExcludesOwnUsage(t *testing.T) {
+	settings := &state.AppSettings{
+		Limits: map[string]interface{}{
+			"nodes": map[string]interface{}{
+				"pve1": map[string]interface{}{"cores": map[string]interface{}{"min": float64(1), "max": float64(6)}},
+			},
+		},
+	}
+	sm, client := newAPITestState(settings)
+	client.responses["/nodes/pve1/qemu/100/config"] = map[string]interface{}{
+		"data": map[string]interface{}{
+			"tags": "pvmss", "sockets": 1, "cores": 4, "memory": 2048,
+			"boot": "order=scsi0", "scsi0": "local-lvm:vm-100-disk-0,size=32G",
+		},
+	}
+	client.responses["/nodes/pve1/qemu/100/pending"] = map[string]interface{}{
+		"data": []map[string]interface{}{
+			{"key": "cores", "value": 4},
+			{"key": "memory", "value": 2048, "pending": 4096},
+		},
+	}
+	h := NewVMHandler(sm)
+
+	// VMs 100 (4 cores) and 200 (2 cores) already use the whole aggregate core limit;
+	// keeping 4 cores on VM 100 must still be allowed
+	values := url.Values{"vmid": {"100"}, "node": {"pve1"}, "sockets": {"1"}, "cores": {"4"}, "memory": {"4096"}, "disk_size": {"32"}}
+	rec := serveAs(sm, "alice", false, h.VMResizeHandler, newResizeRequest(values), nil)
+	if rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "warning=1") {
+		t.Fatalf("Expected a restart warning redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if !client.called(http.MethodPost, "/nodes/pve1/qemu/100/config") {
+		t.Error("Expected the VM config to be updated")
+	}
+	if client.called(http.MethodPut, "/nodes/pve1/qemu/100/resize") {
+		t.Error("Disk must not be resized when its size is unchanged")
+	}
+
+	values.Set("cores", "5")
+	rec = serveAs(sm, "alice", false, h.VMResizeHandler, newResizeRequest(values), nil)
+	if rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "error=1") {
+		t.Fatalf("Expected a limit error redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestVMResizeDisk(t *testing.T) {
+	sm, client := newAPITestState(nil)
+	client.responses["/nodes/pve1/qemu/100/config"] = map[string]interface{}{
+		"data": map[string]interface{}{
+			"sockets": 1, "cores": 2, "memory": 2048,
+			"boot": "order=scsi0", "scsi0": "local-lvm:vm-100-disk-0,size=32G",
+		},
+	}
+	h := NewVMHandler(sm)
+
+	values := url.Values{"vmid": {"100"}, "node": {"pve1"}, "sockets": {"1"}, "cores": {"2"}, "memory": {"2048"}, "disk_size": {"16"}}
+	rec := serveAs(sm, "alice", false, h.VMResizeHandler, newResizeRequest(values), nil)
+	if !strings.Contains(rec.Header().Get("Location"), "error=1") {
+		t.Fatalf("Expected shrinking the disk to fail, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if client.called(http.MethodPut, "/nodes/pve1/qemu/100/resize") {
+		t.Fatal("Disk must not be shrunk")
+	}
+
+	values.Set("disk_size", "64")
+	rec = serveAs(sm, "alice", false, h.VMResizeHandler, newResizeRequest(values), nil)
+	if !strings.Contains(rec.Header().Get("Location"), "success=1") {
+		t.Fatalf("Expected growing the disk to succeed, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if !client.called(http.MethodPut, "/nodes/pve1/qemu/100/resize") {
+		t.Error("Expected the disk to be resized")
+	}
+	if client.called(http.MethodPost, "/nodes/pve1/qemu/100/config") {
+		t.Error("Config must not be updated when only the disk grows")
+	}
+}
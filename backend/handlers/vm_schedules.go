@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// maxDisplayedScheduleRuns bounds the run history shown on the details page
+const maxDisplayedScheduleRuns = 20
+
+// defaultScheduleTimezone is the timezone preselected on the schedule form: the TZ of the
+// server when it is a valid IANA name, UTC otherwise
+func defaultScheduleTimezone() string {
+	if tz := os.Getenv("TZ"); tz != "" {
+		if _, err := time.LoadLocation(tz); err == nil {
+			return tz
+		}
+	}
+	return "UTC"
+}
+
+// ScheduleView is a power schedule as displayed on the details page
+type ScheduleView struct {
+	ID       string
+	Action   string
+	Days     []time.Weekday
+	Time     string
+	Timezone string
+	Next     string
+}
+
+// ScheduleRunView is a schedule run as displayed on the details page
+type ScheduleRunView struct {
+	Time     string
+	Action   string
+	Result   string
+	Fallback bool
+	Error    string
+}
+
+// vmScheduleViews returns the schedules and recent runs of a VM for the details page
+func vmScheduleViews(sm VMStateManager, vmid int) ([]ScheduleView, []ScheduleRunView) {
+	store := sm.GetVMScheduleStore()
+	if store == nil {
+		return nil, nil
+	}
+	now := time.Now()
+
+	schedules := make([]ScheduleView, 0)
+	for _, schedule := range store.ListForVM(vmid) {
+		view := ScheduleView{
+			ID:       schedule.ID,
+			Action:   schedule.Action,
+			Days:     schedule.Days,
+			Time:     schedule.Time,
+			Timezone: schedule.Timezone,
+		}
+		if next, ok := schedule.NextOccurrence(now); ok {
+			view.Next = next.Local().Format("2006-01-02 15:04")
+		}
+		schedules = append(schedules, view)
+	}
+
+	runs := make([]ScheduleRunView, 0)
+	for i, run := range store.RunsForVM(vmid) {
+		if i == maxDisplayedScheduleRuns {
+			break
+		}
+		runs = append(runs, ScheduleRunView{
+			Time:     run.Time.Local().Format("2006-01-02 15:04"),
+			Action:   run.Action,
+			Result:   run.Result,
+			Fallback: run.Fallback,
+			Error:    run.Error,
+		})
+	}
+	return schedules, runs
+}
+
+// parseScheduleDays reads the checked weekdays of the schedule form, sorted Monday first
+func parseScheduleDays(values []string) []time.Weekday {
+	seen := make(map[time.Weekday]bool)
+	days := make([]time.Weekday, 0, len(values))
+	for _, v := range values {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < int(time.Sunday) || n > int(time.Saturday) || seen[time.Weekday(n)] {
+			continue
+		}
+		seen[time.Weekday(n)] = true
+		days = append(days, time.Weekday(n))
+	}
+	sort.Slice(days, func(i, j int) bool { return (days[i]+6)%7 < (days[j]+6)%7 })
+	return days
+}
+
+// scheduleOwner returns the user a schedule acts for: the session user, or for administrators
+// the owner of the VM's pool, if any
+func scheduleOwner(ctx *HandlerContext, vm *proxmox.ClusterVM) string {
+	if !ctx.IsAdmin() {
+		return ctx.GetUsername()
+	}
+	if vm == nil {
+		return ""
+	}
+	owner, _ := poolOwner(vm.Pool)
+	return owner
+}
+
+// VMScheduleCreateHandler adds a power schedule to a VM
+func (h *VMHandler) VMScheduleCreateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "VMScheduleCreateHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(r.FormValue("vmid"))
+	if err != nil || vmid <= 0 {
+		ctx.HandleError(err, "Invalid VM ID", http.StatusBadRequest)
+		return
+	}
+	details := buildVMDetailsURL(strconv.Itoa(vmid))
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.HandleError(nil, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	vms, err := proxmox.GetInventoryVMsWithContext(r.Context(), client)
+	if err != nil {
+		ctx.Log.Error().Err(err).Int("vmid", vmid).Msg("Failed to list VMs")
+		ctx.RedirectWithError(details, "VMDetails.Schedules.CreateFailed")
+		return
+	}
+	var vm *proxmox.ClusterVM
+	for i := range vms {
+		if vms[i].VMID == vmid {
+			vm = &vms[i]
+			break
+		}
+	}
+	if vm == nil {
+		ctx.HandleError(nil, "VM not found", http.StatusNotFound)
+		return
+	}
+
+	schedule := state.VMSchedule{
+		VMID:     vmid,
+		Name:     vm.Name,
+		Username: scheduleOwner(ctx, vm),
+		Action:   r.FormValue("action"),
+		Days:     parseScheduleDays(r.Form["days"]),
+		Time:     strings.TrimSpace(r.FormValue("time")),
+		Timezone: strings.TrimSpace(r.FormValue("timezone")),
+	}
+	created, err := h.stateManager.GetVMScheduleStore().Create(schedule)
+	if created != nil {
+		schedule.ID = created.ID
+	}
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMScheduleCreate,
+		VMID:   vmid,
+		Pool:   vm.Pool,
+		Params: map[string]string{
+			"schedule": schedule.ID,
+			"action":   schedule.Action,
+			"time":     schedule.Time,
+			"timezone": schedule.Timezone,
+			"owner":    schedule.Username,
+		},
+	}, err)
+	switch {
+	case errors.Is(err, state.ErrInvalidVMSchedule):
+		ctx.Log.Warn().Err(err).Int("vmid", vmid).Msg("Invalid power schedule")
+		ctx.RedirectWithError(details, "VMDetails.Schedules.Invalid")
+		return
+	case err != nil:
+		ctx.Log.Error().Err(err).Int("vmid", vmid).Msg("Failed to save power schedule")
+		ctx.RedirectWithError(details, "VMDetails.Schedules.CreateFailed")
+		return
+	}
+
+	ctx.RedirectWithSuccess(details, "VMDetails.Schedules.Created")
+}
+
+// VMScheduleDeleteHandler removes a power schedule of a VM
+func (h *VMHandler) VMScheduleDeleteHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "VMScheduleDeleteHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(r.FormValue("vmid"))
+	if err != nil || vmid <= 0 {
+		ctx.HandleError(err, "Invalid VM ID", http.StatusBadRequest)
+		return
+	}
+	details := buildVMDetailsURL(strconv.Itoa(vmid))
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.HandleError(nil, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	store := h.stateManager.GetVMScheduleStore()
+	id := r.FormValue("id")
+	schedule, ok := store.Get(id)
+	if !ok || schedule.VMID != vmid {
+		ctx.RedirectWithError(details, "VMDetails.Schedules.NotFound")
+		return
+	}
+	err = store.Delete(id)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMScheduleDelete,
+		VMID:   vmid,
+		Params: map[string]string{"schedule": id, "action": schedule.Action, "owner": schedule.Username},
+	}, err)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("schedule_id", id).Msg("Failed to delete power schedule")
+		ctx.RedirectWithError(details, "Message.ActionFailed")
+		return
+	}
+
+	ctx.RedirectWithSuccess(details, "VMDetails.Schedules.Deleted")
+}
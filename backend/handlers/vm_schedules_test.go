@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+var allWeekdays = []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+
+func TestScheduleOccurrences(t *testing.T) {
+	schedule := state.VMSchedule{Days: []time.Weekday{time.Monday, time.Friday}, Time: "20:00", Timezone: "Europe/Paris"}
+	paris, _ := time.LoadLocation("Europe/Paris")
+
+	// Wednesday 2026-10-14 12:00 in Paris
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, paris)
+	if last, ok := schedule.LastOccurrence(now); !ok || !last.Equal(time.Date(2026, 10, 12, 20, 0, 0, 0, paris)) {
+		t.Errorf("Expected Monday 20:00 as the last occurrence, got %s", last)
+	}
+	if next, ok := schedule.NextOccurrence(now); !ok || !next.Equal(time.Date(2026, 10, 16, 20, 0, 0, 0, paris)) {
+		t.Errorf("Expected Friday 20:00 as the next occurrence, got %s", next)
+	}
+
+	if err := (&state.VMSchedule{Action: "reboot", Days: allWeekdays, Time: "08:00", Timezone: "UTC"}).Validate(); err == nil {
+		t.Error("Expected an unknown action to be refused")
+	}
+	if err := (&state.VMSchedule{Action: state.ScheduleActionStart, Days: allWeekdays, Time: "08:00", Timezone: "Mars/Olympus"}).Validate(); err == nil {
+		t.Error("Expected an unknown timezone to be refused")
+	}
+}
+
+func TestParseScheduleDays(t *testing.T) {
+	got := parseScheduleDays([]string{"0", "5", "1", "x", "1", "9"})
+	want := []time.Weekday{time.Monday, time.Friday, time.Sunday}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestRunDueSchedules(t *testing.T) {
+	sm, client := newAPITestState(nil)
+	store := sm.GetVMScheduleStore()
+	proxmox.InvalidateInventory()
+
+	at := time.Now().UTC().Add(-time.Hour).Format("15:04")
+	owned, err := store.Create(state.VMSchedule{VMID: 200, Name: "bob-vm", Username: "bob", Action: state.ScheduleActionStart, Days: allWeekdays, Time: at, Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// VM 100 is in alice's pool, not bob's
+	if _, err := store.Create(state.VMSchedule{VMID: 100, Name: "alice-vm", Username: "bob", Action: state.ScheduleActionShutdown, Days: allWeekdays, Time: at, Timezone: "UTC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Creating a schedule does not run today's past occurrence
+	runDueSchedules(context.Background(), sm, time.Now())
+	if client.called(http.MethodPost, "/nodes/pve1/qemu/") {
+		t.Fatal("Expected no action right after the schedules were created")
+	}
+
+	next, _ := owned.NextOccurrence(time.Now())
+	runDueSchedules(context.Background(), sm, next.Add(time.Minute))
+	if !client.called(http.MethodPost, "/nodes/pve1/qemu/200/status/start") {
+		t.Error("Expected the owned VM to be started")
+	}
+	if client.called(http.MethodPost, "/nodes/pve1/qemu/100/status/") {
+		t.Error("Expected the VM the user no longer owns to be skipped")
+	}
+	if runs := store.RunsForVM(200); len(runs) != 1 || runs[0].Result != state.ScheduleRunOK {
+		t.Errorf("Expected a successful run, got %+v", runs)
+	}
+	if runs := store.RunsForVM(100); len(runs) != 1 || runs[0].Result != state.ScheduleRunSkipped {
+		t.Errorf("Expected a skipped run, got %+v", runs)
+	}
+
+	// An occurrence runs once
+	runDueSchedules(context.Background(), sm, next.Add(2*time.Minute))
+	if runs := store.RunsForVM(200); len(runs) != 1 {
+		t.Errorf("Expected the occurrence to run once, got %+v", runs)
+	}
+
+	// An occurrence missed by more than the window is not run late
+	runDueSchedules(context.Background(), sm, next.Add(24*time.Hour+time.Hour))
+	if runs := store.RunsForVM(200); len(runs) != 1 {
+		t.Errorf("Expected a missed occurrence not to run, got %+v", runs)
+	}
+	if schedule, _ := store.Get(owned.ID); schedule.LastRun == nil || !schedule.LastRun.Equal(next.Add(24*time.Hour)) {
+		t.Errorf("Expected the missed occurrence to be marked, got %+v", schedule.LastRun)
+	}
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/i18n"
+	"pvmss/proxmox"
+	"pvmss/state"
+)
+
+// snapshotNameRegex matches a Proxmox snapshot name (a letter followed by 1 to 39 letters, digits, _ or -)
+var snapshotNameRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{1,39}$`)
+
+// errSnapshotLimitReached is returned when a user already holds the maximum number of snapshots
+var errSnapshotLimitReached = errors.New("snapshot limit reached")
+
+// Actions of the snapshot and backup tasks in the task tracker, counted against the per-user limits
+const (
+	snapshotTaskAction = "snapshot"
+	backupTaskAction   = "backup"
+)
+
+// userLimitLocks serializes, per user, the limit checks with the submission of what they count
+var userLimitLocks = struct {
+	sync.Mutex
+	users map[string]*sync.Mutex
+}{users: make(map[string]*sync.Mutex)}
+
+// lockUserLimits holds the limit lock of username until the returned function is called, so two
+// requests of the same user cannot both pass a check before either is counted
+func lockUserLimits(username string) (unlock func()) {
+	userLimitLocks.Lock()
+	mu, ok := userLimitLocks.users[username]
+	if !ok {
+		mu = &sync.Mutex{}
+		userLimitLocks.users[username] = mu
+	}
+	userLimitLocks.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// SnapshotView is a VM snapshot as displayed on the details page
+type SnapshotView struct {
+	Name        string
+	Description string
+	CreatedAt   string
+	WithRAM     bool
+}
+
+// perUserLimit returns the max_per_user value of a limits entry such as "snapshots" or "backups".
+// limited is false when no limit is configured; a limit of 0 disables the feature.
+func perUserLimit(settings *state.AppSettings, entity string) (max int, limited bool) {
+	if settings == nil || settings.Limits == nil {
+		return 0, false
+	}
+	raw, ok := settings.Limits[entity].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	switch v := raw["max_per_user"].(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	}
+	return 0, false
+}
+
+// countUserSnapshots counts the snapshots of all VMs in a user's pool, read past the cache, and the
+// snapshot tasks of the user still running, whose snapshots may not be listed yet
+func countUserSnapshots(ctx context.Context, client proxmox.ClientInterface, username string) (int, error) {
+	members, err := getPoolVMNodes(ctx, client, userPoolName(username), false)
+	if err != nil {
+		return 0, err
+	}
+	total := proxmox.CountRunningTasks(snapshotTaskAction, username)
+	for vmid, node := range members {
+		proxmox.InvalidateVMSnapshots(client, node, vmid)
+		snapshots, err := proxmox.GetVMSnapshotsWithContext(ctx, client, node, vmid)
+		if err != nil {
+			return 0, err
+		}
+		total += len(snapshots)
+	}
+	return total, nil
+}
+
+// checkSnapshotLimit returns errSnapshotLimitReached when username cannot take another snapshot.
+// Admins are not limited.
+func checkSnapshotLimit(ctx context.Context, client proxmox.ClientInterface, settings *state.AppSettings, username string, isAdmin bool) error {
+	if isAdmin {
+		return nil
+	}
+	max, limited := perUserLimit(settings, "snapshots")
+	if !limited {
+		return nil
+	}
+	count, err := countUserSnapshots(ctx, client, username)
+	if err != nil {
+		return err
+	}
+	if count >= max {
+		return errSnapshotLimitReached
+	}
+	return nil
+}
+
+// listSnapshotViews returns the snapshots of a VM formatted for the details template
+func listSnapshotViews(ctx context.Context, client proxmox.ClientInterface, node string, vmid int) ([]SnapshotView, error) {
+	snapshots, err := proxmox.GetVMSnapshotsWithContext(ctx, client, node, vmid)
+	if err != nil {
+		return nil, err
+	}
+	views := make([]SnapshotView, 0, len(snapshots))
+	for _, s := range snapshots {
+		view := SnapshotView{Name: s.Name, Description: s.Description, WithRAM: s.VMState == 1}
+		if s.SnapTime > 0 {
+			view.CreatedAt = time.Unix(s.SnapTime, 0).Local().Format("2006-01-02 15:04")
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// parseSnapshotForm reads and validates the vmid, node and snapshot name fields of a snapshot form
+func parseSnapshotForm(r *http.Request) (vmid int, node, name string, err error) {
+	vmid, err = strconv.Atoi(r.FormValue("vmid"))
+	if err != nil || vmid <= 0 {
+		return 0, "", "", fmt.Errorf("invalid VM ID")
+	}
+	node = strings.TrimSpace(r.FormValue("node"))
+	if node == "" {
+		return 0, "", "", fmt.Errorf("missing node")
+	}
+	name = strings.TrimSpace(r.FormValue("snapname"))
+	if !snapshotNameRegex.MatchString(name) {
+		return 0, "", "", fmt.Errorf("invalid snapshot name")
+	}
+	return vmid, node, name, nil
+}
+
+// VMSnapshotCreateHandler takes a snapshot of a VM
+func (h *VMHandler) VMSnapshotCreateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := NewHandlerContext(w, r, "VMSnapshotCreateHandler")
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, node, name, err := parseSnapshotForm(r)
+	if err != nil {
+		ctx.Log.Warn().Err(err).Msg("Invalid snapshot form")
+		if vmid > 0 {
+			ctx.RedirectWithError("/vm/details/"+strconv.Itoa(vmid), "VMDetails.Snapshots.InvalidName")
+			return
+		}
+		localizer := i18n.GetLocalizerFromRequest(r)
+		http.Error(w, i18n.Localize(localizer, "Error.Generic"), http.StatusBadRequest)
+		return
+	}
+	details := "/vm/details/" + strconv.Itoa(vmid)
+	description := strings.TrimSpace(r.FormValue("description"))
+	includeRAM := r.FormValue("vmstate") == "1"
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.Log.Error().Msg("Proxmox client not available")
+		http.Error(w, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	username := ctx.GetUsername()
+	unlock := lockUserLimits(username)
+	defer unlock()
+	if err := checkSnapshotLimit(r.Context(), client, h.stateManager.GetSettings(), username, ctx.IsAdmin()); err != nil {
+		if errors.Is(err, errSnapshotLimitReached) {
+			ctx.RedirectWithError(details, "VMDetails.Snapshots.LimitReached")
+			return
+		}
+		ctx.Log.Error().Err(err).Msg("Unable to count user snapshots")
+		ctx.RedirectWithError(details, "VMDetails.Snapshots.CreateFailed")
+		return
+	}
+
+	upid, err := proxmox.CreateVMSnapshotWithContext(r.Context(), client, node, vmid, name, description, includeRAM)
+	recordAudit(r, state.AuditEvent{
+		Action: state.AuditActionVMSnapshotCreate,
+		VMID:   vmid,
+		Params: map[string]string{"node": node, "snapshot": name, "vmstate": strconv.FormatBool(includeRAM)},
+		UPID:   upid,
+	}, err)
+	if err != nil {
+		ctx.RedirectWithError(details, "VMDetails.Snapshots.CreateFailed")
+		return
+	}
+	proxmox.TrackTask(client, upid, vmid, snapshotTaskAction, username)
+
+	ctx.Log.Info().Int("vmid", vmid).Str("snapshot", name).Bool("vmstate", includeRAM).Str("upid", upid).Msg("VM snapshot requested")
+	ctx.RedirectWithSuccess(details, "VMDetails.Snapshots.Created")
+}
+
+// VMSnapshotRollbackConfirmHandler shows a confirmation page before rolling a VM back to a snapshot
+func (h *VMHandler) VMSnapshotRollbackConfirmHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	log := CreateHandlerLogger("VMSnapshotRollbackConfirmHandler", r)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodGet) {
+		return
+	}
+
+	vmid, err := strconv.Atoi(ps.ByName("vmid"))
+	if err != nil {
+		http.Error(w, "Invalid VM ID", http.StatusBadRequest)
+		return
+	}
+	name := ps.ByName("snapname")
+	if !snapshotNameRegex.MatchString(name) {
+		http.Error(w, "Invalid snapshot name", http.StatusBadRequest)
+		return
+	}
+
+	stateManager := getStateManager(r)
+	client := stateManager.GetProxmoxClient()
+	if client == nil {
+		log.Error().Msg("Proxmox client not available")
+		http.Error(w, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	vms, err := proxmox.GetVMsWithContext(r.Context(), client)
+	if err != nil {
+		log.Error().Err(err).Int("vmid", vmid).Msg("Failed to get VMs")
+		http.Error(w, "Failed to get VMs", http.StatusInternalServerError)
+		return
+	}
+	vm := findVMByID(vms, vmid)
+	if vm == nil {
+		http.Error(w, "VM not found", http.StatusNotFound)
+		return
+	}
+
+	snapshots, err := listSnapshotViews(r.Context(), client, vm.Node, vmid)
+	if err != nil {
+		log.Error().Err(err).Int("vmid", vmid).Msg("Failed to list snapshots")
+		http.Error(w, "Failed to list snapshots", http.StatusBadGateway)
+		return
+	}
+	var snapshot *SnapshotView
+	for i := range snapshots {
+		if snapshots[i].Name == name {
+			snapshot = &snapshots[i]
+			break
+		}
+	}
+	if snapshot == nil {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+
+	handlerCtx := NewHandlerContext(w, r, "VMSnapshotRollbackConfirmHandler")
+	csrfToken, _ := handlerCtx.GetCSRFToken()
+
+	custom := map[string]interface{}{
+		"VM":        vm,
+		"Snapshot":  snapshot,
+		"CSRFToken": csrfToken,
+	}
+
+	th := NewTemplateHelpers()
+	th.RenderUserPage(w, r, "vm_snapshot_rollback_confirm", "Confirm Snapshot Rollback", stateManager, custom)
+}
+
+// VMSnapshotRollbackHandler rolls a VM back to a snapshot
+func (h *VMHandler) VMSnapshotRollbackHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	h.snapshotAction(w, r, "VMSnapshotRollbackHandler", state.AuditActionVMSnapshotRollback, "VMDetails.Snapshots.RolledBack", "VMDetails.Snapshots.RollbackFailed",
+		func(ctx context.Context, client proxmox.ClientInterface, node string, vmid int, name string) (string, error) {
+			return proxmox.RollbackVMSnapshotWithContext(ctx, client, node, vmid, name)
+		})
+}
+
+// VMSnapshotDeleteHandler deletes a snapshot of a VM
+func (h *VMHandler) VMSnapshotDeleteHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	h.snapshotAction(w, r, "VMSnapshotDeleteHandler", state.AuditActionVMSnapshotDelete, "VMDetails.Snapshots.Deleted", "VMDetails.Snapshots.DeleteFailed",
+		proxmox.DeleteVMSnapshotWithContext)
+}
+
+// snapshotAction runs a rollback or delete on a snapshot of an owned VM, records it as auditAction
+// and redirects to the details page
+func (h *VMHandler) snapshotAction(w http.ResponseWriter, r *http.Request, name, auditAction, successKey, errorKey string,
+	action func(ctx context.Context, client proxmox.ClientInterface, node string, vmid int, name string) (string, error)) {
+	ctx := NewHandlerContext(w, r, name)
+
+	if !ValidateMethodAndParseForm(w, r, http.MethodPost) {
+		return
+	}
+
+	vmid, node, snapname, err := parseSnapshotForm(r)
+	if err != nil {
+		ctx.Log.Warn().Err(err).Msg("Invalid snapshot form")
+		localizer := i18n.GetLocalizerFromRequest(r)
+		http.Error(w, i18n.Localize(localizer, "Error.Generic"), http.StatusBadRequest)
+		return
+	}
+	details := "/vm/details/" + strconv.Itoa(vmid)
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		ctx.Log.Error().Msg("Proxmox client not available")
+		http.Error(w, "Proxmox client not available", http.StatusInternalServerError)
+		return
+	}
+
+	if !requireVMOwnership(w, r, client, vmid) {
+		return
+	}
+
+	upid, err := action(r.Context(), client, node, vmid, snapname)
+	recordAudit(r, state.AuditEvent{
+		Action: auditAction,
+		VMID:   vmid,
+		Params: map[string]string{"node": node, "snapshot": snapname},
+		UPID:   upid,
+	}, err)
+	if err != nil {
+		ctx.RedirectWithError(details, errorKey)
+		return
+	}
+
+	ctx.Log.Info().Int("vmid", vmid).Str("snapshot", snapname).Str("upid", upid).Msg("VM snapshot action requested")
+	ctx.RedirectWithSuccess(details, successKey)
+}
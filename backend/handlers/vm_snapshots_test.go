@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"pvmss/state"
+)
+
+func TestVMSnapshotCreateLimit(t *testing.T) {
+	settings := &state.AppSettings{
+		Limits: map[string]interface{}{"snapshots": map[string]interface{}{"max_per_user": float64(1)}},
+	}
+	sm, client := newAPITestState(settings)
+	client.responses["/nodes/pve1/qemu/100/snapshot"] = map[string]interface{}{
+		"data": []map[string]interface{}{
+			{"name": "first", "snaptime": 1700000000},
+			{"name": "current", "parent": "first"},
+		},
+	}
+	h := NewVMHandler(sm)
+
+	newRequest := func() *http.Request {
+		values := url.Values{"vmid": {"100"}, "node": {"pve1"}, "snapname": {"second"}}
+		req := httptest.NewRequest(http.MethodPost, "/vm/snapshot/create", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+
+	rec := serveAs(sm, "alice", false, h.VMSnapshotCreateHandler, newRequest(), nil)
+	if rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "error=1") {
+		t.Fatalf("Expected an error redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if client.called(http.MethodPost, "/nodes/pve1/qemu/100/snapshot") {
+		t.Error("Snapshot must not be created once the user limit is reached")
+	}
+
+	rec = serveAs(sm, "admin", true, h.VMSnapshotCreateHandler, newRequest(), nil)
+	if rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "success=1") {
+		t.Fatalf("Expected a success redirect for an admin, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if !client.called(http.MethodPost, "/nodes/pve1/qemu/100/snapshot") {
+		t.Error("Expected the snapshot to be created for an admin")
+	}
+}
+
+func TestVMSnapshotCreateLimitCountsRunningTasks(t *testing.T) {
+	settings := &state.AppSettings{
+		Limits: map[string]interface{}{"snapshots": map[string]interface{}{"max_per_user": float64(2)}},
+	}
+	sm, client := newAPITestState(settings)
+	client.responses["/nodes/pve1/qemu/200/snapshot"] = map[string]interface{}{
+		"data": []map[string]interface{}{{"name": "first", "snaptime": 1700000000}},
+	}
+	h := NewVMHandler(sm)
+
+	create := func(name string) *httptest.ResponseRecorder {
+		values := url.Values{"vmid": {"200"}, "node": {"pve1"}, "snapname": {name}}
+		req := httptest.NewRequest(http.MethodPost, "/vm/snapshot/create", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serveAs(sm, "bob", false, h.VMSnapshotCreateHandler, req, nil)
+	}
+
+	if rec := create("second"); !strings.Contains(rec.Header().Get("Location"), "success=1") {
+		t.Fatalf("Expected the second snapshot to be allowed, got %s", rec.Header().Get("Location"))
+	}
+	// The second snapshot task has not finished, so it is not listed yet but still counts
+	if rec := create("third"); !strings.Contains(rec.Header().Get("Location"), "error=1") {
+		t.Fatalf("Expected the third snapshot to be refused while the second one runs, got %s", rec.Header().Get("Location"))
+	}
+}
+
+func TestPerUserLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		limits      map[string]interface{}
+		wantMax     int
+		wantLimited bool
+	}{
+		{"unset", nil, 0, false},
+		{"from json", map[string]interface{}{"snapshots": map[string]interface{}{"max_per_user": float64(5)}}, 5, true},
+		{"from form", map[string]interface{}{"snapshots": map[string]interface{}{"max_per_user": 0}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			max, limited := perUserLimit(&state.AppSettings{Limits: tt.limits}, "snapshots")
+			if max != tt.wantMax || limited != tt.wantLimited {
+				t.Errorf("perUserLimit() = %d, %v, want %d, %v", max, limited, tt.wantMax, tt.wantLimited)
+			}
+		})
+	}
+}
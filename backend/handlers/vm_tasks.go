@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/constants"
+	"pvmss/proxmox"
+)
+
+// TaskView is a tracked Proxmox task as displayed in the recent tasks panel
+type TaskView struct {
+	UPID       string `json:"upid"`
+	Action     string `json:"action"`
+	User       string `json:"user,omitempty"`
+	StartedAt  string `json:"started_at"`
+	Duration   string `json:"duration,omitempty"`
+	State      string `json:"state"` // running, ok, failed or unknown
+	ExitStatus string `json:"exitstatus,omitempty"`
+}
+
+// toTaskView formats a tracked task for the details page and the tasks endpoint
+func toTaskView(t proxmox.TrackedTask) TaskView {
+	view := TaskView{
+		UPID:       t.UPID,
+		Action:     t.Action,
+		User:       t.User,
+		StartedAt:  t.StartedAt.Local().Format("2006-01-02 15:04:05"),
+		ExitStatus: t.ExitStatus,
+	}
+	switch {
+	case t.Running():
+		view.State = "running"
+	case t.Succeeded():
+		view.State = "ok"
+	case t.Status == proxmox.TaskStatusUnknown:
+		view.State = "unknown"
+	default:
+		view.State = "failed"
+	}
+	if !t.EndedAt.IsZero() {
+		view.Duration = t.EndedAt.Sub(t.StartedAt).Round(time.Second).String()
+	}
+	return view
+}
+
+// recentTaskViews returns the recent tasks of a VM, most recent first
+func recentTaskViews(vmid int) []TaskView {
+	tasks := proxmox.RecentVMTasks(vmid)
+	views := make([]TaskView, 0, len(tasks))
+	for _, t := range tasks {
+		views = append(views, toTaskView(t))
+	}
+	return views
+}
+
+// VMTasksHandler handles GET /api/vm/tasks/:vmid and returns the recent tasks of a VM.
+// With ?upid=... it also returns the log lines of that task, which must belong to the VM.
+func (h *VMHandler) VMTasksHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	log := CreateHandlerLogger("VMTasksHandler", r)
+
+	vmid, err := strconv.Atoi(ps.ByName("vmid"))
+	if err != nil || vmid <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_vmid", "Invalid VM ID")
+		return
+	}
+
+	client := h.stateManager.GetProxmoxClient()
+	if client == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "proxmox_unavailable", "Proxmox client not available")
+		return
+	}
+
+	if err := authorizeVMAccess(r, client, vmid); err != nil {
+		if errors.Is(err, ErrVMAccessDenied) {
+			writeAPIError(w, http.StatusForbidden, "forbidden", LocalizeErrorWithFallback(r, "Error.VMAccessDenied", "You do not have access to this virtual machine."))
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	data := map[string]interface{}{"tasks": recentTaskViews(vmid)}
+
+	if upid := r.URL.Query().Get("upid"); upid != "" {
+		task, ok := proxmox.GetTrackedTask(upid)
+		if !ok || task.VMID != vmid {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Task not found")
+			return
+		}
+		lines, err := proxmox.GetTaskLogWithContext(r.Context(), client, task.Node, upid, 0, constants.TaskLogLimit)
+		if err != nil {
+			log.Warn().Err(err).Str("upid", upid).Msg("Unable to read task log")
+			writeAPIError(w, http.StatusBadGateway, "proxmox_error", "Unable to read the task log")
+			return
+		}
+		text := make([]string, 0, len(lines))
+		for _, line := range lines {
+			text = append(text, line.T)
+		}
+		data["task"] = toTaskView(task)
+		data["log"] = text
+	}
+
+	writeAPIJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"pvmss/proxmox"
+)
+
+func TestVMTasksHandler(t *testing.T) {
+	sm, client := newAPITestState(nil)
+	h := NewVMHandler(sm)
+
+	const upid = "UPID:pve1:00000A01:00000001:00000001:qmstart:100:alice@pve:"
+	client.responses["/nodes/pve1/tasks/"+url.PathEscape(upid)+"/log?start=0&limit=200"] = map[string]interface{}{
+		"data": []map[string]interface{}{{"n": 1, "t": "starting VM"}, {"n": 2, "t": "TASK OK"}},
+	}
+	if _, err := proxmox.TrackAndWaitTask(t.Context(), client, upid, 100, "start", "alice"); err != nil {
+		t.Fatalf("TrackAndWaitTask() error = %v", err)
+	}
+
+	get := func(username string, vmid, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/vm/tasks/"+vmid+query, nil)
+		return serveAs(sm, username, false, h.VMTasksHandler, req, httprouter.Params{{Key: "vmid", Value: vmid}})
+	}
+
+	rec := get("alice", "100", "?upid="+url.QueryEscape(upid))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Data struct {
+			Tasks []TaskView `json:"tasks"`
+			Log   []string   `json:"log"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(body.Data.Tasks) == 0 || body.Data.Tasks[0].UPID != upid || body.Data.Tasks[0].State != "ok" {
+		t.Errorf("Expected the finished start task, got %+v", body.Data.Tasks)
+	}
+	if strings.Join(body.Data.Log, "\n") != "starting VM\nTASK OK" {
+		t.Errorf("Unexpected task log: %v", body.Data.Log)
+	}
+
+	if rec := get("bob", "100", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a non-owner to get %d, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	// The task belongs to VM 100, so it cannot be read through bob's VM
+	if rec := get("bob", "200", "?upid="+url.QueryEscape(upid)); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a task of another VM to be hidden, got %d", rec.Code)
+	}
+}
+
+func TestDestroyVMWaitsForTasks(t *testing.T) {
+	client := newFakeProxmoxClient()
+
+	if _, err := destroyVM(t.Context(), client, "pve1", 300, "alice"); err != nil {
+		t.Fatalf("destroyVM() error = %v", err)
+	}
+	if !client.called(http.MethodGet, "/nodes/pve1/tasks/") {
+		t.Error("Expected destroyVM to poll the stop task status")
+	}
+	if !client.called(http.MethodDelete, "/nodes/pve1/qemu/300") {
+		t.Error("Expected the VM to be deleted once stopped")
+	}
+
+	// The VMID can be reused by another owner: the deleted VM's tasks must not outlive it
+	if tasks := proxmox.RecentVMTasks(300); len(tasks) != 0 {
+		t.Errorf("Expected the tasks of the deleted VM to be dropped, got %+v", tasks)
+	}
+}
@@ -83,7 +83,9 @@ func (h *VMBRHandler) ToggleVMBRHandler(w http.ResponseWriter, r *http.Request,
 	}
 
 	if changed {
-		if err := h.stateManager.SetSettings(settings); err != nil {
+		err := h.stateManager.SetSettings(settings)
+		auditSettings(r, "vmbrs", map[string]string{"vmbr": name, "action": action}, err)
+		if err != nil {
 			log.Error().Err(err).Msg("Failed to update settings")
 			http.Error(w, "Failed to update settings", http.StatusInternalServerError)
 			return
@@ -182,7 +184,7 @@ func (h *VMBRHandler) RegisterRoutes(router *httprouter.Router) {
 	routeHelpers := NewAdminPageRoutes()
 
 	// Register admin VMBR routes using helper
-	routeHelpers.RegisterCRUDRoutes(router, "/admin/vmbr", map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
+	routeHelpers.RegisterCRUDRoutes(router, "/admin/vmbr", PermissionManage, map[string]func(w http.ResponseWriter, r *http.Request, ps httprouter.Params){
 		"page":   h.VMBRPageHandler,
 		"toggle": h.ToggleVMBRHandler,
 	})
@@ -10,20 +10,25 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// Init initializes the logger with the specified log level
-func Init(level string) {
+// Init initializes the logger with the specified log level and format: "json" writes one JSON
+// object per line for log pipelines, anything else the human-friendly console format
+func Init(level, format string) {
 	// Set time format
 	zerolog.TimeFieldFormat = time.RFC3339Nano
 
-	// Configure console writer for human-friendly output
-	output := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
-		TimeFormat: "2006-01-02 15:04:05",
+	// Set the global logger, defaulting to the console format if the format is unknown.
+	formatIn := format
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case "json":
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	default:
+		// Configure console writer for human-friendly output
+		log.Logger = log.Output(zerolog.ConsoleWriter{
+			Out:        os.Stdout,
+			TimeFormat: "2006-01-02 15:04:05",
+		})
 	}
 
-	// Set the global logger
-	log.Logger = log.Output(output)
-
 	// Set log level, defaulting to InfoLevel if parsing fails.
 	lvl, err := zerolog.ParseLevel(strings.ToLower(level))
 	if err != nil {
@@ -31,9 +36,16 @@ func Init(level string) {
 		log.Warn().Str("log_level_in", level).Msg("Invalid log level, defaulting to 'info'")
 	}
 	zerolog.SetGlobalLevel(lvl)
+	if format != "json" && format != "console" && format != "" {
+		log.Warn().Str("log_format_in", formatIn).Msg("Invalid log format, defaulting to 'console'")
+	}
+	if format != "json" {
+		format = "console"
+	}
 
 	log.Info().
 		Str("level", zerolog.GlobalLevel().String()).
+		Str("format", format).
 		Msg("Logger initialized")
 }
 
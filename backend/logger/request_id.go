@@ -0,0 +1,21 @@
+package logger
+
+import "context"
+
+// RequestIDHeader carries the ID correlating the log lines of a request, from the client to
+// PVMSS and from PVMSS to Proxmox
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is an unexported type for the context key to avoid collisions
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, "" if none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -19,10 +19,12 @@ import (
 	"pvmss/handlers"
 	"pvmss/i18n"
 	"pvmss/logger"
+	"pvmss/middleware"
 	"pvmss/proxmox"
 	"pvmss/security"
 	"pvmss/state"
 	"pvmss/templates"
+	"pvmss/tracing"
 )
 
 func main() {
@@ -35,6 +37,21 @@ func main() {
 		logger.Get().Warn().Msg("No .env file found, using environment variables")
 	}
 
+	// Export traces to the OpenTelemetry collector, when one is set
+	traceExporter, err := tracing.NewOTLPExporterFromEnv()
+	if err != nil {
+		logger.Get().Fatal().Err(err).Msg("Failed to start trace export")
+	}
+	if traceExporter != nil {
+		tracing.SetExporter(traceExporter)
+		logger.Get().Info().Msg("Exporting traces over OTLP")
+	}
+
+	// Only the reverse proxies listed may forward the client IP used by sessions, audit and rate limits
+	if err := middleware.SetTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		logger.Get().Fatal().Err(err).Msg("Invalid TRUSTED_PROXIES")
+	}
+
 	if err := initializeApp(stateManager); err != nil {
 		logger.Get().Fatal().Err(err).Msg("Failed to initialize application")
 	}
@@ -51,6 +68,21 @@ func main() {
 	// Register guest agent cache cleanup function
 	stateManager.SetGuestAgentCleanupFunc(handlers.CleanExpiredGuestAgentCache)
 
+	// Stop and delete VMs whose lease expired
+	handlers.StartLeaseReaper(stateManager)
+
+	// Run the VM power schedules
+	handlers.StartPowerScheduler(stateManager)
+
+	// Drop audit events past their retention
+	handlers.StartAuditPruner(stateManager)
+
+	// Forward audit events to the syslog collector, when one is set
+	auditSyslog, err := handlers.StartAuditSyslog(stateManager)
+	if err != nil {
+		logger.Get().Fatal().Err(err).Msg("Failed to start syslog forwarding")
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = constants.DefaultPort
@@ -87,6 +119,15 @@ func main() {
 	} else {
 		logger.Get().Info().Msg("Server shutdown complete")
 	}
+
+	if auditSyslog != nil {
+		_ = auditSyslog.Close()
+	}
+	if traceExporter != nil {
+		if err := traceExporter.Shutdown(ctx); err != nil {
+			logger.Get().Warn().Err(err).Msg("Failed to export the last traces")
+		}
+	}
 }
 
 func initLogger() {
@@ -94,7 +135,7 @@ func initLogger() {
 	if level == "" {
 		level = constants.DefaultLogLevel
 	}
-	logger.Init(level)
+	logger.Init(level, os.Getenv("LOG_FORMAT"))
 }
 
 func initializeApp(stateManager state.StateManager) error {
@@ -114,15 +155,20 @@ func initializeApp(stateManager state.StateManager) error {
 			return fmt.Errorf("failed to initialize Proxmox client: %w", err)
 		}
 
-		if err := stateManager.SetProxmoxClient(proxmoxClient); err != nil {
-			return fmt.Errorf("failed to set Proxmox client: %w", err)
-		}
-
-		if connected := stateManager.CheckProxmoxConnection(); !connected {
-			_, errorMsg := stateManager.GetProxmoxStatus()
-			logger.Get().Warn().
-				Str("error", errorMsg).
-				Msg("Proxmox server not reachable, starting in read-only mode")
+		if proxmoxClient == nil {
+			// No client in test mode: avoid wrapping a nil *proxmox.Client in the interface
+			stateManager.SetOfflineMode()
+		} else {
+			if err := stateManager.SetProxmoxClient(proxmoxClient); err != nil {
+				return fmt.Errorf("failed to set Proxmox client: %w", err)
+			}
+
+			if connected := stateManager.CheckProxmoxConnection(); !connected {
+				_, errorMsg := stateManager.GetProxmoxStatus()
+				logger.Get().Warn().
+					Str("error", errorMsg).
+					Msg("Proxmox server not reachable, starting in read-only mode")
+			}
 		}
 	}
 
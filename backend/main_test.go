@@ -10,6 +10,11 @@ import (
 )
 
 func TestMain(m *testing.M) {
+	// Security initialization requires a session secret
+	if os.Getenv("SESSION_SECRET") == "" {
+		_ = os.Setenv("SESSION_SECRET", "pvmss-test-session-secret-0123456789")
+	}
+
 	// Run the main application in a goroutine
 	go main()
 
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// contentType is the media type of the Prometheus text format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the metrics of r. When token is not empty, scrapes must send it as a bearer token.
+func Handler(r *Registry, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" {
+			got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		var buf bytes.Buffer
+		if _, err := r.WriteTo(&buf); err != nil {
+			http.Error(w, "Failed to collect metrics", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("app_requests_total", "Requests served.\nBy route.", "method", "route")
+	requests.Inc("GET", "/vm/details/:vmid")
+	requests.Add(2, "POST", `/say "hi"`)
+	up := r.NewGaugeVec("app_up", "Whether the backend answers.")
+	up.Set(1)
+	r.NewGaugeFunc("app_sessions", "Signed-in sessions.", func() float64 { return 3 })
+	latency := r.NewHistogramVec("app_latency_seconds", "Latency.", []float64{0.5, 0.1}, "route")
+	latency.Observe(0.05, "/")
+	latency.Observe(0.3, "/")
+	latency.Observe(2, "/")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP app_latency_seconds Latency.
+# TYPE app_latency_seconds histogram
+app_latency_seconds_bucket{route="/",le="0.1"} 1
+app_latency_seconds_bucket{route="/",le="0.5"} 2
+app_latency_seconds_bucket{route="/",le="+Inf"} 3
+app_latency_seconds_sum{route="/"} 2.35
+app_latency_seconds_count{route="/"} 3
+# HELP app_requests_total Requests served.\nBy route.
+# TYPE app_requests_total counter
+app_requests_total{method="GET",route="/vm/details/:vmid"} 1
+app_requests_total{method="POST",route="/say \"hi\""} 2
+# HELP app_sessions Signed-in sessions.
+# TYPE app_sessions gauge
+app_sessions 3
+# HELP app_up Whether the backend answers.
+# TYPE app_up gauge
+app_up 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryRefusesMisuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("app_total", "Total.", "route")
+
+	expectPanic := func(what string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected %s to panic", what)
+			}
+		}()
+		fn()
+	}
+	expectPanic("a second registration", func() { r.NewGaugeVec("app_total", "Again.") })
+	expectPanic("missing label values", func() { c.Inc() })
+	expectPanic("a negative counter increment", func() { c.Add(-1, "/") })
+}
+
+func TestHandlerToken(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeVec("app_up", "Up.").Set(1)
+	h := Handler(r, "s3cret")
+
+	for _, tc := range []struct {
+		auth string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("Authorization %q: expected %d, got %d", tc.auth, tc.code, rec.Code)
+		}
+		if rec.Code == http.StatusOK && (!strings.Contains(rec.Body.String(), "app_up 1") || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4")) {
+			t.Errorf("Expected the metrics in the text format, got %q", rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	Handler(r, "").ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST to be refused, got %d", rec.Code)
+	}
+}
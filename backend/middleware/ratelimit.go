@@ -6,8 +6,13 @@ import (
 	"time"
 
 	"pvmss/logger"
+	"pvmss/metrics"
 )
 
+// rateLimitRejections exports the rejects of the buckets, by rule
+var rateLimitRejections = metrics.NewCounterVec("pvmss_rate_limit_rejections_total",
+	"Requests refused by the rate limiter, by route.", "route")
+
 // Package middleware provides rate-limiting functionality using an in-memory token bucket algorithm.
 // This implementation is designed for single-instance deployments and is not distributed.
 
@@ -104,6 +109,7 @@ func (l *Limiter) Allow(method, path, ip string) bool {
 
 	// Track rejection for monitoring
 	bk.rejects++
+	rateLimitRejections.Inc(key)
 	return false
 }
 
@@ -150,7 +156,7 @@ func (l *Limiter) GetStats() map[string]interface{} {
 func RateLimitMiddleware(limiter *Limiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := clientIP(r)
+			ip := ClientIP(r)
 			if !limiter.Allow(r.Method, r.URL.Path, ip) {
 				logger.Get().Warn().
 					Str("ip", ip).
@@ -1,29 +1,95 @@
 package middleware
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"pvmss/logger"
 )
 
-// clientIP extracts the client's IP address from the request, respecting common proxy headers.
-func clientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// X-Forwarded-For can be a comma-separated list of IPs. The first one is the original client.
-		if parts := strings.Split(xff, ","); len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
+// trustedProxies are the networks whose X-Forwarded-For and X-Real-IP headers are believed
+var (
+	trustedProxiesMu sync.RWMutex
+	trustedProxies   []netip.Prefix
+)
+
+// SetTrustedProxies sets the reverse proxies allowed to forward the client IP, as a comma-separated
+// list of IP addresses and CIDR networks (the TRUSTED_PROXIES variable). An empty list trusts none.
+func SetTrustedProxies(list string) error {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
 		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
 	}
-	if xr := r.Header.Get("X-Real-IP"); xr != "" {
-		return xr
+
+	trustedProxiesMu.Lock()
+	defer trustedProxiesMu.Unlock()
+	trustedProxies = prefixes
+	return nil
+}
+
+// isTrustedProxy reports whether ip belongs to one of the trusted proxy networks
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
 	}
+	addr = addr.Unmap()
 
+	trustedProxiesMu.RLock()
+	defer trustedProxiesMu.RUnlock()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP extracts the client's IP address from the request. The X-Forwarded-For and X-Real-IP
+// headers are only honored when the connection comes from a trusted proxy, since clients can set them.
+func ClientIP(r *http.Request) string {
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return r.RemoteAddr // Fallback to RemoteAddr if parsing fails.
+		host = r.RemoteAddr // Fallback to RemoteAddr if parsing fails.
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		// Each proxy appends the address it received the request from: the client is the
+		// rightmost address that is not one of our proxies
+		parts := strings.Split(xff, ",")
+		for i := len(parts) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(parts[i])
+			if i == 0 || !isTrustedProxy(ip) {
+				return ip
+			}
+		}
+	}
+	if xr := strings.TrimSpace(r.Header.Get("X-Real-IP")); xr != "" {
+		return xr
 	}
 	return host
 }
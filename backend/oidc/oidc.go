@@ -0,0 +1,526 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE used to sign
+// users in through an external identity provider. It only depends on the standard library:
+// discovery, JWKS retrieval and ID token verification (RS256 and ES256) are done here.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"pvmss/logger"
+)
+
+const (
+	// clockSkew is the tolerance applied to the exp and iat claims
+	clockSkew = 2 * time.Minute
+	// jwksRefreshInterval bounds how often an unknown key ID triggers a JWKS refetch
+	jwksRefreshInterval = time.Minute
+	// maxResponseSize bounds the discovery, JWKS and token responses
+	maxResponseSize = 1 << 20
+)
+
+var (
+	// ErrInvalidToken is returned when the ID token signature or claims do not verify
+	ErrInvalidToken = errors.New("invalid id token")
+	// ErrInvalidUsername is returned when the username claim is missing or not a valid Proxmox user name
+	ErrInvalidUsername = errors.New("invalid username claim")
+	// ErrInvalidSubject is returned when the sub claim is missing or malformed
+	ErrInvalidSubject = errors.New("invalid subject claim")
+
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+	// subjectPattern is the printable ASCII identifier of at most 255 characters the spec allows
+	subjectPattern = regexp.MustCompile(`^[\x21-\x7e]{1,255}$`)
+)
+
+// Config holds the identity provider settings
+type Config struct {
+	IssuerURL      string
+	ClientID       string
+	ClientSecret   string // empty for public clients
+	RedirectURL    string
+	Scopes         []string
+	UsernameClaim  string
+	AdminClaim     string   // claim holding the groups or roles, e.g. groups
+	AdminValues    []string // values of AdminClaim that grant the full administrator role
+	HelpdeskValues []string // values of AdminClaim that grant the helpdesk role
+	AuditorValues  []string // values of AdminClaim that grant the auditor role
+}
+
+// Administrator roles granted through AdminClaim, named as the roles of the administrator accounts
+const (
+	AdminRoleFull     = "full"
+	AdminRoleHelpdesk = "helpdesk"
+	AdminRoleAuditor  = "auditor"
+)
+
+// ConfigFromEnv reads the OIDC_* environment variables. It returns nil when OIDC_ISSUER_URL,
+// OIDC_CLIENT_ID or OIDC_REDIRECT_URL is not set, which disables OIDC login.
+func ConfigFromEnv() *Config {
+	cfg := &Config{
+		IssuerURL:      strings.TrimSpace(os.Getenv("OIDC_ISSUER_URL")),
+		ClientID:       strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID")),
+		ClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:    strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL")),
+		Scopes:         strings.Fields(os.Getenv("OIDC_SCOPES")),
+		UsernameClaim:  strings.TrimSpace(os.Getenv("OIDC_USERNAME_CLAIM")),
+		AdminClaim:     strings.TrimSpace(os.Getenv("OIDC_ADMIN_CLAIM")),
+		AdminValues:    splitList(os.Getenv("OIDC_ADMIN_VALUES")),
+		HelpdeskValues: splitList(os.Getenv("OIDC_HELPDESK_VALUES")),
+		AuditorValues:  splitList(os.Getenv("OIDC_AUDITOR_VALUES")),
+	}
+	if cfg.IssuerURL == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.AdminClaim == "" {
+		cfg.AdminClaim = "groups"
+	}
+	return cfg
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	out := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// discoveryDocument is the subset of /.well-known/openid-configuration used here
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jsonWebKey is a public key of the provider JWKS
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Provider talks to an OpenID Connect identity provider. The discovery document and the
+// signing keys are fetched on first use and cached.
+type Provider struct {
+	cfg        Config
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	discovery   *discoveryDocument
+	keys        map[string]crypto.PublicKey // keyed by kid
+	keysFetched time.Time
+}
+
+// NewProvider returns a provider for cfg. A nil httpClient uses a client with a 10s timeout.
+func NewProvider(cfg Config, httpClient *http.Client) *Provider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.IssuerURL = strings.TrimRight(cfg.IssuerURL, "/")
+	return &Provider{cfg: cfg, httpClient: httpClient}
+}
+
+// Config returns the provider settings
+func (p *Provider) Config() Config {
+	return p.cfg
+}
+
+// getJSON fetches a JSON document
+func (p *Provider) getJSON(ctx context.Context, endpoint string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(out)
+}
+
+// discover returns the cached discovery document, fetching it on first use
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var doc discoveryDocument
+	if err := p.getJSON(ctx, p.cfg.IssuerURL+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != p.cfg.IssuerURL {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", doc.Issuer, p.cfg.IssuerURL)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing an endpoint")
+	}
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns the authorization endpoint URL the browser is sent to. state and nonce
+// must be random and kept in the session along with the PKCE verifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns the verified claims
+// of the ID token
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to parse token response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("token request rejected (status %d): %s %s", resp.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID token and returns its claims
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: bad header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+
+	key, err := p.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidToken)
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 {
+			return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidToken)
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidToken)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: bad payload: %v", ErrInvalidToken, err)
+	}
+	if err := p.checkClaims(claims, nonce, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkClaims validates the registered claims of an ID token
+func (p *Provider) checkClaims(claims Claims, nonce string, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != p.cfg.IssuerURL {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, iss)
+	}
+	if !claims.HasValue("aud", p.cfg.ClientID) {
+		return fmt.Errorf("%w: token not issued for this client", ErrInvalidToken)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return fmt.Errorf("%w: unexpected authorized party %q", ErrInvalidToken, azp)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(clockSkew)) {
+		return fmt.Errorf("%w: token issued in the future", ErrInvalidToken)
+	}
+	if got, _ := claims["nonce"].(string); nonce == "" || got != nonce {
+		return fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	return nil
+}
+
+// signingKey returns the provider key with the given ID. An unknown ID refetches the JWKS, at
+// most once per jwksRefreshInterval, to pick up key rotations.
+func (p *Provider) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, doc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.Get().Warn().Err(err).Str("kid", jwk.Kid).Msg("Ignoring unsupported OIDC signing key")
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+}
+
+// lookupKey finds a cached key. A token without kid matches when the JWKS holds a single key.
+// Callers must hold p.mu.
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// publicKey decodes an RSA or P-256 JSON Web Key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("bad modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("bad coordinates")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Claims are the claims of a verified ID token
+type Claims map[string]any
+
+// HasValue reports whether a claim equals value, or contains it when the claim is a list
+func (c Claims) HasValue(name, value string) bool {
+	switch v := c[name].(type) {
+	case string:
+		return v == value
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Username returns the username claim configured for the provider. It must be a valid Proxmox
+// user name without realm.
+func (p *Provider) Username(c Claims) (string, error) {
+	username, _ := c[p.cfg.UsernameClaim].(string)
+	if !usernamePattern.MatchString(username) {
+		return "", fmt.Errorf("%w: %s=%q", ErrInvalidUsername, p.cfg.UsernameClaim, username)
+	}
+	return username, nil
+}
+
+// Subject returns the sub claim, the identifier of the user at the provider. Unlike the
+// username claim, it is never reassigned to another user.
+func (p *Provider) Subject(c Claims) (string, error) {
+	sub, _ := c["sub"].(string)
+	if !subjectPattern.MatchString(sub) {
+		return "", fmt.Errorf("%w: sub=%q", ErrInvalidSubject, sub)
+	}
+	return sub, nil
+}
+
+// IsAdmin reports whether the claims grant administrator rights in any role
+func (p *Provider) IsAdmin(c Claims) bool {
+	return p.AdminRole(c) != ""
+}
+
+// AdminRole returns the administrator role the claims grant, the highest one when the admin claim
+// holds values of several roles, or "" when they grant none. No value configured for a role means
+// nobody gets it.
+func (p *Provider) AdminRole(c Claims) string {
+	roles := []struct {
+		role   string
+		values []string
+	}{
+		{AdminRoleFull, p.cfg.AdminValues},
+		{AdminRoleHelpdesk, p.cfg.HelpdeskValues},
+		{AdminRoleAuditor, p.cfg.AuditorValues},
+	}
+	for _, r := range roles {
+		for _, value := range r.values {
+			if c.HasValue(p.cfg.AdminClaim, value) {
+				return r.role
+			}
+		}
+	}
+	return ""
+}
+
+// RandomString returns a random URL-safe string for the state, nonce and PKCE verifier
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge of a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
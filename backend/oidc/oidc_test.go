@@ -0,0 +1,152 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"pvmss/oidc"
+	"pvmss/oidc/oidctest"
+)
+
+// authorize follows the authorization URL on the mock issuer and returns the code and state
+// sent back to the redirect URI
+func authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected a redirect to the client, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func newTestProvider(t *testing.T) (*oidc.Provider, *oidctest.Issuer) {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("pvmss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	provider := oidc.NewProvider(oidc.Config{
+		IssuerURL:      issuer.URL,
+		ClientID:       "pvmss",
+		RedirectURL:    "http://pvmss.test/auth/oidc/callback",
+		Scopes:         []string{"openid"},
+		UsernameClaim:  "preferred_username",
+		AdminClaim:     "groups",
+		AdminValues:    []string{"pvmss-admins"},
+		HelpdeskValues: []string{"pvmss-helpdesk"},
+	}, nil)
+	return provider, issuer
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	issuer.SetClaims(map[string]any{"preferred_username": "alice", "groups": []any{"staff", "pvmss-admins"}})
+	ctx := context.Background()
+
+	verifier, _ := oidc.RandomString()
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := authorize(t, authURL)
+	if state != "state-1" {
+		t.Errorf("Expected the state to be returned, got %q", state)
+	}
+
+	claims, err := provider.Exchange(ctx, code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if username, err := provider.Username(claims); err != nil || username != "alice" {
+		t.Errorf("Expected username alice, got %q (%v)", username, err)
+	}
+	if !provider.IsAdmin(claims) {
+		t.Error("Expected the admin group to grant administrator rights")
+	}
+
+	// A code is redeemed once
+	if _, err := provider.Exchange(ctx, code, verifier, "nonce-1"); err == nil {
+		t.Error("Expected a reused code to be rejected")
+	}
+
+	// The verifier must match the challenge sent with the authorization request
+	authURL, _ = provider.AuthCodeURL(ctx, "state-2", "nonce-2", verifier)
+	code, _ = authorize(t, authURL)
+	if _, err := provider.Exchange(ctx, code, "another-verifier", "nonce-2"); err == nil {
+		t.Error("Expected a wrong PKCE verifier to be rejected")
+	}
+
+	// The nonce must match the one stored in the session
+	authURL, _ = provider.AuthCodeURL(ctx, "state-3", "nonce-3", verifier)
+	code, _ = authorize(t, authURL)
+	if _, err := provider.Exchange(ctx, code, verifier, "nonce-other"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("Expected a nonce mismatch, got %v", err)
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+
+	valid := issuer.Sign(map[string]any{"nonce": "n"})
+	if _, err := provider.Verify(ctx, valid, "n"); err != nil {
+		t.Fatalf("Expected a valid token, got %v", err)
+	}
+
+	tests := map[string]string{
+		"expired":        issuer.Sign(map[string]any{"nonce": "n", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"other audience": issuer.Sign(map[string]any{"nonce": "n", "aud": "another-client"}),
+		"other issuer":   issuer.Sign(map[string]any{"nonce": "n", "iss": "https://evil.example"}),
+		"tampered":       valid[:len(valid)-4] + "AAAA",
+	}
+	for name, token := range tests {
+		if _, err := provider.Verify(ctx, token, "n"); !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestClaimMapping(t *testing.T) {
+	provider, _ := newTestProvider(t)
+
+	if _, err := provider.Username(oidc.Claims{"preferred_username": "alice@example.com"}); !errors.Is(err, oidc.ErrInvalidUsername) {
+		t.Errorf("Expected a username with a domain to be refused, got %v", err)
+	}
+	if _, err := provider.Username(oidc.Claims{}); !errors.Is(err, oidc.ErrInvalidUsername) {
+		t.Errorf("Expected a missing username to be refused, got %v", err)
+	}
+	if sub, err := provider.Subject(oidc.Claims{"sub": "248289761001"}); err != nil || sub != "248289761001" {
+		t.Errorf("Subject() = %q, %v", sub, err)
+	}
+	for _, sub := range []any{nil, "", "a b", 42} {
+		if _, err := provider.Subject(oidc.Claims{"sub": sub}); !errors.Is(err, oidc.ErrInvalidSubject) {
+			t.Errorf("Expected sub %v to be refused, got %v", sub, err)
+		}
+	}
+	if provider.IsAdmin(oidc.Claims{"groups": []any{"staff"}}) {
+		t.Error("Expected a user outside the admin group not to be an administrator")
+	}
+	if !provider.IsAdmin(oidc.Claims{"groups": "pvmss-admins"}) {
+		t.Error("Expected a single-valued admin claim to match")
+	}
+	if role := provider.AdminRole(oidc.Claims{"groups": []any{"pvmss-helpdesk"}}); role != oidc.AdminRoleHelpdesk {
+		t.Errorf("Expected the helpdesk role, got %q", role)
+	}
+	if role := provider.AdminRole(oidc.Claims{"groups": []any{"pvmss-helpdesk", "pvmss-admins"}}); role != oidc.AdminRoleFull {
+		t.Errorf("Expected the highest role to win, got %q", role)
+	}
+	if role := provider.AdminRole(oidc.Claims{"groups": []any{"pvmss-auditors"}}); role != "" {
+		t.Errorf("Expected no role for a value not configured, got %q", role)
+	}
+}
@@ -109,14 +109,19 @@ func CreateTicket(ctx context.Context, client ClientInterface, username, passwor
 }
 
 // EnsureUser creates a Proxmox user if it does not already exist. This function is idempotent.
+// The password is required for the pve realm only; users of other realms authenticate against
+// their directory.
 func EnsureUser(ctx context.Context, client ClientInterface, username, password, email, comment, realm string, enable bool) error {
-	if err := validateClientAndParams(client, param{"username", username}, param{"password", password}); err != nil {
-		return err
-	}
-
 	if realm == "" {
 		realm = "pve"
 	}
+	params := []param{{"username", username}}
+	if realm == "pve" {
+		params = append(params, param{"password", password})
+	}
+	if err := validateClientAndParams(client, params...); err != nil {
+		return err
+	}
 	uid := normalizeUserID(username, realm)
 
 	ctx, cancel := withDefaultTimeout(ctx, client.GetTimeout())
@@ -133,7 +138,9 @@ func EnsureUser(ctx context.Context, client ClientInterface, username, password,
 	// Create user
 	form := url.Values{}
 	form.Set("userid", uid)
-	form.Set("password", password)
+	if password != "" {
+		form.Set("password", password)
+	}
 	form.Set("enable", boolToForm(enable))
 	if email != "" {
 		form.Set("email", email)
@@ -154,6 +161,62 @@ func EnsureUser(ctx context.Context, client ClientInterface, username, password,
 	return nil
 }
 
+// User is a Proxmox user as listed by GET /access/users
+type User struct {
+	UserID  string `json:"userid"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// GetUsers lists the users of every realm. The list is never served from the cache since
+// callers rely on it to decide who an account belongs to.
+//
+// GET /access/users
+func GetUsers(ctx context.Context, client ClientInterface) ([]User, error) {
+	if err := validateClientAndParams(client); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx, client.GetTimeout())
+	defer cancel()
+
+	client.InvalidateCache("/access/users")
+	var resp struct {
+		Data []User `json:"data"`
+	}
+	if err := client.GetJSON(ctx, "/access/users", &resp); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return resp.Data, nil
+}
+
+// Realm is an authentication domain configured on the cluster.
+type Realm struct {
+	Realm   string `json:"realm"`
+	Type    string `json:"type"` // pve, pam, ldap, ad, openid...
+	Comment string `json:"comment,omitempty"`
+	Default int    `json:"default,omitempty"`
+}
+
+// GetRealms lists the authentication realms of the cluster.
+//
+// GET /access/domains
+func GetRealms(ctx context.Context, client ClientInterface) ([]Realm, error) {
+	if err := validateClientAndParams(client); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx, client.GetTimeout())
+	defer cancel()
+
+	var resp struct {
+		Data []Realm `json:"data"`
+	}
+	if err := client.GetJSON(ctx, "/access/domains", &resp); err != nil {
+		return nil, fmt.Errorf("failed to list realms: %w", err)
+	}
+	return resp.Data, nil
+}
+
 // UpdateUserPassword updates the password for an existing Proxmox user.
 // This function uses the PUT /access/password endpoint.
 //
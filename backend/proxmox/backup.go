@@ -0,0 +1,192 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pvmss/logger"
+)
+
+// Backup defines a vzdump archive as returned by GET /nodes/{node}/storage/{storage}/content.
+// Node is not part of the API response; it is set to the node the listing was made on.
+type Backup struct {
+	VolID     string `json:"volid"`
+	Content   string `json:"content"`
+	Format    string `json:"format"`
+	Size      int64  `json:"size"`
+	CTime     int64  `json:"ctime"`
+	VMID      int    `json:"vmid"`
+	Notes     string `json:"notes"`
+	Protected int    `json:"protected"`
+	Node      string `json:"-"`
+}
+
+// RestoreOptions controls how a backup archive is restored into a VM
+type RestoreOptions struct {
+	Force   bool   // overwrite an existing VM with the same VMID
+	Unique  bool   // regenerate unique properties such as MAC addresses
+	Pool    string // add the restored VM to this pool
+	Storage string // restore disks to this storage instead of the original ones
+}
+
+// storageContentPath returns the content path of a storage on a node, or a single volume when volid is set
+func storageContentPath(node, storage, volid string) string {
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content", url.PathEscape(node), url.PathEscape(storage))
+	if volid != "" {
+		path += "/" + url.PathEscape(volid)
+	}
+	return path
+}
+
+// GetBackupsWithContext lists the vzdump archives of a storage on a node, newest first.
+// When vmid is greater than zero only the backups of that VM are returned.
+func GetBackupsWithContext(ctx context.Context, client ClientInterface, node, storage string, vmid int) ([]Backup, error) {
+	if node == "" {
+		return nil, fmt.Errorf("node name cannot be empty")
+	}
+	if storage == "" {
+		return nil, fmt.Errorf("storage name cannot be empty")
+	}
+
+	// The unfiltered content path is shared with the ISO listing and can be invalidated after changes
+	var response ListResponse[Backup]
+	if err := client.GetJSON(ctx, storageContentPath(node, storage, ""), &response); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Str("storage", storage).Msg("Failed to list backups from Proxmox API")
+		return nil, fmt.Errorf("failed to list backups on storage %s of node %s: %w", storage, node, err)
+	}
+
+	backups := make([]Backup, 0, len(response.Data))
+	for _, b := range response.Data {
+		if b.Content != "backup" || (vmid > 0 && b.VMID != vmid) {
+			continue
+		}
+		b.Node = node
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CTime > backups[j].CTime })
+	return backups, nil
+}
+
+// InvalidateBackups drops the cached content of a storage on a node, so the next listing reads it from Proxmox
+func InvalidateBackups(client ClientInterface, node, storage string) {
+	client.InvalidateCache(storageContentPath(node, storage, ""))
+}
+
+// CreateVMBackupWithContext starts a vzdump backup of a VM to a storage:
+//
+//	POST /nodes/{node}/vzdump
+//
+// notes is stored with the archive. Returns the task UPID.
+func CreateVMBackupWithContext(ctx context.Context, client ClientInterface, node string, vmid int, storage, notes string) (string, error) {
+	values := url.Values{}
+	values.Set("vmid", strconv.Itoa(vmid))
+	values.Set("storage", storage)
+	values.Set("mode", "snapshot")
+	values.Set("compress", "zstd")
+	if notes != "" {
+		values.Set("notes-template", notes)
+	}
+
+	var response Response[string]
+	path := fmt.Sprintf("/nodes/%s/vzdump", url.PathEscape(node))
+	if err := client.PostFormAndGetJSON(ctx, path, values, &response); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Str("storage", storage).Msg("VM backup failed")
+		return "", fmt.Errorf("failed to back up vm %d on node %s: %w", vmid, node, err)
+	}
+	client.InvalidateCache(storageContentPath(node, storage, ""))
+	return response.Data, nil
+}
+
+// GetBackupConfigWithContext reads the VM config stored in a vzdump archive:
+//
+//	GET /nodes/{node}/vzdump/extractconfig?volume={volid}
+//
+// The config is returned in the shape of GetVMConfigWithContext: numeric values are float64
+// and snapshot sections are ignored.
+func GetBackupConfigWithContext(ctx context.Context, client ClientInterface, node, volid string) (map[string]interface{}, error) {
+	path := fmt.Sprintf("/nodes/%s/vzdump/extractconfig?volume=%s", url.PathEscape(node), url.QueryEscape(volid))
+	var response Response[string]
+	if err := client.GetJSON(ctx, path, &response); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Str("volid", volid).Msg("Failed to read backup config")
+		return nil, fmt.Errorf("failed to read the config of backup %s on node %s: %w", volid, node, err)
+	}
+	return parseVMConfig(response.Data), nil
+}
+
+// parseVMConfig parses a qemu-server config file up to its first snapshot section
+func parseVMConfig(text string) map[string]interface{} {
+	cfg := make(map[string]interface{})
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			cfg[key] = n
+		} else {
+			cfg[key] = value
+		}
+	}
+	return cfg
+}
+
+// RestoreVMBackupWithContext restores a vzdump archive into vmid:
+//
+//	POST /nodes/{node}/qemu (archive=...)
+//
+// Returns the task UPID.
+func RestoreVMBackupWithContext(ctx context.Context, client ClientInterface, node string, vmid int, archive string, opts RestoreOptions) (string, error) {
+	values := url.Values{}
+	values.Set("vmid", strconv.Itoa(vmid))
+	values.Set("archive", archive)
+	if opts.Force {
+		values.Set("force", "1")
+	}
+	if opts.Unique {
+		values.Set("unique", "1")
+	}
+	if opts.Pool != "" {
+		values.Set("pool", opts.Pool)
+	}
+	if opts.Storage != "" {
+		values.Set("storage", opts.Storage)
+	}
+
+	var response Response[string]
+	path := fmt.Sprintf("/nodes/%s/qemu", url.PathEscape(node))
+	if err := client.PostFormAndGetJSON(ctx, path, values, &response); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Str("archive", archive).Msg("VM restore failed")
+		return "", fmt.Errorf("failed to restore %s into vm %d on node %s: %w", archive, vmid, node, err)
+	}
+	client.InvalidateCache(path)
+	if opts.Pool != "" {
+		client.InvalidateCache("/pools/" + url.PathEscape(opts.Pool))
+	}
+	InvalidateInventory()
+	return response.Data, nil
+}
+
+// DeleteBackupWithContext removes a backup archive from a storage:
+//
+//	DELETE /nodes/{node}/storage/{storage}/content/{volid}
+//
+// Returns the task UPID when Proxmox provides one.
+func DeleteBackupWithContext(ctx context.Context, client ClientInterface, node, storage, volid string) (string, error) {
+	resp, err := client.DeleteWithContext(ctx, storageContentPath(node, storage, volid), url.Values{})
+	if err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Str("volid", volid).Msg("Backup deletion failed")
+		return "", fmt.Errorf("failed to delete backup %s on node %s: %w", volid, node, err)
+	}
+	client.InvalidateCache(storageContentPath(node, storage, ""))
+	upid, _ := resp["data"].(string)
+	return upid, nil
+}
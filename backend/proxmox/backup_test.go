@@ -0,0 +1,13 @@
+package proxmox
+
+import "testing"
+
+func TestParseVMConfig(t *testing.T) {
+	cfg := parseVMConfig("#web server\nname: web\ncores: 4\nscsi0: local-lvm:vm-100-disk-0,size=32G\n\n[snap1]\ncores: 8\n")
+	if cfg["name"] != "web" || cfg["cores"] != float64(4) || cfg["scsi0"] != "local-lvm:vm-100-disk-0,size=32G" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	if _, ok := cfg["#web server"]; ok || len(cfg) != 3 {
+		t.Errorf("Expected comments and snapshots to be skipped, got %+v", cfg)
+	}
+}
@@ -39,6 +39,7 @@ func (c *LRUCache) Get(key string) []byte {
 
 	elem, ok := c.cache[key]
 	if !ok {
+		cacheMisses.Inc()
 		return nil
 	}
 
@@ -47,11 +48,14 @@ func (c *LRUCache) Get(key string) []byte {
 	// Check if entry has expired
 	if time.Since(ent.timestamp) > c.ttl {
 		c.removeElement(elem)
+		cacheMisses.Inc()
+		cacheEvictions.Inc("expired")
 		return nil
 	}
 
 	// Move to front (most recently used)
 	c.lru.MoveToFront(elem)
+	cacheHits.Inc()
 	return ent.value
 }
 
@@ -83,6 +87,7 @@ func (c *LRUCache) Set(key string, value []byte) {
 		oldest := c.lru.Back()
 		if oldest != nil {
 			c.removeElement(oldest)
+			cacheEvictions.Inc("capacity")
 		}
 	}
 }
@@ -140,6 +145,7 @@ func (c *LRUCache) CleanExpired() int {
 	for _, elem := range toRemove {
 		c.removeElement(elem)
 	}
+	cacheEvictions.Add(float64(len(toRemove)), "expired")
 
 	return len(toRemove)
 }
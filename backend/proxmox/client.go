@@ -317,7 +317,21 @@ func newHTTPClient(insecureSkipVerify bool, timeout time.Duration) *http.Client
 		ExpectContinueTimeout: constants.HTTPExpectContinueTimeout,
 		ResponseHeaderTimeout: constants.HTTPResponseHeaderTimeout,
 	}
-	return &http.Client{Transport: tr, Timeout: timeout}
+	return &http.Client{Transport: metricsTransport{next: tracingTransport{next: requestIDTransport{next: tr}}}, Timeout: timeout}
+}
+
+// requestIDTransport forwards the ID of the PVMSS request being served, so the Proxmox logs can
+// be matched with ours
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if id := logger.RequestIDFromContext(req.Context()); id != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(logger.RequestIDHeader, id)
+	}
+	return t.next.RoundTrip(req)
 }
 
 // normalizeBaseURL ensures the Proxmox API URL is correctly formatted.
@@ -0,0 +1,31 @@
+package proxmox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"pvmss/logger"
+)
+
+func TestRequestIDForwarded(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get(logger.RequestIDHeader)
+		_, _ = w.Write([]byte(`{"data":{}}`))
+	}))
+	defer srv.Close()
+
+	ctx := logger.WithRequestID(context.Background(), "req-42")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api2/json/version", nil)
+	resp, err := newHTTPClient(true, time.Second).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if id := <-got; id != "req-42" {
+		t.Errorf("Expected the request ID forwarded to Proxmox, got %q", id)
+	}
+}
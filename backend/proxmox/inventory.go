@@ -0,0 +1,134 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"pvmss/constants"
+	"pvmss/logger"
+)
+
+// clusterVMsPath lists every VM of the cluster with its tags, pool and sizing in a single call
+const clusterVMsPath = "/cluster/resources?type=vm"
+
+// ClusterVM is a VM entry of GET /cluster/resources?type=vm
+type ClusterVM struct {
+	ID       string  `json:"id"` // e.g. "qemu/100"
+	Type     string  `json:"type"`
+	VMID     int     `json:"vmid"`
+	Name     string  `json:"name"`
+	Node     string  `json:"node"`
+	Status   string  `json:"status"`
+	Tags     string  `json:"tags"` // semicolon-separated
+	Pool     string  `json:"pool"`
+	MaxCPU   int     `json:"maxcpu"` // sockets * cores
+	MaxMem   int64   `json:"maxmem"` // bytes
+	MaxDisk  int64   `json:"maxdisk"`
+	Mem      int64   `json:"mem"`
+	CPU      float64 `json:"cpu"`
+	Uptime   int64   `json:"uptime"`
+	Template int     `json:"template"`
+}
+
+// TagList splits the VM tags on semicolons and commas
+func (vm ClusterVM) TagList() []string {
+	var tags []string
+	for _, part := range strings.FieldsFunc(vm.Tags, func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag := strings.TrimSpace(part); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// HasTag reports whether the VM carries tag, ignoring case
+func (vm ClusterVM) HasTag(tag string) bool {
+	for _, t := range vm.TagList() {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxMemMB returns the configured memory of the VM in MB
+func (vm ClusterVM) MaxMemMB() int64 {
+	return vm.MaxMem / (1024 * 1024)
+}
+
+// GetClusterVMsWithContext lists the QEMU VMs of the whole cluster in a single API call
+func GetClusterVMsWithContext(ctx context.Context, client ClientInterface) ([]ClusterVM, error) {
+	var response ListResponse[ClusterVM]
+	if err := client.GetJSON(ctx, clusterVMsPath, &response); err != nil {
+		logger.Get().Error().Err(err).Msg("Failed to get cluster resources from Proxmox API")
+		return nil, fmt.Errorf("failed to list cluster VMs: %w", err)
+	}
+	vms := make([]ClusterVM, 0, len(response.Data))
+	for _, vm := range response.Data {
+		if vm.Type == "qemu" {
+			vms = append(vms, vm)
+		}
+	}
+	return vms, nil
+}
+
+// Inventory keeps the cluster VM list for a short TTL so that pages listing or counting VMs
+// share one /cluster/resources call instead of fetching every VM config.
+type Inventory struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	client    ClientInterface
+	vms       []ClusterVM
+	fetchedAt time.Time
+}
+
+// NewInventory creates an inventory that refreshes its VM list after ttl
+func NewInventory(ttl time.Duration) *Inventory {
+	return &Inventory{ttl: ttl}
+}
+
+// VMs returns the cluster VMs, fetching them again when the list is older than the TTL
+// or was loaded through another client. The returned slice must not be modified.
+func (inv *Inventory) VMs(ctx context.Context, client ClientInterface) ([]ClusterVM, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if inv.vms != nil && inv.client == client && time.Since(inv.fetchedAt) < inv.ttl {
+		return inv.vms, nil
+	}
+
+	// The inventory TTL decides freshness, so skip the client's longer-lived response cache
+	client.InvalidateCache(clusterVMsPath)
+	vms, err := GetClusterVMsWithContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	inv.client = client
+	inv.vms = vms
+	inv.fetchedAt = time.Now()
+	logger.Get().Debug().Int("count", len(vms)).Msg("Cluster VM inventory refreshed")
+	return vms, nil
+}
+
+// Invalidate drops the cached VM list so the next call fetches it again
+func (inv *Inventory) Invalidate() {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.vms = nil
+}
+
+// defaultInventory is shared by all handlers
+var defaultInventory = NewInventory(constants.InventoryTTL)
+
+// GetInventoryVMsWithContext returns the cluster VMs from the shared inventory
+func GetInventoryVMsWithContext(ctx context.Context, client ClientInterface) ([]ClusterVM, error) {
+	return defaultInventory.VMs(ctx, client)
+}
+
+// InvalidateInventory drops the shared inventory after a VM was created, changed or deleted
+func InvalidateInventory() {
+	defaultInventory.Invalidate()
+}
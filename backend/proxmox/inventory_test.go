@@ -0,0 +1,75 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// countingClient answers /cluster/resources with a fixed body and counts the calls
+type countingClient struct {
+	ClientInterface
+	body  string
+	calls int
+}
+
+func (c *countingClient) GetJSON(_ context.Context, _ string, target interface{}) error {
+	c.calls++
+	return json.Unmarshal([]byte(c.body), target)
+}
+
+func (c *countingClient) InvalidateCache(string) {}
+
+const clusterBody = `{"data":[
+	{"id":"qemu/100","type":"qemu","vmid":100,"name":"a","node":"pve1","tags":"pvmss;web","pool":"pvmss_alice","maxcpu":4,"maxmem":2147483648},
+	{"id":"lxc/101","type":"lxc","vmid":101,"name":"ct","node":"pve1"},
+	{"id":"qemu/102","type":"qemu","vmid":102,"name":"b","node":"pve2","tags":"PVMSS,db"}
+]}`
+
+func TestInventoryVMs(t *testing.T) {
+	inv := NewInventory(time.Minute)
+	client := &countingClient{body: clusterBody}
+
+	vms, err := inv.VMs(context.Background(), client)
+	if err != nil {
+		t.Fatalf("VMs() error = %v", err)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("Expected 2 QEMU VMs, got %d", len(vms))
+	}
+	if vms[0].MaxMemMB() != 2048 || vms[0].Pool != "pvmss_alice" {
+		t.Errorf("Unexpected VM fields: %+v", vms[0])
+	}
+
+	if _, err := inv.VMs(context.Background(), client); err != nil || client.calls != 1 {
+		t.Errorf("Expected the list to be reused within the TTL, got %d calls", client.calls)
+	}
+
+	other := &countingClient{body: clusterBody}
+	if _, err := inv.VMs(context.Background(), other); err != nil || other.calls != 1 {
+		t.Errorf("Expected a new client to trigger a fetch, got %d calls", other.calls)
+	}
+
+	inv.Invalidate()
+	if _, err := inv.VMs(context.Background(), other); err != nil || other.calls != 2 {
+		t.Errorf("Expected Invalidate to trigger a fetch, got %d calls", other.calls)
+	}
+
+	expired := NewInventory(0)
+	_, _ = expired.VMs(context.Background(), client)
+	_, _ = expired.VMs(context.Background(), client)
+	if client.calls != 3 {
+		t.Errorf("Expected an expired list to be fetched again, got %d calls", client.calls)
+	}
+}
+
+func TestClusterVMHasTag(t *testing.T) {
+	vm := ClusterVM{Tags: "PVMSS,db; web"}
+	if !vm.HasTag("pvmss") || !vm.HasTag("web") {
+		t.Errorf("Expected tags to match case-insensitively across separators, got %v", vm.TagList())
+	}
+	if vm.HasTag("pvm") {
+		t.Error("Expected partial tags not to match")
+	}
+}
@@ -0,0 +1,91 @@
+package proxmox
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pvmss/metrics"
+)
+
+var (
+	apiRequests = metrics.NewCounterVec("pvmss_proxmox_requests_total",
+		"Proxmox API requests, by method, endpoint and HTTP status (error when no response came back).",
+		"method", "endpoint", "code")
+	apiRequestDuration = metrics.NewHistogramVec("pvmss_proxmox_request_duration_seconds",
+		"Time until the Proxmox API answered, by method and endpoint.",
+		nil, "method", "endpoint")
+	apiRequestErrors = metrics.NewCounterVec("pvmss_proxmox_request_errors_total",
+		"Proxmox API requests that failed or returned an error status, by method and endpoint.",
+		"method", "endpoint")
+
+	cacheHits = metrics.NewCounterVec("pvmss_proxmox_cache_hits_total",
+		"Proxmox API responses served from the cache.")
+	cacheMisses = metrics.NewCounterVec("pvmss_proxmox_cache_misses_total",
+		"Proxmox API responses not found in the cache, or expired.")
+	cacheEvictions = metrics.NewCounterVec("pvmss_proxmox_cache_evictions_total",
+		"Entries removed from the Proxmox API cache, because it was full (capacity) or they were too old (expired).",
+		"reason")
+)
+
+// identifierCollections are the path segments followed by an identifier, such as a node name
+var identifierCollections = map[string]string{
+	"nodes":    ":node",
+	"qemu":     ":vmid",
+	"lxc":      ":vmid",
+	"storage":  ":storage",
+	"tasks":    ":upid",
+	"snapshot": ":snapname",
+	"pools":    ":pool",
+	"users":    ":userid",
+	"groups":   ":group",
+	"token":    ":tokenid",
+	"content":  ":volume",
+	"roles":    ":role",
+}
+
+// numericSegment matches identifiers found outside a known collection
+var numericSegment = regexp.MustCompile(`^[0-9]+$`)
+
+// apiEndpoint reduces an API path to its endpoint, replacing identifiers with placeholders so the
+// number of series stays bounded: /api2/json/nodes/pve1/qemu/100/status/start becomes
+// /nodes/:node/qemu/:vmid/status/start.
+func apiEndpoint(path string) string {
+	if i := strings.Index(path, "/api2/json"); i >= 0 {
+		path = path[i+len("/api2/json"):]
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range segments {
+		switch {
+		case i > 0 && identifierCollections[segments[i-1]] != "":
+			segments[i] = identifierCollections[segments[i-1]]
+		case numericSegment.MatchString(segments[i]):
+			segments[i] = ":id"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// metricsTransport records the count, latency and errors of the requests sent to the API
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	endpoint := apiEndpoint(req.URL.Path)
+	apiRequestDuration.Observe(time.Since(start).Seconds(), req.Method, endpoint)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	apiRequests.Inc(req.Method, endpoint, code)
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		apiRequestErrors.Inc(req.Method, endpoint)
+	}
+	return resp, err
+}
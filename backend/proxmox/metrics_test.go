@@ -0,0 +1,19 @@
+package proxmox
+
+import "testing"
+
+func TestAPIEndpoint(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api2/json/nodes/pve1/qemu/100/status/start":             "/nodes/:node/qemu/:vmid/status/start",
+		"https://pve:8006/api2/json/cluster/resources":            "/cluster/resources",
+		"/api2/json/nodes/qemu/qemu/101/snapshot/before/rollback": "/nodes/:node/qemu/:vmid/snapshot/:snapname/rollback",
+		"/api2/json/nodes/pve1/tasks/UPID:pve1:0001/status":       "/nodes/:node/tasks/:upid/status",
+		"/api2/json/access/users/alice@pve/token/ci":              "/access/users/:userid/token/:tokenid",
+		"/api2/json/cluster/nextid":                               "/cluster/nextid",
+		"/api2/json/cluster/backup/42":                            "/cluster/backup/:id",
+	} {
+		if got := apiEndpoint(path); got != want {
+			t.Errorf("apiEndpoint(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
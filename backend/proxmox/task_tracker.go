@@ -0,0 +1,270 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"pvmss/constants"
+	"pvmss/logger"
+)
+
+// TaskStatusUnknown marks a task whose outcome could not be read before the tracking timeout
+const TaskStatusUnknown = "unknown"
+
+// TrackedTask is a Proxmox task started by PVMSS and followed until it stops
+type TrackedTask struct {
+	UPID       string    `json:"upid"`
+	Node       string    `json:"node"`
+	VMID       int       `json:"vmid"`
+	Action     string    `json:"action"`
+	User       string    `json:"user,omitempty"`
+	Status     string    `json:"status"`     // "running", "stopped" or "unknown"
+	ExitStatus string    `json:"exitstatus"` // "OK" on success, set once stopped
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at,omitempty"`
+}
+
+// Running reports whether the task is still being followed
+func (t TrackedTask) Running() bool {
+	return t.Status == "running"
+}
+
+// Succeeded reports whether the task stopped with an OK exit status
+func (t TrackedTask) Succeeded() bool {
+	return t.Status == "stopped" && t.ExitStatus == "OK"
+}
+
+// trackedEntry is a task together with the channel closed once it stops
+type trackedEntry struct {
+	task TrackedTask
+	done chan struct{}
+}
+
+// TaskTracker follows the tasks started on VMs in the background and keeps the most recent
+// ones per VM, so pages can show their outcome and callers can wait for them to finish.
+type TaskTracker struct {
+	interval time.Duration
+	timeout  time.Duration
+	keep     int
+
+	mu    sync.Mutex
+	tasks map[string]*trackedEntry
+	byVM  map[int][]string // UPIDs, oldest first
+}
+
+// NewTaskTracker creates a tracker polling every interval for at most timeout,
+// keeping the keep most recent tasks of each VM
+func NewTaskTracker(interval, timeout time.Duration, keep int) *TaskTracker {
+	return &TaskTracker{
+		interval: interval,
+		timeout:  timeout,
+		keep:     keep,
+		tasks:    make(map[string]*trackedEntry),
+		byVM:     make(map[int][]string),
+	}
+}
+
+// Track registers a task and starts polling its status. Tracking the same UPID twice
+// returns the existing entry. An empty UPID is ignored.
+func (t *TaskTracker) Track(client ClientInterface, upid string, vmid int, action, user string) TrackedTask {
+	if upid == "" {
+		return TrackedTask{}
+	}
+
+	t.mu.Lock()
+	if e, ok := t.tasks[upid]; ok {
+		t.mu.Unlock()
+		return e.task
+	}
+	e := &trackedEntry{
+		task: TrackedTask{
+			UPID:      upid,
+			Node:      TaskNode(upid),
+			VMID:      vmid,
+			Action:    action,
+			User:      user,
+			Status:    "running",
+			StartedAt: time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+	t.tasks[upid] = e
+	t.byVM[vmid] = append(t.byVM[vmid], upid)
+	t.pruneLocked(vmid)
+	t.mu.Unlock()
+
+	go t.follow(client, e)
+	return e.task
+}
+
+// pruneLocked forgets the oldest finished tasks of a VM beyond the keep limit.
+// The caller must hold t.mu.
+func (t *TaskTracker) pruneLocked(vmid int) {
+	upids := t.byVM[vmid]
+	for len(upids) > t.keep {
+		idx := -1
+		for i, upid := range upids {
+			if !t.tasks[upid].task.Running() {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			break
+		}
+		delete(t.tasks, upids[idx])
+		upids = append(upids[:idx], upids[idx+1:]...)
+	}
+	t.byVM[vmid] = upids
+}
+
+// follow polls the task until it stops or the tracking timeout expires.
+// Status read errors are retried, since a node may briefly be unreachable.
+func (t *TaskTracker) follow(client ClientInterface, e *trackedEntry) {
+	log := logger.Get().With().Str("component", "task_tracker").Str("upid", e.task.UPID).Logger()
+	defer close(e.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	node := e.task.Node
+	path := taskStatusPath(node, e.task.UPID)
+	for {
+		client.InvalidateCache(path)
+		status, err := GetTaskStatusWithContext(ctx, client, node, e.task.UPID)
+		if err != nil {
+			log.Debug().Err(err).Msg("Unable to read task status, retrying")
+		} else if !status.Running() {
+			t.finish(e, status.Status, status.ExitStatus)
+			log.Info().Str("exitstatus", status.ExitStatus).Msg("Task finished")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			t.finish(e, TaskStatusUnknown, "")
+			log.Warn().Msg("Task still unfinished after the tracking timeout")
+			return
+		case <-time.After(t.interval):
+		}
+	}
+}
+
+// finish records the final state of a task
+func (t *TaskTracker) finish(e *trackedEntry, status, exitStatus string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e.task.Status = status
+	e.task.ExitStatus = exitStatus
+	e.task.EndedAt = time.Now()
+	t.pruneLocked(e.task.VMID)
+}
+
+// Get returns a tracked task by UPID
+func (t *TaskTracker) Get(upid string) (TrackedTask, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.tasks[upid]
+	if !ok {
+		return TrackedTask{}, false
+	}
+	return e.task, true
+}
+
+// ForVM returns the tracked tasks of a VM, most recent first
+func (t *TaskTracker) ForVM(vmid int) []TrackedTask {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tasks := make([]TrackedTask, 0, len(t.byVM[vmid]))
+	for _, upid := range t.byVM[vmid] {
+		tasks = append(tasks, t.tasks[upid].task)
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].StartedAt.After(tasks[j].StartedAt) })
+	return tasks
+}
+
+// CountRunning returns the number of tracked tasks of action started by user that are still running
+func (t *TaskTracker) CountRunning(action, user string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, e := range t.tasks {
+		if e.task.Running() && e.task.Action == action && e.task.User == user {
+			n++
+		}
+	}
+	return n
+}
+
+// ForgetVM drops the tasks of a VM, so a VM later created with the same VMID does not show them
+func (t *TaskTracker) ForgetVM(vmid int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, upid := range t.byVM[vmid] {
+		delete(t.tasks, upid)
+	}
+	delete(t.byVM, vmid)
+}
+
+// Wait blocks until a tracked task stops or ctx is done.
+// It returns an error when the task did not end with an OK exit status.
+func (t *TaskTracker) Wait(ctx context.Context, upid string) (TrackedTask, error) {
+	t.mu.Lock()
+	e, ok := t.tasks[upid]
+	t.mu.Unlock()
+	if !ok {
+		return TrackedTask{}, fmt.Errorf("task %s is not tracked", upid)
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		task, _ := t.Get(upid)
+		return task, fmt.Errorf("waiting for task %s: %w", upid, ctx.Err())
+	}
+
+	t.mu.Lock()
+	task := e.task
+	t.mu.Unlock()
+	if !task.Succeeded() {
+		return task, fmt.Errorf("task %s failed: %s", upid, task.ExitStatus)
+	}
+	return task, nil
+}
+
+// defaultTaskTracker is shared by all handlers
+var defaultTaskTracker = NewTaskTracker(constants.TaskPollInterval, constants.TaskTrackTimeout, constants.MaxTrackedTasksPerVM)
+
+// TrackTask follows a task started on a VM with the shared tracker
+func TrackTask(client ClientInterface, upid string, vmid int, action, user string) TrackedTask {
+	return defaultTaskTracker.Track(client, upid, vmid, action, user)
+}
+
+// TrackAndWaitTask follows a task with the shared tracker and waits for it to stop
+func TrackAndWaitTask(ctx context.Context, client ClientInterface, upid string, vmid int, action, user string) (TrackedTask, error) {
+	defaultTaskTracker.Track(client, upid, vmid, action, user)
+	return defaultTaskTracker.Wait(ctx, upid)
+}
+
+// GetTrackedTask returns a task of the shared tracker by UPID
+func GetTrackedTask(upid string) (TrackedTask, bool) {
+	return defaultTaskTracker.Get(upid)
+}
+
+// ForgetVMTasks drops the tasks of a deleted VM, or of a VMID being allocated to a new VM
+func ForgetVMTasks(vmid int) {
+	defaultTaskTracker.ForgetVM(vmid)
+}
+
+// CountRunningTasks returns the number of running tasks of action started by user in the shared tracker
+func CountRunningTasks(action, user string) int {
+	return defaultTaskTracker.CountRunning(action, user)
+}
+
+// RecentVMTasks returns the tracked tasks of a VM, most recent first
+func RecentVMTasks(vmid int) []TrackedTask {
+	return defaultTaskTracker.ForVM(vmid)
+}
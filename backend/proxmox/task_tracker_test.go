@@ -0,0 +1,162 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// taskClient serves task statuses that tests can change while the tracker polls
+type taskClient struct {
+	ClientInterface
+	mu       sync.Mutex
+	statuses map[string]TaskStatus // keyed by UPID
+}
+
+func (c *taskClient) set(upid, status, exit string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statuses[upid] = TaskStatus{UPID: upid, Status: status, ExitStatus: exit}
+}
+
+func (c *taskClient) GetJSON(_ context.Context, path string, target interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for upid, status := range c.statuses {
+		if strings.Contains(path, upid) {
+			raw, _ := json.Marshal(Response[TaskStatus]{Data: status})
+			return json.Unmarshal(raw, target)
+		}
+	}
+	return fmt.Errorf("no status for %s", path)
+}
+
+func (c *taskClient) InvalidateCache(string) {}
+
+func testUPID(n int) string {
+	return fmt.Sprintf("UPID:pve1:0000%04d:00000001:00000001:qmstart:100:alice@pve:", n)
+}
+
+func TestTaskTrackerWait(t *testing.T) {
+	client := &taskClient{statuses: map[string]TaskStatus{}}
+	tracker := NewTaskTracker(5*time.Millisecond, time.Minute, 10)
+
+	ok, failed := testUPID(1), testUPID(2)
+	client.set(ok, "running", "")
+	client.set(failed, "stopped", "VM is locked")
+
+	task := tracker.Track(client, ok, 100, "start", "alice@pve")
+	if !task.Running() || task.Node != "pve1" {
+		t.Fatalf("Expected a running task on pve1, got %+v", task)
+	}
+	tracker.Track(client, failed, 100, "stop", "alice@pve")
+
+	if _, err := tracker.Wait(context.Background(), failed); err == nil {
+		t.Error("Expected an error for a failed task")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := tracker.Wait(ctx, ok); err == nil {
+		t.Error("Expected the wait to time out while the task runs")
+	}
+
+	client.set(ok, "stopped", "OK")
+	task, err := tracker.Wait(context.Background(), ok)
+	if err != nil || !task.Succeeded() || task.EndedAt.IsZero() {
+		t.Fatalf("Expected the task to succeed, got %+v, %v", task, err)
+	}
+
+	if _, err := tracker.Wait(context.Background(), testUPID(3)); err == nil {
+		t.Error("Expected an error for an untracked task")
+	}
+	if empty := tracker.Track(client, "", 100, "start", ""); empty.UPID != "" {
+		t.Error("Expected an empty UPID to be ignored")
+	}
+}
+
+func TestTaskTrackerRecentTasks(t *testing.T) {
+	client := &taskClient{statuses: map[string]TaskStatus{}}
+	tracker := NewTaskTracker(5*time.Millisecond, time.Minute, 2)
+
+	for i := 1; i <= 3; i++ {
+		upid := testUPID(i)
+		client.set(upid, "stopped", "OK")
+		tracker.Track(client, upid, 100, "start", "")
+		if _, err := tracker.Wait(context.Background(), upid); err != nil {
+			t.Fatalf("Wait(%d) error = %v", i, err)
+		}
+	}
+	tracker.Track(client, testUPID(4), 200, "stop", "")
+
+	tasks := tracker.ForVM(100)
+	if len(tasks) != 2 {
+		t.Fatalf("Expected the 2 most recent tasks to be kept, got %d", len(tasks))
+	}
+	if tasks[0].UPID != testUPID(3) || tasks[1].UPID != testUPID(2) {
+		t.Errorf("Expected most recent tasks first, got %s then %s", tasks[0].UPID, tasks[1].UPID)
+	}
+	if _, ok := tracker.Get(testUPID(1)); ok {
+		t.Error("Expected the oldest task to be forgotten")
+	}
+}
+
+func TestTaskTrackerForgetVM(t *testing.T) {
+	client := &taskClient{statuses: map[string]TaskStatus{}}
+	tracker := NewTaskTracker(5*time.Millisecond, time.Minute, 10)
+
+	for i, vmid := range []int{100, 100, 200} {
+		upid := testUPID(i + 1)
+		client.set(upid, "stopped", "OK")
+		tracker.Track(client, upid, vmid, "start", "")
+		if _, err := tracker.Wait(context.Background(), upid); err != nil {
+			t.Fatalf("Wait(%d) error = %v", i+1, err)
+		}
+	}
+
+	tracker.ForgetVM(100)
+	if tasks := tracker.ForVM(100); len(tasks) != 0 {
+		t.Errorf("Expected no task left for the deleted VM, got %d", len(tasks))
+	}
+	if _, ok := tracker.Get(testUPID(1)); ok {
+		t.Error("Expected the task logs of the deleted VM to be unreachable")
+	}
+	if tasks := tracker.ForVM(200); len(tasks) != 1 {
+		t.Errorf("Expected the other VM's task kept, got %d", len(tasks))
+	}
+}
+
+func TestTaskTrackerCountRunning(t *testing.T) {
+	client := &taskClient{statuses: map[string]TaskStatus{}}
+	tracker := NewTaskTracker(5*time.Millisecond, time.Minute, 10)
+
+	client.set(testUPID(1), "running", "")
+	client.set(testUPID(2), "stopped", "OK")
+	client.set(testUPID(3), "running", "")
+	tracker.Track(client, testUPID(1), 100, "snapshot", "alice")
+	tracker.Track(client, testUPID(2), 100, "snapshot", "alice")
+	tracker.Track(client, testUPID(3), 200, "snapshot", "bob")
+	if _, err := tracker.Wait(context.Background(), testUPID(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := tracker.CountRunning("snapshot", "alice"); n != 1 {
+		t.Errorf("Expected one running snapshot task for alice, got %d", n)
+	}
+	if n := tracker.CountRunning("backup", "alice"); n != 0 {
+		t.Errorf("Expected no running backup task for alice, got %d", n)
+	}
+}
+
+func TestUPIDFromResponse(t *testing.T) {
+	if got := UPIDFromResponse(map[string]interface{}{"data": testUPID(1)}); got != testUPID(1) {
+		t.Errorf("UPIDFromResponse() = %q", got)
+	}
+	if got := UPIDFromResponse(map[string]interface{}{"data": nil}); got != "" {
+		t.Errorf("Expected no UPID, got %q", got)
+	}
+}
@@ -0,0 +1,109 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TaskStatus represents the state of a Proxmox task from
+// GET /nodes/{node}/tasks/{upid}/status
+type TaskStatus struct {
+	UPID       string `json:"upid"`
+	Node       string `json:"node"`
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	User       string `json:"user"`
+	Status     string `json:"status"`     // "running" or "stopped"
+	ExitStatus string `json:"exitstatus"` // "OK" on success, set once stopped
+	StartTime  int64  `json:"starttime"`
+}
+
+// Running reports whether the task has not finished yet
+func (t *TaskStatus) Running() bool {
+	return t.Status == "running"
+}
+
+// Succeeded reports whether the task finished with an OK exit status
+func (t *TaskStatus) Succeeded() bool {
+	return !t.Running() && t.ExitStatus == "OK"
+}
+
+// TaskNode extracts the node name from a UPID (UPID:{node}:...)
+func TaskNode(upid string) string {
+	parts := strings.Split(upid, ":")
+	if len(parts) < 2 || parts[0] != "UPID" {
+		return ""
+	}
+	return parts[1]
+}
+
+// taskStatusPath is the API path of a task status
+func taskStatusPath(node, upid string) string {
+	return fmt.Sprintf("/nodes/%s/tasks/%s/status", url.PathEscape(node), url.PathEscape(upid))
+}
+
+// GetTaskStatusWithContext fetches the status of a task on a node
+func GetTaskStatusWithContext(ctx context.Context, client ClientInterface, node, upid string) (*TaskStatus, error) {
+	path := taskStatusPath(node, upid)
+	var resp Response[TaskStatus]
+	if err := client.GetJSON(ctx, path, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get status of task %s: %w", upid, err)
+	}
+	return &resp.Data, nil
+}
+
+// WaitForTaskWithContext polls a task until it stops or ctx is done.
+// It returns an error when the task ends with a non-OK exit status.
+func WaitForTaskWithContext(ctx context.Context, client ClientInterface, node, upid string, interval time.Duration) (*TaskStatus, error) {
+	path := taskStatusPath(node, upid)
+	for {
+		// Task status must never be served from the GET cache
+		client.InvalidateCache(path)
+		status, err := GetTaskStatusWithContext(ctx, client, node, upid)
+		if err != nil {
+			return nil, err
+		}
+		if !status.Running() {
+			if !status.Succeeded() {
+				return status, fmt.Errorf("task %s failed: %s", upid, status.ExitStatus)
+			}
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, fmt.Errorf("waiting for task %s: %w", upid, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// TaskLogLine is one line of GET /nodes/{node}/tasks/{upid}/log
+type TaskLogLine struct {
+	N int    `json:"n"`
+	T string `json:"t"`
+}
+
+// GetTaskLogWithContext fetches up to limit log lines of a task starting at line start.
+// The log grows while the task runs, so it is never served from the GET cache.
+func GetTaskLogWithContext(ctx context.Context, client ClientInterface, node, upid string, start, limit int) ([]TaskLogLine, error) {
+	path := fmt.Sprintf("/nodes/%s/tasks/%s/log?start=%d&limit=%d", url.PathEscape(node), url.PathEscape(upid), start, limit)
+	client.InvalidateCache(path)
+	var resp ListResponse[TaskLogLine]
+	if err := client.GetJSON(ctx, path, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get log of task %s: %w", upid, err)
+	}
+	return resp.Data, nil
+}
+
+// UPIDFromResponse returns the task ID of an asynchronous call answered with {"data":"UPID:..."},
+// or an empty string when the response carries none.
+func UPIDFromResponse(resp map[string]interface{}) string {
+	if upid, ok := resp["data"].(string); ok && strings.HasPrefix(upid, "UPID:") {
+		return upid
+	}
+	return ""
+}
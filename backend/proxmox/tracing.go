@@ -0,0 +1,63 @@
+package proxmox
+
+import (
+	"net/http"
+	"strings"
+
+	"pvmss/tracing"
+)
+
+// tracingTransport records a client span for each request sent to the API
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !tracing.Enabled() {
+		return t.next.RoundTrip(req)
+	}
+
+	endpoint := apiEndpoint(req.URL.Path)
+	attrs := []tracing.Attribute{
+		tracing.String("http.request.method", req.Method),
+		tracing.String("url.path", req.URL.Path),
+		tracing.String("server.address", req.URL.Hostname()),
+		tracing.String("proxmox.endpoint", endpoint),
+	}
+	node, vmid := apiTarget(req.URL.Path)
+	if node != "" {
+		attrs = append(attrs, tracing.String("pvmss.node", node))
+	}
+	if vmid != "" {
+		attrs = append(attrs, tracing.String("pvmss.vmid", vmid))
+	}
+	_, span := tracing.Start(req.Context(), req.Method+" "+endpoint, tracing.KindClient, attrs...)
+	defer span.End()
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return resp, err
+	}
+	span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(tracing.StatusError, resp.Status)
+	}
+	return resp, nil
+}
+
+// apiTarget returns the node and VMID an API path is about, if any
+func apiTarget(path string) (node, vmid string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		switch segments[i] {
+		case "nodes":
+			node = segments[i+1]
+		case "qemu", "lxc":
+			if numericSegment.MatchString(segments[i+1]) {
+				vmid = segments[i+1]
+			}
+		}
+	}
+	return node, vmid
+}
@@ -0,0 +1,61 @@
+package proxmox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pvmss/tracing"
+)
+
+func TestAPITarget(t *testing.T) {
+	for _, tc := range []struct{ path, node, vmid string }{
+		{"/api2/json/nodes/pve1/qemu/100/status/start", "pve1", "100"},
+		{"/api2/json/nodes/pve2/storage/local/content", "pve2", ""},
+		{"/api2/json/cluster/resources", "", ""},
+		{"/api2/json/nodes/pve1/qemu", "pve1", ""},
+	} {
+		if node, vmid := apiTarget(tc.path); node != tc.node || vmid != tc.vmid {
+			t.Errorf("apiTarget(%q) = %q, %q, want %q, %q", tc.path, node, vmid, tc.node, tc.vmid)
+		}
+	}
+}
+
+func TestTracingTransport(t *testing.T) {
+	rec := tracing.NewRecorder()
+	prev := tracing.SetExporter(rec)
+	defer tracing.SetExporter(prev)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	ctx, parent := tracing.Start(context.Background(), "GET /vm/details/:vmid", tracing.KindServer)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/api2/json/nodes/pve1/qemu/100/status/start", nil)
+	resp, err := tracingTransport{next: http.DefaultTransport}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	parent.End()
+
+	spans := rec.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Name != "POST /nodes/:node/qemu/:vmid/status/start" || s.Kind != tracing.KindClient {
+		t.Errorf("Unexpected span %q of kind %d", s.Name, s.Kind)
+	}
+	if s.TraceID != parent.TraceID() || s.ParentSpanID != parent.SpanID() {
+		t.Error("Expected the API call traced as a child of the request")
+	}
+	if s.Attribute("pvmss.node") != "pve1" || s.Attribute("pvmss.vmid") != "100" || s.Attribute("http.response.status_code") != int64(500) {
+		t.Errorf("Unexpected attributes: %v", s.Attributes)
+	}
+	if s.StatusCode != tracing.StatusError {
+		t.Errorf("Expected an error status for a 500, got %d", s.StatusCode)
+	}
+}
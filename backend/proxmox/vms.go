@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 
 	"pvmss/logger"
@@ -54,10 +56,12 @@ func UpdateVMConfigWithContext(ctx context.Context, client ClientInterface, node
 		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Msg("Failed to update VM config")
 		return fmt.Errorf("failed to update config for vm %d on node %s: %w", vmid, node, err)
 	}
-	// Invalidate the cached GET for this VM's config so the next fetch returns fresh data
+	// Invalidate the cached GETs for this VM's config so the next fetch returns fresh data
 	if c, ok := client.(*Client); ok && c != nil {
 		c.InvalidateCache(path)
+		c.InvalidateCache(fmt.Sprintf("/nodes/%s/qemu/%d/pending", url.PathEscape(node), vmid))
 	}
+	InvalidateInventory()
 	return nil
 }
 
@@ -263,16 +267,17 @@ func GetVMCurrentWithContext(ctx context.Context, client ClientInterface, node s
 
 // VM represents a Proxmox virtual machine
 type VM struct {
-	CPU     float64 `json:"cpu"`
-	CPUs    int     `json:"cpus"`
-	MaxDisk int64   `json:"maxdisk"`
-	MaxMem  int64   `json:"maxmem"`
-	Mem     int64   `json:"mem"`
-	Name    string  `json:"name"`
-	Node    string  `json:"node"`
-	Status  string  `json:"status"`
-	Uptime  int64   `json:"uptime"`
-	VMID    int     `json:"vmid"`
+	CPU      float64 `json:"cpu"`
+	CPUs     int     `json:"cpus"`
+	MaxDisk  int64   `json:"maxdisk"`
+	MaxMem   int64   `json:"maxmem"`
+	Mem      int64   `json:"mem"`
+	Name     string  `json:"name"`
+	Node     string  `json:"node"`
+	Status   string  `json:"status"`
+	Uptime   int64   `json:"uptime"`
+	VMID     int     `json:"vmid"`
+	Template int     `json:"template,omitempty"` // 1 for template VMs
 }
 
 // GetVMsWithContext retrieves a comprehensive list of all VMs across all available Proxmox nodes.
@@ -368,6 +373,7 @@ func VMActionWithContext(ctx context.Context, client ClientInterface, node strin
 	if response.Data == "" {
 		return "", fmt.Errorf("did not receive a task ID from Proxmox for action '%s' on VM %s", action, vmid)
 	}
+	InvalidateInventory()
 
 	return response.Data, nil
 }
@@ -375,22 +381,216 @@ func VMActionWithContext(ctx context.Context, client ClientInterface, node strin
 // DeleteVMWithContext deletes a VM from Proxmox.
 // This performs a DELETE request to /nodes/{node}/qemu/{vmid}
 // Note: The VM must be stopped before deletion. Use VMActionWithContext to stop it first if needed.
-func DeleteVMWithContext(ctx context.Context, client ClientInterface, node string, vmid int) error {
+// Returns the UPID of the deletion task, or an empty string when Proxmox did not return one.
+func DeleteVMWithContext(ctx context.Context, client ClientInterface, node string, vmid int) (string, error) {
 	path := fmt.Sprintf("/nodes/%s/qemu/%d", url.PathEscape(node), vmid)
 
 	// Proxmox DELETE typically responds with {"data":"UPID:..."}
-	_, err := client.DeleteWithContext(ctx, path, url.Values{})
+	resp, err := client.DeleteWithContext(ctx, path, url.Values{})
 	if err != nil {
 		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Msg("VM deletion failed")
-		return fmt.Errorf("failed to delete VM %d on node %s: %w", vmid, node, err)
+		return "", fmt.Errorf("failed to delete VM %d on node %s: %w", vmid, node, err)
 	}
 
-	logger.Get().Info().Str("node", node).Int("vmid", vmid).Msg("VM deleted successfully")
+	logger.Get().Info().Str("node", node).Int("vmid", vmid).Msg("VM deletion started")
 
 	// Invalidate cache for this node's VM list
 	if c, ok := client.(*Client); ok && c != nil {
 		c.InvalidateCache(fmt.Sprintf("/nodes/%s/qemu", url.PathEscape(node)))
 	}
+	InvalidateInventory()
 
+	return UPIDFromResponse(resp), nil
+}
+
+// CloneVMOptions holds the optional parameters of a VM clone
+type CloneVMOptions struct {
+	Name    string
+	Pool    string
+	Full    bool   // full copy instead of a linked clone
+	Storage string // target storage, full clones only
+}
+
+// CloneVMWithContext clones a VM or template into newID:
+//
+//	POST /nodes/{node}/qemu/{vmid}/clone
+//
+// Returns the UPID of the clone task; the new VM stays locked until the task ends.
+func CloneVMWithContext(ctx context.Context, client ClientInterface, node string, vmid, newID int, opts CloneVMOptions) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/clone", url.PathEscape(node), vmid)
+	values := url.Values{}
+	values.Set("newid", strconv.Itoa(newID))
+	if opts.Name != "" {
+		values.Set("name", opts.Name)
+	}
+	if opts.Pool != "" {
+		values.Set("pool", opts.Pool)
+	}
+	if opts.Full {
+		values.Set("full", "1")
+		if opts.Storage != "" {
+			values.Set("storage", opts.Storage)
+		}
+	} else {
+		values.Set("full", "0")
+	}
+
+	var response Response[string]
+	if err := client.PostFormAndGetJSON(ctx, path, values, &response); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Int("newid", newID).Msg("VM clone failed")
+		return "", fmt.Errorf("failed to clone vm %d on node %s: %w", vmid, node, err)
+	}
+	if response.Data == "" {
+		return "", fmt.Errorf("did not receive a task ID from Proxmox for clone of VM %d", vmid)
+	}
+	InvalidateInventory()
+	return response.Data, nil
+}
+
+// ResizeVMDiskWithContext grows a VM disk to an absolute size in GB:
+//
+//	PUT /nodes/{node}/qemu/{vmid}/resize
+//
+// Proxmox refuses to shrink disks, so sizeGB must not be smaller than the current size.
+func ResizeVMDiskWithContext(ctx context.Context, client ClientInterface, node string, vmid int, disk string, sizeGB int) error {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/resize", url.PathEscape(node), vmid)
+	values := url.Values{}
+	values.Set("disk", disk)
+	values.Set("size", strconv.Itoa(sizeGB)+"G")
+	if _, err := client.PutFormWithContext(ctx, path, values); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Str("disk", disk).Msg("VM disk resize failed")
+		return fmt.Errorf("failed to resize disk %s of vm %d on node %s: %w", disk, vmid, node, err)
+	}
+	if c, ok := client.(*Client); ok && c != nil {
+		c.InvalidateCache(fmt.Sprintf("/nodes/%s/qemu/%d/config", url.PathEscape(node), vmid))
+	}
+	InvalidateInventory()
 	return nil
 }
+
+// PendingChange is one entry of GET /nodes/{node}/qemu/{vmid}/pending.
+// Value is the running value and Pending the value applied on the next VM start.
+type PendingChange struct {
+	Key     string      `json:"key"`
+	Value   interface{} `json:"value"`
+	Pending interface{} `json:"pending"`
+	Delete  int         `json:"delete"`
+}
+
+// GetVMPendingWithContext returns the VM config entries, flagging those that only apply after a restart
+func GetVMPendingWithContext(ctx context.Context, client ClientInterface, node string, vmid int) ([]PendingChange, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/pending", url.PathEscape(node), vmid)
+	var response ListResponse[PendingChange]
+	if err := client.GetJSON(ctx, path, &response); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Msg("Failed to get VM pending changes")
+		return nil, fmt.Errorf("failed to get pending changes for vm %d on node %s: %w", vmid, node, err)
+	}
+	return response.Data, nil
+}
+
+// PendingKeys returns the keys of the changes that wait for a VM restart
+func PendingKeys(changes []PendingChange) []string {
+	var keys []string
+	for _, c := range changes {
+		if c.Pending != nil || c.Delete != 0 {
+			keys = append(keys, c.Key)
+		}
+	}
+	return keys
+}
+
+// Snapshot represents a VM snapshot from GET /nodes/{node}/qemu/{vmid}/snapshot
+type Snapshot struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parent      string `json:"parent"`
+	SnapTime    int64  `json:"snaptime"`
+	VMState     int    `json:"vmstate"` // 1 when the RAM state was saved
+}
+
+// snapshotPath returns the snapshot collection path of a VM, or a single snapshot when name is set
+func snapshotPath(node string, vmid int, name string) string {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/snapshot", url.PathEscape(node), vmid)
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return path
+}
+
+// GetVMSnapshotsWithContext lists the snapshots of a VM, newest first.
+// The pseudo-snapshot "current" returned by Proxmox is omitted.
+func GetVMSnapshotsWithContext(ctx context.Context, client ClientInterface, node string, vmid int) ([]Snapshot, error) {
+	var resp ListResponse[Snapshot]
+	if err := client.GetJSON(ctx, snapshotPath(node, vmid, ""), &resp); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Msg("Failed to list VM snapshots")
+		return nil, fmt.Errorf("failed to list snapshots of vm %d on node %s: %w", vmid, node, err)
+	}
+	snapshots := make([]Snapshot, 0, len(resp.Data))
+	for _, s := range resp.Data {
+		if s.Name == "current" {
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].SnapTime > snapshots[j].SnapTime })
+	return snapshots, nil
+}
+
+// InvalidateVMSnapshots drops the cached snapshot list of a VM, so the next listing reads it from Proxmox
+func InvalidateVMSnapshots(client ClientInterface, node string, vmid int) {
+	client.InvalidateCache(snapshotPath(node, vmid, ""))
+}
+
+// CreateVMSnapshotWithContext takes a snapshot of a VM:
+//
+//	POST /nodes/{node}/qemu/{vmid}/snapshot
+//
+// includeRAM also saves the memory state of a running VM. Returns the task UPID.
+func CreateVMSnapshotWithContext(ctx context.Context, client ClientInterface, node string, vmid int, name, description string, includeRAM bool) (string, error) {
+	values := url.Values{}
+	values.Set("snapname", name)
+	if description != "" {
+		values.Set("description", description)
+	}
+	if includeRAM {
+		values.Set("vmstate", "1")
+	}
+	var response Response[string]
+	if err := client.PostFormAndGetJSON(ctx, snapshotPath(node, vmid, ""), values, &response); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Str("snapshot", name).Msg("VM snapshot creation failed")
+		return "", fmt.Errorf("failed to create snapshot %s of vm %d on node %s: %w", name, vmid, node, err)
+	}
+	client.InvalidateCache(snapshotPath(node, vmid, ""))
+	return response.Data, nil
+}
+
+// RollbackVMSnapshotWithContext reverts a VM to a snapshot:
+//
+//	POST /nodes/{node}/qemu/{vmid}/snapshot/{snapname}/rollback
+//
+// Returns the task UPID.
+func RollbackVMSnapshotWithContext(ctx context.Context, client ClientInterface, node string, vmid int, name string) (string, error) {
+	var response Response[string]
+	if err := client.PostFormAndGetJSON(ctx, snapshotPath(node, vmid, name)+"/rollback", url.Values{}, &response); err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Str("snapshot", name).Msg("VM snapshot rollback failed")
+		return "", fmt.Errorf("failed to roll back vm %d on node %s to snapshot %s: %w", vmid, node, name, err)
+	}
+	client.InvalidateCache(fmt.Sprintf("/nodes/%s/qemu/%d/config", url.PathEscape(node), vmid))
+	return response.Data, nil
+}
+
+// DeleteVMSnapshotWithContext deletes a snapshot of a VM:
+//
+//	DELETE /nodes/{node}/qemu/{vmid}/snapshot/{snapname}
+//
+// Returns the task UPID when Proxmox provides one.
+func DeleteVMSnapshotWithContext(ctx context.Context, client ClientInterface, node string, vmid int, name string) (string, error) {
+	resp, err := client.DeleteWithContext(ctx, snapshotPath(node, vmid, name), url.Values{})
+	if err != nil {
+		logger.Get().Error().Err(err).Str("node", node).Int("vmid", vmid).Str("snapshot", name).Msg("VM snapshot deletion failed")
+		return "", fmt.Errorf("failed to delete snapshot %s of vm %d on node %s: %w", name, vmid, node, err)
+	}
+	client.InvalidateCache(snapshotPath(node, vmid, ""))
+	upid, _ := resp["data"].(string)
+	return upid, nil
+}
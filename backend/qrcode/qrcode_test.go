@@ -0,0 +1,178 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, from the worked example of the specification
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("Expected error correction codewords %v, got %v", want, got)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	for mask, want := range []int{
+		0b101010000010010, 0b101000100100101, 0b101111001111100, 0b101101101001011,
+		0b100010111111001, 0b100000011001110, 0b100111110010111, 0b100101010100000,
+	} {
+		if got := formatBits(mask); got != want {
+			t.Errorf("Format bits of M/%d: expected %015b, got %015b", mask, want, got)
+		}
+	}
+	if got := versionBits(7); got != 0b000111110010010100 {
+		t.Errorf("Unexpected version 7 information %018b", got)
+	}
+	if got := versionBits(10); got != 0b001010010011010011 {
+		t.Errorf("Unexpected version 10 information %018b", got)
+	}
+}
+
+// readBack extracts the payload of a code the way a reader does: it reads the format
+// information, removes the mask, collects the codewords, checks each block and decodes the segment
+func readBack(t *testing.T, c *Code) string {
+	t.Helper()
+
+	format := 0
+	for i := 0; i < 8; i++ {
+		if c.Dark(c.Size-1-i, 8) {
+			format |= 1 << i
+		}
+	}
+	for i := 8; i < 15; i++ {
+		if c.Dark(8, c.Size-15+i) {
+			format |= 1 << i
+		}
+	}
+	mask := (format ^ 0x5412) >> 10 & 7
+	if format != formatBits(mask) || mask != c.Mask {
+		t.Fatalf("Invalid format information %015b for mask %d", format, c.Mask)
+	}
+
+	var stream []byte
+	var cur byte
+	n := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if c.isFunction[y][x] {
+					continue
+				}
+				cur = cur<<1 | boolByte(c.Dark(x, y) != maskInverts(mask, x, y))
+				if n++; n%8 == 0 {
+					stream = append(stream, cur)
+					cur = 0
+				}
+			}
+		}
+	}
+	stream = stream[:totalCodewords[c.Version]]
+
+	// De-interleave, then check that every block is a Reed-Solomon codeword
+	numBlocks, eccLen := eccBlocks[c.Version], eccPerBlock[c.Version]
+	numShort := numBlocks - totalCodewords[c.Version]%numBlocks
+	shortData := totalCodewords[c.Version]/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortData; i++ {
+		for j := range blocks {
+			if i < shortData || j >= numShort {
+				blocks[j] = append(blocks[j], stream[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], stream[k])
+			k++
+		}
+	}
+	var data []byte
+	for j, block := range blocks {
+		// A codeword evaluates to zero at the roots of the generator polynomial
+		root := byte(1)
+		for i := 0; i < eccLen; i++ {
+			var s byte
+			for _, b := range block {
+				s = reedSolomonMultiply(s, root) ^ b
+			}
+			if s != 0 {
+				t.Fatalf("Block %d is not a valid codeword", j)
+			}
+			root = reedSolomonMultiply(root, 0x02)
+		}
+		data = append(data, block[:len(block)-eccLen]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("Expected a byte mode segment, got mode %x", data[0]>>4)
+	}
+	bitPos := 4
+	readBits := func(w int) int {
+		v := 0
+		for i := 0; i < w; i++ {
+			v = v<<1 | int(data[bitPos>>3]>>(7-bitPos&7)&1)
+			bitPos++
+		}
+		return v
+	}
+	out := make([]byte, readBits(charCountBits(c.Version)))
+	for i := range out {
+		out[i] = byte(readBits(8))
+	}
+	return string(out)
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestEncodeReadsBack(t *testing.T) {
+	uri := "otpauth://totp/PVMSS:alice?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=PVMSS&algorithm=SHA1&digits=6&period=30"
+	for _, text := range []string{"", "PVMSS", uri, strings.Repeat("x", capacity(7)), strings.Repeat("y", capacity(maxVersion))} {
+		c, err := Encode(text)
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", len(text), err)
+		}
+		if c.Size != c.Version*4+17 {
+			t.Errorf("Unexpected size %d for version %d", c.Size, c.Version)
+		}
+		if c.Version > 1 && len(text) <= capacity(c.Version-1) {
+			t.Errorf("%d bytes fit in version %d, got version %d", len(text), c.Version-1, c.Version)
+		}
+		if got := readBack(t, c); got != text {
+			t.Errorf("Expected %q to read back, got %q", text, got)
+		}
+	}
+
+	if _, err := Encode(strings.Repeat("z", capacity(maxVersion)+1)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	c, err := Encode("PVMSS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := c.SVG(4)
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `viewBox="0 0 29 29"`) || !strings.Contains(svg, "M4 4h1v1h-1z") {
+		t.Errorf("Unexpected SVG %s", svg)
+	}
+}
@@ -64,6 +64,13 @@ func CSRF(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := logger.Get().With().Str("middleware", "security.CSRF").Logger()
 
+		// Bearer-authenticated API calls carry no cookie-based credentials to forge;
+		// the token itself is validated by the API handlers.
+		if IsBearerAPIRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Get the session manager. This must be available in the context.
 		sessionManager := GetSession(r)
 		if sessionManager == nil {
@@ -110,6 +117,25 @@ func CSRF(next http.Handler) http.Handler {
 	})
 }
 
+// IsBearerAPIRequest reports whether r targets the versioned JSON API with an Authorization: Bearer header
+func IsBearerAPIRequest(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		return false
+	}
+	_, ok := BearerToken(r)
+	return ok
+}
+
+// BearerToken extracts the token from an Authorization: Bearer header
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
 // getOrCreateSessionToken retrieves a CSRF token from the session or generates a new one.
 func getOrCreateSessionToken(r *http.Request, sm sessionManager) string {
 	token := sm.GetString(r.Context(), csrfSessionKey)
@@ -11,6 +11,8 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/alexedwards/scs/v2/memstore"
 	"pvmss/logger"
+	"pvmss/sessionstore"
+	"pvmss/state"
 )
 
 // RegisterSessionTypes registers custom types with gob for session serialization
@@ -39,7 +41,11 @@ func InitSecurity() (*scs.SessionManager, error) {
 
 	// Initialize session manager with enhanced configuration.
 	scsm := scs.New()
-	scsm.Store = memstore.New()
+	store, err := newSessionStore(sessionSecret)
+	if err != nil {
+		return nil, err
+	}
+	scsm.Store = store
 	scsm.Lifetime = 24 * time.Hour
 	scsm.Cookie = scs.SessionCookie{
 		Name:     "pvmss_session",
@@ -62,3 +68,44 @@ func InitSecurity() (*scs.SessionManager, error) {
 
 	return scsm, nil
 }
+
+// newSessionStore returns the session store selected by SESSION_STORE: memory (the default), file,
+// or redis to keep the sessions on a Redis server. PVMSS still runs as a single instance with redis:
+// the rest of its state is in local files and the lease reaper and power scheduler are not shared.
+// Sessions hold the Proxmox tickets of the users, so the persistent stores keep them encrypted with
+// a key derived from SESSION_SECRET.
+func newSessionStore(sessionSecret string) (scs.Store, error) {
+	log := logger.Get()
+
+	var store sessionstore.Store
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("SESSION_STORE"))); kind {
+	case "", "memory":
+		log.Info().Msg("Sessions are kept in memory and lost on restart")
+		return memstore.New(), nil
+	case "file":
+		path, err := state.DataFilePath("PVMSS_SESSIONS_PATH", "sessions.db")
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve session store path: %w", err)
+		}
+		fileStore, err := sessionstore.OpenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Str("path", path).Msg("Sessions are stored in a local file")
+		store = fileStore
+	case "redis":
+		redisURL := os.Getenv("SESSION_REDIS_URL")
+		if redisURL == "" {
+			return nil, fmt.Errorf("SESSION_REDIS_URL environment variable not set for SESSION_STORE=redis")
+		}
+		redisStore, err := sessionstore.OpenRedis(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Str("addr", redisStore.Addr()).Msg("Sessions are stored in Redis")
+		store = redisStore
+	default:
+		return nil, fmt.Errorf("unknown SESSION_STORE %q: use memory, file or redis", kind)
+	}
+	return sessionstore.Encrypt(store, sessionSecret)
+}
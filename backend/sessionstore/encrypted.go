@@ -0,0 +1,100 @@
+package sessionstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"time"
+)
+
+const (
+	// encryptionInfo binds the derived key to its use, so the secret can serve other purposes
+	encryptionInfo = "pvmss session store v1"
+	// sealedVersion is the first byte of the encrypted data, for a later change of format
+	sealedVersion byte = 1
+)
+
+// encryptedStore encrypts session data before it reaches the underlying store
+type encryptedStore struct {
+	Store
+	aead cipher.AEAD
+}
+
+// Encrypt wraps store so that session data is kept encrypted with AES-256-GCM, under a key derived
+// from secret with HKDF-SHA256. The token is authenticated with the data, so the data of a session
+// cannot be replayed under another token. Data that does not decrypt, e.g. after a change of
+// secret, reads as no session.
+func Encrypt(store Store, secret string) (Store, error) {
+	if secret == "" {
+		return nil, errors.New("session encryption secret is empty")
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, encryptionInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStore{Store: store, aead: aead}, nil
+}
+
+func (s *encryptedStore) seal(token string, b []byte) ([]byte, error) {
+	out := make([]byte, 1+s.aead.NonceSize(), 1+s.aead.NonceSize()+len(b)+s.aead.Overhead())
+	out[0] = sealedVersion
+	nonce := out[1:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(out, nonce, b, []byte(token)), nil
+}
+
+func (s *encryptedStore) open(token string, b []byte) ([]byte, bool) {
+	if len(b) < 1+s.aead.NonceSize() || b[0] != sealedVersion {
+		return nil, false
+	}
+	nonce, sealed := b[1:1+s.aead.NonceSize()], b[1+s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, sealed, []byte(token))
+	return plain, err == nil
+}
+
+// Find returns the decrypted data of a session
+func (s *encryptedStore) Find(token string) ([]byte, bool, error) {
+	b, found, err := s.Store.Find(token)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	plain, ok := s.open(token, b)
+	return plain, ok, nil
+}
+
+// Commit encrypts and stores the data of a session
+func (s *encryptedStore) Commit(token string, b []byte, expiry time.Time) error {
+	sealed, err := s.seal(token, b)
+	if err != nil {
+		return err
+	}
+	return s.Store.Commit(token, sealed, expiry)
+}
+
+// All returns the decrypted data of every session, leaving out the data that does not decrypt
+func (s *encryptedStore) All() (map[string][]byte, error) {
+	all, err := s.Store.All()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make(map[string][]byte, len(all))
+	for token, b := range all {
+		if plain, ok := s.open(token, b); ok {
+			sessions[token] = plain
+		}
+	}
+	return sessions, nil
+}
@@ -0,0 +1,266 @@
+package sessionstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	recordCommit byte = 1
+	recordDelete byte = 2
+
+	// recordHeaderSize is the operation, the expiry, the token length and the data length
+	recordHeaderSize = 1 + 8 + 2 + 4
+	// maxRecordData bounds the data length read back, so a corrupt length cannot exhaust memory
+	maxRecordData = 16 << 20
+	// compactMinRecords is the log length under which the file is never rewritten
+	compactMinRecords = 1000
+)
+
+// errCorruptRecord marks a record cut short by a crash or damaged on disk
+var errCorruptRecord = errors.New("corrupt session record")
+
+// fileSession is a session held by a FileStore
+type fileSession struct {
+	data   []byte
+	expiry time.Time
+}
+
+// FileStore keeps sessions in a local file, for a single instance. The file is a log of commits
+// and deletions replayed when the store opens, and rewritten with the live sessions only once
+// most of its records are obsolete. A record cut short by a crash is dropped.
+type FileStore struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	sessions map[string]fileSession
+	records  int // records in the log, live or obsolete
+}
+
+// OpenFile opens the store kept in path, creating it when missing
+func OpenFile(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session store directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session store: %w", err)
+	}
+
+	s := &FileStore{path: path, file: f, sessions: make(map[string]fileSession)}
+	if err := s.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if s.records > compactMinRecords && s.records > 2*len(s.sessions) {
+		if err := s.compact(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// replay loads the sessions from the log and cuts off a damaged tail
+func (s *FileStore) replay() error {
+	r := bufio.NewReader(s.file)
+	var offset int64
+	now := time.Now()
+	for {
+		op, token, data, expiry, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if truncErr := s.file.Truncate(offset); truncErr != nil {
+				return fmt.Errorf("failed to drop damaged session records: %w", truncErr)
+			}
+			break
+		}
+		offset += n
+		s.records++
+
+		switch op {
+		case recordCommit:
+			s.sessions[token] = fileSession{data: data, expiry: expiry}
+		case recordDelete:
+			delete(s.sessions, token)
+		}
+	}
+
+	for token, session := range s.sessions {
+		if !now.Before(session.expiry) {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+// readRecord reads one record and returns its length in bytes
+func readRecord(r *bufio.Reader) (op byte, token string, data []byte, expiry time.Time, n int64, err error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return 0, "", nil, time.Time{}, 0, io.EOF
+		}
+		return 0, "", nil, time.Time{}, 0, errCorruptRecord
+	}
+	tokenLen := int(binary.BigEndian.Uint16(header[9:11]))
+	dataLen := int(binary.BigEndian.Uint32(header[11:15]))
+	if dataLen > maxRecordData {
+		return 0, "", nil, time.Time{}, 0, errCorruptRecord
+	}
+
+	body := make([]byte, tokenLen+dataLen+4)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, "", nil, time.Time{}, 0, errCorruptRecord
+	}
+	sum := crc32.NewIEEE()
+	sum.Write(header)
+	sum.Write(body[:tokenLen+dataLen])
+	if sum.Sum32() != binary.BigEndian.Uint32(body[tokenLen+dataLen:]) {
+		return 0, "", nil, time.Time{}, 0, errCorruptRecord
+	}
+
+	op = header[0]
+	expiry = time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9])))
+	token = string(body[:tokenLen])
+	data = body[tokenLen : tokenLen+dataLen]
+	return op, token, data, expiry, int64(recordHeaderSize + len(body)), nil
+}
+
+// encodeRecord returns a record with its checksum
+func encodeRecord(op byte, token string, data []byte, expiry time.Time) []byte {
+	b := make([]byte, recordHeaderSize, recordHeaderSize+len(token)+len(data)+4)
+	b[0] = op
+	binary.BigEndian.PutUint64(b[1:9], uint64(expiry.UnixNano()))
+	binary.BigEndian.PutUint16(b[9:11], uint16(len(token)))
+	binary.BigEndian.PutUint32(b[11:15], uint32(len(data)))
+	b = append(b, token...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+// append writes a record to the log and rewrites the log when mostly obsolete
+func (s *FileStore) append(record []byte) error {
+	if _, err := s.file.Write(record); err != nil {
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+	s.records++
+	if s.records > compactMinRecords && s.records > 2*len(s.sessions) {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact rewrites the log with the unexpired sessions only, replacing it atomically
+func (s *FileStore) compact() error {
+	now := time.Now()
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite session store: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	for token, session := range s.sessions {
+		if !now.Before(session.expiry) {
+			delete(s.sessions, token)
+			continue
+		}
+		if _, err := w.Write(encodeRecord(recordCommit, token, session.data, session.expiry)); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to rewrite session store: %w", err)
+		}
+	}
+	if err := w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rewrite session store: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen session store: %w", err)
+	}
+	s.file.Close()
+	s.file = f
+	s.records = len(s.sessions)
+	return nil
+}
+
+// Find returns the data of an unexpired session
+func (s *FileStore) Find(token string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok || !time.Now().Before(session.expiry) {
+		return nil, false, nil
+	}
+	return append([]byte(nil), session.data...), true, nil
+}
+
+// Commit adds or replaces a session
+func (s *FileStore) Commit(token string, b []byte, expiry time.Time) error {
+	if len(token) > 0xffff || len(b) > maxRecordData {
+		return errors.New("session too large for the session store")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := append([]byte(nil), b...)
+	s.sessions[token] = fileSession{data: data, expiry: expiry}
+	return s.append(encodeRecord(recordCommit, token, data, expiry))
+}
+
+// Delete removes a session
+func (s *FileStore) Delete(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[token]; !ok {
+		return nil
+	}
+	delete(s.sessions, token)
+	return s.append(encodeRecord(recordDelete, token, nil, time.Unix(0, 0)))
+}
+
+// All returns the data of every unexpired session
+func (s *FileStore) All() (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	sessions := make(map[string][]byte, len(s.sessions))
+	for token, session := range s.sessions {
+		if now.Before(session.expiry) {
+			sessions[token] = append([]byte(nil), session.data...)
+		}
+	}
+	return sessions, nil
+}
+
+// Close closes the file of the store
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,296 @@
+package sessionstore
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// KeyPrefix namespaces the session keys in Redis
+	KeyPrefix = "pvmss:session:"
+
+	redisTimeout = 5 * time.Second
+	redisMaxIdle = 8
+	// redisMaxBulk bounds the length of a reply read back, as Redis does for values
+	redisMaxBulk = 512 << 20
+	// redisScanCount is the number of keys asked for on each SCAN iteration
+	redisScanCount = "200"
+)
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisConn is a connection to the server with its reply reader
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// RedisStore keeps sessions in a Redis server, or any server speaking its protocol, so that they
+// outlive the container. Each session is the key KeyPrefix+token, expiring with the session.
+type RedisStore struct {
+	addr      string
+	username  string
+	password  string
+	db        int
+	tlsConfig *tls.Config
+	idle      chan *redisConn
+}
+
+// OpenRedis connects to the server of rawURL, redis://[[user]:password@]host[:port][/db], or
+// rediss:// for TLS
+func OpenRedis(rawURL string) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid Redis URL scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("invalid Redis URL: missing host")
+	}
+
+	s := &RedisStore{addr: u.Host, idle: make(chan *redisConn, redisMaxIdle)}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		s.tlsConfig = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil || s.db < 0 {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+
+	if _, err := s.do("PING"); err != nil {
+		return nil, fmt.Errorf("failed to reach Redis at %s: %w", s.addr, err)
+	}
+	return s, nil
+}
+
+// Addr returns the host and port of the server
+func (s *RedisStore) Addr() string {
+	return s.addr
+}
+
+// dial opens an authenticated connection on the database of the store
+func (s *RedisStore) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if s.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+
+	var setup [][]string
+	switch {
+	case s.username != "":
+		setup = append(setup, []string{"AUTH", s.username, s.password})
+	case s.password != "":
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(args); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// roundTrip sends a command and reads its reply
+func (c *redisConn) roundTrip(args []string) (any, error) {
+	if err := c.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// readReply reads a RESP2 reply: a string, a redisError, an int64, a []byte, a []any or nil
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("malformed Redis reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > redisMaxBulk {
+			return nil, errors.New("malformed Redis bulk length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > redisMaxBulk {
+			return nil, errors.New("malformed Redis array length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected Redis reply type %q", kind)
+}
+
+// do runs a command on an idle connection, or a new one, and returns its reply. A connection
+// that failed is closed rather than reused.
+func (s *RedisStore) do(args ...string) (any, error) {
+	var c *redisConn
+	select {
+	case c = <-s.idle:
+	default:
+		var err error
+		if c, err = s.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	select {
+	case s.idle <- c:
+	default:
+		c.Close()
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// Find returns the data of a session
+func (s *RedisStore) Find(token string) ([]byte, bool, error) {
+	reply, err := s.do("GET", KeyPrefix+token)
+	if err != nil {
+		return nil, false, err
+	}
+	b, ok := reply.([]byte)
+	return b, ok, nil
+}
+
+// Commit stores a session until expiry
+func (s *RedisStore) Commit(token string, b []byte, expiry time.Time) error {
+	ttl := time.Until(expiry).Milliseconds()
+	if ttl <= 0 {
+		return s.Delete(token)
+	}
+	_, err := s.do("SET", KeyPrefix+token, string(b), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+// Delete removes a session
+func (s *RedisStore) Delete(token string) error {
+	_, err := s.do("DEL", KeyPrefix+token)
+	return err
+}
+
+// All returns the data of every session, scanning the keys under KeyPrefix
+func (s *RedisStore) All() (map[string][]byte, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.do("SCAN", cursor, "MATCH", KeyPrefix+"*", "COUNT", redisScanCount)
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, errors.New("malformed Redis SCAN reply")
+		}
+		next, _ := page[0].([]byte)
+		batch, _ := page[1].([]any)
+		for _, key := range batch {
+			if k, ok := key.([]byte); ok {
+				keys = append(keys, string(k))
+			}
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			break
+		}
+	}
+
+	sessions := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		reply, err := s.do("GET", key)
+		if err != nil {
+			return nil, err
+		}
+		// A session expired between the scan and the read is left out
+		if b, ok := reply.([]byte); ok {
+			sessions[strings.TrimPrefix(key, KeyPrefix)] = b
+		}
+	}
+	return sessions, nil
+}
+
+// Close closes the idle connections
+func (s *RedisStore) Close() error {
+	for {
+		select {
+		case c := <-s.idle:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
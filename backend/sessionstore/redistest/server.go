@@ -0,0 +1,303 @@
+// Package redistest provides an in-process server speaking the Redis protocol, for tests and
+// development. It implements the commands the session store uses on a single database: PING,
+// AUTH, SELECT, GET, SET with EX or PX, DEL, SCAN with MATCH and FLUSHALL.
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entry is a stored value with its expiry, zero for none
+type entry struct {
+	value  []byte
+	expiry time.Time
+}
+
+// Server is a Redis stand-in listening on a local port
+type Server struct {
+	// Addr is the host and port the server listens on
+	Addr string
+
+	listener net.Listener
+	password string
+
+	mu    sync.Mutex
+	data  map[string]entry
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer starts a server; connections must AUTH with password when it is not empty
+func NewServer(password string) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: l.Addr().String(), listener: l, password: password, data: map[string]entry{}, conns: map[net.Conn]struct{}{}}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// URL returns the redis:// URL of the server, with its password
+func (s *Server) URL() string {
+	if s.password != "" {
+		return "redis://:" + s.password + "@" + s.Addr
+	}
+	return "redis://" + s.Addr
+}
+
+// Close stops the server and closes its connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Get returns the raw value of a key, as the server stores it
+func (s *Server) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(key)
+	return e.value, ok
+}
+
+// TTL returns the time left before a key expires, zero for a key without expiry
+func (s *Server) TTL(key string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(key)
+	if !ok || e.expiry.IsZero() {
+		return 0, ok
+	}
+	return time.Until(e.expiry), true
+}
+
+// lookup returns an unexpired entry, dropping it once expired; s.mu must be held
+func (s *Server) lookup(key string) (entry, bool) {
+	e, ok := s.data[key]
+	if ok && !e.expiry.IsZero() && !time.Now().Before(e.expiry) {
+		delete(s.data, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// serve answers the commands of a connection until it closes
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(args[0])
+		var reply string
+		switch {
+		case name == "AUTH":
+			reply, authenticated = s.auth(args[1:], authenticated)
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		default:
+			reply = s.execute(name, args[1:])
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// auth checks the password of an AUTH password or AUTH username password command
+func (s *Server) auth(args []string, authenticated bool) (string, bool) {
+	if len(args) == 0 || len(args) > 2 {
+		return "-ERR wrong number of arguments for 'auth' command\r\n", authenticated
+	}
+	if s.password == "" {
+		return "-ERR AUTH called without any password configured\r\n", authenticated
+	}
+	if args[len(args)-1] != s.password {
+		return "-WRONGPASS invalid username-password pair\r\n", false
+	}
+	return "+OK\r\n", true
+}
+
+// execute runs a command and returns its encoded reply
+func (s *Server) execute(name string, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch name {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "FLUSHALL":
+		s.data = map[string]entry{}
+		return "+OK\r\n"
+	case "GET":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'get' command\r\n"
+		}
+		e, ok := s.lookup(args[0])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(string(e.value))
+	case "SET":
+		return s.set(args)
+	case "DEL":
+		deleted := 0
+		for _, key := range args {
+			if _, ok := s.lookup(key); ok {
+				delete(s.data, key)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "SCAN":
+		return s.scan(args)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", name)
+}
+
+// set stores a value, with an expiry given by EX seconds or PX milliseconds
+func (s *Server) set(args []string) string {
+	if len(args) != 2 && len(args) != 4 {
+		return "-ERR syntax error\r\n"
+	}
+	e := entry{value: []byte(args[1])}
+	if len(args) == 4 {
+		n, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || n <= 0 {
+			return "-ERR invalid expire time in 'set' command\r\n"
+		}
+		switch strings.ToUpper(args[2]) {
+		case "EX":
+			e.expiry = time.Now().Add(time.Duration(n) * time.Second)
+		case "PX":
+			e.expiry = time.Now().Add(time.Duration(n) * time.Millisecond)
+		default:
+			return "-ERR syntax error\r\n"
+		}
+	}
+	s.data[args[0]] = e
+	return "+OK\r\n"
+}
+
+// scan returns every matching key in one iteration, with the cursor 0
+func (s *Server) scan(args []string) string {
+	if len(args) == 0 {
+		return "-ERR wrong number of arguments for 'scan' command\r\n"
+	}
+	pattern := "*"
+	for i := 1; i+1 < len(args); i += 2 {
+		if strings.EqualFold(args[i], "MATCH") {
+			pattern = args[i+1]
+		}
+	}
+
+	var keys []string
+	for key := range s.data {
+		if _, ok := s.lookup(key); !ok {
+			continue
+		}
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	var b strings.Builder
+	b.WriteString("*2\r\n" + bulk("0"))
+	fmt.Fprintf(&b, "*%d\r\n", len(keys))
+	for _, key := range keys {
+		b.WriteString(bulk(key))
+	}
+	return b.String()
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, errors.New("expected an array")
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, errors.New("invalid array length")
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errors.New("expected a bulk string")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.New("invalid bulk length")
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
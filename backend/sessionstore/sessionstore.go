@@ -0,0 +1,19 @@
+// Package sessionstore provides session stores that outlive the process: an embedded database file
+// and a Redis store. They implement the Find, Commit,
+// Delete and All methods of scs.Store and scs.IterableStore, and Encrypt keeps their data
+// encrypted at rest.
+package sessionstore
+
+import "time"
+
+// Store is a session store as used by the scs session manager, keyed by session token
+type Store interface {
+	// Find returns the data of an unexpired session
+	Find(token string) (b []byte, found bool, err error)
+	// Commit adds or replaces a session, until expiry
+	Commit(token string, b []byte, expiry time.Time) error
+	// Delete removes a session; an unknown token is not an error
+	Delete(token string) error
+	// All returns the data of every unexpired session, by token
+	All() (map[string][]byte, error)
+}
@@ -0,0 +1,216 @@
+package sessionstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pvmss/sessionstore/redistest"
+)
+
+// testStore runs the behaviour every store shares
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	expiry := time.Now().Add(time.Hour)
+
+	if _, found, err := s.Find("missing"); err != nil || found {
+		t.Fatalf("Expected an unknown token to be missing, got found=%v err=%v", found, err)
+	}
+	if err := s.Commit("alice", []byte("alice data"), expiry); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit("bob", []byte("bob data"), expiry); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit("alice", []byte("alice data v2"), expiry); err != nil {
+		t.Fatal(err)
+	}
+	if b, found, err := s.Find("alice"); err != nil || !found || string(b) != "alice data v2" {
+		t.Fatalf("Expected the last commit of alice, got %q found=%v err=%v", b, found, err)
+	}
+
+	if err := s.Commit("expired", []byte("old"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := s.Find("expired"); found {
+		t.Error("Expected an expired session to be missing")
+	}
+
+	if err := s.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("bob"); err != nil {
+		t.Errorf("Expected deleting a missing session to succeed, got %v", err)
+	}
+	all, err := s.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || string(all["alice"]) != "alice data v2" {
+		t.Errorf("Expected only alice in All, got %v", all)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions", "sessions.db")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	if err := s.Commit("carol", []byte("carol data"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the store to be readable by its owner only, got %v", info.Mode().Perm())
+	}
+
+	// Sessions survive a restart, and a record cut short by a crash is dropped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := encodeRecord(recordCommit, "dave", []byte("dave data"), time.Now().Add(time.Hour))
+	f.Write(partial[:len(partial)-3])
+	f.Close()
+
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	all, _ := s.All()
+	if len(all) != 2 || string(all["alice"]) != "alice data v2" || string(all["carol"]) != "carol data" {
+		t.Fatalf("Expected alice and carol after a restart, got %v", all)
+	}
+	if err := s.Commit("erin", []byte("erin data"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if s, err = OpenFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if b, found, _ := s.Find("erin"); !found || string(b) != "erin data" {
+		t.Errorf("Expected a session written after the damaged record to be kept, got %q", b)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	expiry := time.Now().Add(time.Hour)
+	for i := 0; i < 3*compactMinRecords; i++ {
+		if err := s.Commit("busy", bytes.Repeat([]byte("x"), 100), expiry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, _ := os.Stat(path)
+	if info.Size() > int64(compactMinRecords*200) {
+		t.Errorf("Expected the log to be rewritten, got %d bytes", info.Size())
+	}
+	if _, found, _ := s.Find("busy"); !found {
+		t.Error("Expected the session to survive the rewrite")
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	server, err := redistest.NewServer("redis-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if _, err := OpenRedis("redis://:wrong@" + server.Addr); err == nil {
+		t.Error("Expected a wrong password to be refused")
+	}
+	s, err := OpenRedis(server.URL() + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, s)
+
+	ttl, ok := server.TTL(KeyPrefix + "alice")
+	if !ok || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected the key to expire with the session, got %v", ttl)
+	}
+
+	// Replicas sharing the server see the same sessions
+	replica, err := OpenRedis(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	if b, found, err := replica.Find("alice"); err != nil || !found || string(b) != "alice data v2" {
+		t.Errorf("Expected another replica to find the session, got %q found=%v err=%v", b, found, err)
+	}
+
+	// With the server gone, operations fail rather than hang
+	server.Close()
+	if _, _, err := s.Find("alice"); err == nil {
+		t.Error("Expected an error with the server down")
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	server, err := redistest.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	raw, err := OpenRedis(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	s, err := Encrypt(raw, "session secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+
+	plain := []byte("pve_auth_cookie PVE:alice@pve:ticket")
+	if err := s.Commit("alice", plain, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := server.Get(KeyPrefix + "alice")
+	if bytes.Contains(stored, []byte("pve_auth_cookie")) || bytes.Contains(stored, []byte("ticket")) {
+		t.Fatal("Expected the session to be encrypted at rest")
+	}
+	if b, found, _ := s.Find("alice"); !found || !bytes.Equal(b, plain) {
+		t.Errorf("Expected the session to decrypt, got %q", b)
+	}
+
+	// Data moved to another token, or read with another secret, is no session
+	if err := raw.Commit("mallory", stored, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := s.Find("mallory"); found {
+		t.Error("Expected data copied to another token to be refused")
+	}
+	other, _ := Encrypt(raw, "another secret")
+	if _, found, err := other.Find("alice"); found || err != nil {
+		t.Errorf("Expected another secret to find no session, got found=%v err=%v", found, err)
+	}
+	if all, _ := other.All(); len(all) != 0 {
+		t.Errorf("Expected another secret to list no session, got %d", len(all))
+	}
+
+	if _, err := Encrypt(raw, ""); err == nil {
+		t.Error("Expected an empty secret to be refused")
+	}
+}
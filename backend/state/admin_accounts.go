@@ -0,0 +1,546 @@
+package state
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"pvmss/constants"
+	"pvmss/logger"
+	"pvmss/totp"
+)
+
+// BootstrapAdminUsername is the account name of the administrator authenticated by the
+// ADMIN_PASSWORD_HASH environment variable. It cannot be used for a named account.
+const BootstrapAdminUsername = "admin"
+
+// Administrator roles, from the most restricted to the most privileged
+const (
+	AdminRoleAuditor  = "auditor"  // read-only access to the admin pages
+	AdminRoleHelpdesk = "helpdesk" // auditor + VM power actions, user password resets and VM requests
+	AdminRoleFull     = "full"     // every admin feature
+)
+
+// AdminRoles lists the roles in rank order
+var AdminRoles = []string{AdminRoleAuditor, AdminRoleHelpdesk, AdminRoleFull}
+
+var (
+	// ErrAdminInvalidCredentials is returned when a username or password is wrong or the account is disabled
+	ErrAdminInvalidCredentials = errors.New("invalid credentials")
+	// ErrAdminInvalidCode is returned when a TOTP or recovery code is wrong or was already used
+	ErrAdminInvalidCode = errors.New("invalid verification code")
+	// ErrAdminAccountNotFound is returned for an unknown account
+	ErrAdminAccountNotFound = errors.New("admin account not found")
+	// ErrAdminAccountExists is returned when creating an account whose name is taken
+	ErrAdminAccountExists = errors.New("admin account already exists")
+	// ErrAdminLastAccount is returned when a change would leave no full administrator able to sign in
+	ErrAdminLastAccount = errors.New("at least one enabled full administrator with two-factor authentication is required")
+	// ErrAdminAccountsUnavailable is returned by every change to a store whose file could not be loaded
+	ErrAdminAccountsUnavailable = errors.New("admin accounts file could not be loaded")
+)
+
+// adminUsernamePattern restricts account names to what is safe in logs and URLs
+var adminUsernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// maxBcryptPasswordLength is the longest password bcrypt accepts
+const maxBcryptPasswordLength = 72
+
+// dummyAdminHash is compared against when the account does not exist, so a login takes as long
+// for an unknown name as for a wrong password
+var (
+	dummyAdminHash     []byte
+	dummyAdminHashOnce sync.Once
+)
+
+// AdminAccount is a named administrator. Only the bcrypt hash of the password and the SHA-256
+// hashes of the recovery codes are stored.
+type AdminAccount struct {
+	Username      string     `json:"username"`
+	Role          string     `json:"role"`
+	PasswordHash  string     `json:"password_hash"`
+	TOTPSecret    string     `json:"totp_secret,omitempty"`    // set once enrollment is confirmed
+	PendingTOTP   string     `json:"pending_totp,omitempty"`   // secret shown while enrolling
+	LastTOTPStep  int64      `json:"last_totp_step,omitempty"` // time step of the last accepted code
+	RecoveryCodes []string   `json:"recovery_codes,omitempty"` // hashes of the unused recovery codes
+	Disabled      bool       `json:"disabled,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastLogin     *time.Time `json:"last_login,omitempty"`
+}
+
+// TOTPEnrolled reports whether the account has confirmed a TOTP authenticator
+func (a *AdminAccount) TOTPEnrolled() bool {
+	return a.TOTPSecret != ""
+}
+
+// usable reports whether the account can complete a login and manage the other accounts
+func (a *AdminAccount) usable() bool {
+	return !a.Disabled && a.TOTPEnrolled() && a.Role == AdminRoleFull
+}
+
+// IsValidAdminRole reports whether role is a known administrator role
+func IsValidAdminRole(role string) bool {
+	switch role {
+	case AdminRoleAuditor, AdminRoleHelpdesk, AdminRoleFull:
+		return true
+	}
+	return false
+}
+
+// AdminRoleAllows reports whether role grants everything the required role can do
+func AdminRoleAllows(role, required string) bool {
+	rank := map[string]int{AdminRoleAuditor: 1, AdminRoleHelpdesk: 2, AdminRoleFull: 3}
+	have, ok := rank[role]
+	if !ok {
+		return false
+	}
+	return have >= rank[required]
+}
+
+// adminAccountsFile is the on-disk form of the store
+type adminAccountsFile struct {
+	BootstrapDisabled bool            `json:"bootstrap_disabled"`
+	Accounts          []*AdminAccount `json:"accounts"`
+}
+
+// AdminAccountStore keeps the named administrator accounts and persists them to a JSON file
+type AdminAccountStore struct {
+	mu                sync.RWMutex
+	path              string
+	bootstrapDisabled bool
+	accounts          map[string]*AdminAccount // keyed by username
+	loadErr           error                    // why the file could not be loaded; the store is then locked
+}
+
+// NewAdminAccountStore loads the account store from path. An empty path keeps accounts in memory only.
+func NewAdminAccountStore(path string) (*AdminAccountStore, error) {
+	s := &AdminAccountStore{path: path, accounts: make(map[string]*AdminAccount)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin accounts file: %w", err)
+	}
+
+	var file adminAccountsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse admin accounts file: %w", err)
+	}
+	s.bootstrapDisabled = file.BootstrapDisabled
+	for _, a := range file.Accounts {
+		// Accounts created before roles existed were full administrators
+		if a.Role == "" {
+			a.Role = AdminRoleFull
+		}
+		s.accounts[a.Username] = a
+	}
+	return s, nil
+}
+
+// NewUnavailableAdminAccountStore returns the store used when the accounts file exists but cannot be
+// loaded. It holds no account, refuses the bootstrap account and rejects every change, so a damaged
+// file never re-enables ADMIN_PASSWORD_HASH nor gets overwritten.
+func NewUnavailableAdminAccountStore(cause error) *AdminAccountStore {
+	return &AdminAccountStore{
+		accounts:          make(map[string]*AdminAccount),
+		bootstrapDisabled: true,
+		loadErr:           fmt.Errorf("%w: %v", ErrAdminAccountsUnavailable, cause),
+	}
+}
+
+// save writes the store to disk. Callers must hold s.mu.
+func (s *AdminAccountStore) save() error {
+	if s.loadErr != nil {
+		return s.loadErr
+	}
+	if s.path == "" {
+		return nil
+	}
+	file := adminAccountsFile{BootstrapDisabled: s.bootstrapDisabled, Accounts: make([]*AdminAccount, 0, len(s.accounts))}
+	for _, a := range s.accounts {
+		file.Accounts = append(file.Accounts, a)
+	}
+	sort.Slice(file.Accounts, func(i, j int) bool { return file.Accounts[i].Username < file.Accounts[j].Username })
+
+	data, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal admin accounts: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write admin accounts file: %w", err)
+	}
+	return nil
+}
+
+// update applies change to a copy of the account and keeps it only if the store is saved
+func (s *AdminAccountStore) update(username string, change func(a *AdminAccount) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.accounts[username]
+	if !ok {
+		return ErrAdminAccountNotFound
+	}
+	updated := *current
+	updated.RecoveryCodes = append([]string(nil), current.RecoveryCodes...)
+	if err := change(&updated); err != nil {
+		return err
+	}
+	s.accounts[username] = &updated
+	if err := s.save(); err != nil {
+		s.accounts[username] = current
+		return err
+	}
+	return nil
+}
+
+// hasUsableAccount reports whether a full administrator other than except can sign in. Callers must hold s.mu.
+func (s *AdminAccountStore) hasUsableAccount(except string) bool {
+	for name, a := range s.accounts {
+		if name != except && a.usable() {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeAdminUsername returns the canonical form of an account name
+func NormalizeAdminUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func validateAdminPassword(password string) error {
+	if len(password) < constants.MinAdminPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", constants.MinAdminPasswordLength)
+	}
+	if len(password) > maxBcryptPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", maxBcryptPasswordLength)
+	}
+	return nil
+}
+
+// List returns the accounts sorted by name
+func (s *AdminAccountStore) List() []AdminAccount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]AdminAccount, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Username < out[j].Username })
+	return out
+}
+
+// Get returns a copy of an account
+func (s *AdminAccountStore) Get(username string) (AdminAccount, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.accounts[username]
+	if !ok {
+		return AdminAccount{}, false
+	}
+	return *a, true
+}
+
+// Create adds an account; its owner enrolls a TOTP authenticator at the first login
+func (s *AdminAccountStore) Create(username, password, role string) error {
+	username = NormalizeAdminUsername(username)
+	if !adminUsernamePattern.MatchString(username) || username == BootstrapAdminUsername {
+		return fmt.Errorf("invalid username %q", username)
+	}
+	if !IsValidAdminRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	if err := validateAdminPassword(password); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.accounts[username]; exists {
+		return ErrAdminAccountExists
+	}
+	s.accounts[username] = &AdminAccount{Username: username, Role: role, PasswordHash: string(hash), CreatedAt: time.Now().UTC()}
+	if err := s.save(); err != nil {
+		delete(s.accounts, username)
+		return err
+	}
+
+	logger.Get().Info().Str("admin_account", username).Str("role", role).Msg("Admin account created")
+	return nil
+}
+
+// SetPassword replaces the password of an account
+func (s *AdminAccountStore) SetPassword(username, password string) error {
+	if err := validateAdminPassword(password); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	return s.update(username, func(a *AdminAccount) error {
+		a.PasswordHash = string(hash)
+		return nil
+	})
+}
+
+// SetDisabled enables or disables an account
+func (s *AdminAccountStore) SetDisabled(username string, disabled bool) error {
+	return s.update(username, func(a *AdminAccount) error {
+		if disabled && s.bootstrapDisabled && !s.hasUsableAccount(username) {
+			return ErrAdminLastAccount
+		}
+		a.Disabled = disabled
+		return nil
+	})
+}
+
+// SetRole changes the role of an account
+func (s *AdminAccountStore) SetRole(username, role string) error {
+	if !IsValidAdminRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	return s.update(username, func(a *AdminAccount) error {
+		if role != AdminRoleFull && s.bootstrapDisabled && !s.hasUsableAccount(username) {
+			return ErrAdminLastAccount
+		}
+		a.Role = role
+		return nil
+	})
+}
+
+// ResetTOTP removes the authenticator and recovery codes of an account; its owner enrolls again at the next login
+func (s *AdminAccountStore) ResetTOTP(username string) error {
+	return s.update(username, func(a *AdminAccount) error {
+		if s.bootstrapDisabled && !s.hasUsableAccount(username) {
+			return ErrAdminLastAccount
+		}
+		a.TOTPSecret, a.PendingTOTP, a.LastTOTPStep, a.RecoveryCodes = "", "", 0, nil
+		return nil
+	})
+}
+
+// Delete removes an account
+func (s *AdminAccountStore) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[username]
+	if !ok {
+		return ErrAdminAccountNotFound
+	}
+	if s.bootstrapDisabled && !s.hasUsableAccount(username) {
+		return ErrAdminLastAccount
+	}
+	delete(s.accounts, username)
+	if err := s.save(); err != nil {
+		s.accounts[username] = a
+		return err
+	}
+
+	logger.Get().Info().Str("admin_account", username).Msg("Admin account deleted")
+	return nil
+}
+
+// BootstrapDisabled reports whether the ADMIN_PASSWORD_HASH account is refused
+func (s *AdminAccountStore) BootstrapDisabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bootstrapDisabled
+}
+
+// HasUsableAccount reports whether a named full administrator account can sign in
+func (s *AdminAccountStore) HasUsableAccount() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hasUsableAccount("")
+}
+
+// SetBootstrapDisabled disables or re-enables the ADMIN_PASSWORD_HASH account. It can only be
+// disabled while a full administrator account with two-factor authentication can sign in.
+func (s *AdminAccountStore) SetBootstrapDisabled(disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if disabled && !s.hasUsableAccount("") {
+		return ErrAdminLastAccount
+	}
+	previous := s.bootstrapDisabled
+	s.bootstrapDisabled = disabled
+	if err := s.save(); err != nil {
+		s.bootstrapDisabled = previous
+		return err
+	}
+	return nil
+}
+
+// Authenticate checks the password of an enabled account
+func (s *AdminAccountStore) Authenticate(username, password string) (AdminAccount, error) {
+	a, ok := s.Get(NormalizeAdminUsername(username))
+	var hash []byte
+	if ok {
+		hash = []byte(a.PasswordHash)
+	} else {
+		dummyAdminHashOnce.Do(func() {
+			dummyAdminHash, _ = bcrypt.GenerateFromPassword([]byte("pvmss-unknown-admin"), bcrypt.DefaultCost)
+		})
+		hash = dummyAdminHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok || a.Disabled {
+		return AdminAccount{}, ErrAdminInvalidCredentials
+	}
+	return a, nil
+}
+
+// BeginTOTPEnrollment returns the secret the account owner adds to an authenticator app. The same
+// secret is returned until the enrollment is confirmed.
+func (s *AdminAccountStore) BeginTOTPEnrollment(username string) (string, error) {
+	var secret string
+	err := s.update(username, func(a *AdminAccount) error {
+		if a.TOTPEnrolled() {
+			return fmt.Errorf("two-factor authentication is already enrolled")
+		}
+		if a.PendingTOTP == "" {
+			generated, err := totp.GenerateSecret()
+			if err != nil {
+				return err
+			}
+			a.PendingTOTP = generated
+		}
+		secret = a.PendingTOTP
+		return nil
+	})
+	return secret, err
+}
+
+// ConfirmTOTPEnrollment activates the pending secret once the owner enters a valid code, and
+// returns the recovery codes, which are shown once and never stored in clear
+func (s *AdminAccountStore) ConfirmTOTPEnrollment(username, code string, now time.Time) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.update(username, func(a *AdminAccount) error {
+		if a.PendingTOTP == "" {
+			return fmt.Errorf("no two-factor enrollment in progress")
+		}
+		step, ok := totp.Validate(a.PendingTOTP, code, now)
+		if !ok {
+			return ErrAdminInvalidCode
+		}
+		a.TOTPSecret, a.PendingTOTP, a.LastTOTPStep, a.RecoveryCodes = a.PendingTOTP, "", step, hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Get().Info().Str("admin_account", username).Msg("Admin account enrolled two-factor authentication")
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code, which is accepted once, or consumes a recovery code.
+// It reports whether a recovery code was used.
+func (s *AdminAccountStore) VerifySecondFactor(username, code string, now time.Time) (bool, error) {
+	usedRecovery := false
+	err := s.update(username, func(a *AdminAccount) error {
+		if a.Disabled || !a.TOTPEnrolled() {
+			return ErrAdminInvalidCredentials
+		}
+		if step, ok := totp.Validate(a.TOTPSecret, code, now); ok {
+			if step <= a.LastTOTPStep {
+				return ErrAdminInvalidCode
+			}
+			a.LastTOTPStep = step
+			return nil
+		}
+
+		hash := hashRecoveryCode(code)
+		for i, stored := range a.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				a.RecoveryCodes = append(a.RecoveryCodes[:i], a.RecoveryCodes[i+1:]...)
+				usedRecovery = true
+				return nil
+			}
+		}
+		return ErrAdminInvalidCode
+	})
+	return usedRecovery, err
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of an enrolled account and returns the new ones
+func (s *AdminAccountStore) RegenerateRecoveryCodes(username string) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.update(username, func(a *AdminAccount) error {
+		if !a.TOTPEnrolled() {
+			return fmt.Errorf("two-factor authentication is not enrolled")
+		}
+		a.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RecordLogin stores the time of a completed login
+func (s *AdminAccountStore) RecordLogin(username string, now time.Time) error {
+	return s.update(username, func(a *AdminAccount) error {
+		t := now.UTC()
+		a.LastLogin = &t
+		return nil
+	})
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns codes formatted as xxxxx-xxxxx and their hashes
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < constants.AdminRecoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed, ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
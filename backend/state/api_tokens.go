@@ -0,0 +1,294 @@
+package state
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pvmss/logger"
+)
+
+// API token scopes, from the most to the least restrictive
+const (
+	APITokenScopeRead  = "read"  // read-only JSON endpoints
+	APITokenScopePower = "power" // read + power actions (start/stop/shutdown/reboot/reset)
+	APITokenScopeFull  = "full"  // every endpoint available to the user
+)
+
+// apiTokenPrefix marks PVMSS personal access tokens so they are easy to recognise in logs and secret scanners
+const apiTokenPrefix = "pvmss_"
+
+var (
+	// ErrAPITokenInvalid is returned when a presented token is unknown or malformed
+	ErrAPITokenInvalid = errors.New("invalid api token")
+	// ErrAPITokenExpired is returned when a presented token is past its expiry
+	ErrAPITokenExpired = errors.New("api token expired")
+	// ErrAPITokenNotFound is returned when revoking a token that does not belong to the user
+	ErrAPITokenNotFound = errors.New("api token not found")
+)
+
+// APIToken is a personal access token. Only the SHA-256 hash of the secret is stored.
+type APIToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Username  string     `json:"username"`
+	Scope     string     `json:"scope"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// IsValidAPITokenScope reports whether scope is a known token scope
+func IsValidAPITokenScope(scope string) bool {
+	switch scope {
+	case APITokenScopeRead, APITokenScopePower, APITokenScopeFull:
+		return true
+	}
+	return false
+}
+
+// Allows reports whether the token scope grants the required scope
+func (t *APIToken) Allows(required string) bool {
+	rank := map[string]int{APITokenScopeRead: 1, APITokenScopePower: 2, APITokenScopeFull: 3}
+	have, ok := rank[t.Scope]
+	if !ok {
+		return false
+	}
+	return have >= rank[required]
+}
+
+// Expired reports whether the token is past its expiry at the given time
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// APITokenStore keeps personal access tokens and persists them to a JSON file
+type APITokenStore struct {
+	mu     sync.RWMutex
+	path   string
+	tokens map[string]*APIToken // keyed by ID
+}
+
+// DataFilePath returns the path of a data file stored next to settings.json,
+// unless envVar overrides it.
+func DataFilePath(envVar, fileName string) (string, error) {
+	if v := os.Getenv(envVar); v != "" {
+		return v, nil
+	}
+	settingsFile, err := getSettingsFilePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(settingsFile), fileName), nil
+}
+
+// NewAPITokenStore loads the token store from path. An empty path keeps tokens in memory only.
+func NewAPITokenStore(path string) (*APITokenStore, error) {
+	s := &APITokenStore{path: path, tokens: make(map[string]*APIToken)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api tokens file: %w", err)
+	}
+
+	var tokens []*APIToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse api tokens file: %w", err)
+	}
+	for _, t := range tokens {
+		s.tokens[t.ID] = t
+	}
+	return s, nil
+}
+
+// save writes the store to disk. Callers must hold s.mu.
+func (s *APITokenStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	tokens := make([]*APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(tokens, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal api tokens: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write api tokens file: %w", err)
+	}
+	return nil
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create issues a new token for username and returns the plaintext value, which is never stored
+func (s *APITokenStore) Create(username, name, scope string, expiresAt *time.Time) (string, *APIToken, error) {
+	if username == "" {
+		return "", nil, fmt.Errorf("username is required")
+	}
+	if !IsValidAPITokenScope(scope) {
+		return "", nil, fmt.Errorf("invalid scope %q", scope)
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate token id: %w", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate token secret: %w", err)
+	}
+	plaintext := apiTokenPrefix + id + "_" + secret
+
+	token := &APIToken{
+		ID:        id,
+		Name:      name,
+		Username:  username,
+		Scope:     scope,
+		Hash:      hashAPIToken(plaintext),
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[id] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, id)
+		return "", nil, err
+	}
+
+	logger.Get().Info().Str("username", username).Str("token_id", id).Str("scope", scope).Msg("API token created")
+	copied := *token
+	return plaintext, &copied, nil
+}
+
+// List returns the tokens of username, newest first
+func (s *APITokenStore) List(username string) []APIToken {
+	return s.list(func(t *APIToken) bool { return t.Username == username })
+}
+
+// ListAll returns the tokens of every user, newest first
+func (s *APITokenStore) ListAll() []APIToken {
+	return s.list(func(*APIToken) bool { return true })
+}
+
+func (s *APITokenStore) list(match func(*APIToken) bool) []APIToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]APIToken, 0)
+	for _, t := range s.tokens {
+		if match(t) {
+			out = append(out, *t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// Revoke deletes a token owned by username
+func (s *APITokenStore) Revoke(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok || t.Username != username {
+		return ErrAPITokenNotFound
+	}
+	delete(s.tokens, id)
+	if err := s.save(); err != nil {
+		s.tokens[id] = t
+		return err
+	}
+
+	logger.Get().Info().Str("username", username).Str("token_id", id).Msg("API token revoked")
+	return nil
+}
+
+// RevokeAll deletes every token of username and returns how many it deleted
+func (s *APITokenStore) RevokeAll(username string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := make(map[string]*APIToken)
+	for id, t := range s.tokens {
+		if t.Username == username {
+			revoked[id] = t
+			delete(s.tokens, id)
+		}
+	}
+	if len(revoked) == 0 {
+		return 0, nil
+	}
+	if err := s.save(); err != nil {
+		for id, t := range revoked {
+			s.tokens[id] = t
+		}
+		return 0, err
+	}
+
+	logger.Get().Info().Str("username", username).Int("tokens", len(revoked)).Msg("API tokens revoked")
+	return len(revoked), nil
+}
+
+// Authenticate resolves a plaintext token to its record and records its last use
+func (s *APITokenStore) Authenticate(plaintext string) (*APIToken, error) {
+	rest, ok := strings.CutPrefix(plaintext, apiTokenPrefix)
+	if !ok {
+		return nil, ErrAPITokenInvalid
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok || id == "" {
+		return nil, ErrAPITokenInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hashAPIToken(plaintext))) != 1 {
+		return nil, ErrAPITokenInvalid
+	}
+	now := time.Now().UTC()
+	if t.Expired(now) {
+		return nil, ErrAPITokenExpired
+	}
+
+	// Last use is kept in memory and persisted with the next change to the store
+	t.LastUsed = &now
+	copied := *t
+	return &copied, nil
+}
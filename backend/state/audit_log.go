@@ -0,0 +1,290 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"pvmss/logger"
+)
+
+// Audited actions. VM power actions are recorded as "vm." followed by the Proxmox action.
+const (
+	AuditActionLogin          = "auth.login"       // a user signed in, with a password or single sign-on
+	AuditActionAdminLogin     = "auth.admin_login" // an administrator signed in
+	AuditActionVMCreate       = "vm.create"
+	AuditActionVMDelete       = "vm.delete"
+	AuditActionVMTags         = "vm.tags"
+	AuditActionVMDescription  = "vm.description"
+	AuditActionVMConsole      = "vm.console" // a console session was opened, or refused
+	AuditActionSettingsUpdate = "settings.update"
+	AuditActionPoolCreate     = "pool.create"
+	AuditActionPoolDelete     = "pool.delete"
+	AuditActionPasswordReset  = "user.password_reset"
+
+	AuditActionVMResize           = "vm.resize"
+	AuditActionVMSnapshotCreate   = "vm.snapshot_create"
+	AuditActionVMSnapshotRollback = "vm.snapshot_rollback"
+	AuditActionVMSnapshotDelete   = "vm.snapshot_delete"
+	AuditActionVMBackupCreate     = "vm.backup_create"
+	AuditActionVMBackupRestore    = "vm.backup_restore"
+	AuditActionVMBackupDelete     = "vm.backup_delete"
+	AuditActionVMScheduleCreate   = "vm.schedule_create"
+	AuditActionVMScheduleDelete   = "vm.schedule_delete"
+	AuditActionVMLeaseExtend      = "vm.lease_extend"
+	AuditActionVMLeaseRelease     = "vm.lease_release"
+	AuditActionVMRequestSubmit    = "vm.request_submit" // a creation or restore waits for approval
+	AuditActionVMRequestEdit      = "vm.request_edit"
+	AuditActionVMRequestReject    = "vm.request_reject"
+
+	AuditActionPasswordChange = "user.password_change"
+	AuditActionSessionRevoke  = "user.session_revoke"
+	AuditActionTokenCreate    = "user.token_create"
+	AuditActionTokenRevoke    = "user.token_revoke"
+
+	AuditActionAccountCreate        = "admin.account_create"
+	AuditActionAccountPassword      = "admin.account_password"
+	AuditActionAccountRole          = "admin.account_role"
+	AuditActionAccountToggle        = "admin.account_toggle"
+	AuditActionAccountTOTPReset     = "admin.account_totp_reset"
+	AuditActionAccountRecoveryCodes = "admin.account_recovery_codes"
+	AuditActionAccountDelete        = "admin.account_delete"
+	AuditActionAccountBootstrap     = "admin.account_bootstrap"
+)
+
+// AuditActorSystem is the actor of the actions PVMSS takes on its own, such as lease expiry
+const AuditActorSystem = "system"
+
+// Audit results
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// AuditEvent is one mutating action: who did what, on which target, and how it went
+type AuditEvent struct {
+	Time   time.Time         `json:"time"`
+	Actor  string            `json:"actor"`
+	Role   string            `json:"role,omitempty"` // user, api-token or the administrator role
+	IP     string            `json:"ip,omitempty"`
+	Action string            `json:"action"`
+	VMID   int               `json:"vmid,omitempty"`
+	Pool   string            `json:"pool,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+	Result string            `json:"result"`
+	Error  string            `json:"error,omitempty"`
+	UPID   string            `json:"upid,omitempty"` // Proxmox task started by the action
+}
+
+// AuditFilter selects audit events; zero fields match everything
+type AuditFilter struct {
+	Actor  string // exact match
+	Action string // exact match, or a prefix ending with a dot such as "vm."
+	VMID   int
+	Pool   string
+	Result string
+	Since  time.Time // inclusive
+	Until  time.Time // exclusive
+	Limit  int       // newest events first; 0 returns them all
+}
+
+// Matches reports whether the event is selected by the filter
+func (f AuditFilter) Matches(event AuditEvent) bool {
+	switch {
+	case f.Actor != "" && !strings.EqualFold(event.Actor, f.Actor):
+		return false
+	case f.Action != "" && event.Action != f.Action &&
+		!(strings.HasSuffix(f.Action, ".") && strings.HasPrefix(event.Action, f.Action)):
+		return false
+	case f.VMID != 0 && event.VMID != f.VMID:
+		return false
+	case f.Pool != "" && event.Pool != f.Pool:
+		return false
+	case f.Result != "" && event.Result != f.Result:
+		return false
+	case !f.Since.IsZero() && event.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !event.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// AuditLogStore keeps the audit trail in an append-only JSON Lines file, one event per line.
+// Events are only ever appended; Prune is the one operation that rewrites the file.
+type AuditLogStore struct {
+	mu      sync.RWMutex
+	path    string
+	events  []AuditEvent // oldest first
+	forward func(AuditEvent)
+}
+
+// NewAuditLogStore loads the audit log from path. An empty path keeps events in memory only.
+// Lines that cannot be parsed, such as one cut short by a crash, are skipped.
+func NewAuditLogStore(path string) (*AuditLogStore, error) {
+	s := &AuditLogStore{path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	skipped := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var event AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			skipped++
+			continue
+		}
+		s.events = append(s.events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if skipped > 0 {
+		logger.Get().Warn().Int("skipped", skipped).Str("path", path).Msg("Skipped unreadable audit log lines")
+	}
+	return s, nil
+}
+
+// SetForwarder registers a function that receives every recorded event, such as a syslog sink.
+// It is called with the store locked and must not block.
+func (s *AuditLogStore) SetForwarder(forward func(AuditEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forward = forward
+}
+
+// Record appends an event to the audit log and hands it to the forwarder, if any
+func (s *AuditLogStore) Record(event AuditEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.Result == "" {
+		event.Result = AuditResultSuccess
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.forward != nil {
+		s.forward(event)
+	}
+	if s.path != "" {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit event: %w", err)
+		}
+		f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		_, err = f.Write(append(line, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+// Query returns the events selected by the filter, newest first
+func (s *AuditLogStore) Query(filter AuditFilter) []AuditEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]AuditEvent, 0)
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if !filter.Matches(s.events[i]) {
+			continue
+		}
+		out = append(out, s.events[i])
+		if filter.Limit > 0 && len(out) == filter.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// Actors returns the distinct actors found in the log, in order of first appearance
+func (s *AuditLogStore) Actors() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	actors := make([]string, 0)
+	for _, event := range s.events {
+		if event.Actor != "" && !seen[event.Actor] {
+			seen[event.Actor] = true
+			actors = append(actors, event.Actor)
+		}
+	}
+	return actors
+}
+
+// Prune drops the events older than before and rewrites the file. It returns how many were dropped.
+func (s *AuditLogStore) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keep := 0
+	for keep < len(s.events) && s.events[keep].Time.Before(before) {
+		keep++
+	}
+	if keep == 0 {
+		return 0, nil
+	}
+	remaining := append([]AuditEvent(nil), s.events[keep:]...)
+
+	if s.path != "" {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, event := range remaining {
+			if err := enc.Encode(event); err != nil {
+				return 0, fmt.Errorf("failed to marshal audit event: %w", err)
+			}
+		}
+		// Write a copy and rename it over the log so a crash never leaves a truncated trail
+		tmp, err := os.CreateTemp(filepath.Dir(s.path), ".audit-*")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create audit log copy: %w", err)
+		}
+		_, err = tmp.Write(buf.Bytes())
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0600)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), s.path)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return 0, fmt.Errorf("failed to rewrite audit log: %w", err)
+		}
+	}
+	s.events = remaining
+	return keep, nil
+}
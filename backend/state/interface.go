@@ -47,6 +47,24 @@ type StateManager interface {
 	ValidateAndRemoveCSRFToken(token string) bool
 	CleanExpiredCSRFTokens()
 
+	// Personal API tokens
+	GetAPITokenStore() *APITokenStore
+
+	// VM creation requests awaiting approval
+	GetVMRequestStore() *VMRequestStore
+
+	// VM leases and lease reaper activity
+	GetVMLeaseStore() *VMLeaseStore
+
+	// VM power schedules and their run history
+	GetVMScheduleStore() *VMScheduleStore
+
+	// Named administrator accounts
+	GetAdminAccountStore() *AdminAccountStore
+
+	// Audit trail of mutating actions
+	GetAuditLogStore() *AuditLogStore
+
 	// Frontend configuration
 	GetFrontendPath() string
 	SetFrontendPath(path string)
@@ -13,9 +13,17 @@ import (
 	"pvmss/constants"
 	"pvmss/i18n"
 	"pvmss/logger"
+	"pvmss/metrics"
 	"pvmss/proxmox"
 )
 
+var (
+	proxmoxUp = metrics.NewGaugeVec("pvmss_proxmox_up",
+		"Whether the last Proxmox connectivity check succeeded (1) or not (0).")
+	proxmoxChecks = metrics.NewCounterVec("pvmss_proxmox_connectivity_checks_total",
+		"Proxmox connectivity checks, by result (success or failure).", "result")
+)
+
 // appState is the concrete implementation of StateManager
 type appState struct {
 	templates      *template.Template
@@ -45,6 +53,20 @@ type appState struct {
 	// Cleanup callbacks
 	guestAgentCleanupFunc func()
 	cleanupMu             sync.RWMutex
+
+	// Personal API tokens, loaded on first use
+	apiTokens         *APITokenStore
+	apiTokensOnce     sync.Once
+	vmRequests        *VMRequestStore
+	vmRequestsOnce    sync.Once
+	vmLeases          *VMLeaseStore
+	vmLeasesOnce      sync.Once
+	vmSchedules       *VMScheduleStore
+	vmSchedulesOnce   sync.Once
+	adminAccounts     *AdminAccountStore
+	adminAccountsOnce sync.Once
+	auditLog          *AuditLogStore
+	auditLogOnce      sync.Once
 }
 
 func translateProxmoxMessage(messageID string) string {
@@ -63,14 +85,23 @@ func (s *appState) startProxmoxMonitor() {
 	s.proxmoxMu.Unlock()
 
 	log := logger.Get().With().Str("component", "ProxmoxMonitor").Logger()
+	check := func() bool {
+		ok := s.CheckProxmoxConnection()
+		if ok {
+			proxmoxChecks.Inc("success")
+		} else {
+			proxmoxChecks.Inc("failure")
+		}
+		return ok
+	}
 	go func() {
 		// Immediate check to ensure status freshness
-		s.CheckProxmoxConnection()
+		check()
 
 		ticker := time.NewTicker(constants.ProxmoxConnectionCheckInterval)
 		defer ticker.Stop()
 		for range ticker.C {
-			ok := s.CheckProxmoxConnection()
+			ok := check()
 			if !ok {
 				_, errMsg := s.GetProxmoxStatus()
 				log.Debug().Str("error", errMsg).Msg("Proxmox connectivity check failed")
@@ -256,6 +287,12 @@ func (s *appState) updateProxmoxStatus(connected bool, errorMsg string) {
 	s.proxmoxMu.Lock()
 	defer s.proxmoxMu.Unlock()
 
+	if connected {
+		proxmoxUp.Set(1)
+	} else {
+		proxmoxUp.Set(0)
+	}
+
 	// Only log if status changed
 	if s.proxmoxConnected != connected || s.proxmoxError != errorMsg {
 		status := "connected"
@@ -430,3 +467,132 @@ func (s *appState) SetFrontendPath(path string) {
 	s.frontendPath = path
 	logger.Get().Debug().Str("path", path).Msg("Frontend path configured")
 }
+
+// API Token Methods
+
+// GetAPITokenStore returns the personal API token store, loading it from disk on first use.
+// If the file cannot be read, an in-memory store is used so the application keeps working.
+func (s *appState) GetAPITokenStore() *APITokenStore {
+	s.apiTokensOnce.Do(func() {
+		log := logger.Get()
+		path, err := DataFilePath("PVMSS_API_TOKENS_PATH", "api_tokens.json")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to resolve API tokens file path")
+		}
+		store, err := NewAPITokenStore(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to load API tokens; using an in-memory store")
+			store, _ = NewAPITokenStore("")
+		}
+		s.apiTokens = store
+	})
+	return s.apiTokens
+}
+
+// VM Request Methods
+
+// GetVMRequestStore returns the VM request store, loading it from disk on first use.
+// If the file cannot be read, an in-memory store is used so the application keeps working.
+func (s *appState) GetVMRequestStore() *VMRequestStore {
+	s.vmRequestsOnce.Do(func() {
+		log := logger.Get()
+		path, err := DataFilePath("PVMSS_VM_REQUESTS_PATH", "vm_requests.json")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to resolve VM requests file path")
+		}
+		store, err := NewVMRequestStore(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to load VM requests; using an in-memory store")
+			store, _ = NewVMRequestStore("")
+		}
+		s.vmRequests = store
+	})
+	return s.vmRequests
+}
+
+// VM Lease Methods
+
+// GetVMLeaseStore returns the VM lease store, loading it from disk on first use.
+// If the file cannot be read, an in-memory store is used so the application keeps working.
+func (s *appState) GetVMLeaseStore() *VMLeaseStore {
+	s.vmLeasesOnce.Do(func() {
+		log := logger.Get()
+		path, err := DataFilePath("PVMSS_VM_LEASES_PATH", "vm_leases.json")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to resolve VM leases file path")
+		}
+		store, err := NewVMLeaseStore(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to load VM leases; using an in-memory store")
+			store, _ = NewVMLeaseStore("")
+		}
+		s.vmLeases = store
+	})
+	return s.vmLeases
+}
+
+// VM Schedule Methods
+
+// GetVMScheduleStore returns the VM power schedule store, loading it from disk on first use.
+// If the file cannot be read, an in-memory store is used so the application keeps working.
+func (s *appState) GetVMScheduleStore() *VMScheduleStore {
+	s.vmSchedulesOnce.Do(func() {
+		log := logger.Get()
+		path, err := DataFilePath("PVMSS_VM_SCHEDULES_PATH", "vm_schedules.json")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to resolve VM schedules file path")
+		}
+		store, err := NewVMScheduleStore(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to load VM schedules; using an in-memory store")
+			store, _ = NewVMScheduleStore("")
+		}
+		s.vmSchedules = store
+	})
+	return s.vmSchedules
+}
+
+// Admin Account Methods
+
+// GetAdminAccountStore returns the named administrator account store, loading it from disk on first use.
+// Only a missing file means an empty store: if the file cannot be read, the store fails closed and
+// refuses the bootstrap account and every change until the file is fixed and PVMSS restarted.
+func (s *appState) GetAdminAccountStore() *AdminAccountStore {
+	s.adminAccountsOnce.Do(func() {
+		log := logger.Get()
+		path, err := DataFilePath("PVMSS_ADMIN_ACCOUNTS_PATH", "admin_accounts.json")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to resolve admin accounts file path; administrator logins are refused")
+			s.adminAccounts = NewUnavailableAdminAccountStore(err)
+			return
+		}
+		store, err := NewAdminAccountStore(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to load admin accounts; administrator logins and account changes are refused")
+			store = NewUnavailableAdminAccountStore(err)
+		}
+		s.adminAccounts = store
+	})
+	return s.adminAccounts
+}
+
+// Audit Log Methods
+
+// GetAuditLogStore returns the audit log, loading it from disk on first use.
+// If the file cannot be read, an in-memory store is used so the application keeps working.
+func (s *appState) GetAuditLogStore() *AuditLogStore {
+	s.auditLogOnce.Do(func() {
+		log := logger.Get()
+		path, err := DataFilePath("PVMSS_AUDIT_LOG_PATH", "audit.jsonl")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to resolve audit log file path")
+		}
+		store, err := NewAuditLogStore(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to load audit log; using an in-memory store")
+			store, _ = NewAuditLogStore("")
+		}
+		s.auditLog = store
+	})
+	return s.auditLog
+}
@@ -15,6 +15,7 @@ func defaultSettings() *AppSettings {
 	return &AppSettings{
 		Tags:            []string{"pvmss"},
 		ISOs:            []string{},
+		Templates:       []VMTemplateOffering{},
 		VMBRs:           []string{},
 		EnabledStorages: []string{},
 		Limits:          make(map[string]interface{}),
@@ -26,9 +27,49 @@ var settingsMutex = &sync.Mutex{}
 type AppSettings struct {
 	Tags            []string               `json:"tags"`
 	ISOs            []string               `json:"isos"`
+	Templates       []VMTemplateOffering   `json:"templates"`
 	VMBRs           []string               `json:"vmbrs"`
 	EnabledStorages []string               `json:"enabled_storages"`
+	BackupStorage   string                 `json:"backup_storage"`           // storage receiving user-triggered vzdump backups
+	SnippetPrefix   string                 `json:"snippet_prefix,omitempty"` // Cloud-Init user-data snippets users may attach; none when empty
 	Limits          map[string]interface{} `json:"limits"`
+	Leases          *LeasePolicy           `json:"leases,omitempty"`
+	LoginRealms     []string               `json:"login_realms,omitempty"`         // Proxmox realms offered on the login page; only pve when empty
+	AuditRetention  int                    `json:"audit_retention_days,omitempty"` // days of audit log kept; the default applies when 0
+}
+
+// LeasePolicy defines how long VMs live before the lease reaper stops and deletes them.
+// Durations are in days; a DefaultDays of 0 means VMs without a tag duration never expire.
+type LeasePolicy struct {
+	DefaultDays   int            `json:"default_days"`
+	TagDays       map[string]int `json:"tag_days,omitempty"` // per-tag default, the shortest matching tag wins
+	MaxDays       int            `json:"max_days"`           // longest lease a user can pick at creation, 0 for no cap
+	ExtensionDays int            `json:"extension_days"`     // added to the expiry by each extension
+	MaxExtensions int            `json:"max_extensions"`     // extensions a user can make per VM
+	GraceDays     int            `json:"grace_days"`         // delay between stopping and deleting an expired VM
+	DryRun        bool           `json:"dry_run"`            // record what the reaper would do without acting
+}
+
+// VMTemplateOffering is a Proxmox template VM that users can clone from the create form
+type VMTemplateOffering struct {
+	VMID      int    `json:"vmid"`
+	Node      string `json:"node"`
+	Name      string `json:"name"`        // display name shown to users
+	OSFamily  string `json:"os_family"`   // e.g. linux, windows, bsd
+	MinDiskGB int    `json:"min_disk_gb"` // clones cannot be smaller than the template disk
+}
+
+// FindTemplate returns the template offering with the given VMID, or nil
+func (s *AppSettings) FindTemplate(vmid int) *VMTemplateOffering {
+	if s == nil {
+		return nil
+	}
+	for i := range s.Templates {
+		if s.Templates[i].VMID == vmid {
+			return &s.Templates[i]
+		}
+	}
+	return nil
 }
 
 // getSettingsFilePath returns the absolute path to the settings file.
@@ -96,6 +137,10 @@ func LoadSettings() (*AppSettings, bool, error) {
 		modified = true
 		settings.ISOs = []string{}
 	}
+	if settings.Templates == nil {
+		modified = true
+		settings.Templates = []VMTemplateOffering{}
+	}
 	if settings.VMBRs == nil {
 		modified = true
 		settings.VMBRs = []string{}
@@ -118,6 +163,14 @@ func LoadSettings() (*AppSettings, bool, error) {
 			"disk":    map[string]int{"min": 1, "max": 10},
 		}
 	}
+	if _, exists := settings.Limits["snapshots"]; !exists {
+		modified = true
+		settings.Limits["snapshots"] = map[string]interface{}{"max_per_user": 10}
+	}
+	if _, exists := settings.Limits["backups"]; !exists {
+		modified = true
+		settings.Limits["backups"] = map[string]interface{}{"max_per_user": 5}
+	}
 
 	log.Info().
 		Bool("modified", modified).
@@ -0,0 +1,286 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"pvmss/logger"
+)
+
+// Lease reaper actions recorded in the activity log
+const (
+	LeaseActionStop   = "stop"   // the expired VM was stopped
+	LeaseActionDelete = "delete" // the VM was deleted after the grace period
+)
+
+// maxLeaseEvents bounds the reaper activity kept in the store
+const maxLeaseEvents = 500
+
+var (
+	// ErrVMLeaseNotFound is returned when a VM has no lease
+	ErrVMLeaseNotFound = errors.New("vm lease not found")
+	// ErrVMLeaseExtensionLimit is returned when a lease was already extended the maximum number of times
+	ErrVMLeaseExtensionLimit = errors.New("vm lease extension limit reached")
+)
+
+// VMLease is the expiry of a VM created through the portal
+type VMLease struct {
+	VMID       int        `json:"vmid"`
+	Name       string     `json:"name"` // guards against acting on a VM that reused the VMID
+	Username   string     `json:"username,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Extensions int        `json:"extensions"`
+	StoppedAt  *time.Time `json:"stopped_at,omitempty"` // set once the reaper stopped the expired VM
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Expired reports whether the lease is past its expiry at the given time
+func (l *VMLease) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// LeaseEvent is an action taken, or simulated in dry-run mode, by the lease reaper
+type LeaseEvent struct {
+	Time     time.Time `json:"time"`
+	VMID     int       `json:"vmid"`
+	Name     string    `json:"name"`
+	Username string    `json:"username,omitempty"`
+	Action   string    `json:"action"`
+	DryRun   bool      `json:"dry_run,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// leaseFile is the on-disk layout of the lease store
+type leaseFile struct {
+	Leases []*VMLease   `json:"leases"`
+	Events []LeaseEvent `json:"events"`
+}
+
+// VMLeaseStore keeps VM leases and the reaper activity, and persists them to a JSON file
+type VMLeaseStore struct {
+	mu     sync.RWMutex
+	path   string
+	leases map[int]*VMLease // keyed by VMID
+	events []LeaseEvent     // oldest first
+}
+
+// NewVMLeaseStore loads the lease store from path. An empty path keeps leases in memory only.
+func NewVMLeaseStore(path string) (*VMLeaseStore, error) {
+	s := &VMLeaseStore{path: path, leases: make(map[int]*VMLease)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vm leases file: %w", err)
+	}
+
+	var file leaseFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse vm leases file: %w", err)
+	}
+	for _, lease := range file.Leases {
+		s.leases[lease.VMID] = lease
+	}
+	s.events = file.Events
+	return s, nil
+}
+
+// save writes the store to disk. Callers must hold s.mu.
+func (s *VMLeaseStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	file := leaseFile{Leases: make([]*VMLease, 0, len(s.leases)), Events: s.events}
+	for _, lease := range s.leases {
+		file.Leases = append(file.Leases, lease)
+	}
+	sort.Slice(file.Leases, func(i, j int) bool { return file.Leases[i].VMID < file.Leases[j].VMID })
+	if file.Events == nil {
+		file.Events = []LeaseEvent{}
+	}
+
+	data, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal vm leases: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write vm leases file: %w", err)
+	}
+	return nil
+}
+
+// Set records or replaces the lease of a VM
+func (s *VMLeaseStore) Set(lease VMLease) error {
+	if lease.VMID <= 0 {
+		return fmt.Errorf("invalid vmid %d", lease.VMID)
+	}
+	if lease.CreatedAt.IsZero() {
+		lease.CreatedAt = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.leases[lease.VMID]
+	s.leases[lease.VMID] = &lease
+	if err := s.save(); err != nil {
+		if existed {
+			s.leases[lease.VMID] = previous
+		} else {
+			delete(s.leases, lease.VMID)
+		}
+		return err
+	}
+
+	logger.Get().Info().Int("vmid", lease.VMID).Time("expires_at", lease.ExpiresAt).Msg("VM lease set")
+	return nil
+}
+
+// Get returns a copy of the lease of a VM
+func (s *VMLeaseStore) Get(vmid int) (VMLease, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lease, ok := s.leases[vmid]
+	if !ok {
+		return VMLease{}, false
+	}
+	return *lease, true
+}
+
+// List returns all leases, the soonest expiry first
+func (s *VMLeaseStore) List() []VMLease {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]VMLease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		out = append(out, *lease)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ExpiresAt.Before(out[j].ExpiresAt) })
+	return out
+}
+
+// update applies change to a lease and persists it, rolling back on a write error
+func (s *VMLeaseStore) update(vmid int, change func(lease *VMLease) error) (*VMLease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease, ok := s.leases[vmid]
+	if !ok {
+		return nil, ErrVMLeaseNotFound
+	}
+	previous := *lease
+	if err := change(lease); err != nil {
+		return nil, err
+	}
+	if err := s.save(); err != nil {
+		*lease = previous
+		return nil, err
+	}
+	copied := *lease
+	return &copied, nil
+}
+
+// Extend pushes the expiry back by d, counted from now when the lease already expired.
+// A negative maxExtensions allows unlimited extensions. Extending a stopped VM cancels its deletion.
+func (s *VMLeaseStore) Extend(vmid int, d time.Duration, maxExtensions int, now time.Time) (*VMLease, error) {
+	lease, err := s.update(vmid, func(lease *VMLease) error {
+		if maxExtensions >= 0 && lease.Extensions >= maxExtensions {
+			return ErrVMLeaseExtensionLimit
+		}
+		base := lease.ExpiresAt
+		if base.Before(now) {
+			base = now
+		}
+		lease.ExpiresAt = base.Add(d).UTC()
+		lease.Extensions++
+		lease.StoppedAt = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Get().Info().Int("vmid", vmid).Int("extensions", lease.Extensions).Time("expires_at", lease.ExpiresAt).Msg("VM lease extended")
+	return lease, nil
+}
+
+// MarkStopped records that the reaper stopped the expired VM, which starts the grace period
+func (s *VMLeaseStore) MarkStopped(vmid int, at time.Time) error {
+	_, err := s.update(vmid, func(lease *VMLease) error {
+		stopped := at.UTC()
+		lease.StoppedAt = &stopped
+		return nil
+	})
+	return err
+}
+
+// Remove drops the lease of a VM; removing an unknown lease is not an error
+func (s *VMLeaseStore) Remove(vmid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease, ok := s.leases[vmid]
+	if !ok {
+		return nil
+	}
+	delete(s.leases, vmid)
+	if err := s.save(); err != nil {
+		s.leases[vmid] = lease
+		return err
+	}
+	return nil
+}
+
+// Record appends a reaper action to the activity log, dropping the oldest entries beyond maxLeaseEvents
+func (s *VMLeaseStore) Record(event LeaseEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.events
+	s.events = append(s.events, event)
+	if len(s.events) > maxLeaseEvents {
+		s.events = append([]LeaseEvent(nil), s.events[len(s.events)-maxLeaseEvents:]...)
+	}
+	if err := s.save(); err != nil {
+		s.events = previous
+		return err
+	}
+	return nil
+}
+
+// Events returns the reaper activity, newest first
+func (s *VMLeaseStore) Events() []LeaseEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]LeaseEvent, len(s.events))
+	for i, event := range s.events {
+		out[len(s.events)-1-i] = event
+	}
+	return out
+}
+
+// LastEvent returns the most recent reaper action on a VM
+func (s *VMLeaseStore) LastEvent(vmid int) (LeaseEvent, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].VMID == vmid {
+			return s.events[i], true
+		}
+	}
+	return LeaseEvent{}, false
+}
@@ -0,0 +1,267 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"pvmss/logger"
+)
+
+// VM request statuses
+const (
+	VMRequestPending      = "pending"      // waiting for an administrator
+	VMRequestProvisioning = "provisioning" // approved, the VM is being created
+	VMRequestApproved     = "approved"     // the VM was created
+	VMRequestRejected     = "rejected"     // refused by an administrator
+)
+
+var (
+	// ErrVMRequestNotFound is returned when a request ID is unknown
+	ErrVMRequestNotFound = errors.New("vm request not found")
+	// ErrVMRequestNotPending is returned when a request has already been decided
+	ErrVMRequestNotPending = errors.New("vm request is not pending")
+)
+
+// VMRequest is a VM creation that exceeded the auto-approve envelope and waits for an administrator.
+// Form holds the creation form as submitted (or as edited by an administrator), encoded by the handlers.
+type VMRequest struct {
+	ID        string          `json:"id"`
+	Username  string          `json:"username"`
+	Status    string          `json:"status"`
+	Form      json.RawMessage `json:"form"`
+	CreatedAt time.Time       `json:"created_at"`
+	DecidedAt *time.Time      `json:"decided_at,omitempty"`
+	DecidedBy string          `json:"decided_by,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	VMID      int             `json:"vmid,omitempty"`
+	LastError string          `json:"last_error,omitempty"` // why the last approval failed to create the VM
+}
+
+// VMRequestStore keeps VM creation requests and persists them to a JSON file
+type VMRequestStore struct {
+	mu       sync.RWMutex
+	path     string
+	requests map[string]*VMRequest // keyed by ID
+}
+
+// NewVMRequestStore loads the request store from path. An empty path keeps requests in memory only.
+func NewVMRequestStore(path string) (*VMRequestStore, error) {
+	s := &VMRequestStore{path: path, requests: make(map[string]*VMRequest)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vm requests file: %w", err)
+	}
+
+	var requests []*VMRequest
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("failed to parse vm requests file: %w", err)
+	}
+	for _, req := range requests {
+		// A creation interrupted by a restart is offered for approval again
+		if req.Status == VMRequestProvisioning {
+			req.Status = VMRequestPending
+		}
+		s.requests[req.ID] = req
+	}
+	return s, nil
+}
+
+// save writes the store to disk. Callers must hold s.mu.
+func (s *VMRequestStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	requests := make([]*VMRequest, 0, len(s.requests))
+	for _, req := range s.requests {
+		requests = append(requests, req)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].CreatedAt.Before(requests[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(requests, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal vm requests: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write vm requests file: %w", err)
+	}
+	return nil
+}
+
+// Create records a pending request of username
+func (s *VMRequestStore) Create(username string, form json.RawMessage) (*VMRequest, error) {
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	id, err := randomHex(6)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate request id: %w", err)
+	}
+
+	req := &VMRequest{
+		ID:        id,
+		Username:  username,
+		Status:    VMRequestPending,
+		Form:      form,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[id] = req
+	if err := s.save(); err != nil {
+		delete(s.requests, id)
+		return nil, err
+	}
+
+	logger.Get().Info().Str("username", username).Str("vm_request_id", id).Msg("VM request created")
+	copied := *req
+	return &copied, nil
+}
+
+// Get returns a copy of a request
+func (s *VMRequestStore) Get(id string) (VMRequest, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	req, ok := s.requests[id]
+	if !ok {
+		return VMRequest{}, false
+	}
+	return *req, true
+}
+
+// list returns the requests matching keep, newest first
+func (s *VMRequestStore) list(keep func(*VMRequest) bool) []VMRequest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]VMRequest, 0)
+	for _, req := range s.requests {
+		if keep(req) {
+			out = append(out, *req)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// List returns all requests, newest first
+func (s *VMRequestStore) List() []VMRequest {
+	return s.list(func(*VMRequest) bool { return true })
+}
+
+// ListForUser returns the requests of username, newest first
+func (s *VMRequestStore) ListForUser(username string) []VMRequest {
+	return s.list(func(req *VMRequest) bool { return req.Username == username })
+}
+
+// update applies change to a request and persists it, rolling back on a write error
+func (s *VMRequestStore) update(id string, change func(req *VMRequest)) (*VMRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[id]
+	if !ok {
+		return nil, ErrVMRequestNotFound
+	}
+	previous := *req
+	change(req)
+	if err := s.save(); err != nil {
+		*req = previous
+		return nil, err
+	}
+	copied := *req
+	return &copied, nil
+}
+
+// requirePending wraps change so it only applies to a pending request
+func requirePending(err *error, change func(req *VMRequest)) func(req *VMRequest) {
+	return func(req *VMRequest) {
+		if req.Status != VMRequestPending {
+			*err = ErrVMRequestNotPending
+			return
+		}
+		change(req)
+	}
+}
+
+// UpdateForm replaces the form of a pending request, e.g. after an administrator edited it
+func (s *VMRequestStore) UpdateForm(id string, form json.RawMessage) error {
+	var stateErr error
+	_, err := s.update(id, requirePending(&stateErr, func(req *VMRequest) {
+		req.Form = form
+	}))
+	if err != nil {
+		return err
+	}
+	return stateErr
+}
+
+// StartProvisioning marks a pending request as being created so it cannot be approved twice
+func (s *VMRequestStore) StartProvisioning(id string) (*VMRequest, error) {
+	var stateErr error
+	req, err := s.update(id, requirePending(&stateErr, func(req *VMRequest) {
+		req.Status = VMRequestProvisioning
+		req.LastError = ""
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if stateErr != nil {
+		return nil, stateErr
+	}
+	return req, nil
+}
+
+// FinishProvisioning records the outcome of an approval. On failure the request goes back to
+// pending with the error so it can be edited and approved again.
+func (s *VMRequestStore) FinishProvisioning(id, admin string, vmid int, failure error) error {
+	_, err := s.update(id, func(req *VMRequest) {
+		if failure != nil {
+			req.Status = VMRequestPending
+			req.LastError = failure.Error()
+			return
+		}
+		now := time.Now().UTC()
+		req.Status = VMRequestApproved
+		req.DecidedAt = &now
+		req.DecidedBy = admin
+		req.VMID = vmid
+	})
+	if err == nil && failure == nil {
+		logger.Get().Info().Str("vm_request_id", id).Str("admin", admin).Int("vmid", vmid).Msg("VM request approved")
+	}
+	return err
+}
+
+// Reject refuses a pending request with a reason shown to the requester
+func (s *VMRequestStore) Reject(id, admin, reason string) error {
+	var stateErr error
+	_, err := s.update(id, requirePending(&stateErr, func(req *VMRequest) {
+		now := time.Now().UTC()
+		req.Status = VMRequestRejected
+		req.DecidedAt = &now
+		req.DecidedBy = admin
+		req.Reason = reason
+	}))
+	if err != nil {
+		return err
+	}
+	if stateErr != nil {
+		return stateErr
+	}
+	logger.Get().Info().Str("vm_request_id", id).Str("admin", admin).Msg("VM request rejected")
+	return nil
+}
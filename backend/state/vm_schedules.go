@@ -0,0 +1,340 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"pvmss/logger"
+)
+
+// Power schedule actions
+const (
+	ScheduleActionStart    = "start"
+	ScheduleActionShutdown = "shutdown" // falls back to a forced stop when the guest does not shut down
+)
+
+// Power schedule run results recorded in the history
+const (
+	ScheduleRunOK      = "ok"
+	ScheduleRunSkipped = "skipped" // the VM no longer belongs to the schedule owner
+	ScheduleRunFailed  = "failed"
+)
+
+// maxScheduleRuns bounds the run history kept in the store
+const maxScheduleRuns = 1000
+
+var (
+	// ErrVMScheduleNotFound is returned when a schedule ID is unknown
+	ErrVMScheduleNotFound = errors.New("vm schedule not found")
+	// ErrInvalidVMSchedule is returned when a schedule has no day, an invalid time or an unknown timezone
+	ErrInvalidVMSchedule = errors.New("invalid vm schedule")
+)
+
+// VMSchedule powers a VM on or off at a time of day on the selected weekdays
+type VMSchedule struct {
+	ID        string         `json:"id"`
+	VMID      int            `json:"vmid"`
+	Name      string         `json:"name"`     // guards against acting on a VM that reused the VMID
+	Username  string         `json:"username"` // owner the VM must still belong to; empty when managed by an administrator
+	Action    string         `json:"action"`
+	Days      []time.Weekday `json:"days"`     // 0 is Sunday
+	Time      string         `json:"time"`     // HH:MM in Timezone
+	Timezone  string         `json:"timezone"` // IANA name, e.g. Europe/Paris
+	CreatedAt time.Time      `json:"created_at"`
+	LastRun   *time.Time     `json:"last_run,omitempty"` // occurrence of the last run, in UTC
+}
+
+// Validate checks the action, days, time and timezone of the schedule
+func (s *VMSchedule) Validate() error {
+	if s.Action != ScheduleActionStart && s.Action != ScheduleActionShutdown {
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidVMSchedule, s.Action)
+	}
+	if len(s.Days) == 0 {
+		return fmt.Errorf("%w: no day selected", ErrInvalidVMSchedule)
+	}
+	for _, day := range s.Days {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("%w: invalid day %d", ErrInvalidVMSchedule, day)
+		}
+	}
+	if _, err := time.Parse("15:04", s.Time); err != nil {
+		return fmt.Errorf("%w: invalid time %q", ErrInvalidVMSchedule, s.Time)
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalidVMSchedule, s.Timezone)
+	}
+	return nil
+}
+
+// occurrences returns the times the schedule is due on the 8 days around now, starting
+// a week before it when back is true
+func (s *VMSchedule) occurrences(now time.Time, back bool) []time.Time {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil
+	}
+	at, err := time.Parse("15:04", s.Time)
+	if err != nil {
+		return nil
+	}
+
+	local := now.In(loc)
+	out := make([]time.Time, 0, 8)
+	for i := 0; i <= 7; i++ {
+		offset := i
+		if back {
+			offset = -i
+		}
+		day := local.AddDate(0, 0, offset)
+		candidate := time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, loc)
+		for _, d := range s.Days {
+			if candidate.Weekday() == d {
+				out = append(out, candidate)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// LastOccurrence returns the latest time at or before now when the schedule was due
+func (s *VMSchedule) LastOccurrence(now time.Time) (time.Time, bool) {
+	for _, candidate := range s.occurrences(now, true) {
+		if !candidate.After(now) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// NextOccurrence returns the first time after now when the schedule is due
+func (s *VMSchedule) NextOccurrence(now time.Time) (time.Time, bool) {
+	for _, candidate := range s.occurrences(now, false) {
+		if candidate.After(now) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ScheduleRun is an execution of a power schedule
+type ScheduleRun struct {
+	Time       time.Time `json:"time"`
+	ScheduleID string    `json:"schedule_id"`
+	VMID       int       `json:"vmid"`
+	Username   string    `json:"username"`
+	Action     string    `json:"action"`
+	Result     string    `json:"result"`
+	Fallback   bool      `json:"fallback,omitempty"` // the shutdown timed out and the VM was stopped
+	Error      string    `json:"error,omitempty"`
+}
+
+// scheduleFile is the on-disk layout of the schedule store
+type scheduleFile struct {
+	Schedules []*VMSchedule `json:"schedules"`
+	Runs      []ScheduleRun `json:"runs"`
+}
+
+// VMScheduleStore keeps VM power schedules and their run history, and persists them to a JSON file
+type VMScheduleStore struct {
+	mu        sync.RWMutex
+	path      string
+	schedules map[string]*VMSchedule // keyed by ID
+	runs      []ScheduleRun          // oldest first
+}
+
+// NewVMScheduleStore loads the schedule store from path. An empty path keeps schedules in memory only.
+func NewVMScheduleStore(path string) (*VMScheduleStore, error) {
+	s := &VMScheduleStore{path: path, schedules: make(map[string]*VMSchedule)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vm schedules file: %w", err)
+	}
+
+	var file scheduleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse vm schedules file: %w", err)
+	}
+	for _, schedule := range file.Schedules {
+		s.schedules[schedule.ID] = schedule
+	}
+	s.runs = file.Runs
+	return s, nil
+}
+
+// save writes the store to disk. Callers must hold s.mu.
+func (s *VMScheduleStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	file := scheduleFile{Schedules: make([]*VMSchedule, 0, len(s.schedules)), Runs: s.runs}
+	for _, schedule := range s.schedules {
+		file.Schedules = append(file.Schedules, schedule)
+	}
+	sort.Slice(file.Schedules, func(i, j int) bool { return file.Schedules[i].CreatedAt.Before(file.Schedules[j].CreatedAt) })
+	if file.Runs == nil {
+		file.Runs = []ScheduleRun{}
+	}
+
+	data, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal vm schedules: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write vm schedules file: %w", err)
+	}
+	return nil
+}
+
+// Create validates and records a schedule; its next occurrence is the first one after now
+func (s *VMScheduleStore) Create(schedule VMSchedule) (*VMSchedule, error) {
+	if schedule.VMID <= 0 {
+		return nil, fmt.Errorf("invalid vmid %d", schedule.VMID)
+	}
+	if err := schedule.Validate(); err != nil {
+		return nil, err
+	}
+	id, err := randomHex(6)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate schedule id: %w", err)
+	}
+	schedule.ID = id
+	schedule.CreatedAt = time.Now().UTC()
+	// An occurrence earlier today must not fire as soon as the schedule is saved
+	if last, ok := schedule.LastOccurrence(schedule.CreatedAt); ok {
+		last = last.UTC()
+		schedule.LastRun = &last
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules[id] = &schedule
+	if err := s.save(); err != nil {
+		delete(s.schedules, id)
+		return nil, err
+	}
+
+	logger.Get().Info().Str("schedule_id", id).Int("vmid", schedule.VMID).Str("action", schedule.Action).Msg("VM power schedule created")
+	copied := schedule
+	return &copied, nil
+}
+
+// Get returns a copy of a schedule
+func (s *VMScheduleStore) Get(id string) (VMSchedule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return VMSchedule{}, false
+	}
+	return *schedule, true
+}
+
+// List returns all schedules, oldest first
+func (s *VMScheduleStore) List() []VMSchedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]VMSchedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		out = append(out, *schedule)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// ListForVM returns the schedules of a VM, oldest first
+func (s *VMScheduleStore) ListForVM(vmid int) []VMSchedule {
+	out := make([]VMSchedule, 0)
+	for _, schedule := range s.List() {
+		if schedule.VMID == vmid {
+			out = append(out, schedule)
+		}
+	}
+	return out
+}
+
+// MarkRun records the occurrence a schedule was last run for
+func (s *VMScheduleStore) MarkRun(id string, occurrence time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return ErrVMScheduleNotFound
+	}
+	previous := schedule.LastRun
+	at := occurrence.UTC()
+	schedule.LastRun = &at
+	if err := s.save(); err != nil {
+		schedule.LastRun = previous
+		return err
+	}
+	return nil
+}
+
+// Delete removes a schedule
+func (s *VMScheduleStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return ErrVMScheduleNotFound
+	}
+	delete(s.schedules, id)
+	if err := s.save(); err != nil {
+		s.schedules[id] = schedule
+		return err
+	}
+
+	logger.Get().Info().Str("schedule_id", id).Int("vmid", schedule.VMID).Msg("VM power schedule deleted")
+	return nil
+}
+
+// Record appends a run to the history, dropping the oldest entries beyond maxScheduleRuns
+func (s *VMScheduleStore) Record(run ScheduleRun) error {
+	if run.Time.IsZero() {
+		run.Time = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.runs
+	s.runs = append(s.runs, run)
+	if len(s.runs) > maxScheduleRuns {
+		s.runs = append([]ScheduleRun(nil), s.runs[len(s.runs)-maxScheduleRuns:]...)
+	}
+	if err := s.save(); err != nil {
+		s.runs = previous
+		return err
+	}
+	return nil
+}
+
+// RunsForVM returns the runs of the schedules of a VM, newest first
+func (s *VMScheduleStore) RunsForVM(vmid int) []ScheduleRun {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]ScheduleRun, 0)
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if s.runs[i].VMID == vmid {
+			out = append(out, s.runs[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,243 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pvmss/logger"
+)
+
+const (
+	// DefaultBufferSize is the number of messages queued while the collector is slow or down
+	DefaultBufferSize = 1024
+
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+	minBackoff   = time.Second
+	maxBackoff   = 30 * time.Second
+)
+
+// defaultPorts are the ports assigned to syslog over each transport
+var defaultPorts = map[string]string{"udp": "514", "tcp": "601", "tls": "6514"}
+
+// Options configure a Forwarder
+type Options struct {
+	Header Header
+	// TLSConfig is used by tls:// collectors; by default the server is verified against the system roots
+	TLSConfig *tls.Config
+	// BufferSize bounds the queued messages, DefaultBufferSize when 0
+	BufferSize int
+}
+
+// Forwarder sends messages to a collector in the background
+type Forwarder struct {
+	transport string // udp, tcp or tls
+	addr      string
+	tlsConfig *tls.Config
+	header    Header
+
+	queue     chan []byte
+	dropped   atomic.Uint64
+	reported  uint64 // dropped count last logged, owned by run
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a forwarder to the collector of rawURL, udp://host[:port], tcp://host[:port] or
+// tls://host[:port]. It connects on the first message, so a collector that is down does not prevent
+// starting.
+func New(rawURL string, opts Options) (*Forwarder, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog URL: %w", err)
+	}
+	port, ok := defaultPorts[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog URL scheme %q: use udp, tcp or tls", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("invalid syslog URL: missing host")
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	f := &Forwarder{
+		transport: u.Scheme,
+		addr:      net.JoinHostPort(u.Hostname(), port),
+		header:    opts.Header,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	if f.transport == "tls" {
+		f.tlsConfig = opts.TLSConfig
+		if f.tlsConfig == nil {
+			f.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		if f.tlsConfig.ServerName == "" {
+			f.tlsConfig = f.tlsConfig.Clone()
+			f.tlsConfig.ServerName = u.Hostname()
+		}
+	}
+	size := opts.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	f.queue = make(chan []byte, size)
+
+	go f.run()
+	return f, nil
+}
+
+// Addr returns the transport and address of the collector, such as tls://siem:6514
+func (f *Forwarder) Addr() string {
+	return f.transport + "://" + f.addr
+}
+
+// Send queues msg without blocking. It reports false when the buffer is full or the forwarder is
+// closed, and the message is dropped.
+func (f *Forwarder) Send(msg Message) bool {
+	select {
+	case <-f.done:
+		return false
+	default:
+	}
+	select {
+	case f.queue <- Format(f.header, msg):
+		return true
+	default:
+		f.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns how many messages were dropped because the buffer was full or a write failed
+func (f *Forwarder) Dropped() uint64 {
+	return f.dropped.Load()
+}
+
+// Close stops the forwarder after sending the queued messages it can within a write timeout
+func (f *Forwarder) Close() error {
+	f.closeOnce.Do(func() { close(f.done) })
+	<-f.stopped
+	return nil
+}
+
+// run sends the queued messages, reconnecting with an increasing delay while the collector is
+// unreachable. A message that fails on a fresh connection is dropped rather than retried forever.
+func (f *Forwarder) run() {
+	defer close(f.stopped)
+	log := logger.Get()
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	backoff := minBackoff
+	down := false
+
+	for {
+		var msg []byte
+		select {
+		case msg = <-f.queue:
+		case <-f.done:
+			f.drain(conn)
+			return
+		}
+
+		for {
+			fresh := false
+			if conn == nil {
+				c, err := f.dial()
+				if err != nil {
+					if !down {
+						log.Warn().Err(err).Str("collector", f.Addr()).Msg("Syslog collector unreachable, queuing messages")
+						down = true
+					}
+					select {
+					case <-time.After(backoff):
+					case <-f.done:
+						f.dropped.Add(uint64(len(f.queue)) + 1)
+						return
+					}
+					backoff = min(backoff*2, maxBackoff)
+					continue
+				}
+				conn, fresh = c, true
+				backoff = minBackoff
+				if down {
+					log.Info().Str("collector", f.Addr()).Msg("Syslog collector reconnected")
+					down = false
+				}
+			}
+
+			err := f.write(conn, msg)
+			if err == nil {
+				break
+			}
+			conn.Close()
+			conn = nil
+			// A broken connection is retried once; a fresh one failing means the message is the problem
+			if fresh {
+				log.Warn().Err(err).Str("collector", f.Addr()).Msg("Failed to send syslog message, dropping it")
+				f.dropped.Add(1)
+				break
+			}
+		}
+
+		if dropped := f.dropped.Load(); dropped > f.reported {
+			log.Warn().Uint64("dropped", dropped-f.reported).Str("collector", f.Addr()).Msg("Syslog messages dropped")
+			f.reported = dropped
+		}
+	}
+}
+
+// drain sends what remains in the queue on the open connection, if any, on closing
+func (f *Forwarder) drain(conn net.Conn) {
+	for {
+		select {
+		case msg := <-f.queue:
+			if conn == nil || f.write(conn, msg) != nil {
+				f.dropped.Add(uint64(len(f.queue)) + 1)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// dial connects to the collector
+func (f *Forwarder) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	switch f.transport {
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", f.addr, f.tlsConfig)
+	case "tcp":
+		return dialer.Dial("tcp", f.addr)
+	default:
+		return dialer.Dial("udp", f.addr)
+	}
+}
+
+// write frames msg for the transport: one datagram over UDP, octet counting over TCP and TLS
+func (f *Forwarder) write(conn net.Conn, msg []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	if f.transport != "udp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	_, err := conn.Write(msg)
+	return err
+}
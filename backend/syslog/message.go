@@ -0,0 +1,168 @@
+// Package syslog forwards events to a syslog collector as RFC 5424 messages, over UDP (RFC 5426),
+// TCP (RFC 6587 octet counting) or TLS (RFC 5425). Messages are queued in a bounded buffer and sent
+// by a background goroutine that reconnects on failure, so a collector that is down or slow never
+// blocks the caller: once the buffer is full, new messages are dropped and counted.
+package syslog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity of a message, as defined by RFC 5424
+type Severity int
+
+// Severities
+const (
+	SeverityEmergency Severity = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+// facilities maps the facility names to their RFC 5424 codes
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// ParseFacility returns the code of a facility name such as auth, authpriv or local0
+func ParseFacility(name string) (int, error) {
+	code, ok := facilities[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return code, nil
+}
+
+// Param is a parameter of a structured-data element
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Element is a structured-data element: an SD-ID such as "actor@32473" and its parameters, in order
+type Element struct {
+	ID     string
+	Params []Param
+}
+
+// Message is one event to forward
+type Message struct {
+	Time     time.Time
+	Severity Severity
+	MsgID    string // type of the event, such as "auth.login"
+	Data     []Element
+	Text     string
+}
+
+// Header holds the fields identifying the sender, the same on every message
+type Header struct {
+	Facility int
+	Hostname string
+	AppName  string
+	ProcID   string
+}
+
+// Field lengths of the RFC 5424 header
+const (
+	maxHostname = 255
+	maxAppName  = 48
+	maxProcID   = 128
+	maxMsgID    = 32
+	maxSDName   = 32
+)
+
+// Format renders msg as an RFC 5424 message, without framing:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func Format(h Header, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("<")
+	b.WriteString(strconv.Itoa(h.Facility*8 + int(msg.Severity)))
+	b.WriteString(">1 ")
+	if msg.Time.IsZero() {
+		b.WriteString("-")
+	} else {
+		b.WriteString(msg.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	}
+	for _, field := range []struct {
+		value string
+		max   int
+	}{{h.Hostname, maxHostname}, {h.AppName, maxAppName}, {h.ProcID, maxProcID}, {msg.MsgID, maxMsgID}} {
+		b.WriteString(" ")
+		b.WriteString(headerField(field.value, field.max))
+	}
+
+	b.WriteString(" ")
+	written := false
+	for _, el := range msg.Data {
+		id := sdName(el.ID)
+		if id == "" {
+			continue
+		}
+		b.WriteString("[")
+		b.WriteString(id)
+		for _, p := range el.Params {
+			name := sdName(p.Name)
+			if name == "" {
+				continue
+			}
+			b.WriteString(" ")
+			b.WriteString(name)
+			b.WriteString(`="`)
+			b.WriteString(sdEscaper.Replace(p.Value))
+			b.WriteString(`"`)
+		}
+		b.WriteString("]")
+		written = true
+	}
+	if !written {
+		b.WriteString("-")
+	}
+
+	if msg.Text != "" {
+		b.WriteString(" ")
+		// A newline would end the message for collectors reading lines
+		b.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Text))
+	}
+	return []byte(b.String())
+}
+
+// sdEscaper escapes the characters RFC 5424 reserves in parameter values
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// headerField keeps the printable ASCII characters of a header field, up to max, or "-" when empty
+func headerField(s string, max int) string {
+	out := printable(s, max, func(rune) bool { return true })
+	if out == "" {
+		return "-"
+	}
+	return out
+}
+
+// sdName keeps the characters allowed in an SD-ID or parameter name
+func sdName(s string) string {
+	return printable(s, maxSDName, func(c rune) bool { return c != '=' && c != ']' && c != '"' })
+}
+
+// printable keeps the printable ASCII characters of s, other than spaces, that allowed accepts
+func printable(s string, max int, allowed func(rune) bool) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c > ' ' && c <= '~' && allowed(c) {
+			b.WriteRune(c)
+			if b.Len() == max {
+				break
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,181 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testHeader = Header{Facility: 10, Hostname: "pvmss-1", AppName: "pvmss", ProcID: "42"}
+
+func TestFormat(t *testing.T) {
+	msg := Message{
+		Time:     time.Date(2026, 3, 4, 5, 6, 7, 890000000, time.FixedZone("CET", 3600)),
+		Severity: SeverityWarning,
+		MsgID:    "auth.login",
+		Data: []Element{
+			{ID: "actor@32473", Params: []Param{{"name", "alice"}, {"ip", "192.0.2.1"}}},
+			{ID: "result@32473", Params: []Param{{"status", "failure"}, {"error", `bad "password" \ ]`}}},
+		},
+		Text: "alice auth.login: failure\nforged line",
+	}
+	want := `<84>1 2026-03-04T04:06:07.890000Z pvmss-1 pvmss 42 auth.login ` +
+		`[actor@32473 name="alice" ip="192.0.2.1"][result@32473 status="failure" error="bad \"password\" \\ \]"] ` +
+		`alice auth.login: failure forged line`
+	if got := string(Format(testHeader, msg)); got != want {
+		t.Errorf("Unexpected message:\n got %s\nwant %s", got, want)
+	}
+
+	if got := string(Format(Header{Facility: 1, AppName: "my app"}, Message{Severity: SeverityInfo})); got != "<14>1 - - myapp - - -" {
+		t.Errorf("Expected nil values for empty fields, got %q", got)
+	}
+}
+
+func TestParseFacility(t *testing.T) {
+	if code, err := ParseFacility(" LOCAL3 "); err != nil || code != 19 {
+		t.Errorf("Expected local3 to be 19, got %d (%v)", code, err)
+	}
+	if _, err := ParseFacility("local9"); err == nil {
+		t.Error("Expected an unknown facility to be refused")
+	}
+}
+
+func TestNewRejectsInvalidURLs(t *testing.T) {
+	for _, raw := range []string{"http://collector:514", "udp://", "collector:514"} {
+		if _, err := New(raw, Options{}); err == nil {
+			t.Errorf("Expected %q to be refused", raw)
+		}
+	}
+}
+
+// readFrame reads one octet-counted message
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	length, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestForwarderTCPReconnects(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := New("tcp://"+ln.Addr().String(), Options{Header: testHeader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Send(Message{MsgID: "first", Text: "one"})
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if got := readFrame(t, bufio.NewReader(conn)); !strings.Contains(got, " first - one") {
+		t.Fatalf("Unexpected first message %q", got)
+	}
+
+	// The collector restarts: messages keep flowing on a new connection
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	var second net.Conn
+	for second == nil && time.Now().Before(deadline) {
+		f.Send(Message{MsgID: "again", Text: "two"})
+		select {
+		case second = <-accepted:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if second == nil {
+		t.Fatal("Expected the forwarder to reconnect")
+	}
+	defer second.Close()
+	_ = second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if got := readFrame(t, bufio.NewReader(second)); !strings.Contains(got, " again - two") {
+		t.Errorf("Unexpected message after reconnecting %q", got)
+	}
+}
+
+func TestForwarderUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	f, err := New("udp://"+pc.LocalAddr().String(), Options{Header: testHeader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Send(Message{Severity: SeverityNotice, MsgID: "vm.start", Text: "alice vm.start"})
+
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); !strings.HasPrefix(got, "<85>1 - pvmss-1 pvmss 42 vm.start - alice vm.start") {
+		t.Errorf("Expected one unframed message per datagram, got %q", got)
+	}
+}
+
+func TestForwarderNeverBlocksWhenCollectorIsDown(t *testing.T) {
+	// A port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	f, err := New("tcp://"+addr, Options{BufferSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		f.Send(Message{MsgID: "auth.login"})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected sending to return at once, took %s", elapsed)
+	}
+	if f.Dropped() < 7 {
+		t.Errorf("Expected the messages beyond the buffer to be dropped, got %d", f.Dropped())
+	}
+
+	start = time.Now()
+	_ = f.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected closing not to wait for the collector, took %s", elapsed)
+	}
+	if f.Send(Message{MsgID: "late"}) {
+		t.Error("Expected a closed forwarder to refuse messages")
+	}
+}
@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890"
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8-digit values; a 6-digit code is their last six digits
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Code at %d: expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, now)
+
+	step, ok := Validate(secret, code, now)
+	if !ok || step != Step(now) {
+		t.Fatalf("Expected the current code to be valid at step %d, got %d (%v)", Step(now), step, ok)
+	}
+	if _, ok := Validate(strings.ToLower(secret), code[:3]+" "+code[3:], now.Add(Period)); !ok {
+		t.Error("Expected a code from the previous step, typed with a space, to be accepted")
+	}
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Error("Expected a code three steps old to be refused")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("Expected a short code to be refused")
+	}
+	if _, ok := Validate("not base32!", code, now); ok {
+		t.Error("Expected an invalid secret to be refused")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("PVMSS", "alice", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/PVMSS:alice?algorithm=SHA1&digits=6&issuer=PVMSS&period=30&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,323 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pvmss/logger"
+)
+
+const (
+	// otlpQueueSize bounds the spans waiting to be sent; more are dropped
+	otlpQueueSize = 2048
+	// otlpBatchSize is the most spans sent in one request
+	otlpBatchSize = 512
+	// otlpFlushInterval is how long a span may wait before its batch is sent
+	otlpFlushInterval = 5 * time.Second
+	otlpTimeout       = 10 * time.Second
+)
+
+// OTLPOptions configure an OTLPExporter
+type OTLPOptions struct {
+	// Endpoint is the URL spans are posted to, such as http://collector:4318/v1/traces
+	Endpoint string
+	// Headers are added to every request, such as an authorization header
+	Headers map[string]string
+	// ServiceName is the service.name resource attribute
+	ServiceName string
+	// Client sends the requests, a client with a timeout when nil
+	Client *http.Client
+}
+
+// OTLPExporter sends spans to a collector over OTLP/HTTP with the JSON encoding. Spans are
+// batched in the background: a slow or unreachable collector never delays a request, and once
+// the queue is full new spans are dropped and counted.
+type OTLPExporter struct {
+	opts    OTLPOptions
+	queue   chan SpanData
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// NewOTLPExporter starts an exporter posting to opts.Endpoint
+func NewOTLPExporter(opts OTLPOptions) (*OTLPExporter, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", opts.Endpoint)
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = "pvmss"
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: otlpTimeout}
+	}
+
+	e := &OTLPExporter{
+		opts:    opts,
+		queue:   make(chan SpanData, otlpQueueSize),
+		flush:   make(chan chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// ExportSpan queues the span without blocking
+func (e *OTLPExporter) ExportSpan(span SpanData) {
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+	select {
+	case e.queue <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many spans were dropped because the queue was full or the collector failed
+func (e *OTLPExporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Flush sends the queued spans and waits until they are sent or ctx is done
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case e.flush <- ack:
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown sends the queued spans and stops the exporter
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.once.Do(func() { close(e.done) })
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run batches the queued spans and sends a batch when it is full, on each interval and on flush
+func (e *OTLPExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, otlpBatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.dropped.Add(uint64(len(batch)))
+			logger.Get().Warn().Err(err).Int("spans", len(batch)).Str("endpoint", e.opts.Endpoint).Msg("Failed to export spans")
+		}
+		batch = batch[:0]
+	}
+	drain := func() {
+		for {
+			select {
+			case span := <-e.queue:
+				batch = append(batch, span)
+				if len(batch) == otlpBatchSize {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) == otlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-e.flush:
+			drain()
+			close(ack)
+		case <-e.done:
+			drain()
+			return
+		}
+	}
+}
+
+// send posts a batch to the collector
+func (e *OTLPExporter) send(batch []SpanData) error {
+	body, err := json.Marshal(otlpRequest(e.opts.ServiceName, batch))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New("collector answered " + resp.Status + ": " + string(bytes.TrimSpace(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding: IDs are hexadecimal, 64-bit integers are strings and enums numbers
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+// otlpRequest builds an ExportTraceServiceRequest
+func otlpRequest(serviceName string, batch []SpanData) map[string]any {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, d := range batch {
+		s := otlpSpan{
+			TraceID:           d.TraceID.String(),
+			SpanID:            d.SpanID.String(),
+			Name:              d.Name,
+			Kind:              d.Kind,
+			StartTimeUnixNano: strconv.FormatInt(d.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(d.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(d.Attributes),
+			Status:            otlpStatus{Code: d.StatusCode, Message: d.StatusMessage},
+		}
+		if d.ParentSpanID.IsValid() {
+			s.ParentSpanID = d.ParentSpanID.String()
+		}
+		spans = append(spans, s)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]Attribute{String("service.name", serviceName)}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "pvmss"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes encodes attributes as OTLP AnyValues
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]any
+		switch value := a.Value.(type) {
+		case string:
+			v = map[string]any{"stringValue": value}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]any{"doubleValue": value}
+		case bool:
+			v = map[string]any{"boolValue": value}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(value)}
+		}
+		out = append(out, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return out
+}
+
+// NewOTLPExporterFromEnv starts an exporter configured by the standard OpenTelemetry variables:
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or OTEL_EXPORTER_OTLP_ENDPOINT followed by /v1/traces,
+// OTEL_EXPORTER_OTLP_HEADERS (key=value pairs separated by commas) and OTEL_SERVICE_NAME.
+// It returns nil when no endpoint is set.
+func NewOTLPExporterFromEnv() (*OTLPExporter, error) {
+	endpoint := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+	if endpoint == "" {
+		if base := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q", pair)
+		}
+		if decoded, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+
+	return NewOTLPExporter(OTLPOptions{
+		Endpoint:    endpoint,
+		Headers:     headers,
+		ServiceName: strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME")),
+	})
+}
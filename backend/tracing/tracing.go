@@ -0,0 +1,293 @@
+// Package tracing records spans following the OpenTelemetry model and hands them to an exporter:
+// OTLPExporter sends them to a collector over OTLP/HTTP, Recorder keeps them in memory for tests.
+// Trace context crosses process boundaries in the W3C traceparent header.
+//
+// Tracing is off until SetExporter is called: Start then returns a nil span, whose methods do
+// nothing, so instrumented code costs next to nothing when no collector is configured.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// String returns the ID in lowercase hexadecimal
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid reports whether the ID is not all zeros
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the ID in lowercase hexadecimal
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the ID is not all zeros
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// Kind is the role of a span, as numbered by OTLP
+type Kind int
+
+// Span kinds
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// StatusCode is the outcome of a span, as numbered by OTLP
+type StatusCode int
+
+// Status codes
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key and a string, int64, float64 or bool value
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute { return Attribute{Key: key, Value: value} }
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute { return Attribute{Key: key, Value: int64(value)} }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute { return Attribute{Key: key, Value: value} }
+
+// SpanData is a finished span as handed to the exporter
+type SpanData struct {
+	TraceID       TraceID
+	SpanID        SpanID
+	ParentSpanID  SpanID // zero for a root span
+	Name          string
+	Kind          Kind
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Attribute returns the value of the attribute key, nil when it is not set
+func (d SpanData) Attribute(key string) any {
+	for i := len(d.Attributes) - 1; i >= 0; i-- {
+		if d.Attributes[i].Key == key {
+			return d.Attributes[i].Value
+		}
+	}
+	return nil
+}
+
+// Exporter receives the spans as they end
+type Exporter interface {
+	// ExportSpan takes a finished span; it must not block
+	ExportSpan(SpanData)
+	// Shutdown sends what is still buffered and stops the exporter
+	Shutdown(ctx context.Context) error
+}
+
+// exporterHolder lets an interface be stored atomically
+type exporterHolder struct{ Exporter }
+
+var current atomic.Pointer[exporterHolder]
+
+// SetExporter turns tracing on with e, or off when e is nil, and returns the previous exporter
+func SetExporter(e Exporter) Exporter {
+	var prev *exporterHolder
+	if e == nil {
+		prev = current.Swap(nil)
+	} else {
+		prev = current.Swap(&exporterHolder{e})
+	}
+	if prev == nil {
+		return nil
+	}
+	return prev.Exporter
+}
+
+// Enabled reports whether an exporter is set
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Span is a timed operation. A nil Span is valid and records nothing.
+type Span struct {
+	mu       sync.Mutex
+	data     SpanData
+	exporter Exporter
+	ended    bool
+}
+
+type spanKey struct{}
+
+// remoteKey holds the span context extracted from an incoming request
+type remoteKey struct{}
+
+type remoteParent struct {
+	traceID TraceID
+	spanID  SpanID
+}
+
+// Start begins a span named name, child of the span in ctx if any, and returns a context
+// carrying it. It returns ctx and a nil span when tracing is off.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attribute) (context.Context, *Span) {
+	holder := current.Load()
+	if holder == nil {
+		return ctx, nil
+	}
+
+	s := &Span{exporter: holder.Exporter, data: SpanData{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: append([]Attribute(nil), attrs...),
+	}}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.data.TraceID = parent.data.TraceID
+		s.data.ParentSpanID = parent.data.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		s.data.TraceID = remote.traceID
+		s.data.ParentSpanID = remote.spanID
+	} else {
+		_, _ = rand.Read(s.data.TraceID[:])
+	}
+	_, _ = rand.Read(s.data.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SpanFromContext returns the span carried by ctx, nil if none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// TraceID returns the trace of the span, zero for a nil span
+func (s *Span) TraceID() TraceID {
+	if s == nil {
+		return TraceID{}
+	}
+	return s.data.TraceID
+}
+
+// SpanID returns the ID of the span, zero for a nil span
+func (s *Span) SpanID() SpanID {
+	if s == nil {
+		return SpanID{}
+	}
+	return s.data.SpanID
+}
+
+// SetName renames the span, once the operation is better known
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttributes adds attributes to the span; a key set twice keeps its last value
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// SetStatus sets the outcome of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.StatusCode = code
+	s.data.StatusMessage = message
+}
+
+// RecordError marks the span as failed with err; a nil err does nothing
+func (s *Span) RecordError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	}
+}
+
+// End finishes the span and hands it to the exporter; later calls do nothing
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = dedupe(data.Attributes)
+	s.mu.Unlock()
+	s.exporter.ExportSpan(data)
+}
+
+// dedupe keeps the last value of each attribute key, in first-set order
+func dedupe(attrs []Attribute) []Attribute {
+	index := make(map[string]int, len(attrs))
+	out := make([]Attribute, 0, len(attrs))
+	for _, a := range attrs {
+		if i, ok := index[a.Key]; ok {
+			out[i] = a
+			continue
+		}
+		index[a.Key] = len(out)
+		out = append(out, a)
+	}
+	return out
+}
+
+// traceparentHeader is the W3C Trace Context header
+const traceparentHeader = "traceparent"
+
+// Extract returns ctx with the trace context of the traceparent header of h, so the next span
+// started continues the caller's trace. An absent or malformed header leaves ctx unchanged.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(strings.TrimSpace(h.Get(traceparentHeader)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	var remote remoteParent
+	if _, err := hex.Decode(remote.traceID[:], []byte(parts[1])); err != nil || !remote.traceID.IsValid() {
+		return ctx
+	}
+	if _, err := hex.Decode(remote.spanID[:], []byte(parts[2])); err != nil || !remote.spanID.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, remote)
+}
+
+// Inject sets the traceparent header of h to the span in ctx, if any
+func Inject(ctx context.Context, h http.Header) {
+	if s := SpanFromContext(ctx); s != nil {
+		h.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-01", s.data.TraceID, s.data.SpanID))
+	}
+}
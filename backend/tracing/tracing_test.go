@@ -0,0 +1,203 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useRecorder turns tracing on with a recorder for the duration of the test
+func useRecorder(t *testing.T) *Recorder {
+	t.Helper()
+	rec := NewRecorder()
+	prev := SetExporter(rec)
+	t.Cleanup(func() { SetExporter(prev) })
+	return rec
+}
+
+func TestStartDisabled(t *testing.T) {
+	prev := SetExporter(nil)
+	defer SetExporter(prev)
+
+	ctx, span := Start(context.Background(), "noop", KindInternal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("Expected no span while tracing is off")
+	}
+	// A nil span must be safe to use
+	span.SetName("x")
+	span.SetAttributes(String("k", "v"))
+	span.RecordError(errors.New("boom"))
+	span.End()
+}
+
+func TestSpanParentage(t *testing.T) {
+	rec := useRecorder(t)
+
+	ctx, parent := Start(context.Background(), "parent", KindServer, String("route", "/a"))
+	_, child := Start(ctx, "child", KindClient)
+	child.RecordError(errors.New("boom"))
+	child.End()
+	parent.SetAttributes(String("route", "/b"), Int("status", 200))
+	parent.End()
+	parent.End()
+
+	spans := rec.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.TraceID != p.TraceID || !p.TraceID.IsValid() {
+		t.Errorf("Expected both spans in the same trace, got %s and %s", c.TraceID, p.TraceID)
+	}
+	if c.ParentSpanID != p.SpanID || p.ParentSpanID.IsValid() {
+		t.Errorf("Expected child of %s, got parent %s (root parent %s)", p.SpanID, c.ParentSpanID, p.ParentSpanID)
+	}
+	if c.StatusCode != StatusError || c.StatusMessage != "boom" {
+		t.Errorf("Expected the error recorded, got %d %q", c.StatusCode, c.StatusMessage)
+	}
+	if len(p.Attributes) != 2 || p.Attribute("route") != "/b" || p.Attribute("status") != int64(200) {
+		t.Errorf("Expected the last value of each attribute, got %v", p.Attributes)
+	}
+	if p.End.Before(p.Start) {
+		t.Error("Expected the span to end after it started")
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	rec := useRecorder(t)
+
+	h := http.Header{}
+	h.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := Start(Extract(context.Background(), h), "server", KindServer)
+	out := http.Header{}
+	Inject(ctx, out)
+	span.End()
+
+	got := rec.Spans()[0]
+	if got.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || got.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the caller's trace to continue, got trace %s parent %s", got.TraceID, got.ParentSpanID)
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + got.SpanID.String() + "-01"; out.Get("traceparent") != want {
+		t.Errorf("Expected traceparent %q, got %q", want, out.Get("traceparent"))
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01",
+	} {
+		h.Set("traceparent", bad)
+		if ctx := Extract(context.Background(), h); ctx.Value(remoteKey{}) != nil {
+			t.Errorf("Expected %q to be ignored", bad)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   map[string]any
+	}
+	requests := make(chan received, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode the request: %v", err)
+		}
+		requests <- received{header: r.Header, body: body}
+	}))
+	defer srv.Close()
+
+	e, err := NewOTLPExporter(OTLPOptions{Endpoint: srv.URL + "/v1/traces", Headers: map[string]string{"Authorization": "Bearer s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 5)
+	e.ExportSpan(SpanData{
+		TraceID:    TraceID{1},
+		SpanID:     SpanID{2},
+		Name:       "GET /vm/details/:vmid",
+		Kind:       KindServer,
+		Start:      start,
+		End:        start.Add(time.Second),
+		Attributes: []Attribute{String("pvmss.vmid", "100"), Int("http.response.status_code", 500), Bool("ok", false)},
+		StatusCode: StatusError,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var req received
+	select {
+	case req = <-requests:
+	default:
+		t.Fatal("Expected the span to be sent on flush")
+	}
+	if req.header.Get("Content-Type") != "application/json" || req.header.Get("Authorization") != "Bearer s3cret" {
+		t.Errorf("Unexpected headers: %v", req.header)
+	}
+	rs := req.body["resourceSpans"].([]any)[0].(map[string]any)
+	resource, _ := json.Marshal(rs["resource"])
+	if string(resource) != `{"attributes":[{"key":"service.name","value":{"stringValue":"pvmss"}}]}` {
+		t.Errorf("Unexpected resource: %s", resource)
+	}
+	span, _ := json.Marshal(rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0])
+	want := `{"attributes":[{"key":"pvmss.vmid","value":{"stringValue":"100"}},{"key":"http.response.status_code","value":{"intValue":"500"}},{"key":"ok","value":{"boolValue":false}}],` +
+		`"endTimeUnixNano":"1700000001000000005","kind":2,"name":"GET /vm/details/:vmid","spanId":"0200000000000000",` +
+		`"startTimeUnixNano":"1700000000000000005","status":{"code":2},"traceId":"01000000000000000000000000000000"}`
+	if string(span) != want {
+		t.Errorf("Unexpected span:\n got %s\nwant %s", span, want)
+	}
+
+	if err := e.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	e.ExportSpan(SpanData{})
+	if e.Dropped() != 0 {
+		t.Errorf("Expected no span dropped, got %d", e.Dropped())
+	}
+}
+
+func TestNewOTLPExporterFromEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if e, err := NewOTLPExporterFromEnv(); e != nil || err != nil {
+		t.Fatalf("Expected no exporter without an endpoint, got %v (%v)", e, err)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20abc, X-Scope-OrgID=pvmss")
+	t.Setenv("OTEL_SERVICE_NAME", "pvmss-prod")
+	e, err := NewOTLPExporterFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = e.Shutdown(context.Background()) }()
+	if e.opts.Endpoint != "http://collector:4318/v1/traces" {
+		t.Errorf("Expected the traces path appended, got %q", e.opts.Endpoint)
+	}
+	if e.opts.Headers["Authorization"] != "Bearer abc" || e.opts.Headers["X-Scope-OrgID"] != "pvmss" {
+		t.Errorf("Unexpected headers: %v", e.opts.Headers)
+	}
+	if e.opts.ServiceName != "pvmss-prod" {
+		t.Errorf("Expected the service name from the environment, got %q", e.opts.ServiceName)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "broken")
+	if _, err := NewOTLPExporterFromEnv(); err == nil {
+		t.Error("Expected an error for a malformed header")
+	}
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "collector:4318")
+	if _, err := NewOTLPExporterFromEnv(); err == nil {
+		t.Error("Expected an error for an endpoint without a scheme")
+	}
+}
@@ -0,0 +1,106 @@
+// Recent tasks panel of the VM details page
+// Polls the tasks endpoint while a task is running and loads task logs on demand
+
+const POLL_INTERVAL_MS = 3000;
+
+const STATE_CLASSES = {
+    running: 'is-info',
+    ok: 'is-success',
+    failed: 'is-danger',
+    unknown: 'is-warning',
+};
+
+/**
+ * Initialize the recent tasks panel
+ * @param {HTMLElement} panel - The #vm-tasks card, carrying data-vmid and the state labels
+ */
+export function initTaskPanel(panel) {
+    if (!panel) {
+        return;
+    }
+    const vmid = panel.dataset.vmid;
+    const endpoint = `/api/vm/tasks/${encodeURIComponent(vmid)}`;
+
+    /**
+     * Fetch the tasks endpoint, optionally with the log of one task
+     * @param {string} [upid] - Task whose log lines should be included
+     */
+    async function fetchTasks(upid) {
+        const url = upid ? `${endpoint}?upid=${encodeURIComponent(upid)}` : endpoint;
+        const response = await fetch(url, { credentials: 'same-origin', headers: { Accept: 'application/json' } });
+        if (!response.ok) {
+            throw new Error(`HTTP ${response.status}`);
+        }
+        const body = await response.json();
+        return body.data;
+    }
+
+    /**
+     * Update the status badge and duration of a rendered task row
+     * @param {Object} task - Task view returned by the endpoint
+     */
+    function updateRow(task) {
+        const row = panel.querySelector(`tr[data-upid="${CSS.escape(task.upid)}"]`);
+        if (!row || row.dataset.state === task.state) {
+            return;
+        }
+        row.dataset.state = task.state;
+        const badge = row.querySelector('.task-state');
+        if (badge) {
+            Object.values(STATE_CLASSES).forEach((cls) => badge.classList.remove(cls));
+            badge.classList.add(STATE_CLASSES[task.state] || 'is-warning');
+            badge.textContent = panel.dataset[`label${task.state.charAt(0).toUpperCase()}${task.state.slice(1)}`] || task.state;
+            if (task.exitstatus) {
+                badge.title = task.exitstatus;
+            }
+        }
+        const duration = row.querySelector('.task-duration');
+        if (duration) {
+            duration.textContent = task.duration || '';
+        }
+    }
+
+    function hasRunningTasks() {
+        return panel.querySelector('tr[data-state="running"]') !== null;
+    }
+
+    async function poll() {
+        if (!hasRunningTasks()) {
+            return;
+        }
+        try {
+            const data = await fetchTasks();
+            (data.tasks || []).forEach(updateRow);
+        } catch (err) {
+            console.warn('Unable to refresh VM tasks:', err);
+        }
+        setTimeout(poll, POLL_INTERVAL_MS);
+    }
+
+    panel.querySelectorAll('.task-log-toggle').forEach((button) => {
+        button.addEventListener('click', async () => {
+            const upid = button.closest('tr').dataset.upid;
+            const logRow = panel.querySelector(`tr[data-log-for="${CSS.escape(upid)}"]`);
+            if (!logRow) {
+                return;
+            }
+            if (!logRow.classList.contains('is-hidden')) {
+                logRow.classList.add('is-hidden');
+                return;
+            }
+            const pre = logRow.querySelector('.task-log');
+            try {
+                const data = await fetchTasks(upid);
+                pre.textContent = (data.log || []).join('\n');
+                if (data.task) {
+                    updateRow(data.task);
+                }
+            } catch (err) {
+                pre.textContent = panel.dataset.labelLogError;
+            }
+            logRow.classList.remove('is-hidden');
+        });
+    });
+
+    setTimeout(poll, POLL_INTERVAL_MS);
+}